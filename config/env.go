@@ -2,6 +2,7 @@ package config
 
 import (
 	"fmt"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/pflag"
@@ -9,9 +10,21 @@ import (
 )
 
 const (
-	Port       = "port"
-	Env        = "env"
-	DefaultEnv = "dev"
+	Port           = "port"
+	Env            = "env"
+	DefaultEnv     = "dev"
+	HealthInterval = "health_interval"
+
+	DrainDelay             = "drain_delay"
+	ShutdownTimeout        = "shutdown_timeout"
+	DefaultShutdownTimeout = 30 * time.Second
+
+	ProbeRateLimit = "rate_limit.probes"
+	MaxHeaderBytes = "limits.max_header_bytes"
+
+	TLSCertFile = "tls.cert_file"
+	TLSKeyFile  = "tls.key_file"
+	H2C         = "h2c"
 )
 
 func init() {
@@ -33,15 +46,4 @@ func init() {
 	if err != nil { // Handle errors reading the config file
 		panic(fmt.Errorf("Fatal error config file: %s ", err))
 	}
-
-	// Real-Time Config Changes
-	// go func() {
-	// 	for {
-	// 		time.Sleep(time.Second * 5)
-	// 		viper.WatchConfig()
-	// 		viper.OnConfigChange(func(e fsnotify.Event) {
-	// 			log.Println("config file changed: ", e.Name)
-	// 		})
-	// 	}
-	// }()
 }
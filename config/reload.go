@@ -0,0 +1,248 @@
+package config
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"io/ioutil"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	reloadDebounce = 100 * time.Millisecond
+)
+
+//Loader validate a candidate config
+//  returns a func that applies it, or an error to keep the previous config
+type Loader func(v *viper.Viper) (apply func(), err error)
+
+type namedLoader struct {
+	name string
+	load Loader
+}
+
+var (
+	loaded     int32
+	loaders    []namedLoader
+	holds      []sync.Locker
+	reloadLock sync.Mutex
+	readFile   func(filename string) ([]byte, error)
+)
+
+func init() {
+	readFile = ioutil.ReadFile
+}
+
+//OnReload register a loader run at startup and on every reload
+func OnReload(name string, load Loader) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	loaders = append(loaders, namedLoader{name, load})
+}
+
+//Hold lock l through every load and reload
+//  state a loader checks in prepare cannot then change before apply
+func Hold(l sync.Locker) {
+	reloadLock.Lock()
+	defer reloadLock.Unlock()
+	holds = append(holds, l)
+}
+
+//Load run every loader against the current config
+func Load() error {
+	defer lock()()
+
+	if err := apply(viper.GetViper()); err != nil {
+		return err
+	}
+	atomic.StoreInt32(&loaded, 1)
+	return nil
+}
+
+// lock takes the reload lock then the held locks, returns their unlock
+func lock() (unlock func()) {
+	reloadLock.Lock()
+	for _, l := range holds {
+		l.Lock()
+	}
+	return func() {
+		for i := len(holds) - 1; i >= 0; i-- {
+			holds[i].Unlock()
+		}
+		reloadLock.Unlock()
+	}
+}
+
+//Ready check that a valid config has been loaded
+func Ready() error {
+	if atomic.LoadInt32(&loaded) == 0 {
+		return errors.New("not loaded")
+	}
+	return nil
+}
+
+//Reload re-read the config file and apply it
+//  an invalid config is rejected and the previous config is kept
+func Reload() error {
+	defer lock()()
+
+	file := viper.ConfigFileUsed()
+	data, err := readFile(file)
+	if err != nil {
+		log.Error("Reload Error: " + err.Error())
+		return err
+	}
+
+	configType := strings.TrimPrefix(filepath.Ext(file), ".")
+	candidate := viper.New()
+	candidate.SetConfigType(configType)
+	if err := candidate.ReadConfig(bytes.NewReader(data)); err != nil {
+		log.Error("Reload Error: " + err.Error())
+		return err
+	}
+
+	changes := diff(viper.GetViper(), candidate)
+	if len(changes) == 0 {
+		log.Info("Config reloaded with no changes")
+		return nil
+	}
+
+	applies, err := prepare(candidate)
+	if err != nil {
+		log.Error("Reload Error: keeping previous config - " + err.Error())
+		return err
+	}
+
+	// log before applying since the log level may change
+	for _, change := range changes {
+		log.Info("Config changed: " + change)
+	}
+	for _, fn := range applies {
+		fn()
+	}
+
+	viper.SetConfigType(configType)
+	viper.ReadConfig(bytes.NewReader(data))
+	return nil
+}
+
+//apply validate v with every loader before applying any of them
+func apply(v *viper.Viper) error {
+	applies, err := prepare(v)
+	if err != nil {
+		return err
+	}
+	for _, fn := range applies {
+		fn()
+	}
+	return nil
+}
+
+func prepare(v *viper.Viper) ([]func(), error) {
+	applies := make([]func(), 0, len(loaders))
+	for _, l := range loaders {
+		fn, err := l.load(v)
+		if err != nil {
+			return nil, fmt.Errorf("%s: %s", l.name, err.Error())
+		}
+		applies = append(applies, fn)
+	}
+	return applies, nil
+}
+
+//Watch reload the config whenever the config file changes
+func Watch() error {
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return err
+	}
+
+	// watch the directory since editors often replace the file
+	file := filepath.Clean(viper.ConfigFileUsed())
+	if err := watcher.Add(filepath.Dir(file)); err != nil {
+		watcher.Close()
+		return err
+	}
+
+	go func() {
+		defer watcher.Close()
+		var pending <-chan time.Time
+		for {
+			select {
+			case e, ok := <-watcher.Events:
+				if !ok {
+					return
+				}
+				if filepath.Clean(e.Name) == file && e.Op&(fsnotify.Write|fsnotify.Create) != 0 {
+					pending = time.After(reloadDebounce)
+				}
+			case err, ok := <-watcher.Errors:
+				if !ok {
+					return
+				}
+				log.Error("Watch Error: " + err.Error())
+			case <-pending:
+				pending = nil
+				Reload()
+			}
+		}
+	}()
+	return nil
+}
+
+//diff describe every setting that differs between old and new
+//  values of key and secret settings are not logged
+func diff(old, new *viper.Viper) []string {
+	keys := make(map[string]bool)
+	for _, k := range old.AllKeys() {
+		keys[k] = true
+	}
+	for _, k := range new.AllKeys() {
+		keys[k] = true
+	}
+
+	sorted := make([]string, 0, len(keys))
+	for k := range keys {
+		sorted = append(sorted, k)
+	}
+	sort.Strings(sorted)
+
+	var changes []string
+	for _, k := range sorted {
+		// flags and defaults are not part of the file
+		if k == Env {
+			continue
+		}
+		a, b := old.Get(k), new.Get(k)
+		if reflect.DeepEqual(a, b) {
+			continue
+		}
+
+		switch {
+		case a == nil:
+			changes = append(changes, k+" added"+describe(k, b))
+		case b == nil:
+			changes = append(changes, k+" removed")
+		default:
+			changes = append(changes, k+" changed"+describe(k, b))
+		}
+	}
+	return changes
+}
+
+func describe(key string, value interface{}) string {
+	if strings.Contains(key, "key") || strings.Contains(key, "secret") {
+		return ""
+	}
+	return fmt.Sprintf(" to %v", value)
+}
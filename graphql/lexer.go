@@ -0,0 +1,281 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"unicode/utf8"
+)
+
+type tokenKind int
+
+const (
+	tokenEOF tokenKind = iota
+	tokenPunct
+	tokenName
+	tokenInt
+	tokenFloat
+	tokenString
+)
+
+var tokenNames = map[tokenKind]string{
+	tokenEOF:    "end of document",
+	tokenPunct:  "punctuator",
+	tokenName:   "name",
+	tokenInt:    "int",
+	tokenFloat:  "float",
+	tokenString: "string",
+}
+
+type token struct {
+	kind  tokenKind
+	value string
+	line  int
+	col   int
+}
+
+func (t token) String() string {
+	if t.kind == tokenEOF {
+		return tokenNames[t.kind]
+	}
+	return fmt.Sprintf("%s %q", tokenNames[t.kind], t.value)
+}
+
+//SyntaxError invalid GraphQL document
+type SyntaxError struct {
+	Message string
+	Line    int
+	Column  int
+}
+
+func (e *SyntaxError) Error() string {
+	return fmt.Sprintf("Syntax Error: %s (%d:%d)", e.Message, e.Line, e.Column)
+}
+
+//lexer split a GraphQL document into tokens
+//  commas, whitespace and comments are ignored
+type lexer struct {
+	src  string
+	pos  int
+	line int
+	col  int
+}
+
+func (l *lexer) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{fmt.Sprintf(format, args...), l.line, l.col}
+}
+
+func (l *lexer) advance(n int) {
+	for i := 0; i < n; i++ {
+		if l.src[l.pos] == '\n' {
+			l.line++
+			l.col = 0
+		}
+		l.pos++
+		l.col++
+	}
+}
+
+func (l *lexer) skipIgnored() {
+	for l.pos < len(l.src) {
+		switch c := l.src[l.pos]; {
+		case c == ' ' || c == '\t' || c == '\n' || c == '\r' || c == ',':
+			l.advance(1)
+		case c == '#':
+			for l.pos < len(l.src) && l.src[l.pos] != '\n' {
+				l.advance(1)
+			}
+		case strings.HasPrefix(l.src[l.pos:], "\uFEFF"):
+			l.pos += len("\uFEFF")
+		default:
+			return
+		}
+	}
+}
+
+func (l *lexer) next() (token, error) {
+	l.skipIgnored()
+	t := token{line: l.line, col: l.col}
+	if l.pos >= len(l.src) {
+		t.kind = tokenEOF
+		return t, nil
+	}
+
+	c := l.src[l.pos]
+	switch {
+	case strings.IndexByte("!$&()=:@[]{}|", c) >= 0:
+		t.kind, t.value = tokenPunct, string(c)
+		l.advance(1)
+	case strings.HasPrefix(l.src[l.pos:], "..."):
+		t.kind, t.value = tokenPunct, "..."
+		l.advance(3)
+	case c == '_' || isLetter(c):
+		start := l.pos
+		for l.pos < len(l.src) && (l.src[l.pos] == '_' || isLetter(l.src[l.pos]) || isDigit(l.src[l.pos])) {
+			l.advance(1)
+		}
+		t.kind, t.value = tokenName, l.src[start:l.pos]
+	case c == '-' || isDigit(c):
+		return l.number(t)
+	case strings.HasPrefix(l.src[l.pos:], `"""`):
+		return l.blockString(t)
+	case c == '"':
+		return l.string(t)
+	default:
+		r, _ := utf8.DecodeRuneInString(l.src[l.pos:])
+		return t, l.errorf("unexpected character %q", r)
+	}
+	return t, nil
+}
+
+func (l *lexer) number(t token) (token, error) {
+	start := l.pos
+	t.kind = tokenInt
+	if l.src[l.pos] == '-' {
+		l.advance(1)
+	}
+	if !l.digits() {
+		return t, l.errorf("invalid number")
+	}
+	if l.pos < len(l.src) && l.src[l.pos] == '.' {
+		t.kind = tokenFloat
+		l.advance(1)
+		if !l.digits() {
+			return t, l.errorf("invalid number")
+		}
+	}
+	if l.pos < len(l.src) && (l.src[l.pos] == 'e' || l.src[l.pos] == 'E') {
+		t.kind = tokenFloat
+		l.advance(1)
+		if l.pos < len(l.src) && (l.src[l.pos] == '+' || l.src[l.pos] == '-') {
+			l.advance(1)
+		}
+		if !l.digits() {
+			return t, l.errorf("invalid number")
+		}
+	}
+	t.value = l.src[start:l.pos]
+	return t, nil
+}
+
+func (l *lexer) digits() bool {
+	start := l.pos
+	for l.pos < len(l.src) && isDigit(l.src[l.pos]) {
+		l.advance(1)
+	}
+	return l.pos > start
+}
+
+func (l *lexer) string(t token) (token, error) {
+	t.kind = tokenString
+	l.advance(1)
+	var b strings.Builder
+	for {
+		if l.pos >= len(l.src) || l.src[l.pos] == '\n' {
+			return t, l.errorf("unterminated string")
+		}
+		c := l.src[l.pos]
+		if c == '"' {
+			l.advance(1)
+			t.value = b.String()
+			return t, nil
+		}
+		if c != '\\' {
+			r, size := utf8.DecodeRuneInString(l.src[l.pos:])
+			b.WriteRune(r)
+			l.pos += size
+			l.col++
+			continue
+		}
+
+		if l.pos+1 >= len(l.src) {
+			return t, l.errorf("unterminated string")
+		}
+		switch esc := l.src[l.pos+1]; esc {
+		case '"', '\\', '/':
+			b.WriteByte(esc)
+		case 'b':
+			b.WriteByte('\b')
+		case 'f':
+			b.WriteByte('\f')
+		case 'n':
+			b.WriteByte('\n')
+		case 'r':
+			b.WriteByte('\r')
+		case 't':
+			b.WriteByte('\t')
+		case 'u':
+			if l.pos+6 > len(l.src) {
+				return t, l.errorf("invalid unicode escape")
+			}
+			code, err := strconv.ParseUint(l.src[l.pos+2:l.pos+6], 16, 32)
+			if err != nil {
+				return t, l.errorf("invalid unicode escape")
+			}
+			b.WriteRune(rune(code))
+			l.advance(4)
+		default:
+			return t, l.errorf("invalid escape \\%c", esc)
+		}
+		l.advance(2)
+	}
+}
+
+//blockString read a """ string, removing the common indentation of its lines
+func (l *lexer) blockString(t token) (token, error) {
+	t.kind = tokenString
+	l.advance(3)
+	start := l.pos
+	for {
+		if l.pos >= len(l.src) {
+			return t, l.errorf("unterminated string")
+		}
+		if strings.HasPrefix(l.src[l.pos:], `\"""`) {
+			l.advance(4)
+			continue
+		}
+		if strings.HasPrefix(l.src[l.pos:], `"""`) {
+			break
+		}
+		l.advance(1)
+	}
+	raw := strings.Replace(l.src[start:l.pos], `\"""`, `"""`, -1)
+	l.advance(3)
+
+	lines := strings.Split(strings.Replace(raw, "\r\n", "\n", -1), "\n")
+	indent := -1
+	for _, line := range lines[1:] {
+		trimmed := strings.TrimLeft(line, " \t")
+		if trimmed == "" {
+			continue
+		}
+		if n := len(line) - len(trimmed); indent < 0 || n < indent {
+			indent = n
+		}
+	}
+	if indent > 0 {
+		for i := 1; i < len(lines); i++ {
+			if len(lines[i]) >= indent {
+				lines[i] = lines[i][indent:]
+			} else {
+				lines[i] = strings.TrimLeft(lines[i], " \t")
+			}
+		}
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[0]) == "" {
+		lines = lines[1:]
+	}
+	for len(lines) > 0 && strings.TrimSpace(lines[len(lines)-1]) == "" {
+		lines = lines[:len(lines)-1]
+	}
+	t.value = strings.Join(lines, "\n")
+	return t, nil
+}
+
+func isLetter(c byte) bool {
+	return (c >= 'a' && c <= 'z') || (c >= 'A' && c <= 'Z')
+}
+
+func isDigit(c byte) bool {
+	return c >= '0' && c <= '9'
+}
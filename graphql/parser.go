@@ -0,0 +1,628 @@
+package graphql
+
+import (
+	"fmt"
+	"strconv"
+)
+
+// Operation types
+const (
+	Query        = "query"
+	Mutation     = "mutation"
+	Subscription = "subscription"
+)
+
+// Value kinds
+const (
+	VariableValue = iota
+	IntValue
+	FloatValue
+	StringValue
+	BooleanValue
+	NullValue
+	EnumValue
+	ListValue
+	ObjectValue
+)
+
+// maxDepth deepest nesting of selection sets, values and types Parse accepts
+const maxDepth = 64
+
+//Document parsed GraphQL executable document
+type Document struct {
+	Operations []*Operation
+	Fragments  []*Fragment
+}
+
+//Operation query, mutation or subscription of a document
+type Operation struct {
+	Type         string
+	Name         string
+	Variables    []*VariableDefinition
+	Directives   []*Directive
+	SelectionSet []Selection
+}
+
+//VariableDefinition variable of an operation, Default is nil without one
+type VariableDefinition struct {
+	Name    string
+	Type    *Type
+	Default *Value
+}
+
+//Type reference to a named, list or non-null type
+//  Elem is set for list types
+type Type struct {
+	Name    string
+	Elem    *Type
+	NonNull bool
+}
+
+//Selection *Field, *FragmentSpread or *InlineFragment
+type Selection interface{}
+
+//Field selected field, Alias is empty when not aliased
+type Field struct {
+	Alias        string
+	Name         string
+	Arguments    []*Argument
+	Directives   []*Directive
+	SelectionSet []Selection
+}
+
+//Key name of a field in the response
+func (f *Field) Key() string {
+	if f.Alias != "" {
+		return f.Alias
+	}
+	return f.Name
+}
+
+//FragmentSpread ...Name
+type FragmentSpread struct {
+	Name       string
+	Directives []*Directive
+}
+
+//InlineFragment ... on Type { }, TypeCondition may be empty
+type InlineFragment struct {
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+}
+
+//Fragment named fragment definition
+type Fragment struct {
+	Name          string
+	TypeCondition string
+	Directives    []*Directive
+	SelectionSet  []Selection
+}
+
+//Argument name: value
+type Argument struct {
+	Name  string
+	Value *Value
+}
+
+//Directive @name(arguments)
+type Directive struct {
+	Name      string
+	Arguments []*Argument
+}
+
+//Value input value, Raw holds the name, literal or decoded string
+type Value struct {
+	Kind   int
+	Raw    string
+	List   []*Value
+	Fields []*Argument
+}
+
+//Operation find the operation to execute
+//  name may be empty when the document has a single operation
+func (d *Document) Operation(name string) (*Operation, error) {
+	if name == "" {
+		if len(d.Operations) != 1 {
+			return nil, fmt.Errorf("operationName is required for documents with %d operations", len(d.Operations))
+		}
+		return d.Operations[0], nil
+	}
+	for _, op := range d.Operations {
+		if op.Name == name {
+			return op, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown operation %s", name)
+}
+
+//Fragment find a fragment definition by name
+func (d *Document) Fragment(name string) *Fragment {
+	for _, f := range d.Fragments {
+		if f.Name == name {
+			return f
+		}
+	}
+	return nil
+}
+
+//Interface Go value of v with variables substituted
+//  numbers are float64 as from encoding/json
+func (v *Value) Interface(variables map[string]interface{}) interface{} {
+	switch v.Kind {
+	case VariableValue:
+		return variables[v.Raw]
+	case IntValue, FloatValue:
+		f, _ := strconv.ParseFloat(v.Raw, 64)
+		return f
+	case StringValue, EnumValue:
+		return v.Raw
+	case BooleanValue:
+		return v.Raw == "true"
+	case ListValue:
+		list := make([]interface{}, len(v.List))
+		for i, item := range v.List {
+			list[i] = item.Interface(variables)
+		}
+		return list
+	case ObjectValue:
+		obj := make(map[string]interface{}, len(v.Fields))
+		for _, f := range v.Fields {
+			obj[f.Name] = f.Value.Interface(variables)
+		}
+		return obj
+	}
+	return nil
+}
+
+//Parse parse an executable GraphQL document
+//  type system definitions are not supported
+//  selection sets, values and types nested deeper than maxDepth are rejected
+func Parse(src string) (*Document, error) {
+	p := &parser{lex: lexer{src: src, line: 1, col: 1}}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	doc := &Document{}
+	for p.tok.kind != tokenEOF {
+		switch {
+		case p.peek(tokenPunct, "{"):
+			sel, err := p.selectionSet()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, &Operation{Type: Query, SelectionSet: sel})
+		case p.peek(tokenName, "fragment"):
+			f, err := p.fragment()
+			if err != nil {
+				return nil, err
+			}
+			if doc.Fragment(f.Name) != nil {
+				return nil, p.errorf("duplicate fragment %s", f.Name)
+			}
+			doc.Fragments = append(doc.Fragments, f)
+		case p.peek(tokenName, Query) || p.peek(tokenName, Mutation) || p.peek(tokenName, Subscription):
+			op, err := p.operation()
+			if err != nil {
+				return nil, err
+			}
+			doc.Operations = append(doc.Operations, op)
+		default:
+			return nil, p.errorf("unexpected %s", p.tok)
+		}
+	}
+	if len(doc.Operations) == 0 {
+		return nil, &SyntaxError{"document has no operations", 1, 1}
+	}
+	return doc, nil
+}
+
+type parser struct {
+	lex   lexer
+	tok   token
+	depth int
+}
+
+func (p *parser) errorf(format string, args ...interface{}) error {
+	return &SyntaxError{fmt.Sprintf(format, args...), p.tok.line, p.tok.col}
+}
+
+func (p *parser) advance() error {
+	tok, err := p.lex.next()
+	if err != nil {
+		return err
+	}
+	p.tok = tok
+	return nil
+}
+
+func (p *parser) peek(kind tokenKind, value string) bool {
+	return p.tok.kind == kind && p.tok.value == value
+}
+
+//nest enter a nested selection set, value or type, undone by unnest
+func (p *parser) nest() error {
+	p.depth++
+	if p.depth > maxDepth {
+		return p.errorf("nesting deeper than %d", maxDepth)
+	}
+	return nil
+}
+
+func (p *parser) unnest() {
+	p.depth--
+}
+
+//expect consume a punctuator
+func (p *parser) expect(value string) error {
+	if !p.peek(tokenPunct, value) {
+		return p.errorf("expected %q, found %s", value, p.tok)
+	}
+	return p.advance()
+}
+
+//skip consume a punctuator if present
+func (p *parser) skip(value string) (bool, error) {
+	if !p.peek(tokenPunct, value) {
+		return false, nil
+	}
+	return true, p.advance()
+}
+
+func (p *parser) name() (string, error) {
+	if p.tok.kind != tokenName {
+		return "", p.errorf("expected name, found %s", p.tok)
+	}
+	name := p.tok.value
+	return name, p.advance()
+}
+
+func (p *parser) operation() (*Operation, error) {
+	op := &Operation{Type: p.tok.value}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+
+	var err error
+	if p.tok.kind == tokenName {
+		if op.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if p.peek(tokenPunct, "(") {
+		if op.Variables, err = p.variableDefinitions(); err != nil {
+			return nil, err
+		}
+	}
+	if op.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if op.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return op, nil
+}
+
+func (p *parser) variableDefinitions() ([]*VariableDefinition, error) {
+	if err := p.expect("("); err != nil {
+		return nil, err
+	}
+	var defs []*VariableDefinition
+	for {
+		if ok, err := p.skip(")"); err != nil || ok {
+			return defs, err
+		}
+		if err := p.expect("$"); err != nil {
+			return nil, err
+		}
+		def := &VariableDefinition{}
+		var err error
+		if def.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if err := p.expect(":"); err != nil {
+			return nil, err
+		}
+		if def.Type, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if ok, err := p.skip("="); err != nil {
+			return nil, err
+		} else if ok {
+			if def.Default, err = p.value(true); err != nil {
+				return nil, err
+			}
+		}
+		defs = append(defs, def)
+	}
+}
+
+func (p *parser) typeRef() (*Type, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+	t := &Type{}
+	if ok, err := p.skip("["); err != nil {
+		return nil, err
+	} else if ok {
+		if t.Elem, err = p.typeRef(); err != nil {
+			return nil, err
+		}
+		if err := p.expect("]"); err != nil {
+			return nil, err
+		}
+	} else if t.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+
+	ok, err := p.skip("!")
+	t.NonNull = ok
+	return t, err
+}
+
+func (p *parser) directives() ([]*Directive, error) {
+	var directives []*Directive
+	for p.peek(tokenPunct, "@") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		d := &Directive{}
+		var err error
+		if d.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if d.Arguments, err = p.arguments(false); err != nil {
+			return nil, err
+		}
+		directives = append(directives, d)
+	}
+	return directives, nil
+}
+
+func (p *parser) arguments(constant bool) ([]*Argument, error) {
+	if !p.peek(tokenPunct, "(") {
+		return nil, nil
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	var args []*Argument
+	for {
+		if ok, err := p.skip(")"); err != nil || ok {
+			return args, err
+		}
+		arg, err := p.argument(constant)
+		if err != nil {
+			return nil, err
+		}
+		args = append(args, arg)
+	}
+}
+
+func (p *parser) argument(constant bool) (*Argument, error) {
+	arg := &Argument{}
+	var err error
+	if arg.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if err := p.expect(":"); err != nil {
+		return nil, err
+	}
+	if arg.Value, err = p.value(constant); err != nil {
+		return nil, err
+	}
+	return arg, nil
+}
+
+func (p *parser) selectionSet() ([]Selection, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+	if err := p.expect("{"); err != nil {
+		return nil, err
+	}
+	var selections []Selection
+	for {
+		if ok, err := p.skip("}"); err != nil {
+			return nil, err
+		} else if ok {
+			if len(selections) == 0 {
+				return nil, p.errorf("selection set must not be empty")
+			}
+			return selections, nil
+		}
+
+		var sel Selection
+		var err error
+		if p.peek(tokenPunct, "...") {
+			sel, err = p.fragmentSelection()
+		} else {
+			sel, err = p.field()
+		}
+		if err != nil {
+			return nil, err
+		}
+		selections = append(selections, sel)
+	}
+}
+
+func (p *parser) field() (*Field, error) {
+	f := &Field{}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if ok, err := p.skip(":"); err != nil {
+		return nil, err
+	} else if ok {
+		f.Alias = f.Name
+		if f.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if f.Arguments, err = p.arguments(false); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if p.peek(tokenPunct, "{") {
+		if f.SelectionSet, err = p.selectionSet(); err != nil {
+			return nil, err
+		}
+	}
+	return f, nil
+}
+
+func (p *parser) fragmentSelection() (Selection, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if p.tok.kind == tokenName && p.tok.value != "on" {
+		spread := &FragmentSpread{}
+		var err error
+		if spread.Name, err = p.name(); err != nil {
+			return nil, err
+		}
+		if spread.Directives, err = p.directives(); err != nil {
+			return nil, err
+		}
+		return spread, nil
+	}
+
+	inline := &InlineFragment{}
+	var err error
+	if p.peek(tokenName, "on") {
+		if err := p.advance(); err != nil {
+			return nil, err
+		}
+		if inline.TypeCondition, err = p.name(); err != nil {
+			return nil, err
+		}
+	}
+	if inline.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if inline.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return inline, nil
+}
+
+func (p *parser) fragment() (*Fragment, error) {
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	f := &Fragment{}
+	var err error
+	if f.Name, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Name == "on" {
+		return nil, p.errorf("fragment cannot be named on")
+	}
+	if !p.peek(tokenName, "on") {
+		return nil, p.errorf("expected \"on\", found %s", p.tok)
+	}
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	if f.TypeCondition, err = p.name(); err != nil {
+		return nil, err
+	}
+	if f.Directives, err = p.directives(); err != nil {
+		return nil, err
+	}
+	if f.SelectionSet, err = p.selectionSet(); err != nil {
+		return nil, err
+	}
+	return f, nil
+}
+
+//value parse an input value, variables are not allowed in constants
+func (p *parser) value(constant bool) (*Value, error) {
+	tok := p.tok
+	v := &Value{Raw: tok.value}
+	switch tok.kind {
+	case tokenInt:
+		v.Kind = IntValue
+	case tokenFloat:
+		v.Kind = FloatValue
+	case tokenString:
+		v.Kind = StringValue
+	case tokenName:
+		switch tok.value {
+		case "true", "false":
+			v.Kind = BooleanValue
+		case "null":
+			v.Kind = NullValue
+		default:
+			v.Kind = EnumValue
+		}
+	case tokenPunct:
+		switch tok.value {
+		case "$":
+			if constant {
+				return nil, p.errorf("unexpected variable in constant value")
+			}
+			if err := p.advance(); err != nil {
+				return nil, err
+			}
+			name, err := p.name()
+			return &Value{Kind: VariableValue, Raw: name}, err
+		case "[":
+			return p.listValue(constant)
+		case "{":
+			return p.objectValue(constant)
+		}
+		return nil, p.errorf("unexpected %s", tok)
+	default:
+		return nil, p.errorf("unexpected %s", tok)
+	}
+	return v, p.advance()
+}
+
+func (p *parser) listValue(constant bool) (*Value, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	v := &Value{Kind: ListValue}
+	for {
+		if ok, err := p.skip("]"); err != nil || ok {
+			return v, err
+		}
+		item, err := p.value(constant)
+		if err != nil {
+			return nil, err
+		}
+		v.List = append(v.List, item)
+	}
+}
+
+func (p *parser) objectValue(constant bool) (*Value, error) {
+	if err := p.nest(); err != nil {
+		return nil, err
+	}
+	defer p.unnest()
+	if err := p.advance(); err != nil {
+		return nil, err
+	}
+	v := &Value{Kind: ObjectValue}
+	for {
+		if ok, err := p.skip("}"); err != nil || ok {
+			return v, err
+		}
+		field, err := p.argument(constant)
+		if err != nil {
+			return nil, err
+		}
+		v.Fields = append(v.Fields, field)
+	}
+}
@@ -0,0 +1,120 @@
+package graphql
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	tests := []struct {
+		src      string
+		expected string
+	}{
+		{`{ user { id } }`, `query { user { id } }`},
+		{
+			`query Q($id: ID! = "x", $ids: [Int!]!) @live { a: user(id: $id, n: 1.5e3, o: {x: [1, 2], y: null, z: RED}) { ...F } }
+			fragment F on User { id, __typename # comment
+			}`,
+			`query Q($id: ID! = "x", $ids: [Int!]!) @live { a: user(id: $id, n: 1.5e3, o: {x: [1, 2], y: null, z: RED}) { ...F } } fragment F on User { id __typename }`,
+		},
+		{
+			`mutation { save(input: "a\"bé\n") @include(if: true) { ... on Saved { ok } ... @skip(if: false) { id } } }`,
+			`mutation { save(input: "a\"bé\n") @include(if: true) { ... on Saved { ok } ... @skip(if: false) { id } } }`,
+		},
+		{
+			"{ doc(text: \"\"\"\n    first\n      second\n    \"\"\") }",
+			`query { doc(text: "first\n  second") }`,
+		},
+	}
+
+	for _, test := range tests {
+		doc, err := Parse(test.src)
+		if err != nil {
+			t.Errorf("parse returned unexpected error for %q: %v", test.src, err)
+			continue
+		}
+		if res := doc.String(); res != test.expected {
+			t.Errorf("parse returned unexpected document: got %v want %v", res, test.expected)
+		}
+		if again, err := Parse(doc.String()); err != nil || again.String() != doc.String() {
+			t.Errorf("printed document does not parse back: got %v %v", again, err)
+		}
+	}
+}
+
+func TestParseFail(t *testing.T) {
+	tests := []string{
+		``,
+		`{ }`,
+		`{ user(id: ) }`,
+		`{ user { id }`,
+		`query Q($id ID) { id }`,
+		`fragment F on User { id }`,
+		`{ id } fragment on on User { id }`,
+		`{ id } fragment F on User { id } fragment F on User { id }`,
+		`type Query { id: ID }`,
+		`{ name(s: "unterminated) }`,
+		`{ name(n: 1.) }`,
+		`query Q($id: ID = $other) { id }`,
+		`{ a ^ }`,
+		strings.Repeat("{a", 100) + strings.Repeat("}", 100),
+		"{ a(v: " + strings.Repeat("[", 100000) + ") }",
+		"{ a(v: " + strings.Repeat("{a: ", 100) + "1" + strings.Repeat("}", 100) + ") }",
+		"query Q($v: " + strings.Repeat("[", 100) + "ID" + strings.Repeat("]", 100) + ") { a }",
+	}
+
+	for _, src := range tests {
+		if _, err := Parse(src); err == nil {
+			t.Errorf("parse accepted invalid document %q", src)
+		} else if _, ok := err.(*SyntaxError); !ok {
+			t.Errorf("parse returned wrong error type: got %T want *SyntaxError", err)
+		}
+	}
+}
+
+func TestParseDepth(t *testing.T) {
+	src := strings.Repeat("{a", maxDepth) + strings.Repeat("}", maxDepth)
+	if _, err := Parse(src); err != nil {
+		t.Errorf("parse rejected a document at the depth limit: %v", err)
+	}
+	if _, err := Parse("{a" + src + "}"); err == nil {
+		t.Error("parse accepted a document past the depth limit")
+	}
+}
+
+func TestDocumentOperation(t *testing.T) {
+	doc, err := Parse(`query A { a } query B { b }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, err := doc.Operation("B"); err != nil || op.Name != "B" {
+		t.Errorf("operation returned unexpected operation: got %v %v want B", op, err)
+	}
+	if _, err := doc.Operation(""); err == nil {
+		t.Errorf("operation returned no error for an ambiguous document")
+	}
+	if _, err := doc.Operation("C"); err == nil {
+		t.Errorf("operation returned no error for an unknown operation")
+	}
+}
+
+func TestValueInterface(t *testing.T) {
+	doc, err := Parse(`{ f(v: {a: [1, 2.5, $x], b: true, c: null, d: "s", e: ENUM}) }`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	field := doc.Operations[0].SelectionSet[0].(*Field)
+	res := field.Arguments[0].Value.Interface(map[string]interface{}{"x": "var"})
+
+	expected := map[string]interface{}{
+		"a": []interface{}{float64(1), 2.5, "var"},
+		"b": true,
+		"c": nil,
+		"d": "s",
+		"e": "ENUM",
+	}
+	if !reflect.DeepEqual(res, expected) {
+		t.Errorf("value returned unexpected result: got %v want %v", res, expected)
+	}
+}
@@ -0,0 +1,158 @@
+package graphql
+
+import (
+	"encoding/json"
+	"strings"
+)
+
+//String print the document in compact form
+func (d *Document) String() string {
+	var b strings.Builder
+	for i, op := range d.Operations {
+		if i > 0 {
+			b.WriteString(" ")
+		}
+		printOperation(&b, op)
+	}
+	for _, f := range d.Fragments {
+		b.WriteString(" fragment ")
+		b.WriteString(f.Name)
+		b.WriteString(" on ")
+		b.WriteString(f.TypeCondition)
+		printDirectives(&b, f.Directives)
+		printSelectionSet(&b, f.SelectionSet)
+	}
+	return b.String()
+}
+
+func printOperation(b *strings.Builder, op *Operation) {
+	b.WriteString(op.Type)
+	if op.Name != "" {
+		b.WriteString(" ")
+		b.WriteString(op.Name)
+	}
+	if len(op.Variables) > 0 {
+		b.WriteString("(")
+		for i, v := range op.Variables {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString("$")
+			b.WriteString(v.Name)
+			b.WriteString(": ")
+			b.WriteString(v.Type.String())
+			if v.Default != nil {
+				b.WriteString(" = ")
+				printValue(b, v.Default)
+			}
+		}
+		b.WriteString(")")
+	}
+	printDirectives(b, op.Directives)
+	printSelectionSet(b, op.SelectionSet)
+}
+
+//String print a type reference such as [ID!]!
+func (t *Type) String() string {
+	s := t.Name
+	if t.Elem != nil {
+		s = "[" + t.Elem.String() + "]"
+	}
+	if t.NonNull {
+		s += "!"
+	}
+	return s
+}
+
+func printSelectionSet(b *strings.Builder, selections []Selection) {
+	if len(selections) == 0 {
+		return
+	}
+	b.WriteString(" {")
+	for _, sel := range selections {
+		b.WriteString(" ")
+		switch s := sel.(type) {
+		case *Field:
+			if s.Alias != "" {
+				b.WriteString(s.Alias)
+				b.WriteString(": ")
+			}
+			b.WriteString(s.Name)
+			printArguments(b, s.Arguments)
+			printDirectives(b, s.Directives)
+			printSelectionSet(b, s.SelectionSet)
+		case *FragmentSpread:
+			b.WriteString("...")
+			b.WriteString(s.Name)
+			printDirectives(b, s.Directives)
+		case *InlineFragment:
+			b.WriteString("...")
+			if s.TypeCondition != "" {
+				b.WriteString(" on ")
+				b.WriteString(s.TypeCondition)
+			}
+			printDirectives(b, s.Directives)
+			printSelectionSet(b, s.SelectionSet)
+		}
+	}
+	b.WriteString(" }")
+}
+
+func printDirectives(b *strings.Builder, directives []*Directive) {
+	for _, d := range directives {
+		b.WriteString(" @")
+		b.WriteString(d.Name)
+		printArguments(b, d.Arguments)
+	}
+}
+
+func printArguments(b *strings.Builder, args []*Argument) {
+	if len(args) == 0 {
+		return
+	}
+	b.WriteString("(")
+	for i, arg := range args {
+		if i > 0 {
+			b.WriteString(", ")
+		}
+		b.WriteString(arg.Name)
+		b.WriteString(": ")
+		printValue(b, arg.Value)
+	}
+	b.WriteString(")")
+}
+
+func printValue(b *strings.Builder, v *Value) {
+	switch v.Kind {
+	case VariableValue:
+		b.WriteString("$")
+		b.WriteString(v.Raw)
+	case StringValue:
+		j, _ := json.Marshal(v.Raw)
+		b.Write(j)
+	case NullValue:
+		b.WriteString("null")
+	case ListValue:
+		b.WriteString("[")
+		for i, item := range v.List {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			printValue(b, item)
+		}
+		b.WriteString("]")
+	case ObjectValue:
+		b.WriteString("{")
+		for i, f := range v.Fields {
+			if i > 0 {
+				b.WriteString(", ")
+			}
+			b.WriteString(f.Name)
+			b.WriteString(": ")
+			printValue(b, f.Value)
+		}
+		b.WriteString("}")
+	default:
+		b.WriteString(v.Raw)
+	}
+}
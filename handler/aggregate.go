@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dtan44/SMUG/service"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	aggregatePath = "/aggregate/"
+	errorsField   = "errors"
+)
+
+//HandleAggregate serve a composite endpoint from the aggregates config
+//  the merged document lists failed calls under errors, the status is
+//  502 if a call that is not optional failed, each call is checked against
+//  the auth policy of its service
+func (sh ServiceHandler) HandleAggregate(w http.ResponseWriter, r *http.Request) {
+	name := strings.TrimPrefix(r.URL.Path, aggregatePath)
+	authorize := func(routed *http.Request) bool {
+		return authorized(routed, service.AuthPolicy(routed))
+	}
+	res, err := sh.Aggregator.Aggregate(r, name, authorize)
+	if err == service.ErrUnknownAggregate {
+		writeError(w, r, http.StatusNotFound, err.Error())
+		return
+	}
+	if err != nil {
+		writeError(w, r, http.StatusInternalServerError, err.Error())
+		return
+	}
+
+	doc := make(map[string]interface{}, len(res.Data)+1)
+	for key, val := range res.Data {
+		doc[key] = val
+	}
+	if len(res.Errors) > 0 {
+		doc[errorsField] = res.Errors
+	}
+
+	j, err := jsonMarshal(doc)
+	if err != nil {
+		log.Error("HandleAggregate Error: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	status := http.StatusOK
+	if res.Failed {
+		status = http.StatusBadGateway
+	}
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
@@ -0,0 +1,89 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type AggregateMock struct {
+	authorize *func(*http.Request) bool
+	res       service.AggregateResult
+	err       error
+}
+
+func (am AggregateMock) Aggregate(r *http.Request, name string, authorize func(*http.Request) bool) (service.AggregateResult, error) {
+	if am.authorize != nil {
+		*am.authorize = authorize
+	}
+	return am.res, am.err
+}
+
+func TestHandleAggregate(t *testing.T) {
+	tests := []struct {
+		mock     AggregateMock
+		status   int
+		expected string
+	}{
+		{
+			AggregateMock{res: service.AggregateResult{Data: map[string]interface{}{"profile": "a"}}},
+			http.StatusOK,
+			`{"profile":"a"}`,
+		},
+		{
+			AggregateMock{res: service.AggregateResult{
+				Data:   map[string]interface{}{"profile": "a"},
+				Errors: map[string]service.CallError{"orders": {Status: 503, Reason: "unavailable"}},
+				Failed: true,
+			}},
+			http.StatusBadGateway,
+			`{"errors":{"orders":{"status":503,"reason":"unavailable"}},"profile":"a"}`,
+		},
+		{
+			AggregateMock{err: service.ErrUnknownAggregate},
+			http.StatusNotFound,
+			`{"result":"failure","reason":"Invalid Aggregate Name"}`,
+		},
+	}
+
+	for _, test := range tests {
+		setupServiceHandler()
+		var sh ServiceHandler
+		sh.Aggregator = test.mock
+
+		req, err := http.NewRequest("GET", "/aggregate/home", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(sh.HandleAggregate).ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.status)
+		}
+		if rr.Body.String() != test.expected {
+			t.Errorf("handler returned unexpected body: got %v want %v", rr.Body.String(), test.expected)
+		}
+	}
+}
+
+func TestHandleAggregateAuthorize(t *testing.T) {
+	setUpMiddleWare()
+	var authorize func(*http.Request) bool
+	var sh ServiceHandler
+	sh.Aggregator = AggregateMock{authorize: &authorize}
+
+	r, _ := http.NewRequest("GET", "/aggregate/home", nil)
+	http.HandlerFunc(sh.HandleAggregate).ServeHTTP(httptest.NewRecorder(), r)
+
+	routed, _ := http.NewRequest("GET", "/service/users/me", nil)
+	if authorize(routed) {
+		t.Errorf("handler authorized a request without keys")
+	}
+	routed.Header.Set("api-key", "test")
+	if !authorize(routed) {
+		t.Errorf("handler rejected a request with the api key")
+	}
+}
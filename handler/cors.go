@@ -0,0 +1,62 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"strconv"
+	"strings"
+)
+
+//corsKey context key of the CORS policy applied to a request
+type corsKey struct{}
+
+//allowCORS apply the CORS policy of a request before authentication
+//  preflights are answered here, other requests from an allowed origin
+//  get the CORS headers, which replace those of the service
+func (ch CommonHandler) allowCORS(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		origin := r.Header.Get("Origin")
+		policy := ch.CORS(r)
+		if origin == "" || policy == nil {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		w.Header().Add("Vary", "Origin")
+		method := r.Header.Get("Access-Control-Request-Method")
+		if r.Method != http.MethodOptions || method == "" {
+			if policy.AllowsOrigin(origin) {
+				w.Header().Set("Access-Control-Allow-Origin", origin)
+				if policy.Credentials {
+					w.Header().Set("Access-Control-Allow-Credentials", "true")
+				}
+				if len(policy.ExposeHeaders) > 0 {
+					w.Header().Set("Access-Control-Expose-Headers", strings.Join(policy.ExposeHeaders, ", "))
+				}
+			}
+			next.ServeHTTP(w, r.WithContext(context.WithValue(r.Context(), corsKey{}, policy)))
+			return
+		}
+
+		headers := r.Header.Get("Access-Control-Request-Headers")
+		w.Header().Add("Vary", "Access-Control-Request-Method")
+		w.Header().Add("Vary", "Access-Control-Request-Headers")
+		if !policy.AllowsOrigin(origin) || !policy.AllowsMethod(method) || !policy.AllowsHeaders(headers) {
+			writeError(w, r, http.StatusForbidden, "CORS Request Not Allowed")
+			return
+		}
+
+		w.Header().Set("Access-Control-Allow-Origin", origin)
+		w.Header().Set("Access-Control-Allow-Methods", strings.Join(policy.AllowedMethods(), ", "))
+		if headers != "" {
+			w.Header().Set("Access-Control-Allow-Headers", headers)
+		}
+		if policy.Credentials {
+			w.Header().Set("Access-Control-Allow-Credentials", "true")
+		}
+		if policy.MaxAge > 0 {
+			w.Header().Set("Access-Control-Max-Age", strconv.Itoa(int(policy.MaxAge.Seconds())))
+		}
+		w.WriteHeader(http.StatusNoContent)
+	})
+}
@@ -0,0 +1,159 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/service"
+	"github.com/spf13/viper"
+)
+
+//setupCORS route handler of a service allowing https://app.example.com
+func setupCORS(t *testing.T) http.Handler {
+	setUpMiddleWare()
+	setupServiceHandler()
+	v := viper.New()
+	v.Set("routes", map[string]interface{}{"test": map[string]interface{}{"cors": map[string]interface{}{
+		"origins":        []string{"https://*.example.com"},
+		"methods":        []string{"GET", "PUT"},
+		"headers":        []string{"api-key", "content-type"},
+		"expose_headers": []string{"X-Request-Id"},
+		"credentials":    true,
+		"max_age":        time.Hour,
+	}}})
+	apply, err := service.PreparePolicies(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryRouteCORSMock{}
+	ch := CommonHandler{AllowedMethods: []string{http.MethodGet, http.MethodPut, http.MethodOptions}}
+	ch.AuthPolicy = service.AuthPolicy
+	ch.Resolve = service.Resolve
+	ch.CORS = service.CORS
+	return ch.ApplyMiddleware(http.HandlerFunc(sh.HandleRoute))
+}
+
+type DiscoveryRouteCORSMock struct {
+	DiscoveryRouteMock
+}
+
+func (dm DiscoveryRouteCORSMock) Route(r *http.Request) (*http.Response, []byte, error) {
+	rsp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	rsp.Header.Set("Access-Control-Allow-Origin", "*")
+	rsp.Header.Set("Vary", "Accept")
+	return rsp, []byte("{}"), nil
+}
+
+func TestCORSPreflight(t *testing.T) {
+	h := setupCORS(t)
+	defer func() {
+		apply, _ := service.PreparePolicies(viper.New())
+		apply()
+	}()
+
+	tests := []struct {
+		path    string
+		origin  string
+		method  string
+		headers string
+		status  int
+	}{
+		{"/service/test/items", "https://app.example.com", "PUT", "API-Key, Content-Type", http.StatusNoContent},
+		{"/service/test/items", "https://evil.com", "PUT", "", http.StatusForbidden},
+		{"/service/test/items", "https://app.example.com", "DELETE", "", http.StatusForbidden},
+		{"/service/test/items", "https://app.example.com", "GET", "secret-key", http.StatusForbidden},
+		{"/service/other/items", "https://app.example.com", "GET", "", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodOptions, test.path, nil)
+		req.Header.Set("Origin", test.origin)
+		req.Header.Set("Access-Control-Request-Method", test.method)
+		if test.headers != "" {
+			req.Header.Set("Access-Control-Request-Headers", test.headers)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("handler returned wrong status code for %v %v: got %v want %v", test.origin, test.method, rr.Code, test.status)
+		}
+		allowed := rr.Header().Get("Access-Control-Allow-Origin")
+		if (test.status == http.StatusNoContent) != (allowed == test.origin) {
+			t.Errorf("handler returned wrong allowed origin for %v: got %v", test.origin, allowed)
+		}
+	}
+
+	req := httptest.NewRequest(http.MethodOptions, "/service/test/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("Access-Control-Request-Method", "PUT")
+	req.Header.Set("Access-Control-Request-Headers", "api-key")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	expected := map[string]string{
+		"Access-Control-Allow-Methods":     "GET, PUT",
+		"Access-Control-Allow-Headers":     "api-key",
+		"Access-Control-Allow-Credentials": "true",
+		"Access-Control-Max-Age":           "3600",
+	}
+	for key, val := range expected {
+		if got := rr.Header().Get(key); got != val {
+			t.Errorf("handler returned wrong %v: got %v want %v", key, got, val)
+		}
+	}
+}
+
+func TestCORSRequest(t *testing.T) {
+	h := setupCORS(t)
+	defer func() {
+		apply, _ := service.PreparePolicies(viper.New())
+		apply()
+	}()
+
+	tests := []struct {
+		origin  string
+		key     string
+		status  int
+		allowed string
+	}{
+		{"https://app.example.com", "test", http.StatusOK, "https://app.example.com"},
+		{"https://app.example.com", "wrong", http.StatusForbidden, "https://app.example.com"},
+		{"https://evil.com", "test", http.StatusOK, ""},
+		{"", "test", http.StatusOK, "*"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/service/test/items", nil)
+		if test.origin != "" {
+			req.Header.Set("Origin", test.origin)
+		}
+		req.Header.Set("api-key", test.key)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("handler returned wrong status code for %v: got %v want %v", test.origin, rr.Code, test.status)
+		}
+		if got := rr.Header().Get("Access-Control-Allow-Origin"); got != test.allowed {
+			t.Errorf("handler returned wrong allowed origin for %v: got %v want %v", test.origin, got, test.allowed)
+		}
+		if test.allowed == test.origin && rr.Header().Get("Access-Control-Expose-Headers") != "X-Request-Id" {
+			t.Errorf("handler did not expose headers for %v: got %v", test.origin, rr.Header())
+		}
+	}
+
+	// the Vary of the gateway is kept
+	req := httptest.NewRequest(http.MethodGet, "/service/test/items", nil)
+	req.Header.Set("Origin", "https://app.example.com")
+	req.Header.Set("api-key", "test")
+	rr := httptest.NewRecorder()
+	h.ServeHTTP(rr, req)
+	if vary := rr.Header().Values("Vary"); len(vary) != 2 || vary[0] != "Origin" || vary[1] != "Accept" {
+		t.Errorf("handler returned wrong Vary: got %v", vary)
+	}
+}
@@ -0,0 +1,310 @@
+package handler
+
+import (
+	"html/template"
+	"net/http"
+	"sort"
+	"strings"
+	"sync"
+
+	"github.com/dtan44/SMUG/service"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	gatewayTag  = "gateway"
+	specVersion = "3.0.3"
+)
+
+var (
+	endpoints     []endpoint
+	endpointsLock sync.RWMutex
+	catalogCache  docsCache
+	docsPage      *template.Template
+	specMethods   []string
+)
+
+func init() {
+	docsPage = template.Must(template.New("docs").Parse(docsTemplate))
+	specMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+}
+
+//Endpoint documentation of a gateway endpoint
+//  Path defaults to the pattern, subtree patterns take a {name} parameter
+//  Auth defaults to the auth policy of the handler, MethodAuth overrides it
+//  for single methods
+type Endpoint struct {
+	Path       string
+	Summary    string
+	Auth       string
+	MethodAuth map[string]string
+}
+
+type endpoint struct {
+	Endpoint
+	methods []string
+}
+
+type docsCache struct {
+	lock    sync.Mutex
+	index   uint64
+	count   int
+	valid   bool
+	catalog map[string]interface{}
+	body    []byte
+}
+
+//Handle serve h at pattern behind the middleware and document the endpoint
+//  in the generated spec
+func (ch CommonHandler) Handle(pattern string, doc Endpoint, h http.Handler) {
+	if doc.Path == "" {
+		doc.Path = pattern
+		if strings.HasSuffix(pattern, "/") {
+			doc.Path += "{name}"
+		}
+	}
+	if doc.Auth == "" {
+		doc.Auth = service.AuthAPIKey
+		if ch.AuthPolicy != nil {
+			if r, err := http.NewRequest(http.MethodGet, pattern, nil); err == nil {
+				doc.Auth = ch.AuthPolicy(r)
+			}
+		}
+	}
+
+	endpointsLock.Lock()
+	endpoints = append(endpoints, endpoint{doc, ch.AllowedMethods})
+	endpointsLock.Unlock()
+
+	http.Handle(pattern, ch.ApplyMiddleware(h))
+}
+
+//gatewaySpec OpenAPI document of the endpoints registered with Handle
+func gatewaySpec() (map[string]interface{}, int) {
+	endpointsLock.RLock()
+	defer endpointsLock.RUnlock()
+
+	paths := make(map[string]interface{})
+	for _, e := range endpoints {
+		item, ok := paths[e.Path].(map[string]interface{})
+		if !ok {
+			item = make(map[string]interface{})
+			paths[e.Path] = item
+		}
+
+		var params []interface{}
+		for _, segment := range strings.Split(e.Path, "/") {
+			if strings.HasPrefix(segment, "{") && strings.HasSuffix(segment, "}") {
+				params = append(params, map[string]interface{}{
+					"name":     segment[1 : len(segment)-1],
+					"in":       "path",
+					"required": true,
+					"schema":   map[string]interface{}{"type": "string"},
+				})
+			}
+		}
+
+		for _, method := range e.methods {
+			if !specMethod(method) {
+				continue
+			}
+			auth := e.Auth
+			if a, ok := e.MethodAuth[method]; ok {
+				auth = a
+			}
+			op := map[string]interface{}{
+				"summary":   e.Summary,
+				"tags":      []interface{}{gatewayTag},
+				"security":  service.SecurityRequirement(auth),
+				"responses": map[string]interface{}{"200": map[string]interface{}{"description": "OK"}},
+			}
+			if params != nil {
+				op["parameters"] = params
+			}
+			item[strings.ToLower(method)] = op
+		}
+	}
+
+	apiKey := func(name string) map[string]interface{} {
+		return map[string]interface{}{"type": "apiKey", "in": "header", "name": name}
+	}
+	return map[string]interface{}{
+		"openapi": specVersion,
+		"info": map[string]interface{}{
+			"title":   "SMUG",
+			"version": "1.0.0",
+		},
+		"tags": []interface{}{
+			map[string]interface{}{"name": gatewayTag, "description": "Simple Microservice Universal Gateway"},
+		},
+		"paths": paths,
+		"components": map[string]interface{}{
+			"securitySchemes": map[string]interface{}{
+				service.AuthAPIKey:    apiKey(service.AuthAPIKey),
+				service.AuthSecretKey: apiKey(service.AuthSecretKey),
+			},
+		},
+	}, len(endpoints)
+}
+
+//catalog combined spec of the gateway and registered services
+//  rebuilt only when the registry or the endpoints change
+func (sh ServiceHandler) catalog() (map[string]interface{}, []byte, error) {
+	catalogCache.lock.Lock()
+	defer catalogCache.lock.Unlock()
+
+	index := sh.Catalog.Index()
+	gateway, count := gatewaySpec()
+	if catalogCache.valid && catalogCache.index == index && catalogCache.count == count {
+		return catalogCache.catalog, catalogCache.body, nil
+	}
+
+	catalog := sh.Catalog.Catalog(gateway)
+	body, err := jsonMarshal(catalog)
+	if err != nil {
+		return nil, nil, err
+	}
+	catalogCache.index, catalogCache.count, catalogCache.valid = index, count, true
+	catalogCache.catalog, catalogCache.body = catalog, body
+	return catalog, body, nil
+}
+
+//HandleDocs serve the OpenAPI catalog of the gateway and its services
+//  browsers and ?format=html get an HTML page listing the operations
+func (sh ServiceHandler) HandleDocs(w http.ResponseWriter, r *http.Request) {
+	catalog, body, err := sh.catalog()
+	if err != nil {
+		log.Error("HandleDocs Error: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	format := r.URL.Query().Get("format")
+	if format == "html" || (format == "" && strings.Contains(r.Header.Get("Accept"), "text/html")) {
+		w.Header().Set("Content-Type", "text/html; charset=utf-8")
+		if err := docsPage.Execute(w, docsGroups(catalog)); err != nil {
+			log.Error("HandleDocs Error: " + err.Error())
+		}
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(body)
+}
+
+type docsGroup struct {
+	Name        string
+	Description string
+	Operations  []docsOperation
+}
+
+type docsOperation struct {
+	Method  string
+	Path    string
+	Summary string
+	Auth    string
+}
+
+//docsGroups operations of the catalog grouped by their first tag
+func docsGroups(catalog map[string]interface{}) []docsGroup {
+	var groups []docsGroup
+	index := make(map[string]int)
+	tags, _ := catalog["tags"].([]interface{})
+	for _, t := range tags {
+		tag, _ := t.(map[string]interface{})
+		name, _ := tag["name"].(string)
+		description, _ := tag["description"].(string)
+		index[name] = len(groups)
+		groups = append(groups, docsGroup{Name: name, Description: description})
+	}
+
+	paths, _ := catalog["paths"].(map[string]interface{})
+	sorted := make([]string, 0, len(paths))
+	for path := range paths {
+		sorted = append(sorted, path)
+	}
+	sort.Strings(sorted)
+
+	for _, path := range sorted {
+		item, _ := paths[path].(map[string]interface{})
+		for _, method := range specMethods {
+			op, ok := item[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			group := ""
+			if opTags, ok := op["tags"].([]interface{}); ok && len(opTags) > 0 {
+				group, _ = opTags[0].(string)
+			}
+			i, ok := index[group]
+			if !ok {
+				i = len(groups)
+				index[group] = i
+				groups = append(groups, docsGroup{Name: group})
+			}
+
+			summary, _ := op["summary"].(string)
+			if summary == "" {
+				summary, _ = op["operationId"].(string)
+			}
+			groups[i].Operations = append(groups[i].Operations, docsOperation{
+				Method:  strings.ToUpper(method),
+				Path:    path,
+				Summary: summary,
+				Auth:    securityName(op["security"]),
+			})
+		}
+	}
+	return groups
+}
+
+//specMethod check if OpenAPI can describe an operation of method
+func specMethod(method string) bool {
+	for _, m := range specMethods {
+		if strings.EqualFold(m, method) {
+			return true
+		}
+	}
+	return false
+}
+
+//securityName auth policy of an operation's security requirement
+func securityName(security interface{}) string {
+	requirements, _ := security.([]interface{})
+	if len(requirements) == 0 {
+		return service.AuthPublic
+	}
+	first, _ := requirements[0].(map[string]interface{})
+	if _, ok := first[service.AuthSecretKey]; ok {
+		return service.AuthSecretKey
+	}
+	return service.AuthAPIKey
+}
+
+const docsTemplate = `<!DOCTYPE html>
+<html>
+<head>
+<meta charset="utf-8">
+<title>SMUG API Catalog</title>
+<style>
+body { font-family: sans-serif; margin: 2em; color: #333; }
+table { border-collapse: collapse; width: 100%; margin-bottom: 2em; }
+td, th { border-bottom: 1px solid #ddd; padding: 0.4em; text-align: left; }
+.method { font-family: monospace; font-weight: bold; width: 6em; }
+.path { font-family: monospace; }
+</style>
+</head>
+<body>
+<h1>SMUG API Catalog</h1>
+<p><a href="?format=json">OpenAPI document</a></p>
+{{range .}}
+<h2>{{.Name}}</h2>
+{{if .Description}}<p>{{.Description}}</p>{{end}}
+<table>
+<tr><th>Method</th><th>Path</th><th>Summary</th><th>Auth</th></tr>
+{{range .Operations}}<tr><td class="method">{{.Method}}</td><td class="path">{{.Path}}</td><td>{{.Summary}}</td><td>{{.Auth}}</td></tr>
+{{end}}</table>
+{{end}}
+</body>
+</html>
+`
@@ -0,0 +1,87 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type CatalogMock struct {
+	index *uint64
+	calls *int
+}
+
+func (cm CatalogMock) Index() uint64 {
+	return *cm.index
+}
+
+func (cm CatalogMock) Catalog(gateway map[string]interface{}) map[string]interface{} {
+	*cm.calls++
+	paths := gateway["paths"].(map[string]interface{})
+	paths["/service/users/users/{id}"] = map[string]interface{}{
+		"get": map[string]interface{}{"operationId": "getUser", "tags": []interface{}{"users"}, "security": []interface{}{}},
+	}
+	return gateway
+}
+
+func TestHandleDocs(t *testing.T) {
+	setupServiceHandler()
+	var index uint64
+	var calls int
+	var sh ServiceHandler
+	sh.Catalog = CatalogMock{&index, &calls}
+	catalogCache = docsCache{}
+
+	var ch CommonHandler
+	ch.AllowedMethods = []string{http.MethodGet, http.MethodConnect}
+	ch.Handle("/docs-test/", Endpoint{Summary: "Test endpoint"}, http.HandlerFunc(sh.HandleDocs))
+
+	req, _ := http.NewRequest("GET", "/docs", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleDocs).ServeHTTP(rr, req)
+
+	if rr.Code != http.StatusOK || rr.Header().Get("Content-Type") != "application/json" {
+		t.Fatalf("handler returned unexpected response: got %v %v", rr.Code, rr.Header().Get("Content-Type"))
+	}
+	var doc map[string]interface{}
+	if err := json.Unmarshal(rr.Body.Bytes(), &doc); err != nil {
+		t.Fatal(err)
+	}
+	item, ok := doc["paths"].(map[string]interface{})["/docs-test/{name}"].(map[string]interface{})
+	if !ok || len(item) != 1 {
+		t.Fatalf("handler returned unexpected endpoint: got %v", item)
+	}
+	op := item["get"].(map[string]interface{})
+	if op["summary"] != "Test endpoint" || len(op["parameters"].([]interface{})) != 1 {
+		t.Errorf("handler returned unexpected operation: got %v", op)
+	}
+
+	// the catalog is only rebuilt when the registry changes
+	http.HandlerFunc(sh.HandleDocs).ServeHTTP(httptest.NewRecorder(), req)
+	index++
+	http.HandlerFunc(sh.HandleDocs).ServeHTTP(httptest.NewRecorder(), req)
+	if calls != 2 {
+		t.Errorf("handler built the catalog unexpected times: got %v want %v", calls, 2)
+	}
+
+	req.Header.Set("Accept", "text/html,application/xhtml+xml")
+	rr = httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleDocs).ServeHTTP(rr, req)
+	body := rr.Body.String()
+	if !strings.HasPrefix(rr.Header().Get("Content-Type"), "text/html") ||
+		!strings.Contains(body, "/service/users/users/{id}") || !strings.Contains(body, "<h2>users</h2>") {
+		t.Errorf("handler returned unexpected page: got %v", body)
+	}
+}
+
+func TestSecurityName(t *testing.T) {
+	for _, auth := range []string{service.AuthPublic, service.AuthAPIKey, service.AuthSecretKey} {
+		if got := securityName(service.SecurityRequirement(auth)); got != auth {
+			t.Errorf("function returned unexpected policy: got %v want %v", got, auth)
+		}
+	}
+}
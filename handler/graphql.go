@@ -0,0 +1,63 @@
+package handler
+
+import (
+	"encoding/json"
+	"net/http"
+
+	"github.com/dtan44/SMUG/service"
+	log "github.com/sirupsen/logrus"
+)
+
+//HandleGraphQL serve the stitched schema of the registered GraphQL services
+//  requests are GET with query, operationName and variables parameters or
+//  POST with a JSON body, each service namespace is checked against the
+//  auth policy of the service
+func (sh ServiceHandler) HandleGraphQL(w http.ResponseWriter, r *http.Request) {
+	var req service.GraphQLRequest
+	if r.Method == http.MethodGet {
+		query := r.URL.Query()
+		req.Query = query.Get("query")
+		req.OperationName = query.Get("operationName")
+		if vars := query.Get("variables"); vars != "" {
+			if err := json.Unmarshal([]byte(vars), &req.Variables); err != nil {
+				writeGraphQL(w, http.StatusBadRequest, graphQLError("invalid variables: "+err.Error()))
+				return
+			}
+		}
+	} else if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphQLError("invalid request body: "+err.Error()))
+		return
+	}
+
+	authorize := func(routed *http.Request) bool {
+		return authorized(routed, service.AuthPolicy(routed))
+	}
+	res, err := sh.GraphQL.GraphQL(r, req, authorize)
+	if err == service.ErrGraphQLMethod {
+		w.Header().Set("Allow", http.MethodPost)
+		writeGraphQL(w, http.StatusMethodNotAllowed, graphQLError(err.Error()))
+		return
+	}
+	if err != nil {
+		writeGraphQL(w, http.StatusBadRequest, graphQLError(err.Error()))
+		return
+	}
+	writeGraphQL(w, http.StatusOK, res)
+}
+
+func graphQLError(msg string) service.GraphQLResponse {
+	return service.GraphQLResponse{Errors: []service.GraphQLError{{Message: msg}}}
+}
+
+func writeGraphQL(w http.ResponseWriter, status int, res service.GraphQLResponse) {
+	j, err := jsonMarshal(res)
+	if err != nil {
+		log.Error("HandleGraphQL Error: " + err.Error())
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
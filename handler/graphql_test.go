@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"strings"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type GraphQLMock struct {
+	req       *service.GraphQLRequest
+	authorize *func(*http.Request) bool
+	res       service.GraphQLResponse
+	err       error
+}
+
+func (gm GraphQLMock) GraphQL(r *http.Request, req service.GraphQLRequest, authorize func(*http.Request) bool) (service.GraphQLResponse, error) {
+	*gm.req = req
+	*gm.authorize = authorize
+	return gm.res, gm.err
+}
+
+func TestHandleGraphQL(t *testing.T) {
+	query := url.Values{"query": {"{ users { id } }"}, "variables": {`{"id":"u1"}`}}.Encode()
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		res      service.GraphQLResponse
+		err      error
+		status   int
+		expected string
+	}{
+		{
+			"GET", "/graphql?" + query, "",
+			service.GraphQLResponse{Data: map[string]interface{}{"users": map[string]interface{}{"id": "u1"}}}, nil,
+			http.StatusOK,
+			`{"data":{"users":{"id":"u1"}}}`,
+		},
+		{
+			"POST", "/graphql", `{"query":"{ users { id } }","variables":{"id":"u1"}}`,
+			service.GraphQLResponse{
+				Data:   map[string]interface{}{"users": nil},
+				Errors: []service.GraphQLError{{Message: "Forbidden", Path: []interface{}{"users"}}},
+			}, nil,
+			http.StatusOK,
+			`{"data":{"users":null},"errors":[{"message":"Forbidden","path":["users"]}]}`,
+		},
+		{
+			"POST", "/graphql", `{"query":`,
+			service.GraphQLResponse{}, nil,
+			http.StatusBadRequest,
+			`{"errors":[{"message":"invalid request body: unexpected EOF"}]}`,
+		},
+		{
+			"GET", "/graphql?query=mutation", "",
+			service.GraphQLResponse{}, service.ErrGraphQLMethod,
+			http.StatusMethodNotAllowed,
+			`{"errors":[{"message":"Mutations Require POST"}]}`,
+		},
+		{
+			"POST", "/graphql", `{"query":"{"}`,
+			service.GraphQLResponse{}, errors.New("Syntax Error: expected name"),
+			http.StatusBadRequest,
+			`{"errors":[{"message":"Syntax Error: expected name"}]}`,
+		},
+	}
+
+	for _, test := range tests {
+		setupServiceHandler()
+		var req service.GraphQLRequest
+		var authorize func(*http.Request) bool
+		var sh ServiceHandler
+		sh.GraphQL = GraphQLMock{&req, &authorize, test.res, test.err}
+
+		r, err := http.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(sh.HandleGraphQL).ServeHTTP(rr, r)
+
+		if rr.Code != test.status {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.status)
+		}
+		if body := strings.TrimSpace(rr.Body.String()); body != test.expected {
+			t.Errorf("handler returned unexpected body: got %v want %v", body, test.expected)
+		}
+		if test.status == http.StatusOK && (req.Query != "{ users { id } }" || req.Variables["id"] != "u1") {
+			t.Errorf("handler passed wrong request: got %v", req)
+		}
+	}
+}
+
+func TestHandleGraphQLAuthorize(t *testing.T) {
+	setUpMiddleWare()
+	var req service.GraphQLRequest
+	var authorize func(*http.Request) bool
+	var sh ServiceHandler
+	sh.GraphQL = GraphQLMock{&req, &authorize, service.GraphQLResponse{}, nil}
+
+	r, _ := http.NewRequest("POST", "/graphql", strings.NewReader(`{"query":"{ a }"}`))
+	http.HandlerFunc(sh.HandleGraphQL).ServeHTTP(httptest.NewRecorder(), r)
+
+	routed, _ := http.NewRequest("POST", "/service/users/graphql", nil)
+	if authorize(routed) {
+		t.Errorf("handler authorized a request without keys")
+	}
+	routed.Header.Set("api-key", "test")
+	if !authorize(routed) {
+		t.Errorf("handler rejected a request with the api key")
+	}
+}
@@ -0,0 +1,36 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+const (
+	servicePath = "/service/"
+)
+
+//GRPCPaths serve gRPC requests made to the gateway's own paths
+//  /{package.Service}/{Method} is routed as
+//  /service/{package.Service}/{package.Service}/{Method}, so gRPC services
+//  register under their full service name, other requests are not found,
+//  making this the catch-all handler of the gateway
+func GRPCPaths(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if !service.IsGRPC(r) {
+			writeError(w, r, http.StatusNotFound, "Not Found")
+			return
+		}
+
+		name := strings.SplitN(strings.TrimPrefix(r.URL.Path, "/"), "/", 2)[0]
+		routed := r.WithContext(r.Context())
+		u := *r.URL
+		u.Path = servicePath + name + r.URL.Path
+		if r.URL.RawPath != "" {
+			u.RawPath = servicePath + name + r.URL.RawPath
+		}
+		routed.URL = &u
+		next.ServeHTTP(w, routed)
+	})
+}
@@ -0,0 +1,113 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type StreamMock struct {
+	paths *[]string
+}
+
+func (sm StreamMock) Stream(w http.ResponseWriter, r *http.Request) {
+	*sm.paths = append(*sm.paths, r.URL.Path)
+	w.Header().Set("Grpc-Status", "0")
+}
+
+type DiscoveryRouteTrailerMock struct {
+	DiscoveryRouteMock
+}
+
+func (dm DiscoveryRouteTrailerMock) Route(r *http.Request) (*http.Response, []byte, error) {
+	rsp := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	rsp.Trailer = http.Header{"Grpc-Status": {"0"}}
+	return rsp, []byte("{}"), nil
+}
+
+func TestHandleRouteStream(t *testing.T) {
+	setupServiceHandler()
+	var paths []string
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryRouteTrailerMock{}
+	sh.Stream = StreamMock{&paths}
+	h := GRPCPaths(http.HandlerFunc(sh.HandleRoute))
+
+	tests := []struct {
+		path        string
+		contentType string
+		status      int
+		streamed    string
+	}{
+		{"/helloworld.Greeter/SayHello", "application/grpc", http.StatusOK, "/service/helloworld.Greeter/helloworld.Greeter/SayHello"},
+		{"/helloworld.Greeter/SayHello", "application/json", http.StatusNotFound, ""},
+	}
+
+	for _, test := range tests {
+		paths = nil
+		req, err := http.NewRequest("POST", test.path, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("Content-Type", test.contentType)
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if rr.Code != test.status {
+			t.Errorf("handler returned wrong status code: got %v want %v", rr.Code, test.status)
+		}
+		if test.status == http.StatusNotFound && rr.Body.String() != `{"result":"failure","reason":"Not Found"}` {
+			t.Errorf("handler returned unexpected body: got %v", rr.Body.String())
+		}
+		if test.streamed != "" && (len(paths) != 1 || paths[0] != test.streamed) {
+			t.Errorf("handler streamed wrong path: got %v want %v", paths, test.streamed)
+		}
+		if test.streamed == "" && len(paths) != 0 {
+			t.Errorf("handler streamed a request that is not gRPC: got %v", paths)
+		}
+	}
+
+	// buffered routes pass the trailers of the service
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleRoute).ServeHTTP(rr, req)
+	if status := rr.Result().Trailer.Get("Grpc-Status"); status != "0" || len(paths) != 0 {
+		t.Errorf("handler did not pass the trailers: got %q want %q", status, "0")
+	}
+}
+
+func TestLimitRequestStream(t *testing.T) {
+	setupLimits(1024, 100)
+	ch := CommonHandler{Limits: func(r *http.Request) service.Limits { return service.Limits{MaxBodyBytes: 4} }}
+
+	var read []byte
+	var readErr error
+	h := ch.limitRequest(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		read = make([]byte, 8)
+		var n int
+		n, readErr = r.Body.Read(read)
+		read = read[:n]
+	}))
+
+	req, _ := http.NewRequest("POST", "/service/test/", &chunkedReader{data: []byte("12345678")})
+	req.Header.Set("Content-Type", "application/grpc")
+	req.ContentLength = -1
+	h.ServeHTTP(httptest.NewRecorder(), req)
+
+	// the stream reaches the handler and is cut at the limit as it is read
+	if string(read) != "1234" || readErr == nil {
+		t.Errorf("limitRequest returned wrong body: got %q %v want %q and an error", read, readErr, "1234")
+	}
+}
+
+type chunkedReader struct {
+	data []byte
+}
+
+func (cr *chunkedReader) Read(p []byte) (int, error) {
+	n := copy(p, cr.data)
+	cr.data = cr.data[n:]
+	return n, nil
+}
@@ -1,11 +1,18 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
+	"errors"
+	"io"
+	"net"
 	"net/http"
 	"strings"
+	"syscall"
 
+	"github.com/dtan44/SMUG/metrics"
 	"github.com/dtan44/SMUG/service"
+	log "github.com/sirupsen/logrus"
 )
 
 // Global variables
@@ -30,36 +37,52 @@ type Result struct {
 type ServiceHandler struct {
 	Registration service.RegistrationInterface
 	Discovery    service.DiscoveryInterface
+	Watch        service.WatchInterface
+	Traffic      service.TrafficInterface
+	Catalog      service.CatalogInterface
+	Aggregator   service.AggregateInterface
+	GraphQL      service.GraphQLInterface
+	Stream       service.StreamInterface
 }
 
-type registerBody struct {
-	URL string `json:"URL"`
+//fieldResult JSON response body with field-level detail
+type fieldResult struct {
+	Result
+	Fields []service.FieldError `json:"fields,omitempty"`
+}
+
+//errorResult JSON response body of a failed request
+//  RequestID is the X-Request-Id of the request
+type errorResult struct {
+	fieldResult
+	RequestID string `json:"request_id,omitempty"`
 }
 
 //HandleRegister register service
 func (sh ServiceHandler) HandleRegister(w http.ResponseWriter, r *http.Request) {
 	serviceName := strings.TrimPrefix(r.URL.Path, registerPath)
 	secretKey := r.Header.Get("secret-key")
-	var res Result
+	var res fieldResult
 
-	// get serviceURL from request body
-	requestBody := registerBody{}
-	err := readJSONBody(r.Body, &requestBody)
+	// get service instance from request body
+	instance := service.Instance{}
+	err := readJSONBody(r.Body, &instance)
 	if err != nil {
-		res = Result{"failure", err.Error()}
+		res.Result = Result{"failure", err.Error()}
 	} else {
-		serviceURL := requestBody.URL
-
 		if !validateKey(secretKey) {
 			// http.Error(w, "Incorrect Key", http.StatusInternalServerError)
-			res = Result{"failure", "Incorrect Key"}
+			res.Result = Result{"failure", "Incorrect Key"}
 		} else {
-			err := sh.Registration.Register(serviceName, serviceURL)
+			err := sh.Registration.Register(serviceName, instance)
 
-			if err != nil {
-				res = Result{"failure", err.Error()}
+			if ve, ok := err.(*service.ValidationError); ok {
+				res.Result = Result{"failure", ve.Error()}
+				res.Fields = ve.Fields
+			} else if err != nil {
+				res.Result = Result{"failure", err.Error()}
 			} else {
-				res = Result{"success", ""}
+				res.Result = Result{"success", ""}
 			}
 		}
 	}
@@ -105,12 +128,17 @@ func (sh ServiceHandler) HandleDeregister(w http.ResponseWriter, r *http.Request
 
 //ServicesList List of Services
 type ServicesList struct {
-	Services []string `json:"services"`
+	Services []string                      `json:"services"`
+	Details  map[string][]service.Instance `json:"details,omitempty"`
 }
 
 //HandleList list services
+//  ?detail=true includes registration details of each service
 func (sh ServiceHandler) HandleList(w http.ResponseWriter, r *http.Request) {
-	serviceList := ServicesList{sh.Discovery.List()}
+	serviceList := ServicesList{Services: sh.Discovery.List()}
+	if r.URL.Query().Get("detail") == "true" {
+		serviceList.Details = sh.Discovery.Services()
+	}
 
 	j, err := jsonMarshal(serviceList)
 	if err != nil {
@@ -122,24 +150,50 @@ func (sh ServiceHandler) HandleList(w http.ResponseWriter, r *http.Request) {
 	w.Write(j)
 }
 
+//HandleMetrics expose counters in the Prometheus text format
+func (sh ServiceHandler) HandleMetrics(w http.ResponseWriter, r *http.Request) {
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	if err := metrics.Write(w); err != nil {
+		log.Error("HandleMetrics Error: " + err.Error())
+	}
+}
+
 //HandleRoute route services
+//  gRPC requests are streamed when a Stream service is set
 func (sh ServiceHandler) HandleRoute(w http.ResponseWriter, r *http.Request) {
+	if sh.Stream != nil && service.IsGRPC(r) {
+		sh.Stream.Stream(w, r)
+		return
+	}
+
 	res, body, err := sh.Discovery.Route(r)
+	if ve, ok := err.(*service.ValidationError); ok {
+		writeError(w, r, http.StatusBadRequest, "Invalid Request Body", ve.Fields...)
+		return
+	}
 	if err != nil {
-		resp := Result{"failure", err.Error()}
-		j, err := jsonMarshal(resp)
-		if err != nil {
-			http.Error(w, err.Error(), http.StatusInternalServerError)
-			return
+		status := routeStatus(err)
+		reason := err.Error()
+		switch {
+		case errors.Is(err, service.ErrResponseTooLarge):
+			// the upstream answered, its response is refused
+		case status == http.StatusBadGateway:
+			reason = "Upstream Unreachable"
+		case status == http.StatusGatewayTimeout:
+			reason = "Upstream Timeout"
 		}
-		w.Header().Set("Content-Type", "application/json")
-		w.WriteHeader(http.StatusInternalServerError)
-		w.Write(j)
+		log.WithField("request_id", r.Header.Get(requestIDHeader)).Error("HandleRoute Error: " + err.Error())
+		writeError(w, r, status, reason)
 		return
 	}
 
 	// add all headers (including multi-valued headers)
+	// the CORS headers of the gateway replace those of the service
+	gatewayCORS := r.Context().Value(corsKey{}) != nil
 	for key, vals := range res.Header {
+		if gatewayCORS && strings.HasPrefix(key, "Access-Control-") {
+			continue
+		}
 		val := ""
 		for _, item := range vals {
 			val += item + ","
@@ -147,10 +201,42 @@ func (sh ServiceHandler) HandleRoute(w http.ResponseWriter, r *http.Request) {
 		// remove last comma
 		val = val[:len(val)-1]
 
+		if key == "Vary" {
+			w.Header().Add(key, val)
+			continue
+		}
 		w.Header().Set(key, val)
 	}
 
 	w.WriteHeader(res.StatusCode)
 	w.Header().Set("Content-Type", "application/json")
 	w.Write(body)
+
+	// trailers are only known once the body was read
+	for key, vals := range res.Trailer {
+		w.Header()[http.TrailerPrefix+key] = vals
+	}
+}
+
+//routeStatus HTTP status of a request that could not be routed
+//  services that refuse or drop the connection are unreachable, 502, as are
+//  responses too large to decode, and those that do not answer in time 504
+func routeStatus(err error) int {
+	var ne net.Error
+	var oe *net.OpError
+	var de *net.DNSError
+	switch {
+	case errors.Is(err, service.ErrUnknownService), errors.Is(err, service.ErrNoMatchingVersion),
+		errors.Is(err, service.ErrUnknownPath):
+		return http.StatusNotFound
+	case errors.Is(err, service.ErrMethodNotAllowed):
+		return http.StatusMethodNotAllowed
+	case errors.Is(err, context.DeadlineExceeded), errors.As(err, &ne) && ne.Timeout():
+		return http.StatusGatewayTimeout
+	case errors.Is(err, syscall.ECONNREFUSED), errors.Is(err, syscall.ECONNRESET), errors.Is(err, io.EOF),
+		errors.Is(err, io.ErrUnexpectedEOF), errors.As(err, &de), errors.As(err, &oe) && oe.Op == "dial",
+		errors.Is(err, service.ErrResponseTooLarge):
+		return http.StatusBadGateway
+	}
+	return http.StatusInternalServerError
 }
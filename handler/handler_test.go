@@ -1,14 +1,19 @@
 package handler
 
 import (
+	"context"
 	"encoding/json"
 	"errors"
 	"io"
 	"io/ioutil"
+	"net"
 	"net/http"
 	"net/http/httptest"
+	"net/url"
 	"strings"
 	"testing"
+
+	"github.com/dtan44/SMUG/service"
 )
 
 func setupServiceHandler() {
@@ -16,6 +21,7 @@ func setupServiceHandler() {
 	secretKey = "correct"
 	readAllFunc = ioutil.ReadAll
 	jsonUnmarshal = json.Unmarshal
+	resetDraining()
 }
 
 var (
@@ -32,7 +38,7 @@ type RegisterMock struct {
 	CreateUserWork func() (string, error)
 }
 
-func (rm RegisterMock) Register(serviceName, serviceURL string) error {
+func (rm RegisterMock) Register(serviceName string, instance service.Instance) error {
 	return rm.Work()
 }
 
@@ -206,6 +212,32 @@ func TestHandleRegisterSuccess(t *testing.T) {
 	}
 }
 
+func TestHandleRegisterValidationFail(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Registration = RegisterMock{Work: func() error {
+		return &service.ValidationError{Fields: []service.FieldError{{Field: "weight", Reason: "must be between 0 and 100"}}}
+	}}
+
+	req, err := http.NewRequest("PUT", "/register/test", strings.NewReader(`{"URL" : "http://test", "weight": 101}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("secret-key", "correct")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleRegister)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"result":"failure","reason":"Invalid Fields - weight: must be between 0 and 100",` +
+		`"fields":[{"field":"weight","reason":"must be between 0 and 100"}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
 func TestHandleDeregisterFail(t *testing.T) {
 	setupServiceHandler()
 	var sh ServiceHandler
@@ -345,6 +377,10 @@ func (dm DiscoveryMock) List() []string {
 	return []string{""}
 }
 
+func (dm DiscoveryMock) Services() map[string][]service.Instance {
+	return map[string][]service.Instance{"": {{URL: "http://test/", Version: "1.0.0"}}}
+}
+
 func (dm DiscoveryMock) Route(r *http.Request) (*http.Response, []byte, error) {
 	var rsp http.Response
 	return &rsp, nil, nil
@@ -381,6 +417,28 @@ func TestHandleListSuccess(t *testing.T) {
 	}
 }
 
+func TestHandleListDetailSuccess(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryMock{Work: ReturnNoError}
+
+	req, err := http.NewRequest("GET", "/list?detail=true", strings.NewReader(""))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleList)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"services":[""],"details":{"":[{"URL":"http://test/","version":"1.0.0"}]}}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
 func TestHandleListJSONFail(t *testing.T) {
 	setupServiceHandler()
 	var sh ServiceHandler
@@ -423,6 +481,10 @@ func (dm DiscoveryRouteFailMock) List() []string {
 	return []string{""}
 }
 
+func (dm DiscoveryRouteFailMock) Services() map[string][]service.Instance {
+	return map[string][]service.Instance{"": {{URL: "http://test/", Version: "1.0.0"}}}
+}
+
 func (dm DiscoveryRouteFailMock) Route(r *http.Request) (*http.Response, []byte, error) {
 	var rsp http.Response
 	rsp.Header = http.Header{}
@@ -506,6 +568,10 @@ func (dm DiscoveryRouteMock) List() []string {
 	return []string{""}
 }
 
+func (dm DiscoveryRouteMock) Services() map[string][]service.Instance {
+	return map[string][]service.Instance{"": {{URL: "http://test/", Version: "1.0.0"}}}
+}
+
 func (dm DiscoveryRouteMock) Route(r *http.Request) (*http.Response, []byte, error) {
 	var rsp http.Response
 	rsp.Header = http.Header{}
@@ -545,3 +611,129 @@ func TestHandleRouteSuccess(t *testing.T) {
 			rr.Body.String(), expected)
 	}
 }
+
+type DiscoveryRouteSchemaMock struct {
+	DiscoveryRouteMock
+}
+
+func (dm DiscoveryRouteSchemaMock) Route(r *http.Request) (*http.Response, []byte, error) {
+	return nil, nil, &service.ValidationError{Fields: []service.FieldError{{Field: "/name", Reason: "is required"}}}
+}
+
+func TestHandleRouteSchemaFail(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryRouteSchemaMock{}
+
+	req, err := http.NewRequest("POST", "/service/test/users", strings.NewReader("{}"))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleRoute).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	expected := `{"result":"failure","reason":"Invalid Request Body","fields":[{"field":"/name","reason":"is required"}]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+type DiscoveryRouteErrorMock struct {
+	DiscoveryRouteMock
+	err error
+}
+
+func (dm DiscoveryRouteErrorMock) Route(r *http.Request) (*http.Response, []byte, error) {
+	return nil, nil, dm.err
+}
+
+func TestHandleRouteOperationFail(t *testing.T) {
+	tests := []struct {
+		err    error
+		status int
+	}{
+		{service.ErrUnknownPath, http.StatusNotFound},
+		{service.ErrMethodNotAllowed, http.StatusMethodNotAllowed},
+		{service.ErrResponseTooLarge, http.StatusBadGateway},
+	}
+
+	for _, test := range tests {
+		setupServiceHandler()
+		var sh ServiceHandler
+		sh.Discovery = DiscoveryRouteErrorMock{err: test.err}
+
+		req, err := http.NewRequest("GET", "/service/test/unknown", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+
+		rr := httptest.NewRecorder()
+		http.HandlerFunc(sh.HandleRoute).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, test.status)
+		}
+
+		expected := `{"result":"failure","reason":"` + test.err.Error() + `"}`
+		if rr.Body.String() != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), expected)
+		}
+	}
+}
+
+func TestHandleRouteUpstreamFail(t *testing.T) {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ln.Close()
+	_, refused := http.Get("http://" + ln.Addr().String())
+
+	tests := []struct {
+		err    error
+		status int
+		reason string
+	}{
+		{refused, http.StatusBadGateway, "Upstream Unreachable"},
+		{&url.Error{Op: "Get", URL: "http://test/", Err: io.EOF}, http.StatusBadGateway, "Upstream Unreachable"},
+		{&url.Error{Op: "Get", URL: "http://test/", Err: context.DeadlineExceeded}, http.StatusGatewayTimeout, "Upstream Timeout"},
+		{service.ErrUnknownService, http.StatusNotFound, "Invalid Service Name"},
+		{service.ErrNoMatchingVersion, http.StatusNotFound, "No Matching Version"},
+		{errors.New("test"), http.StatusInternalServerError, "test"},
+	}
+
+	for _, test := range tests {
+		setupServiceHandler()
+		var sh ServiceHandler
+		sh.Discovery = DiscoveryRouteErrorMock{err: test.err}
+
+		req, err := http.NewRequest("GET", "/service/test/", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set(requestIDHeader, "abc-123")
+
+		rr := httptest.NewRecorder()
+		RequestIDs(http.HandlerFunc(sh.HandleRoute)).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.status {
+			t.Errorf("handler returned wrong status code for %v: got %v want %v",
+				test.err, status, test.status)
+		}
+
+		expected := `{"result":"failure","reason":"` + test.reason + `","request_id":"abc-123"}`
+		if rr.Body.String() != expected {
+			t.Errorf("handler returned unexpected body: got %v want %v",
+				rr.Body.String(), expected)
+		}
+	}
+}
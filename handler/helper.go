@@ -7,7 +7,9 @@ import (
 	"io/ioutil"
 	"net"
 	"net/http"
+	"sync"
 
+	"github.com/dtan44/SMUG/service"
 	log "github.com/sirupsen/logrus"
 
 	"github.com/spf13/viper"
@@ -15,6 +17,7 @@ import (
 
 var (
 	secretKey     string
+	keyLock       sync.RWMutex
 	readAllFunc   func(r io.Reader) ([]byte, error)
 	jsonUnmarshal func(data []byte, v interface{}) error
 	parseIP       func(s string) net.IP
@@ -28,12 +31,29 @@ func init() {
 }
 
 func validateKey(key string) bool {
+	keyLock.RLock()
+	defer keyLock.RUnlock()
+
 	if key == secretKey {
 		return true
 	}
 	return false
 }
 
+//PrepareKeys read key.secret and key.api from a candidate config
+func PrepareKeys(v *viper.Viper) (func(), error) {
+	secret, api := v.GetString("key.secret"), v.GetString("key.api")
+	if secret == "" || api == "" {
+		return nil, errors.New("key.secret and key.api are required")
+	}
+
+	return func() {
+		keyLock.Lock()
+		secretKey, apiKey = secret, api
+		keyLock.Unlock()
+	}, nil
+}
+
 func readJSONBody(body io.ReadCloser, t interface{}) error {
 	res, err := readAllFunc(body)
 	if err != nil {
@@ -80,3 +100,17 @@ func getIP(req *http.Request) error {
 	log.Infof("Forwarded for: %s", forward)
 	return nil
 }
+
+//writeError write a failure result with the request ID of r
+func writeError(w http.ResponseWriter, r *http.Request, status int, reason string, fields ...service.FieldError) {
+	res := errorResult{fieldResult{Result{"failure", reason}, fields}, r.Header.Get(requestIDHeader)}
+	j, err := jsonMarshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
@@ -9,6 +9,8 @@ import (
 	"net"
 	"net/http"
 	"testing"
+
+	"github.com/spf13/viper"
 )
 
 func setupHelper() {
@@ -127,3 +129,28 @@ func TestGetIPSuccess(t *testing.T) {
 	}
 	setupHelper()
 }
+
+func TestPrepareKeys(t *testing.T) {
+	setupHelper()
+	defer setUpMiddleWare()
+
+	v := viper.New()
+	if _, err := PrepareKeys(v); err == nil {
+		t.Error("Failed Missing Keys")
+	}
+
+	v.Set("key.secret", "reloaded")
+	v.Set("key.api", "reloaded-api")
+	apply, err := PrepareKeys(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if !validateKey("correct") {
+		t.Error("Failed keys applied before apply")
+	}
+
+	apply()
+	if !validateKey("reloaded") || apiKey != "reloaded-api" {
+		t.Error("Failed to apply reloaded keys")
+	}
+}
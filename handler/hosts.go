@@ -0,0 +1,41 @@
+package handler
+
+import (
+	"net/http"
+	"path"
+	"strings"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+//HostRoutes serve the hosts of services, see service.HostService
+//  orders.api.example.com/{path} is routed as /service/orders/{path},
+//  requests to other hosts go to next
+//  the path is cleaned first, so .. cannot leave the service
+func HostRoutes(routed, next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		name, ok := service.HostService(r.Host)
+		if !ok {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		u := *r.URL
+		u.Path = servicePath + name + cleanPath(r.URL.Path)
+		if r.URL.RawPath != "" {
+			u.RawPath = servicePath + name + cleanPath(r.URL.RawPath)
+		}
+		hosted := r.WithContext(r.Context())
+		hosted.URL = &u
+		routed.ServeHTTP(w, hosted)
+	})
+}
+
+//cleanPath resolve . and .. segments of a path, keeping a trailing slash
+func cleanPath(p string) string {
+	cleaned := path.Clean("/" + p)
+	if strings.HasSuffix(p, "/") && cleaned != "/" {
+		cleaned += "/"
+	}
+	return cleaned
+}
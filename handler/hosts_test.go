@@ -0,0 +1,57 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+	"github.com/spf13/viper"
+)
+
+func TestHostRoutes(t *testing.T) {
+	v := viper.New()
+	v.Set("hosts", []interface{}{map[string]interface{}{"host": "*.api.example.com"}})
+	apply, err := service.PrepareHosts(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	defer func() {
+		apply, _ := service.PrepareHosts(viper.New())
+		apply()
+	}()
+
+	var routedPath, gatewayPath string
+	routed := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { routedPath = r.URL.EscapedPath() })
+	gateway := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { gatewayPath = r.URL.Path })
+	h := HostRoutes(routed, gateway)
+
+	tests := []struct {
+		host    string
+		path    string
+		routed  string
+		gateway string
+	}{
+		{"orders.api.example.com", "/items/1?full=true", "/service/orders/items/1", ""},
+		{"orders.api.example.com", "/a%2Fb", "/service/orders/a%2Fb", ""},
+		{"orders.api.example.com", "/service/users/list", "/service/orders/service/users/list", ""},
+		{"orders.api.example.com", "/../../service/users/list", "/service/orders/service/users/list", ""},
+		{"orders.api.example.com", "/items/./1/../2/", "/service/orders/items/2/", ""},
+		{"orders.api.example.com", "/%2E%2E/users", "/service/orders/users", ""},
+		{"Orders.API.example.com", "/", "/service/orders/", ""},
+		{"gateway.example.com", "/service/users/list", "", "/service/users/list"},
+	}
+
+	for _, test := range tests {
+		routedPath, gatewayPath = "", ""
+		req := httptest.NewRequest(http.MethodGet, test.path, nil)
+		req.Host = test.host
+		h.ServeHTTP(httptest.NewRecorder(), req)
+
+		if routedPath != test.routed || gatewayPath != test.gateway {
+			t.Errorf("handler routed %v%v wrong: got %v %v want %v %v", test.host, test.path,
+				routedPath, gatewayPath, test.routed, test.gateway)
+		}
+	}
+}
@@ -0,0 +1,85 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+	"sync"
+)
+
+var (
+	drainCh    chan struct{}
+	drainLock  sync.Mutex
+	checks     []readinessCheck
+	checksLock sync.RWMutex
+)
+
+func init() {
+	drainCh = make(chan struct{})
+}
+
+type readinessCheck struct {
+	name  string
+	check func() error
+}
+
+//AddReadinessCheck add a named check that must pass for /readyz to report ready
+func AddReadinessCheck(name string, check func() error) {
+	checksLock.Lock()
+	defer checksLock.Unlock()
+	checks = append(checks, readinessCheck{name, check})
+}
+
+//StartDraining report not ready and end long-lived watch requests
+func StartDraining() {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+	select {
+	case <-drainCh:
+	default:
+		close(drainCh)
+	}
+}
+
+//drained channel closed once draining has started
+func drained() <-chan struct{} {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+	return drainCh
+}
+
+func isDraining() bool {
+	select {
+	case <-drained():
+		return true
+	default:
+		return false
+	}
+}
+
+//HandleLive report that the gateway process is up
+func (sh ServiceHandler) HandleLive(w http.ResponseWriter, r *http.Request) {
+	writeResult(w, http.StatusOK, Result{"success", ""})
+}
+
+//HandleReady report whether the gateway accepts traffic
+//  returns 503 listing failed checks, or once draining has started
+func (sh ServiceHandler) HandleReady(w http.ResponseWriter, r *http.Request) {
+	var failures []string
+	if isDraining() {
+		failures = append(failures, "Draining")
+	}
+
+	checksLock.RLock()
+	for _, c := range checks {
+		if err := c.check(); err != nil {
+			failures = append(failures, c.name+": "+err.Error())
+		}
+	}
+	checksLock.RUnlock()
+
+	if len(failures) > 0 {
+		writeError(w, r, http.StatusServiceUnavailable, strings.Join(failures, "; "))
+		return
+	}
+	writeResult(w, http.StatusOK, Result{"success", ""})
+}
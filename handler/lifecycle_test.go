@@ -0,0 +1,133 @@
+package handler
+
+import (
+	"errors"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+//resetDraining accept traffic again after StartDraining
+func resetDraining() {
+	drainLock.Lock()
+	defer drainLock.Unlock()
+	drainCh = make(chan struct{})
+}
+
+func TestHandleLive(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	StartDraining()
+
+	req, err := http.NewRequest("GET", "/healthz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleLive).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+}
+
+func TestHandleReadyChecks(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	defer func() { checks = nil }()
+	AddReadinessCheck("registry", func() error { return nil })
+	AddReadinessCheck("listener", func() error { return errors.New("not listening") })
+
+	req, err := http.NewRequest("GET", "/readyz", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleReady).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	expected := `{"result":"failure","reason":"listener: not listening"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleReadyReady(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleReady).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusOK {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusOK)
+	}
+}
+
+func TestHandleReadyDraining(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	StartDraining()
+	StartDraining()
+
+	req, err := http.NewRequest("GET", "/health", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	http.HandlerFunc(sh.HandleReady).ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusServiceUnavailable {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusServiceUnavailable)
+	}
+
+	expected := `{"result":"failure","reason":"Draining"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleWatchEndsOnDrain(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryMock{Work: ReturnNoError}
+	sh.Watch = service.DiscoveryService{}
+
+	req, err := http.NewRequest("GET", "/watch?wait=10m&index=999999", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	done := make(chan struct{})
+	go func() {
+		http.HandlerFunc(sh.HandleWatch).ServeHTTP(httptest.NewRecorder(), req)
+		close(done)
+	}()
+
+	StartDraining()
+	select {
+	case <-done:
+	case <-time.After(time.Second):
+		t.Error("handler kept watching after draining started")
+	}
+}
@@ -0,0 +1,115 @@
+package handler
+
+import (
+	"bytes"
+	"errors"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sync"
+
+	"github.com/dtan44/SMUG/service"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	maxBodyBytesKey     = "limits.max_body_bytes"
+	maxHeadersKey       = "limits.max_headers"
+	defaultMaxBodyBytes = 10 << 20
+	defaultMaxHeaders   = 100
+)
+
+var (
+	maxBodyBytes int64
+	maxHeaders   int
+	limitsLock   sync.RWMutex
+)
+
+func init() {
+	maxBodyBytes = defaultMaxBodyBytes
+	maxHeaders = defaultMaxHeaders
+}
+
+//PrepareLimits read limits.max_body_bytes and limits.max_headers from a candidate config
+func PrepareLimits(v *viper.Viper) (func(), error) {
+	body, headers := int64(defaultMaxBodyBytes), defaultMaxHeaders
+	if v.IsSet(maxBodyBytesKey) {
+		body = v.GetInt64(maxBodyBytesKey)
+	}
+	if v.IsSet(maxHeadersKey) {
+		headers = v.GetInt(maxHeadersKey)
+	}
+	if body <= 0 || headers <= 0 {
+		return nil, errors.New(maxBodyBytesKey + " and " + maxHeadersKey + " must be positive")
+	}
+
+	return func() {
+		limitsLock.Lock()
+		maxBodyBytes, maxHeaders = body, headers
+		limitsLock.Unlock()
+	}, nil
+}
+
+//limitRequest reject requests over the header or body limits
+//  bodies are read up front so handlers never see more than the limit
+func (ch CommonHandler) limitRequest(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		limitsLock.RLock()
+		bodyLimit, headerLimit := maxBodyBytes, maxHeaders
+		limitsLock.RUnlock()
+
+		var limits service.Limits
+		if ch.Limits != nil {
+			limits = ch.Limits(r)
+		}
+		if limits.MaxBodyBytes > 0 {
+			bodyLimit = limits.MaxBodyBytes
+		}
+
+		count := 0
+		for _, vals := range r.Header {
+			count += len(vals)
+		}
+		if count > headerLimit {
+			log.Info("Too many headers: ", count)
+			writeError(w, r, http.StatusRequestHeaderFieldsTooLarge, "Too Many Headers")
+			return
+		}
+
+		if r.Body == nil || r.Body == http.NoBody || r.ContentLength == 0 {
+			next.ServeHTTP(w, r)
+			return
+		}
+
+		if !limits.AllowsContentType(r.Header.Get("Content-Type")) {
+			log.Info("Unsupported content type: " + r.Header.Get("Content-Type"))
+			writeError(w, r, http.StatusUnsupportedMediaType, "Unsupported Media Type")
+			return
+		}
+
+		if r.ContentLength > bodyLimit {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "Request Body Too Large")
+			return
+		}
+		// streams are limited as they are read instead of buffered
+		if service.IsGRPC(r) {
+			r.Body = http.MaxBytesReader(w, r.Body, bodyLimit)
+			next.ServeHTTP(w, r)
+			return
+		}
+		body, err := ioutil.ReadAll(io.LimitReader(r.Body, bodyLimit+1))
+		if err != nil {
+			log.Error("limitRequest Error: " + err.Error())
+			writeError(w, r, http.StatusBadRequest, "Invalid Request Body")
+			return
+		}
+		if int64(len(body)) > bodyLimit {
+			writeError(w, r, http.StatusRequestEntityTooLarge, "Request Body Too Large")
+			return
+		}
+
+		r.Body = ioutil.NopCloser(bytes.NewReader(body))
+		next.ServeHTTP(w, r)
+	})
+}
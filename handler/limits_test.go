@@ -0,0 +1,106 @@
+package handler
+
+import (
+	"io/ioutil"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"strings"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+	"github.com/spf13/viper"
+)
+
+func setupLimits(body int64, headers int) {
+	setUpMiddleWare()
+	maxBodyBytes, maxHeaders = body, headers
+}
+
+func TestMiddleWareLimits(t *testing.T) {
+	defer setupLimits(defaultMaxBodyBytes, defaultMaxHeaders)
+
+	tests := []struct {
+		limits      service.Limits
+		body        string
+		chunked     bool
+		contentType string
+		headers     int
+		expected    int
+	}{
+		{service.Limits{}, "12345", false, "", 0, http.StatusOK},
+		{service.Limits{}, "123456789012", false, "", 0, http.StatusRequestEntityTooLarge},
+		{service.Limits{}, "123456789012", true, "", 0, http.StatusRequestEntityTooLarge},
+		{service.Limits{MaxBodyBytes: 20}, "123456789012", false, "", 0, http.StatusOK},
+		{service.Limits{MaxBodyBytes: 2}, "12345", true, "", 0, http.StatusRequestEntityTooLarge},
+		{service.Limits{}, "", false, "", 5, http.StatusRequestHeaderFieldsTooLarge},
+		{service.Limits{ContentTypes: []string{"application/json"}}, "{}", false, "application/json; charset=utf-8", 0, http.StatusOK},
+		{service.Limits{ContentTypes: []string{"application/json"}}, "<a/>", false, "text/xml", 0, http.StatusUnsupportedMediaType},
+		{service.Limits{ContentTypes: []string{"text/*"}}, "a", false, "text/plain", 0, http.StatusOK},
+		{service.Limits{ContentTypes: []string{"application/json"}}, "", false, "", 0, http.StatusOK},
+	}
+
+	for i, test := range tests {
+		setupLimits(10, 4)
+		limits := test.limits
+		ch := CommonHandler{}
+		ch.AllowedMethods = []string{"POST"}
+		ch.Limits = func(r *http.Request) service.Limits { return limits }
+
+		var received string
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+			b, _ := ioutil.ReadAll(r.Body)
+			received = string(b)
+			w.WriteHeader(http.StatusOK)
+		})
+
+		req, err := http.NewRequest("POST", "/service/test/", strings.NewReader(test.body))
+		if err != nil {
+			t.Fatal(err)
+		}
+		if test.chunked {
+			req.ContentLength = -1
+		}
+		req.Header.Set("api-key", "test")
+		if test.contentType != "" {
+			req.Header.Set("Content-Type", test.contentType)
+		}
+		for h := 0; h < test.headers; h++ {
+			req.Header.Set("X-Test-"+strconv.Itoa(h), "a")
+		}
+
+		rr := httptest.NewRecorder()
+		ch.ApplyMiddleware(next).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("case %d: handler returned wrong status code: got %v want %v",
+				i, status, test.expected)
+		}
+		if test.expected == http.StatusOK && received != test.body {
+			t.Errorf("case %d: handler received unexpected body: got %v want %v",
+				i, received, test.body)
+		}
+	}
+}
+
+func TestPrepareLimits(t *testing.T) {
+	defer setupLimits(defaultMaxBodyBytes, defaultMaxHeaders)
+
+	v := viper.New()
+	v.Set(maxBodyBytesKey, 0)
+	if _, err := PrepareLimits(v); err == nil {
+		t.Error("Failed invalid body limit")
+	}
+
+	v.Set(maxBodyBytesKey, 2048)
+	v.Set(maxHeadersKey, 20)
+	apply, err := PrepareLimits(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if maxBodyBytes != 2048 || maxHeaders != 20 {
+		t.Errorf("function applied unexpected limits: got %v %v want %v %v",
+			maxBodyBytes, maxHeaders, 2048, 20)
+	}
+}
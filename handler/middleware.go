@@ -2,7 +2,9 @@ package handler
 
 import (
 	"net/http"
+	"strings"
 
+	"github.com/dtan44/SMUG/service"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
@@ -14,13 +16,34 @@ func init() {
 }
 
 //CommonHandler shared handler
+//  AuthPolicy picks the key policy of a request, api-key when nil
+//  Limiter rate limits requests before the key check when set
+//  Limits picks the body limit and content types of a request when set
+//  CORS picks the CORS policy of a request when set, it is applied first
+//  Resolve attaches where a request is routed before the key check when set,
+//  so the key is checked for the instance that serves it
 type CommonHandler struct {
 	AllowedMethods []string
+	AuthPolicy     func(r *http.Request) string
+	Resolve        func(r *http.Request) *http.Request
+	Limiter        *RateLimiter
+	Limits         func(r *http.Request) service.Limits
+	CORS           func(r *http.Request) *service.CORSPolicy
 }
 
 //ApplyMiddleware apply middleware
 func (ch CommonHandler) ApplyMiddleware(next http.Handler) http.Handler {
-	return ch.closeBody(ch.checkKey(ch.checkMethods(next)))
+	h := ch.checkKey(ch.limitRequest(ch.checkMethods(next)))
+	if ch.Resolve != nil {
+		h = ch.resolve(h)
+	}
+	if ch.Limiter != nil {
+		h = ch.limitRate(h)
+	}
+	if ch.CORS != nil {
+		h = ch.allowCORS(h)
+	}
+	return ch.closeBody(h)
 }
 
 func (ch CommonHandler) checkMethods(next http.Handler) http.Handler {
@@ -39,10 +62,10 @@ func (ch CommonHandler) checkMethods(next http.Handler) http.Handler {
 			return
 		}
 
-		w.WriteHeader(http.StatusMethodNotAllowed)
 		log.Info(r.Method, "is not allowed")
 		getIP(r)
-		return
+		w.Header().Set("Allow", strings.Join(ch.AllowedMethods, ", "))
+		writeError(w, r, http.StatusMethodNotAllowed, "Method Not Allowed")
 	})
 }
 
@@ -58,14 +81,51 @@ func (ch CommonHandler) closeBody(next http.Handler) http.Handler {
 
 func (ch CommonHandler) checkKey(next http.Handler) http.Handler {
 	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
-		key := r.Header.Get("api-key")
-		if key != apiKey {
-			w.WriteHeader(http.StatusForbidden)
+		policy := service.AuthAPIKey
+		if ch.AuthPolicy != nil {
+			policy = ch.AuthPolicy(r)
+		}
+
+		if !authorized(r, policy) {
 			log.Println("Forbidden access")
 			getIP(r)
+			writeError(w, r, http.StatusForbidden, "Incorrect Key")
 			return
 		}
 
-		next.ServeHTTP(w, r)
+		next.ServeHTTP(w, r.WithContext(service.WithClaims(r.Context(), claims(r, policy))))
+	})
+}
+
+func (ch CommonHandler) resolve(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		next.ServeHTTP(w, ch.Resolve(r))
 	})
 }
+
+//claims of the caller of r, the strongest key it presented and the policy
+//  it was authorized by
+func claims(r *http.Request, policy string) map[string]interface{} {
+	key := service.AuthPublic
+	if authorized(r, service.AuthSecretKey) {
+		key = service.AuthSecretKey
+	} else if authorized(r, service.AuthAPIKey) {
+		key = service.AuthAPIKey
+	}
+	return map[string]interface{}{"key": key, "auth": policy}
+}
+
+//authorized check the keys of r against an auth policy
+func authorized(r *http.Request, policy string) bool {
+	keyLock.RLock()
+	validAPIKey := r.Header.Get("api-key") == apiKey
+	keyLock.RUnlock()
+
+	switch policy {
+	case service.AuthPublic:
+		return true
+	case service.AuthSecretKey:
+		return validAPIKey && validateKey(r.Header.Get("secret-key"))
+	}
+	return validAPIKey
+}
@@ -5,6 +5,8 @@ import (
 	"net/http/httptest"
 	"strings"
 	"testing"
+
+	"github.com/dtan44/SMUG/service"
 )
 
 func setUpMiddleWare() {
@@ -31,6 +33,12 @@ func TestApplyMiddleWare(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusForbidden)
 	}
+
+	expected := `{"result":"failure","reason":"Incorrect Key"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
 }
 
 func TestMiddleWareMethodFail(t *testing.T) {
@@ -53,6 +61,12 @@ func TestMiddleWareMethodFail(t *testing.T) {
 		t.Errorf("handler returned wrong status code: got %v want %v",
 			status, http.StatusMethodNotAllowed)
 	}
+
+	expected := `{"result":"failure","reason":"Method Not Allowed"}`
+	if rr.Body.String() != expected || rr.Header().Get("Allow") != "GET" {
+		t.Errorf("handler returned unexpected body: got %v %v want %v",
+			rr.Body.String(), rr.Header().Get("Allow"), expected)
+	}
 }
 
 func TestMiddleWareSuccess(t *testing.T) {
@@ -76,3 +90,71 @@ func TestMiddleWareSuccess(t *testing.T) {
 			status, http.StatusOK)
 	}
 }
+
+func TestMiddleWareAuthPolicy(t *testing.T) {
+	setUpMiddleWare()
+	setupHelper()
+
+	tests := []struct {
+		policy    string
+		apiKey    string
+		secretKey string
+		expected  int
+	}{
+		{service.AuthPublic, "", "", http.StatusOK},
+		{service.AuthAPIKey, "test", "", http.StatusOK},
+		{service.AuthAPIKey, "", "", http.StatusForbidden},
+		{service.AuthSecretKey, "test", "correct", http.StatusOK},
+		{service.AuthSecretKey, "test", "wrong", http.StatusForbidden},
+	}
+
+	for _, test := range tests {
+		policy := test.policy
+		ch := CommonHandler{}
+		ch.AllowedMethods = []string{"GET"}
+		ch.AuthPolicy = func(r *http.Request) string { return policy }
+
+		req, err := http.NewRequest("GET", "/service/test", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.Header.Set("api-key", test.apiKey)
+		req.Header.Set("secret-key", test.secretKey)
+
+		rr := httptest.NewRecorder()
+		next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+		ch.ApplyMiddleware(next).ServeHTTP(rr, req)
+
+		if status := rr.Code; status != test.expected {
+			t.Errorf("policy %v: handler returned wrong status code: got %v want %v",
+				test.policy, status, test.expected)
+		}
+	}
+}
+
+func TestClaims(t *testing.T) {
+	setUpMiddleWare()
+	setupHelper()
+
+	tests := []struct {
+		apiKey    string
+		secretKey string
+		expected  string
+	}{
+		{"", "correct", service.AuthPublic},
+		{"test", "", service.AuthAPIKey},
+		{"test", "wrong", service.AuthAPIKey},
+		{"test", "correct", service.AuthSecretKey},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/service/test", nil)
+		req.Header.Set("api-key", test.apiKey)
+		req.Header.Set("secret-key", test.secretKey)
+
+		got := claims(req, service.AuthPublic)
+		if got["key"] != test.expected || got["auth"] != service.AuthPublic {
+			t.Errorf("function returned unexpected claims for %v %v: got %v want %v", test.apiKey, test.secretKey, got, test.expected)
+		}
+	}
+}
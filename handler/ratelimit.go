@@ -0,0 +1,124 @@
+package handler
+
+import (
+	"errors"
+	"math"
+	"net"
+	"net/http"
+	"strconv"
+	"sync"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	defaultRate  = 10
+	defaultBurst = 20
+	maxBuckets   = 10000
+)
+
+var now func() time.Time
+
+func init() {
+	now = time.Now
+}
+
+//RateLimiter token bucket per client IP
+//  rate and burst are read from <key>.rps and <key>.burst
+type RateLimiter struct {
+	key     string
+	rate    float64
+	burst   float64
+	buckets map[string]*bucket
+	mux     sync.Mutex
+}
+
+type bucket struct {
+	tokens float64
+	last   time.Time
+}
+
+//NewRateLimiter create a rate limiter configured by the config section key
+func NewRateLimiter(key string) *RateLimiter {
+	return &RateLimiter{
+		key:     key,
+		rate:    defaultRate,
+		burst:   defaultBurst,
+		buckets: make(map[string]*bucket),
+	}
+}
+
+//Prepare read the rate limit from a candidate config
+func (rl *RateLimiter) Prepare(v *viper.Viper) (func(), error) {
+	rate, burst := float64(defaultRate), float64(defaultBurst)
+	if v.IsSet(rl.key + ".rps") {
+		rate = v.GetFloat64(rl.key + ".rps")
+	}
+	if v.IsSet(rl.key + ".burst") {
+		burst = float64(v.GetInt(rl.key + ".burst"))
+	}
+	if rate <= 0 || burst < 1 {
+		return nil, errors.New(rl.key + " rps must be positive and burst at least 1")
+	}
+
+	return func() {
+		rl.mux.Lock()
+		rl.rate, rl.burst = rate, burst
+		rl.mux.Unlock()
+	}, nil
+}
+
+//Allow take a token from the bucket of client
+//  returns how long to wait when no token is available
+func (rl *RateLimiter) Allow(client string) (bool, time.Duration) {
+	rl.mux.Lock()
+	defer rl.mux.Unlock()
+
+	t := now()
+	b, ok := rl.buckets[client]
+	if !ok {
+		if len(rl.buckets) >= maxBuckets {
+			rl.prune(t)
+		}
+		b = &bucket{tokens: rl.burst, last: t}
+		rl.buckets[client] = b
+	}
+
+	b.tokens = math.Min(rl.burst, b.tokens+t.Sub(b.last).Seconds()*rl.rate)
+	b.last = t
+	if b.tokens >= 1 {
+		b.tokens--
+		return true, 0
+	}
+	return false, time.Duration((1 - b.tokens) / rl.rate * float64(time.Second))
+}
+
+//prune drop buckets that have refilled
+func (rl *RateLimiter) prune(t time.Time) {
+	for client, b := range rl.buckets {
+		if b.tokens+t.Sub(b.last).Seconds()*rl.rate >= rl.burst {
+			delete(rl.buckets, client)
+		}
+	}
+}
+
+func (ch CommonHandler) limitRate(next http.Handler) http.Handler {
+	return http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		client, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			client = r.RemoteAddr
+		}
+
+		if ok, wait := ch.Limiter.Allow(client); !ok {
+			log.Info("Rate limit exceeded")
+			getIP(r)
+			w.Header().Set("Retry-After", strconv.Itoa(int(math.Ceil(wait.Seconds()))))
+			writeError(w, r, http.StatusTooManyRequests, "Rate Limit Exceeded")
+			return
+		}
+
+		next.ServeHTTP(w, r)
+	})
+}
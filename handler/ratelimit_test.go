@@ -0,0 +1,102 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func setupRateLimit(start time.Time) *time.Time {
+	current := start
+	now = func() time.Time { return current }
+	return &current
+}
+
+func TestRateLimiterAllow(t *testing.T) {
+	clock := setupRateLimit(time.Unix(0, 0))
+	defer func() { now = time.Now }()
+
+	rl := NewRateLimiter("test")
+	rl.rate, rl.burst = 2, 2
+
+	for i := 0; i < 2; i++ {
+		if ok, _ := rl.Allow("a"); !ok {
+			t.Fatalf("request %d rejected within burst", i)
+		}
+	}
+
+	ok, wait := rl.Allow("a")
+	if ok || wait != 500*time.Millisecond {
+		t.Errorf("function returned unexpected result: got %v %v want %v %v",
+			ok, wait, false, 500*time.Millisecond)
+	}
+	if ok, _ := rl.Allow("b"); !ok {
+		t.Error("Failed separate client bucket")
+	}
+
+	*clock = clock.Add(500 * time.Millisecond)
+	if ok, _ := rl.Allow("a"); !ok {
+		t.Error("Failed to refill bucket")
+	}
+}
+
+func TestRateLimiterPrepare(t *testing.T) {
+	rl := NewRateLimiter("rate_limit.probes")
+
+	v := viper.New()
+	v.Set("rate_limit.probes.rps", 0)
+	if _, err := rl.Prepare(v); err == nil {
+		t.Error("Failed Invalid Rate")
+	}
+
+	v.Set("rate_limit.probes.rps", 5)
+	v.Set("rate_limit.probes.burst", 7)
+	apply, err := rl.Prepare(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if rl.rate != 5 || rl.burst != 7 {
+		t.Errorf("function applied unexpected limit: got %v %v want %v %v",
+			rl.rate, rl.burst, 5, 7)
+	}
+}
+
+func TestMiddleWareRateLimit(t *testing.T) {
+	setUpMiddleWare()
+	setupRateLimit(time.Unix(0, 0))
+	defer func() { now = time.Now }()
+
+	ch := CommonHandler{}
+	ch.AllowedMethods = []string{"GET"}
+	ch.Limiter = NewRateLimiter("test")
+	ch.Limiter.rate, ch.Limiter.burst = 1, 1
+
+	next := http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) { w.WriteHeader(http.StatusOK) })
+	handler := ch.ApplyMiddleware(next)
+
+	codes := []int{http.StatusOK, http.StatusTooManyRequests}
+	for _, expected := range codes {
+		req, err := http.NewRequest("GET", "/readyz", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		req.RemoteAddr = "127.0.0.1:8080"
+		req.Header.Set("api-key", "test")
+
+		rr := httptest.NewRecorder()
+		handler.ServeHTTP(rr, req)
+
+		if status := rr.Code; status != expected {
+			t.Errorf("handler returned wrong status code: got %v want %v",
+				status, expected)
+		}
+		if expected == http.StatusTooManyRequests && rr.Header().Get("Retry-After") != "1" {
+			t.Errorf("handler returned wrong Retry-After: got %v want %v",
+				rr.Header().Get("Retry-After"), "1")
+		}
+	}
+}
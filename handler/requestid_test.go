@@ -0,0 +1,45 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+)
+
+func TestRequestIDs(t *testing.T) {
+	newRequestID = func() string { return "generated" }
+	defer func() { newRequestID = randomID }()
+
+	var forwarded string
+	h := RequestIDs(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		forwarded = r.Header.Get(requestIDHeader)
+	}))
+
+	tests := []struct {
+		sent     string
+		expected string
+	}{
+		{"", "generated"},
+		{"abc-123", "abc-123"},
+		{"has space", "generated"},
+		{strings.Repeat("a", 129), "generated"},
+	}
+
+	for _, test := range tests {
+		req := httptest.NewRequest(http.MethodGet, "/list", nil)
+		if test.sent != "" {
+			req.Header.Set(requestIDHeader, test.sent)
+		}
+		rr := httptest.NewRecorder()
+		h.ServeHTTP(rr, req)
+
+		if got := rr.Header().Get(requestIDHeader); got != test.expected || forwarded != test.expected {
+			t.Errorf("handler returned wrong request ID for %q: got %v %v want %v", test.sent, got, forwarded, test.expected)
+		}
+	}
+
+	if id := randomID(); len(id) != 32 || id == randomID() {
+		t.Errorf("randomID returned a wrong ID: got %v", id)
+	}
+}
@@ -0,0 +1,69 @@
+package handler
+
+import (
+	"net/http"
+	"strings"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+const (
+	splitPath = "/split/"
+)
+
+//SplitsList runtime traffic splits
+type SplitsList struct {
+	Splits map[string]service.SplitRule `json:"splits"`
+}
+
+//HandleSplit view, set or remove the traffic split of a service
+//  PUT and DELETE require the secret key
+func (sh ServiceHandler) HandleSplit(w http.ResponseWriter, r *http.Request) {
+	serviceName := strings.TrimPrefix(r.URL.Path, splitPath)
+
+	if r.Method == http.MethodGet {
+		j, err := jsonMarshal(SplitsList{sh.Traffic.Splits()})
+		if err != nil {
+			http.Error(w, err.Error(), http.StatusInternalServerError)
+			return
+		}
+		w.Header().Set("Content-Type", "application/json")
+		w.Write(j)
+		return
+	}
+
+	var res fieldResult
+	if !validateKey(r.Header.Get("secret-key")) {
+		res.Result = Result{"failure", "Incorrect Key"}
+	} else if r.Method == http.MethodDelete {
+		if err := sh.Traffic.RemoveSplit(serviceName); err != nil {
+			res.Result = Result{"failure", err.Error()}
+		} else {
+			res.Result = Result{"success", ""}
+		}
+	} else {
+		rule := service.SplitRule{}
+		err := readJSONBody(r.Body, &rule)
+		if err == nil {
+			err = sh.Traffic.SetSplit(serviceName, rule)
+		}
+
+		if ve, ok := err.(*service.ValidationError); ok {
+			res.Result = Result{"failure", ve.Error()}
+			res.Fields = ve.Fields
+		} else if err != nil {
+			res.Result = Result{"failure", err.Error()}
+		} else {
+			res.Result = Result{"success", ""}
+		}
+	}
+
+	j, err := jsonMarshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
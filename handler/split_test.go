@@ -0,0 +1,117 @@
+package handler
+
+import (
+	"net/http"
+	"net/http/httptest"
+	"strings"
+	"testing"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type TrafficMock struct {
+	Work func() error
+}
+
+func (tm TrafficMock) SetSplit(serviceName string, rule service.SplitRule) error {
+	return tm.Work()
+}
+
+func (tm TrafficMock) RemoveSplit(serviceName string) error {
+	return tm.Work()
+}
+
+func (tm TrafficMock) Splits() map[string]service.SplitRule {
+	return map[string]service.SplitRule{"test": {Weights: map[string]int{"v1": 95, "v2": 5}}}
+}
+
+func TestHandleSplitGet(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Traffic = TrafficMock{Work: ReturnNoError}
+
+	req, err := http.NewRequest("GET", "/split/", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleSplit)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"splits":{"test":{"weights":{"v1":95,"v2":5}}}}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleSplitKeyFail(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Traffic = TrafficMock{Work: ReturnNoError}
+
+	req, err := http.NewRequest("PUT", "/split/test", strings.NewReader(`{"weights":{"v1":1}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("secret-key", "wrong")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleSplit)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"result":"failure","reason":"Incorrect Key"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleSplitPutSuccess(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Traffic = TrafficMock{Work: ReturnNoError}
+
+	req, err := http.NewRequest("PUT", "/split/test", strings.NewReader(`{"weights":{"v1":95,"v2":5}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("secret-key", "correct")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleSplit)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"result":"success"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleSplitDeleteFail(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Traffic = TrafficMock{Work: ReturnError}
+
+	req, err := http.NewRequest("DELETE", "/split/test", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	req.Header.Add("secret-key", "correct")
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleSplit)
+
+	handler.ServeHTTP(rr, req)
+
+	expected := `{"result":"failure"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
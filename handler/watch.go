@@ -0,0 +1,116 @@
+package handler
+
+import (
+	"context"
+	"fmt"
+	"net/http"
+	"strconv"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	indexHeader = "X-SMUG-Index"
+	defaultWait = 5 * time.Minute
+	maxWait     = 10 * time.Minute
+)
+
+//HandleWatch long poll until the registry changes past ?index=
+func (sh ServiceHandler) HandleWatch(w http.ResponseWriter, r *http.Request) {
+	var index uint64
+	if v := r.URL.Query().Get("index"); v != "" {
+		i, err := strconv.ParseUint(v, 10, 64)
+		if err != nil {
+			writeError(w, r, http.StatusBadRequest, "Invalid Index")
+			return
+		}
+		index = i
+	}
+
+	wait := defaultWait
+	if v := r.URL.Query().Get("wait"); v != "" {
+		d, err := time.ParseDuration(v)
+		if err != nil || d <= 0 {
+			writeError(w, r, http.StatusBadRequest, "Invalid Wait")
+			return
+		}
+		wait = d
+	}
+	if wait > maxWait {
+		wait = maxWait
+	}
+
+	ctx, cancel := context.WithTimeout(r.Context(), wait)
+	defer cancel()
+	go func() {
+		select {
+		case <-drained():
+			cancel()
+		case <-ctx.Done():
+		}
+	}()
+	current := sh.Watch.Watch(ctx, index)
+
+	j, err := jsonMarshal(ServicesList{Services: sh.Discovery.List()})
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set(indexHeader, strconv.FormatUint(current, 10))
+	w.Header().Set("Content-Type", "application/json")
+	w.Write(j)
+}
+
+//HandleEvents stream registry events as server-sent events
+func (sh ServiceHandler) HandleEvents(w http.ResponseWriter, r *http.Request) {
+	flusher, ok := w.(http.Flusher)
+	if !ok {
+		writeError(w, r, http.StatusInternalServerError, "Streaming Unsupported")
+		return
+	}
+
+	events, cancel := sh.Watch.Subscribe()
+	defer cancel()
+
+	w.Header().Set("Content-Type", "text/event-stream")
+	w.Header().Set("Cache-Control", "no-cache")
+	w.Header().Set(indexHeader, strconv.FormatUint(sh.Watch.Index(), 10))
+	w.WriteHeader(http.StatusOK)
+	flusher.Flush()
+
+	for {
+		select {
+		case e, ok := <-events:
+			if !ok {
+				log.Info("HandleEvents: subscription closed")
+				return
+			}
+			j, err := jsonMarshal(e)
+			if err != nil {
+				log.Error("HandleEvents Error: " + err.Error())
+				continue
+			}
+			fmt.Fprintf(w, "id: %d\nevent: %s\ndata: %s\n\n", e.Index, e.Type, j)
+			flusher.Flush()
+		case <-r.Context().Done():
+			return
+		case <-drained():
+			return
+		}
+	}
+}
+
+//writeResult write a JSON result with the given status code
+func writeResult(w http.ResponseWriter, status int, res Result) {
+	j, err := jsonMarshal(res)
+	if err != nil {
+		http.Error(w, err.Error(), http.StatusInternalServerError)
+		return
+	}
+
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	w.Write(j)
+}
@@ -0,0 +1,143 @@
+package handler
+
+import (
+	"context"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/service"
+)
+
+type WatchMock struct {
+	Current uint64
+	Events  []service.Event
+}
+
+func (wm WatchMock) Index() uint64 {
+	return wm.Current
+}
+
+func (wm WatchMock) Watch(ctx context.Context, index uint64) uint64 {
+	return wm.Current
+}
+
+func (wm WatchMock) Subscribe() (<-chan service.Event, func()) {
+	ch := make(chan service.Event, len(wm.Events))
+	for _, e := range wm.Events {
+		ch <- e
+	}
+	close(ch)
+	return ch, func() {}
+}
+
+func TestHandleWatchSuccess(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryMock{Work: ReturnNoError}
+	sh.Watch = WatchMock{Current: 7}
+
+	req, err := http.NewRequest("GET", "/watch?index=3&wait=1s", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleWatch)
+
+	handler.ServeHTTP(rr, req)
+
+	if index := rr.Header().Get(indexHeader); index != "7" {
+		t.Errorf("handler returned wrong index: got %v want %v", index, "7")
+	}
+
+	expected := `{"services":[""]}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleWatchIndexFail(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryMock{Work: ReturnNoError}
+	sh.Watch = WatchMock{}
+
+	req, err := http.NewRequest("GET", "/watch?index=abc", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleWatch)
+
+	handler.ServeHTTP(rr, req)
+
+	if status := rr.Code; status != http.StatusBadRequest {
+		t.Errorf("handler returned wrong status code: got %v want %v",
+			status, http.StatusBadRequest)
+	}
+
+	expected := `{"result":"failure","reason":"Invalid Index"}`
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
+
+func TestHandleWatchBlocks(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Discovery = DiscoveryMock{Work: ReturnNoError}
+	sh.Watch = service.DiscoveryService{}
+
+	index := sh.Watch.Index()
+	req, err := http.NewRequest("GET", "/watch?wait=20ms&index="+
+		strconv.FormatUint(index, 10), nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleWatch)
+
+	start := time.Now()
+	handler.ServeHTTP(rr, req)
+
+	if elapsed := time.Since(start); elapsed < 20*time.Millisecond {
+		t.Errorf("handler returned before wait elapsed: %v", elapsed)
+	}
+}
+
+func TestHandleEventsStream(t *testing.T) {
+	setupServiceHandler()
+	var sh ServiceHandler
+	sh.Watch = WatchMock{Current: 1, Events: []service.Event{
+		{Index: 2, Type: service.EventRegister, Service: "test", URL: "http://test/"},
+	}}
+
+	req, err := http.NewRequest("GET", "/events", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	rr := httptest.NewRecorder()
+	handler := http.HandlerFunc(sh.HandleEvents)
+
+	handler.ServeHTTP(rr, req)
+
+	if ct := rr.Header().Get("Content-Type"); ct != "text/event-stream" {
+		t.Errorf("handler returned wrong content type: got %v want %v",
+			ct, "text/event-stream")
+	}
+
+	expected := "id: 2\nevent: register\ndata: " +
+		`{"index":2,"type":"register","service":"test","URL":"http://test/"}` + "\n\n"
+	if rr.Body.String() != expected {
+		t.Errorf("handler returned unexpected body: got %v want %v",
+			rr.Body.String(), expected)
+	}
+}
@@ -1,6 +1,7 @@
 package log
 
 import (
+	"errors"
 	log "github.com/sirupsen/logrus"
 	"os"
 	"github.com/spf13/viper"
@@ -15,18 +16,39 @@ func init() {
 
 	log.SetOutput(os.Stdout)
 
-	switch lvl := viper.GetString("log_level"); lvl {
+	log.SetLevel(parseLevel(viper.GetString("log_level")))
+
+	log.Info("Log level is " , log.GetLevel())
+}
+
+//PrepareLevel read log_level from a candidate config
+func PrepareLevel(v *viper.Viper) (func(), error) {
+	lvl := v.GetString("log_level")
+	switch lvl {
+	case "", "fatal", "error", "warn", "info", "debug":
+	default:
+		return nil, errors.New("unsupported log_level " + lvl)
+	}
+
+	return func() {
+		if level := parseLevel(lvl); level != log.GetLevel() {
+			log.SetLevel(level)
+			log.Info("Log level is " , log.GetLevel())
+		}
+	}, nil
+}
+
+func parseLevel(lvl string) log.Level {
+	switch lvl {
 	case "fatal":
-		log.SetLevel(log.FatalLevel)
+		return log.FatalLevel
 	case "error":
-		log.SetLevel(log.ErrorLevel)
+		return log.ErrorLevel
 	case "warn":
-		log.SetLevel(log.WarnLevel)
+		return log.WarnLevel
 	case "info":
-		log.SetLevel(log.InfoLevel)
+		return log.InfoLevel
 	default:
-		log.SetLevel(log.DebugLevel)
+		return log.DebugLevel
 	}
-
-	log.Info("Log level is " , log.GetLevel())
 }
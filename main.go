@@ -1,61 +1,157 @@
 package main
 
 import (
+	"context"
+	"errors"
+	"net"
 	"net/http"
 	"os"
 	"os/signal"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/dtan44/SMUG/config"
 	"github.com/dtan44/SMUG/handler"
+	smuglog "github.com/dtan44/SMUG/log"
 	"github.com/dtan44/SMUG/service"
 	log "github.com/sirupsen/logrus"
 	"github.com/spf13/viper"
 )
 
 var osSignals chan os.Signal
+var reloadSignals chan os.Signal
 var serverError chan error
+var server *http.Server
+var listening int32
+var stopL4 context.CancelFunc
 
 func init() {
 	serverError = make(chan error, 1)
 	osSignals = make(chan os.Signal, 1)
+	reloadSignals = make(chan os.Signal, 1)
 
 	signal.Notify(
 		osSignals,
 		syscall.SIGINT,
 		syscall.SIGTERM,
-		syscall.SIGKILL,
 		syscall.SIGQUIT,
 	)
+	signal.Notify(reloadSignals, syscall.SIGHUP)
 }
 
 func main() {
+	config.Hold(&service.RegisterLock)
+	config.OnReload("keys", handler.PrepareKeys)
+	config.OnReload("limits", handler.PrepareLimits)
+	config.OnReload("log", smuglog.PrepareLevel)
+	config.OnReload("routes", service.PreparePolicies)
+	config.OnReload("cache", service.PrepareCache)
+	config.OnReload("aggregates", service.PrepareAggregates)
+	config.OnReload("hosts", service.PrepareHosts)
+	probeLimiter := handler.NewRateLimiter(config.ProbeRateLimit)
+	config.OnReload("probe rate limit", probeLimiter.Prepare)
+	if err := config.Load(); err != nil {
+		panic(err)
+	}
+	if err := config.Watch(); err != nil {
+		log.Error("Config watch Error: " + err.Error())
+	}
+	go reloadOnSignal()
+
 	var sh handler.ServiceHandler
 	sh.Registration = service.RegistrationService{}
 	sh.Discovery = service.DiscoveryService{}
+	sh.Watch = service.DiscoveryService{}
+	sh.Traffic = service.RegistrationService{}
+	sh.Catalog = service.DiscoveryService{}
+	sh.Aggregator = service.AggregateService{}
+	sh.GraphQL = service.GraphQLService{}
+	sh.Stream = service.DiscoveryService{}
 
 	var get handler.CommonHandler
 	get.AllowedMethods = []string{http.MethodGet}
-	http.Handle("/list", get.ApplyMiddleware(http.HandlerFunc(sh.HandleList)))
+	get.Handle("/list", handler.Endpoint{Summary: "List registered services"}, http.HandlerFunc(sh.HandleList))
+	get.Handle("/watch", handler.Endpoint{Summary: "Wait for a registry change"}, http.HandlerFunc(sh.HandleWatch))
+	get.Handle("/events", handler.Endpoint{Summary: "Stream registry events"}, http.HandlerFunc(sh.HandleEvents))
+	get.Handle("/metrics", handler.Endpoint{Summary: "Prometheus metrics"}, http.HandlerFunc(sh.HandleMetrics))
+	get.Handle("/aggregate/", handler.Endpoint{Summary: "Composite response of the calls of an aggregate"}, http.HandlerFunc(sh.HandleAggregate))
+	get.Handle("/docs", handler.Endpoint{Summary: "OpenAPI catalog of the gateway and its services"}, http.HandlerFunc(sh.HandleDocs))
+
+	handler.AddReadinessCheck("config", config.Ready)
+	handler.AddReadinessCheck("registry", service.Ready)
+	handler.AddReadinessCheck("listener", func() error {
+		if atomic.LoadInt32(&listening) == 0 {
+			return errors.New("not listening")
+		}
+		return nil
+	})
+
+	var health handler.CommonHandler
+	health.AllowedMethods = []string{http.MethodGet, http.MethodHead}
+	health.AuthPolicy = func(r *http.Request) string { return service.AuthPublic }
+	health.Limiter = probeLimiter
+	health.Handle("/healthz", handler.Endpoint{Summary: "Liveness probe"}, http.HandlerFunc(sh.HandleLive))
+	health.Handle("/readyz", handler.Endpoint{Summary: "Readiness probe"}, http.HandlerFunc(sh.HandleReady))
+	health.Handle("/health", handler.Endpoint{Summary: "Readiness probe"}, http.HandlerFunc(sh.HandleReady))
 
 	var delete handler.CommonHandler
 	delete.AllowedMethods = []string{http.MethodDelete}
-	http.Handle("/deregister/", delete.ApplyMiddleware(http.HandlerFunc(sh.HandleDeregister)))
+	delete.Handle("/deregister/", handler.Endpoint{Summary: "Deregister a service or service@version", Auth: service.AuthSecretKey}, http.HandlerFunc(sh.HandleDeregister))
 
 	var put handler.CommonHandler
 	put.AllowedMethods = []string{http.MethodPut}
-	http.Handle("/register/", put.ApplyMiddleware(http.HandlerFunc(sh.HandleRegister)))
+	put.Handle("/register/", handler.Endpoint{Summary: "Register a service instance", Auth: service.AuthSecretKey}, http.HandlerFunc(sh.HandleRegister))
+
+	var split handler.CommonHandler
+	split.AllowedMethods = []string{http.MethodGet, http.MethodPut, http.MethodDelete}
+	split.Handle("/split/", handler.Endpoint{
+		Summary:    "Get, set or remove the traffic split of a service",
+		Auth:       service.AuthSecretKey,
+		MethodAuth: map[string]string{http.MethodGet: service.AuthAPIKey},
+	}, http.HandlerFunc(sh.HandleSplit))
+
+	var graphql handler.CommonHandler
+	graphql.AllowedMethods = []string{http.MethodGet, http.MethodPost}
+	graphql.Handle("/graphql", handler.Endpoint{Summary: "GraphQL schema stitched from the registered GraphQL services"}, http.HandlerFunc(sh.HandleGraphQL))
 
 	var route handler.CommonHandler
 	route.AllowedMethods = []string{http.MethodGet, http.MethodPost,
 		http.MethodPut, http.MethodDelete, http.MethodHead,
 		http.MethodConnect, http.MethodOptions, http.MethodPatch,
 		http.MethodTrace}
-	http.Handle("/service/", route.ApplyMiddleware(http.HandlerFunc(sh.HandleRoute)))
+	route.AuthPolicy = service.AuthPolicy
+	route.Resolve = service.Resolve
+	route.Limits = service.RequestLimits
+	route.CORS = service.CORS
+	route.Handle("/service/", handler.Endpoint{Path: "/service/{name}/{path}", Summary: "Route a request to a service"}, http.HandlerFunc(sh.HandleRoute))
+
+	routed := route.ApplyMiddleware(http.HandlerFunc(sh.HandleRoute))
 
-	//TODO: add custom handler for / as a catch all, http has its own default which returns a 404
-	//http.Handle("/", mh.BodyCloser(http.HandlerFunc(bye)))
+	// gRPC clients call /{package.Service}/{Method} on the gateway itself
+	http.Handle("/", handler.GRPCPaths(routed))
 
+	if interval := viper.GetDuration(config.HealthInterval); interval > 0 {
+		go service.MonitorHealth(interval)
+	}
+
+	// TCP and UDP services are proxied on their own listen ports
+	var l4 context.Context
+	l4, stopL4 = context.WithCancel(context.Background())
+	go service.ProxyL4(l4)
+
+	// the hosts of services route every path, other hosts serve the gateway
+	hosts := handler.HostRoutes(routed, http.DefaultServeMux)
+	server = &http.Server{Addr: ":" + viper.GetString(config.Port), Handler: handler.RequestIDs(hosts)}
+	// the server only reads this at startup
+	if viper.IsSet(config.MaxHeaderBytes) {
+		server.MaxHeaderBytes = viper.GetInt(config.MaxHeaderBytes)
+	}
+	// HTTP/2 is negotiated over TLS, h2c serves it in clear text
+	server.Protocols = new(http.Protocols)
+	server.Protocols.SetHTTP1(true)
+	server.Protocols.SetHTTP2(true)
+	server.Protocols.SetUnencryptedHTTP2(!viper.IsSet(config.H2C) || viper.GetBool(config.H2C))
 	go runHTTP()
 
 	waitForEvent()
@@ -63,9 +159,31 @@ func main() {
 }
 
 func runHTTP() {
+	ln, err := net.Listen("tcp", server.Addr)
+	if err != nil {
+		serverError <- err
+		return
+	}
+	atomic.StoreInt32(&listening, 1)
+	defer atomic.StoreInt32(&listening, 0)
+
 	log.Info("Server started")
 	log.Info("Listening on Port " + viper.GetString(config.Port))
-	serverError <- http.ListenAndServe(":"+viper.GetString(config.Port), nil)
+	if cert := viper.GetString(config.TLSCertFile); cert != "" {
+		err = server.ServeTLS(ln, cert, viper.GetString(config.TLSKeyFile))
+	} else {
+		err = server.Serve(ln)
+	}
+	if err != http.ErrServerClosed {
+		serverError <- err
+	}
+}
+
+func reloadOnSignal() {
+	for sig := range reloadSignals {
+		log.Printf("OS reload signal:%+v", sig)
+		config.Reload()
+	}
 }
 
 func waitForEvent() {
@@ -73,6 +191,7 @@ func waitForEvent() {
 	select {
 	case sig := <-osSignals:
 		log.Printf("OS shutdown signal:%+v", sig)
+		shutdown()
 
 	case err := <-serverError:
 		log.Println(err)
@@ -81,3 +200,28 @@ func waitForEvent() {
 	log.Println("Server exit")
 
 }
+
+//shutdown drain in-flight requests before exiting
+//  /health reports not ready for drain_delay so load balancers stop sending
+//  traffic, then in-flight requests get up to shutdown_timeout to finish
+func shutdown() {
+	handler.StartDraining()
+
+	if delay := viper.GetDuration(config.DrainDelay); delay > 0 {
+		log.Info("Draining for " + delay.String())
+		time.Sleep(delay)
+	}
+	stopL4()
+
+	timeout := viper.GetDuration(config.ShutdownTimeout)
+	if timeout <= 0 {
+		timeout = config.DefaultShutdownTimeout
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), timeout)
+	defer cancel()
+
+	log.Info("Waiting up to " + timeout.String() + " for in-flight requests")
+	if err := server.Shutdown(ctx); err != nil {
+		log.Error("Shutdown Error: " + err.Error())
+	}
+}
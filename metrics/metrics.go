@@ -0,0 +1,85 @@
+package metrics
+
+import (
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+	"sync"
+)
+
+var (
+	counters    map[string]uint64
+	countersMux sync.Mutex
+)
+
+func init() {
+	counters = make(map[string]uint64)
+}
+
+//Inc increment the counter name with the given labels
+func Inc(name string, labels map[string]string) {
+	series := seriesName(name, labels)
+
+	countersMux.Lock()
+	counters[series]++
+	countersMux.Unlock()
+}
+
+//Get current value of the counter name with the given labels
+func Get(name string, labels map[string]string) uint64 {
+	series := seriesName(name, labels)
+
+	countersMux.Lock()
+	defer countersMux.Unlock()
+	return counters[series]
+}
+
+//Write all counters in the Prometheus text format
+func Write(w io.Writer) error {
+	countersMux.Lock()
+	series := make([]string, 0, len(counters))
+	for s := range counters {
+		series = append(series, s)
+	}
+	sort.Strings(series)
+	values := make([]uint64, len(series))
+	for i, s := range series {
+		values[i] = counters[s]
+	}
+	countersMux.Unlock()
+
+	for i, s := range series {
+		if _, err := fmt.Fprintf(w, "%s %d\n", s, values[i]); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//Reset clear all counters
+func Reset() {
+	countersMux.Lock()
+	counters = make(map[string]uint64)
+	countersMux.Unlock()
+}
+
+//seriesName format name{key="value",...} with sorted label keys
+func seriesName(name string, labels map[string]string) string {
+	if len(labels) == 0 {
+		return name
+	}
+
+	keys := make([]string, 0, len(labels))
+	for k := range labels {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+
+	pairs := make([]string, 0, len(keys))
+	for _, k := range keys {
+		v := strings.NewReplacer(`\`, `\\`, `"`, `\"`, "\n", `\n`).Replace(labels[k])
+		pairs = append(pairs, fmt.Sprintf(`%s="%s"`, k, v))
+	}
+	return name + "{" + strings.Join(pairs, ",") + "}"
+}
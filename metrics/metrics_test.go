@@ -0,0 +1,38 @@
+package metrics
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestIncAndGet(t *testing.T) {
+	Reset()
+	labels := map[string]string{"service": "test", "version": "1"}
+
+	Inc("requests_total", labels)
+	Inc("requests_total", labels)
+
+	if got := Get("requests_total", labels); got != 2 {
+		t.Errorf("function returned unexpected count: got %v want %v", got, 2)
+	}
+	if got := Get("requests_total", nil); got != 0 {
+		t.Errorf("function returned unexpected count: got %v want %v", got, 0)
+	}
+}
+
+func TestWrite(t *testing.T) {
+	Reset()
+	Inc("b_total", nil)
+	Inc("a_total", map[string]string{"version": "2", "service": `te"st`})
+
+	var buf bytes.Buffer
+	if err := Write(&buf); err != nil {
+		t.Fatal(err)
+	}
+
+	expected := "a_total{service=\"te\\\"st\",version=\"2\"} 1\nb_total 1\n"
+	if buf.String() != expected {
+		t.Errorf("function returned unexpected output: got %v want %v",
+			buf.String(), expected)
+	}
+}
@@ -0,0 +1,503 @@
+package protobuf
+
+import (
+	"fmt"
+	"strings"
+)
+
+// Field types of a FieldDescriptorProto
+const (
+	typeDouble   = 1
+	typeFloat    = 2
+	typeInt64    = 3
+	typeUint64   = 4
+	typeInt32    = 5
+	typeFixed64  = 6
+	typeFixed32  = 7
+	typeBool     = 8
+	typeString   = 9
+	typeGroup    = 10
+	typeMessage  = 11
+	typeBytes    = 12
+	typeUint32   = 13
+	typeEnum     = 14
+	typeSfixed32 = 15
+	typeSfixed64 = 16
+	typeSint32   = 17
+	typeSint64   = 18
+)
+
+const (
+	labelRepeated = 3
+	// extension number of google.api.http in MethodOptions
+	httpExtension = 72295728
+)
+
+//Set messages, enums and services of a FileDescriptorSet
+//  as written by protoc --include_imports --descriptor_set_out,
+//  types are keyed by their full name such as helloworld.HelloRequest
+type Set struct {
+	Messages map[string]*Message
+	Enums    map[string]*Enum
+	Services []*Service
+}
+
+//Message descriptor of a message type
+type Message struct {
+	Name     string
+	Fields   []*Field
+	MapEntry bool
+	numbers  map[int]*Field
+	names    map[string]*Field
+}
+
+//Field descriptor of a message field
+//  Message or Enum is set for fields of those types
+type Field struct {
+	Name     string
+	JSONName string
+	Number   int
+	Repeated bool
+	Message  *Message
+	Enum     *Enum
+	kind     int
+	packed   bool
+	typeName string
+}
+
+//Enum descriptor of an enum type
+type Enum struct {
+	Name    string
+	names   map[int32]string
+	numbers map[string]int32
+}
+
+//Service descriptor of a gRPC service
+type Service struct {
+	Name    string
+	Methods []*Method
+}
+
+//Method descriptor of an rpc
+//  HTTP holds its google.api.http rule and additional bindings
+type Method struct {
+	Name            string
+	Service         string
+	Input           *Message
+	Output          *Message
+	ClientStreaming bool
+	ServerStreaming bool
+	HTTP            []HTTPRule
+	inputType       string
+	outputType      string
+}
+
+//HTTPRule google.api.http binding of a method
+//  Body is empty, * or the field the request body is decoded into
+//  ResponseBody is empty or the field of the reply that is returned
+type HTTPRule struct {
+	Method       string
+	Path         string
+	Body         string
+	ResponseBody string
+}
+
+//Path gRPC request path of the method, /package.Service/Method
+func (m *Method) Path() string {
+	return "/" + m.Service + "/" + m.Name
+}
+
+//FullName package qualified name of the method
+func (m *Method) FullName() string {
+	return m.Service + "." + m.Name
+}
+
+//Field field by proto or JSON name, nil if there is none
+func (m *Message) Field(name string) *Field {
+	return m.names[name]
+}
+
+//FieldPath fields along a dotted path such as book.author_id
+//  every field but the last must be a singular message
+func (m *Message) FieldPath(path string) ([]*Field, error) {
+	var fields []*Field
+	msg := m
+	for i, name := range strings.Split(path, ".") {
+		if msg == nil || i > 0 && (fields[i-1].Repeated || wellKnown[msg.Name] != nil) {
+			return nil, &Error{path, "not a message field"}
+		}
+		f := msg.names[name]
+		if f == nil {
+			return nil, &Error{path, "unknown field"}
+		}
+		fields = append(fields, f)
+		msg = f.Message
+	}
+	return fields, nil
+}
+
+//ParseSet parse an encoded FileDescriptorSet
+//  every referenced type must be in the set
+func ParseSet(data []byte) (*Set, error) {
+	s := &Set{Messages: make(map[string]*Message), Enums: make(map[string]*Enum)}
+	err := each(data, func(v wireValue) error {
+		if v.num == 1 && v.wire == wireBytes {
+			return s.addFile(v.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %s", err.Error())
+	}
+	if err := s.link(); err != nil {
+		return nil, fmt.Errorf("invalid descriptor set: %s", err.Error())
+	}
+	return s, nil
+}
+
+//addFile add the types of a FileDescriptorProto
+func (s *Set) addFile(data []byte) error {
+	var pkg, syntax string
+	var messages, enums, services [][]byte
+	err := each(data, func(v wireValue) error {
+		switch {
+		case v.wire != wireBytes:
+		case v.num == 2:
+			pkg = string(v.b)
+		case v.num == 4:
+			messages = append(messages, v.b)
+		case v.num == 5:
+			enums = append(enums, v.b)
+		case v.num == 6:
+			services = append(services, v.b)
+		case v.num == 12:
+			syntax = string(v.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	// proto3 and editions pack repeated scalars unless told otherwise
+	packed := syntax == "proto3" || syntax == "editions"
+	for _, m := range messages {
+		if err := s.addMessage(pkg, m, packed); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := s.addEnum(pkg, e); err != nil {
+			return err
+		}
+	}
+	for _, svc := range services {
+		if err := s.addService(pkg, svc); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//addMessage add a DescriptorProto and its nested types
+func (s *Set) addMessage(scope string, data []byte, packed bool) error {
+	m := &Message{numbers: make(map[int]*Field), names: make(map[string]*Field)}
+	var nested, enums [][]byte
+	err := each(data, func(v wireValue) error {
+		if v.wire != wireBytes {
+			return nil
+		}
+		switch v.num {
+		case 1:
+			m.Name = qualify(scope, string(v.b))
+		case 2:
+			f, err := parseField(v.b, packed)
+			if err != nil {
+				return err
+			}
+			m.Fields = append(m.Fields, f)
+		case 3:
+			nested = append(nested, v.b)
+		case 4:
+			enums = append(enums, v.b)
+		case 7:
+			return each(v.b, func(o wireValue) error {
+				if o.num == 7 && o.wire == wireVarint {
+					m.MapEntry = o.u != 0
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, f := range m.Fields {
+		m.numbers[f.Number] = f
+		m.names[f.Name] = f
+		m.names[f.JSONName] = f
+	}
+	s.Messages[m.Name] = m
+	for _, n := range nested {
+		if err := s.addMessage(m.Name, n, packed); err != nil {
+			return err
+		}
+	}
+	for _, e := range enums {
+		if err := s.addEnum(m.Name, e); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//parseField parse a FieldDescriptorProto
+func parseField(data []byte, packed bool) (*Field, error) {
+	f := &Field{}
+	var packedOption *bool
+	err := each(data, func(v wireValue) error {
+		switch {
+		case v.num == 1 && v.wire == wireBytes:
+			f.Name = string(v.b)
+		case v.num == 3 && v.wire == wireVarint:
+			f.Number = int(v.u)
+		case v.num == 4 && v.wire == wireVarint:
+			f.Repeated = v.u == labelRepeated
+		case v.num == 5 && v.wire == wireVarint:
+			f.kind = int(v.u)
+		case v.num == 6 && v.wire == wireBytes:
+			f.typeName = strings.TrimPrefix(string(v.b), ".")
+		case v.num == 10 && v.wire == wireBytes:
+			f.JSONName = string(v.b)
+		case v.num == 8 && v.wire == wireBytes:
+			return each(v.b, func(o wireValue) error {
+				if o.num == 2 && o.wire == wireVarint {
+					p := o.u != 0
+					packedOption = &p
+				}
+				return nil
+			})
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+	if f.Name == "" || f.Number <= 0 {
+		return nil, fmt.Errorf("field %q has no name or number", f.Name)
+	}
+	if f.JSONName == "" {
+		f.JSONName = camelCase(f.Name)
+	}
+	if packedOption != nil {
+		packed = *packedOption
+	}
+	f.packed = packed && f.Repeated && packable(f.kind)
+	return f, nil
+}
+
+//addEnum add an EnumDescriptorProto
+func (s *Set) addEnum(scope string, data []byte) error {
+	e := &Enum{names: make(map[int32]string), numbers: make(map[string]int32)}
+	err := each(data, func(v wireValue) error {
+		if v.wire != wireBytes {
+			return nil
+		}
+		switch v.num {
+		case 1:
+			e.Name = qualify(scope, string(v.b))
+		case 2:
+			var name string
+			var number int32
+			err := each(v.b, func(ev wireValue) error {
+				if ev.num == 1 && ev.wire == wireBytes {
+					name = string(ev.b)
+				} else if ev.num == 2 && ev.wire == wireVarint {
+					number = int32(ev.u)
+				}
+				return nil
+			})
+			if err != nil {
+				return err
+			}
+			// the first name of an aliased number is its JSON name
+			if _, ok := e.names[number]; !ok {
+				e.names[number] = name
+			}
+			e.numbers[name] = number
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+	s.Enums[e.Name] = e
+	return nil
+}
+
+//addService add a ServiceDescriptorProto and the HTTP rules of its methods
+func (s *Set) addService(pkg string, data []byte) error {
+	svc := &Service{}
+	var methods [][]byte
+	err := each(data, func(v wireValue) error {
+		if v.num == 1 && v.wire == wireBytes {
+			svc.Name = qualify(pkg, string(v.b))
+		} else if v.num == 2 && v.wire == wireBytes {
+			methods = append(methods, v.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return err
+	}
+
+	for _, data := range methods {
+		m := &Method{Service: svc.Name}
+		err := each(data, func(v wireValue) error {
+			switch {
+			case v.num == 1 && v.wire == wireBytes:
+				m.Name = string(v.b)
+			case v.num == 2 && v.wire == wireBytes:
+				m.inputType = strings.TrimPrefix(string(v.b), ".")
+			case v.num == 3 && v.wire == wireBytes:
+				m.outputType = strings.TrimPrefix(string(v.b), ".")
+			case v.num == 4 && v.wire == wireBytes:
+				return each(v.b, func(o wireValue) error {
+					if o.num != httpExtension || o.wire != wireBytes {
+						return nil
+					}
+					rules, err := parseHTTPRule(o.b, true)
+					m.HTTP = append(m.HTTP, rules...)
+					return err
+				})
+			case v.num == 5 && v.wire == wireVarint:
+				m.ClientStreaming = v.u != 0
+			case v.num == 6 && v.wire == wireVarint:
+				m.ServerStreaming = v.u != 0
+			}
+			return nil
+		})
+		if err != nil {
+			return err
+		}
+		svc.Methods = append(svc.Methods, m)
+	}
+	s.Services = append(s.Services, svc)
+	return nil
+}
+
+//parseHTTPRule parse a google.api.HttpRule
+//  the rule comes first, followed by its additional bindings
+func parseHTTPRule(data []byte, additional bool) ([]HTTPRule, error) {
+	var rule HTTPRule
+	var bindings [][]byte
+	methods := map[int]string{2: "GET", 3: "PUT", 4: "POST", 5: "DELETE", 6: "PATCH"}
+	err := each(data, func(v wireValue) error {
+		if v.wire != wireBytes {
+			return nil
+		}
+		switch v.num {
+		case 2, 3, 4, 5, 6:
+			rule.Method, rule.Path = methods[v.num], string(v.b)
+		case 7:
+			rule.Body = string(v.b)
+		case 8:
+			return each(v.b, func(c wireValue) error {
+				if c.num == 1 && c.wire == wireBytes {
+					rule.Method = strings.ToUpper(string(c.b))
+				} else if c.num == 2 && c.wire == wireBytes {
+					rule.Path = string(c.b)
+				}
+				return nil
+			})
+		case 11:
+			bindings = append(bindings, v.b)
+		case 12:
+			rule.ResponseBody = string(v.b)
+		}
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	var rules []HTTPRule
+	if rule.Path != "" {
+		rules = append(rules, rule)
+	}
+	if !additional {
+		return rules, nil
+	}
+	for _, b := range bindings {
+		more, err := parseHTTPRule(b, false)
+		if err != nil {
+			return nil, err
+		}
+		rules = append(rules, more...)
+	}
+	return rules, nil
+}
+
+//link resolve the types referenced by fields and methods
+func (s *Set) link() error {
+	for _, m := range s.Messages {
+		for _, f := range m.Fields {
+			switch f.kind {
+			case typeMessage, typeGroup:
+				if f.Message = s.Messages[f.typeName]; f.Message == nil {
+					return fmt.Errorf("unknown type %s of %s.%s", f.typeName, m.Name, f.Name)
+				}
+			case typeEnum:
+				if f.Enum = s.Enums[f.typeName]; f.Enum == nil {
+					return fmt.Errorf("unknown type %s of %s.%s", f.typeName, m.Name, f.Name)
+				}
+			}
+		}
+	}
+	for _, svc := range s.Services {
+		for _, m := range svc.Methods {
+			m.Input, m.Output = s.Messages[m.inputType], s.Messages[m.outputType]
+			if m.Input == nil || m.Output == nil {
+				return fmt.Errorf("unknown type of %s", m.FullName())
+			}
+		}
+	}
+	return nil
+}
+
+//qualify full name of a type declared in scope
+func qualify(scope, name string) string {
+	if scope == "" {
+		return name
+	}
+	return scope + "." + name
+}
+
+//camelCase JSON name protoc gives a field name such as author_id
+func camelCase(name string) string {
+	var b strings.Builder
+	upper := false
+	for _, c := range name {
+		if c == '_' {
+			upper = true
+			continue
+		}
+		if upper && c >= 'a' && c <= 'z' {
+			c -= 'a' - 'A'
+		}
+		upper = false
+		b.WriteRune(c)
+	}
+	return b.String()
+}
+
+//packable check if repeated fields of a type can be packed
+func packable(kind int) bool {
+	switch kind {
+	case typeString, typeBytes, typeMessage, typeGroup:
+		return false
+	}
+	return true
+}
@@ -0,0 +1,181 @@
+package protobuf
+
+import (
+	"bytes"
+	"reflect"
+	"testing"
+)
+
+func str(num int, s string) []byte {
+	return appendBytes(appendKey(nil, num, wireBytes), []byte(s))
+}
+
+func num(n int, v uint64) []byte {
+	return appendVarint(appendKey(nil, n, wireVarint), v)
+}
+
+func msg(n int, parts ...[]byte) []byte {
+	return appendBytes(appendKey(nil, n, wireBytes), bytes.Join(parts, nil))
+}
+
+func field(name string, number, label, kind int, typeName string) []byte {
+	parts := [][]byte{str(1, name), num(3, uint64(number)), num(4, uint64(label)), num(5, uint64(kind)), str(10, camelCase(name))}
+	if typeName != "" {
+		parts = append(parts, str(6, typeName))
+	}
+	return msg(2, parts...)
+}
+
+func method(name, input, output string, serverStreaming bool, rule []byte) []byte {
+	parts := [][]byte{str(1, name), str(2, input), str(3, output), msg(4, msg(httpExtension, rule))}
+	if serverStreaming {
+		parts = append(parts, num(6, 1))
+	}
+	return msg(2, parts...)
+}
+
+//setupDescriptorSet descriptor set of greeter.proto and its imports
+//  service Greeter {
+//    rpc SayHello(HelloRequest) returns (HelloReply) {
+//      option (google.api.http) = {
+//        get: "/v1/hello/{name}"
+//        additional_bindings { post: "/v1/hello" body: "*" }
+//      };
+//    }
+//    rpc UpdateGreeting(UpdateGreetingRequest) returns (Greeting) {
+//      option (google.api.http) = { patch: "/v1/{greeting.name=greetings/*}" body: "greeting" };
+//    }
+//    rpc ListGreetings(ListGreetingsRequest) returns (stream Greeting) {
+//      option (google.api.http) = { get: "/v1/greetings" response_body: "text" };
+//    }
+//  }
+func setupDescriptorSet() []byte {
+	timestamp := msg(1,
+		str(1, "google/protobuf/timestamp.proto"), str(2, "google.protobuf"), str(12, "proto3"),
+		msg(4, str(1, "Timestamp"), field("seconds", 1, 1, typeInt64, ""), field("nanos", 2, 1, typeInt32, "")),
+	)
+	duration := msg(1,
+		str(1, "google/protobuf/duration.proto"), str(2, "google.protobuf"), str(12, "proto3"),
+		msg(4, str(1, "Duration"), field("seconds", 1, 1, typeInt64, ""), field("nanos", 2, 1, typeInt32, "")),
+	)
+	structs := msg(1,
+		str(1, "google/protobuf/struct.proto"), str(2, "google.protobuf"), str(12, "proto3"),
+		msg(4, str(1, "Struct"),
+			field("fields", 1, labelRepeated, typeMessage, ".google.protobuf.Struct.FieldsEntry"),
+			msg(3, str(1, "FieldsEntry"),
+				field("key", 1, 1, typeString, ""), field("value", 2, 1, typeMessage, ".google.protobuf.Value"),
+				msg(7, num(7, 1)),
+			),
+		),
+		msg(4, str(1, "Value"),
+			field("null_value", 1, 1, typeEnum, ".google.protobuf.NullValue"),
+			field("number_value", 2, 1, typeDouble, ""),
+			field("string_value", 3, 1, typeString, ""),
+			field("bool_value", 4, 1, typeBool, ""),
+			field("struct_value", 5, 1, typeMessage, ".google.protobuf.Struct"),
+			field("list_value", 6, 1, typeMessage, ".google.protobuf.ListValue"),
+		),
+		msg(4, str(1, "ListValue"), field("values", 1, labelRepeated, typeMessage, ".google.protobuf.Value")),
+		msg(5, str(1, "NullValue"), msg(2, str(1, "NULL_VALUE"), num(2, 0))),
+	)
+	greeter := msg(1,
+		str(1, "greeter.proto"), str(2, "helloworld"), str(12, "proto3"),
+		msg(4, str(1, "HelloRequest"),
+			field("name", 1, 1, typeString, ""),
+			field("times", 2, 1, typeInt32, ""),
+			field("ids", 3, labelRepeated, typeInt64, ""),
+			field("mood", 4, 1, typeEnum, ".helloworld.Mood"),
+			field("loud", 5, 1, typeBool, ""),
+			field("counts", 6, labelRepeated, typeMessage, ".helloworld.HelloRequest.CountsEntry"),
+			field("sent_at", 7, 1, typeMessage, ".google.protobuf.Timestamp"),
+			field("ratio", 8, 1, typeDouble, ""),
+			field("token", 9, 1, typeBytes, ""),
+			field("extra", 10, 1, typeMessage, ".google.protobuf.Struct"),
+			field("wait", 11, 1, typeMessage, ".google.protobuf.Duration"),
+			msg(3, str(1, "CountsEntry"),
+				field("key", 1, 1, typeString, ""), field("value", 2, 1, typeInt32, ""),
+				msg(7, num(7, 1)),
+			),
+		),
+		msg(4, str(1, "HelloReply"), field("message", 1, 1, typeString, ""), field("sent_at", 2, 1, typeMessage, ".google.protobuf.Timestamp")),
+		msg(4, str(1, "Greeting"), field("name", 1, 1, typeString, ""), field("text", 2, 1, typeString, "")),
+		msg(4, str(1, "UpdateGreetingRequest"), field("greeting", 1, 1, typeMessage, ".helloworld.Greeting")),
+		msg(4, str(1, "ListGreetingsRequest"), field("page_size", 1, 1, typeInt32, "")),
+		msg(5, str(1, "Mood"),
+			msg(2, str(1, "MOOD_UNSPECIFIED"), num(2, 0)),
+			msg(2, str(1, "HAPPY"), num(2, 1)),
+			msg(2, str(1, "GRUMPY"), num(2, 2)),
+		),
+		msg(6, str(1, "Greeter"),
+			method("SayHello", ".helloworld.HelloRequest", ".helloworld.HelloReply", false,
+				bytes.Join([][]byte{str(2, "/v1/hello/{name}"), msg(11, str(4, "/v1/hello"), str(7, "*"))}, nil)),
+			method("UpdateGreeting", ".helloworld.UpdateGreetingRequest", ".helloworld.Greeting", false,
+				bytes.Join([][]byte{str(6, "/v1/{greeting.name=greetings/*}"), str(7, "greeting")}, nil)),
+			method("ListGreetings", ".helloworld.ListGreetingsRequest", ".helloworld.Greeting", true,
+				bytes.Join([][]byte{str(2, "/v1/greetings"), str(12, "text")}, nil)),
+		),
+	)
+	return bytes.Join([][]byte{timestamp, duration, structs, greeter}, nil)
+}
+
+func TestParseSet(t *testing.T) {
+	s, err := ParseSet(setupDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(s.Services) != 1 || s.Services[0].Name != "helloworld.Greeter" || len(s.Services[0].Methods) != 3 {
+		t.Fatalf("ParseSet returned wrong services: got %v", s.Services)
+	}
+	hello := s.Services[0].Methods[0]
+	if hello.Path() != "/helloworld.Greeter/SayHello" || hello.Input != s.Messages["helloworld.HelloRequest"] {
+		t.Errorf("ParseSet returned wrong method: got %v %v", hello.Path(), hello.Input)
+	}
+	expected := []HTTPRule{{Method: "GET", Path: "/v1/hello/{name}"}, {Method: "POST", Path: "/v1/hello", Body: "*"}}
+	if !reflect.DeepEqual(hello.HTTP, expected) {
+		t.Errorf("ParseSet returned wrong rules: got %v want %v", hello.HTTP, expected)
+	}
+	if list := s.Services[0].Methods[2]; !list.ServerStreaming || list.HTTP[0].ResponseBody != "text" {
+		t.Errorf("ParseSet returned wrong streaming method: got %v", list)
+	}
+
+	req := s.Messages["helloworld.HelloRequest"]
+	if f := req.Field("sentAt"); f == nil || f != req.Field("sent_at") || f.Message.Name != "google.protobuf.Timestamp" {
+		t.Errorf("ParseSet returned wrong field by name: got %v", f)
+	}
+	if f := req.Field("ids"); !f.packed || !f.Repeated {
+		t.Errorf("ParseSet did not pack a repeated proto3 scalar: got %v", f)
+	}
+	if f := req.Field("counts"); !f.Message.MapEntry {
+		t.Errorf("ParseSet did not mark the map entry: got %v", f.Message)
+	}
+	if _, err := req.FieldPath("sent_at.seconds"); err == nil {
+		t.Errorf("FieldPath accepted a path into a well-known type")
+	}
+	if fields, err := s.Messages["helloworld.UpdateGreetingRequest"].FieldPath("greeting.name"); err != nil || len(fields) != 2 {
+		t.Errorf("FieldPath returned wrong fields: got %v %v", fields, err)
+	}
+}
+
+func TestParseSetFail(t *testing.T) {
+	tests := []struct {
+		data     []byte
+		expected string
+	}{
+		{[]byte{0x0a, 0x05, 0x01}, "invalid descriptor set: truncated message"},
+		{
+			msg(1, str(2, "pkg"), msg(4, str(1, "A"), field("b", 1, 1, typeMessage, ".pkg.B"))),
+			"invalid descriptor set: unknown type pkg.B of pkg.A.b",
+		},
+		{
+			msg(1, str(2, "pkg"), msg(4, str(1, "A"), msg(2, str(1, "b")))),
+			`invalid descriptor set: field "b" has no name or number`,
+		},
+	}
+
+	for _, test := range tests {
+		if _, err := ParseSet(test.data); err == nil || err.Error() != test.expected {
+			t.Errorf("ParseSet returned wrong error: got %v want %v", err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,632 @@
+package protobuf
+
+import (
+	"encoding/base64"
+	"encoding/json"
+	"fmt"
+	"math"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+//Error invalid value at a field path of a message
+type Error struct {
+	Path   string
+	Reason string
+}
+
+func (e *Error) Error() string {
+	if e.Path == "" {
+		return e.Reason
+	}
+	return e.Path + ": " + e.Reason
+}
+
+//wellKnownType JSON form of a google.protobuf type
+//  toJSON converts the generic JSON of the message,
+//  fromJSON encodes the JSON value as the message
+type wellKnownType struct {
+	toJSON   func(m *Message, obj map[string]interface{}) (interface{}, error)
+	fromJSON func(m *Message, value interface{}, path string) ([]byte, error)
+}
+
+var wellKnown map[string]*wellKnownType
+
+func init() {
+	wrapper := &wellKnownType{wrapperToJSON, wrapperFromJSON}
+	wellKnown = map[string]*wellKnownType{
+		"google.protobuf.Timestamp":   {timestampToJSON, timestampFromJSON},
+		"google.protobuf.Duration":    {durationToJSON, durationFromJSON},
+		"google.protobuf.FieldMask":   {fieldMaskToJSON, fieldMaskFromJSON},
+		"google.protobuf.Struct":      {structToJSON, containerFromJSON},
+		"google.protobuf.ListValue":   {listToJSON, containerFromJSON},
+		"google.protobuf.Value":       {valueToJSON, valueFromJSON},
+		"google.protobuf.DoubleValue": wrapper,
+		"google.protobuf.FloatValue":  wrapper,
+		"google.protobuf.Int64Value":  wrapper,
+		"google.protobuf.UInt64Value": wrapper,
+		"google.protobuf.Int32Value":  wrapper,
+		"google.protobuf.UInt32Value": wrapper,
+		"google.protobuf.BoolValue":   wrapper,
+		"google.protobuf.StringValue": wrapper,
+		"google.protobuf.BytesValue":  wrapper,
+	}
+}
+
+//ToJSON decode an encoded message to its proto3 JSON form
+//  64 bit integers are strings, bytes are base64 and enums are names,
+//  fields missing from the message are left out
+func (m *Message) ToJSON(data []byte) (interface{}, error) {
+	obj := make(map[string]interface{})
+	// occurrences of a message field are merged by decoding them as one
+	merged := make(map[*Field][]byte)
+	err := each(data, func(v wireValue) error {
+		f := m.numbers[v.num]
+		if f == nil {
+			return nil
+		}
+		if f.Message != nil {
+			if v.wire != wireBytes {
+				return &Error{f.JSONName, errWireType.Error()}
+			}
+			if !f.Repeated {
+				merged[f] = append(merged[f], v.b...)
+				return nil
+			}
+			val, err := f.Message.ToJSON(v.b)
+			if err != nil {
+				return prefixError(f.JSONName, err)
+			}
+			if f.Message.MapEntry {
+				return addEntry(obj, f, val)
+			}
+			list, _ := obj[f.JSONName].([]interface{})
+			obj[f.JSONName] = append(list, val)
+			return nil
+		}
+
+		if v.wire == wireBytes && packable(f.kind) {
+			list, _ := obj[f.JSONName].([]interface{})
+			r := &reader{data: v.b}
+			for !r.done() {
+				item, err := r.value(wireFor(f.kind))
+				if err != nil {
+					return &Error{f.JSONName, err.Error()}
+				}
+				val, err := f.scalarJSON(item)
+				if err != nil {
+					return err
+				}
+				list = append(list, val)
+			}
+			obj[f.JSONName] = list
+			return nil
+		}
+		val, err := f.scalarJSON(v)
+		if err != nil {
+			return err
+		}
+		if f.Repeated {
+			list, _ := obj[f.JSONName].([]interface{})
+			val = append(list, val)
+		}
+		obj[f.JSONName] = val
+		return nil
+	})
+	if err != nil {
+		return nil, err
+	}
+
+	for f, data := range merged {
+		val, err := f.Message.ToJSON(data)
+		if err != nil {
+			return nil, prefixError(f.JSONName, err)
+		}
+		obj[f.JSONName] = val
+	}
+	if wkt := wellKnown[m.Name]; wkt != nil {
+		return wkt.toJSON(m, obj)
+	}
+	return obj, nil
+}
+
+//addEntry add a decoded map entry to the JSON object of its map field
+//  missing keys and values are their defaults
+func addEntry(obj map[string]interface{}, f *Field, entry interface{}) error {
+	fields, _ := entry.(map[string]interface{})
+	keyField, valField := f.Message.numbers[1], f.Message.numbers[2]
+	if keyField == nil || valField == nil {
+		return &Error{f.JSONName, "invalid map entry"}
+	}
+
+	key, ok := fields[keyField.JSONName]
+	if !ok {
+		key = keyField.Default()
+	}
+	val, ok := fields[valField.JSONName]
+	if !ok {
+		val = valField.Default()
+	}
+	m, _ := obj[f.JSONName].(map[string]interface{})
+	if m == nil {
+		m = make(map[string]interface{})
+		obj[f.JSONName] = m
+	}
+	m[fmt.Sprint(key)] = val
+	return nil
+}
+
+//Default JSON value of a field missing from a message
+func (f *Field) Default() interface{} {
+	switch {
+	case f.Message != nil && f.Message.MapEntry:
+		return map[string]interface{}{}
+	case f.Repeated:
+		return []interface{}{}
+	case f.Message != nil:
+		val, _ := f.Message.ToJSON(nil)
+		return val
+	}
+	val, _ := f.scalarJSON(wireValue{wire: wireFor(f.kind)})
+	return val
+}
+
+//scalarJSON JSON value of a scalar field
+func (f *Field) scalarJSON(v wireValue) (interface{}, error) {
+	if v.wire != wireFor(f.kind) {
+		return nil, &Error{f.JSONName, errWireType.Error()}
+	}
+	switch f.kind {
+	case typeDouble:
+		return floatJSON(math.Float64frombits(v.u), 64), nil
+	case typeFloat:
+		return floatJSON(float64(math.Float32frombits(uint32(v.u))), 32), nil
+	case typeInt32, typeSfixed32:
+		return int64(int32(v.u)), nil
+	case typeSint32:
+		return int64(int32(unzigzag(v.u))), nil
+	case typeUint32, typeFixed32:
+		return uint64(uint32(v.u)), nil
+	case typeInt64, typeSfixed64:
+		return strconv.FormatInt(int64(v.u), 10), nil
+	case typeSint64:
+		return strconv.FormatInt(unzigzag(v.u), 10), nil
+	case typeUint64, typeFixed64:
+		return strconv.FormatUint(v.u, 10), nil
+	case typeBool:
+		return v.u != 0, nil
+	case typeString:
+		return string(v.b), nil
+	case typeBytes:
+		return base64.StdEncoding.EncodeToString(v.b), nil
+	case typeEnum:
+		if f.Enum.Name == "google.protobuf.NullValue" {
+			return nil, nil
+		}
+		if name, ok := f.Enum.names[int32(v.u)]; ok {
+			return name, nil
+		}
+		return int64(int32(v.u)), nil
+	}
+	return nil, &Error{f.JSONName, "unsupported field type"}
+}
+
+//floatJSON JSON value of a float, a string for NaN and the infinities
+func floatJSON(v float64, bits int) interface{} {
+	switch {
+	case math.IsNaN(v):
+		return "NaN"
+	case math.IsInf(v, 1):
+		return "Infinity"
+	case math.IsInf(v, -1):
+		return "-Infinity"
+	}
+	return json.Number(strconv.FormatFloat(v, 'g', -1, bits))
+}
+
+//FromJSON encode a message from its proto3 JSON form
+//  the value is as decoded by encoding/json, numbers may be float64 or
+//  json.Number and integers may be strings, fields are matched by their
+//  JSON or proto name
+func (m *Message) FromJSON(value interface{}) ([]byte, error) {
+	return m.encode(value, "")
+}
+
+func (m *Message) encode(value interface{}, path string) ([]byte, error) {
+	if wkt := wellKnown[m.Name]; wkt != nil {
+		return wkt.fromJSON(m, value, path)
+	}
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &Error{path, "must be an object"}
+	}
+	return m.encodeObject(obj, path)
+}
+
+//encodeObject encode the fields of a JSON object in field number order
+func (m *Message) encodeObject(obj map[string]interface{}, path string) ([]byte, error) {
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		if m.names[key] == nil {
+			return nil, &Error{joinPath(path, key), "unknown field"}
+		}
+		keys = append(keys, key)
+	}
+	sort.Slice(keys, func(i, j int) bool {
+		a, b := m.names[keys[i]], m.names[keys[j]]
+		return a.Number < b.Number || a.Number == b.Number && keys[i] < keys[j]
+	})
+
+	var b []byte
+	for _, key := range keys {
+		f := m.names[key]
+		// null is the default value, except for google.protobuf.Value
+		if obj[key] == nil && (f.Repeated || !f.isValue()) {
+			continue
+		}
+		var err error
+		if b, err = f.encode(b, obj[key], joinPath(path, key)); err != nil {
+			return nil, err
+		}
+	}
+	return b, nil
+}
+
+//encode append the field with its JSON value to b
+func (f *Field) encode(b []byte, value interface{}, path string) ([]byte, error) {
+	if f.Message != nil && f.Message.MapEntry {
+		return f.encodeMap(b, value, path)
+	}
+	if !f.Repeated {
+		return f.encodeOne(b, value, path)
+	}
+
+	list, ok := value.([]interface{})
+	if !ok {
+		return nil, &Error{path, "must be an array"}
+	}
+	if !f.packed {
+		var err error
+		for i, item := range list {
+			if b, err = f.encodeOne(b, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+				return nil, err
+			}
+		}
+		return b, nil
+	}
+
+	var packed []byte
+	for i, item := range list {
+		var err error
+		if packed, err = f.appendScalar(packed, item, fmt.Sprintf("%s[%d]", path, i)); err != nil {
+			return nil, err
+		}
+	}
+	b = appendKey(b, f.Number, wireBytes)
+	return appendBytes(b, packed), nil
+}
+
+//encodeMap append the entries of a map field, in key order
+func (f *Field) encodeMap(b []byte, value interface{}, path string) ([]byte, error) {
+	obj, ok := value.(map[string]interface{})
+	if !ok {
+		return nil, &Error{path, "must be an object"}
+	}
+	keyField, valField := f.Message.numbers[1], f.Message.numbers[2]
+	if keyField == nil || valField == nil {
+		return nil, &Error{path, "invalid map entry"}
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	for _, key := range keys {
+		var k interface{} = key
+		if keyField.kind == typeBool {
+			parsed, err := strconv.ParseBool(key)
+			if err != nil {
+				return nil, &Error{joinPath(path, key), "map key must be a bool"}
+			}
+			k = parsed
+		}
+		entry, err := keyField.encodeOne(nil, k, joinPath(path, key))
+		if err != nil {
+			return nil, err
+		}
+		if obj[key] != nil || valField.isValue() {
+			if entry, err = valField.encodeOne(entry, obj[key], joinPath(path, key)); err != nil {
+				return nil, err
+			}
+		}
+		b = appendKey(b, f.Number, wireBytes)
+		b = appendBytes(b, entry)
+	}
+	return b, nil
+}
+
+//encodeOne append a single value of the field
+func (f *Field) encodeOne(b []byte, value interface{}, path string) ([]byte, error) {
+	if f.Message != nil {
+		msg, err := f.Message.encode(value, path)
+		if err != nil {
+			return nil, err
+		}
+		b = appendKey(b, f.Number, wireBytes)
+		return appendBytes(b, msg), nil
+	}
+	b = appendKey(b, f.Number, wireFor(f.kind))
+	return f.appendScalar(b, value, path)
+}
+
+//appendScalar append a scalar value without its key
+func (f *Field) appendScalar(b []byte, value interface{}, path string) ([]byte, error) {
+	var err error
+	switch f.kind {
+	case typeDouble, typeFloat:
+		bits := 64
+		if f.kind == typeFloat {
+			bits = 32
+		}
+		var v float64
+		if v, err = jsonFloat(value, bits); err == nil {
+			if bits == 32 {
+				return appendFixed32(b, math.Float32bits(float32(v))), nil
+			}
+			return appendFixed64(b, math.Float64bits(v)), nil
+		}
+	case typeInt32, typeInt64, typeSint32, typeSint64, typeSfixed32, typeSfixed64:
+		bits := 64
+		if f.kind == typeInt32 || f.kind == typeSint32 || f.kind == typeSfixed32 {
+			bits = 32
+		}
+		var v int64
+		if v, err = jsonInt(value, bits); err == nil {
+			switch f.kind {
+			case typeSint32, typeSint64:
+				return appendVarint(b, zigzag(v)), nil
+			case typeSfixed32:
+				return appendFixed32(b, uint32(v)), nil
+			case typeSfixed64:
+				return appendFixed64(b, uint64(v)), nil
+			}
+			return appendVarint(b, uint64(v)), nil
+		}
+	case typeUint32, typeUint64, typeFixed32, typeFixed64:
+		bits := 64
+		if f.kind == typeUint32 || f.kind == typeFixed32 {
+			bits = 32
+		}
+		var v uint64
+		if v, err = jsonUint(value, bits); err == nil {
+			switch f.kind {
+			case typeFixed32:
+				return appendFixed32(b, uint32(v)), nil
+			case typeFixed64:
+				return appendFixed64(b, v), nil
+			}
+			return appendVarint(b, v), nil
+		}
+	case typeBool:
+		if v, ok := value.(bool); ok {
+			if v {
+				return appendVarint(b, 1), nil
+			}
+			return appendVarint(b, 0), nil
+		}
+		err = fmt.Errorf("must be a bool")
+	case typeString:
+		if v, ok := value.(string); ok {
+			return appendBytes(b, []byte(v)), nil
+		}
+		err = fmt.Errorf("must be a string")
+	case typeBytes:
+		var v []byte
+		if v, err = jsonBytes(value); err == nil {
+			return appendBytes(b, v), nil
+		}
+	case typeEnum:
+		var v int32
+		if v, err = f.Enum.number(value); err == nil {
+			return appendVarint(b, uint64(int64(v))), nil
+		}
+	default:
+		err = fmt.Errorf("unsupported field type")
+	}
+	return nil, &Error{path, err.Error()}
+}
+
+//number enum number of a JSON name or number
+func (e *Enum) number(value interface{}) (int32, error) {
+	if value == nil && e.Name == "google.protobuf.NullValue" {
+		return 0, nil
+	}
+	if name, ok := value.(string); ok {
+		if v, ok := e.numbers[name]; ok {
+			return v, nil
+		}
+		return 0, fmt.Errorf("unknown value %s of %s", name, e.Name)
+	}
+	v, err := jsonInt(value, 32)
+	if err != nil {
+		return 0, fmt.Errorf("must be a name or number of %s", e.Name)
+	}
+	return int32(v), nil
+}
+
+//jsonInt signed integer of a JSON number or numeric string
+func jsonInt(value interface{}, bits int) (int64, error) {
+	text, err := numberText(value)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseInt(text, 10, bits)
+	if err != nil {
+		// exponents such as 1e3 are accepted for integral values
+		f, ferr := strconv.ParseFloat(text, 64)
+		if ferr != nil || f != math.Trunc(f) || f < -math.Exp2(float64(bits-1)) || f >= math.Exp2(float64(bits-1)) {
+			return 0, fmt.Errorf("must be a %d bit integer", bits)
+		}
+		return int64(f), nil
+	}
+	return v, nil
+}
+
+//jsonUint unsigned integer of a JSON number or numeric string
+func jsonUint(value interface{}, bits int) (uint64, error) {
+	text, err := numberText(value)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseUint(text, 10, bits)
+	if err != nil {
+		f, ferr := strconv.ParseFloat(text, 64)
+		if ferr != nil || f != math.Trunc(f) || f < 0 || f >= math.Exp2(float64(bits)) {
+			return 0, fmt.Errorf("must be an unsigned %d bit integer", bits)
+		}
+		return uint64(f), nil
+	}
+	return v, nil
+}
+
+//jsonFloat float of a JSON number, numeric string, NaN or Infinity
+func jsonFloat(value interface{}, bits int) (float64, error) {
+	if s, ok := value.(string); ok {
+		switch s {
+		case "NaN":
+			return math.NaN(), nil
+		case "Infinity":
+			return math.Inf(1), nil
+		case "-Infinity":
+			return math.Inf(-1), nil
+		}
+	}
+	text, err := numberText(value)
+	if err != nil {
+		return 0, err
+	}
+	v, err := strconv.ParseFloat(text, 64)
+	if err != nil || math.IsInf(v, 0) || bits == 32 && math.Abs(v) > math.MaxFloat32 {
+		return 0, fmt.Errorf("must be a %d bit float", bits)
+	}
+	return v, nil
+}
+
+//numberText text of a JSON number or quoted number
+func numberText(value interface{}) (string, error) {
+	switch v := value.(type) {
+	case json.Number:
+		return v.String(), nil
+	case float64:
+		return strconv.FormatFloat(v, 'g', -1, 64), nil
+	case int64:
+		return strconv.FormatInt(v, 10), nil
+	case string:
+		return strings.TrimSpace(v), nil
+	}
+	return "", fmt.Errorf("must be a number")
+}
+
+//jsonBytes decode standard or URL-safe base64, padded or not
+func jsonBytes(value interface{}) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, fmt.Errorf("must be a base64 string")
+	}
+	s = strings.TrimRight(s, "=")
+	if strings.ContainsAny(s, "-_") {
+		return base64.RawURLEncoding.DecodeString(s)
+	}
+	b, err := base64.RawStdEncoding.DecodeString(s)
+	if err != nil {
+		return nil, fmt.Errorf("must be a base64 string")
+	}
+	return b, nil
+}
+
+//isValue check if the field is a google.protobuf.Value, which encodes null
+func (f *Field) isValue() bool {
+	return f.Message != nil && f.Message.Name == "google.protobuf.Value"
+}
+
+//SetParam set a URL parameter at a dotted field path of a JSON message
+//  repeated fields take every value and others the last one,
+//  values stay strings, which the JSON form accepts for numbers
+func (m *Message) SetParam(obj map[string]interface{}, path string, values []string) error {
+	fields, err := m.FieldPath(path)
+	if err != nil {
+		return err
+	}
+	for _, f := range fields[:len(fields)-1] {
+		child, ok := obj[f.JSONName].(map[string]interface{})
+		if !ok {
+			child = make(map[string]interface{})
+			obj[f.JSONName] = child
+		}
+		obj = child
+	}
+
+	f := fields[len(fields)-1]
+	if f.Message != nil && (f.Message.MapEntry || !stringForm(f.Message.Name)) {
+		return &Error{path, "cannot be set from a parameter"}
+	}
+	vals := make([]interface{}, len(values))
+	for i, s := range values {
+		vals[i] = s
+		if f.kind == typeBool || f.Message != nil && f.Message.Name == "google.protobuf.BoolValue" {
+			v, err := strconv.ParseBool(s)
+			if err != nil {
+				return &Error{path, "must be a bool"}
+			}
+			vals[i] = v
+		}
+	}
+	if len(vals) == 0 {
+		return nil
+	}
+	if f.Repeated {
+		obj[f.JSONName] = vals
+	} else {
+		obj[f.JSONName] = vals[len(vals)-1]
+	}
+	return nil
+}
+
+//stringForm check if a well-known type is written as a JSON scalar
+func stringForm(name string) bool {
+	switch name {
+	case "google.protobuf.Struct", "google.protobuf.ListValue", "google.protobuf.Value":
+		return false
+	}
+	return wellKnown[name] != nil
+}
+
+//wireFor wire type of a scalar field type
+func wireFor(kind int) int {
+	switch kind {
+	case typeDouble, typeFixed64, typeSfixed64:
+		return wireFixed64
+	case typeFloat, typeFixed32, typeSfixed32:
+		return wireFixed32
+	case typeString, typeBytes, typeMessage:
+		return wireBytes
+	}
+	return wireVarint
+}
+
+func joinPath(path, name string) string {
+	if path == "" {
+		return name
+	}
+	return path + "." + name
+}
+
+func prefixError(path string, err error) error {
+	if e, ok := err.(*Error); ok {
+		return &Error{joinPath(path, e.Path), e.Reason}
+	}
+	return &Error{path, err.Error()}
+}
@@ -0,0 +1,172 @@
+package protobuf
+
+import (
+	"bytes"
+	"encoding/hex"
+	"encoding/json"
+	"testing"
+)
+
+func setupMessage(t *testing.T, name string) *Message {
+	s, err := ParseSet(setupDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	return s.Messages[name]
+}
+
+func decodeJSON(t *testing.T, text string) interface{} {
+	var v interface{}
+	d := json.NewDecoder(bytes.NewReader([]byte(text)))
+	d.UseNumber()
+	if err := d.Decode(&v); err != nil {
+		t.Fatal(err)
+	}
+	return v
+}
+
+func TestFromJSON(t *testing.T) {
+	m := setupMessage(t, "helloworld.HelloRequest")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`{"name":"a","times":150}`, "0a01611096" + "01"},
+		{`{"times":"-1"}`, "10ffffffffffffffffff01"},
+		{`{"ids":[1,"2"]}`, "1a020102"},
+		{`{"mood":"GRUMPY","loud":true}`, "20022801"},
+		{`{"mood":1}`, "2001"},
+		{`{"counts":{"b":2,"a":1}}`, "32050a016110013205" + "0a01621002"},
+		{`{"sent_at":"1970-01-01T00:00:01.5Z"}`, "3a0808011080cab5ee01"},
+		{`{"token":"AQI="}`, "4a020102"},
+		{`{"token":"AQI"}`, "4a020102"},
+		{`{"name":null}`, ""},
+	}
+
+	for _, test := range tests {
+		b, err := m.FromJSON(decodeJSON(t, test.input))
+		if err != nil || hex.EncodeToString(b) != test.expected {
+			t.Errorf("FromJSON returned wrong encoding for %v: got %x %v want %v", test.input, b, err, test.expected)
+		}
+	}
+}
+
+func TestJSONRoundTrip(t *testing.T) {
+	m := setupMessage(t, "helloworld.HelloRequest")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{
+			`{"name":"a","times":2,"ids":["9007199254740993"],"mood":"HAPPY","loud":true}`,
+			`{"ids":["9007199254740993"],"loud":true,"mood":"HAPPY","name":"a","times":2}`,
+		},
+		{`{"sentAt":"2020-05-01T10:00:00.250+02:00"}`, `{"sentAt":"2020-05-01T08:00:00.25Z"}`},
+		{`{"wait":"-1.5s"}`, `{"wait":"-1.5s"}`},
+		{`{"ratio":"NaN"}`, `{"ratio":"NaN"}`},
+		{`{"ratio":0.1}`, `{"ratio":0.1}`},
+		{`{"counts":{"x":0}}`, `{"counts":{"x":0}}`},
+		{
+			`{"extra":{"a":null,"b":[1,"two",true],"c":{"d":{}}}}`,
+			`{"extra":{"a":null,"b":[1,"two",true],"c":{"d":{}}}}`,
+		},
+		{`{}`, `{}`},
+	}
+
+	for _, test := range tests {
+		b, err := m.FromJSON(decodeJSON(t, test.input))
+		if err != nil {
+			t.Errorf("FromJSON returned an error for %v: %v", test.input, err)
+			continue
+		}
+		val, err := m.ToJSON(b)
+		if err != nil {
+			t.Errorf("ToJSON returned an error for %v: %v", test.input, err)
+			continue
+		}
+		if res, _ := json.Marshal(val); string(res) != test.expected {
+			t.Errorf("ToJSON returned wrong JSON: got %s want %s", res, test.expected)
+		}
+	}
+}
+
+func TestFromJSONFail(t *testing.T) {
+	m := setupMessage(t, "helloworld.HelloRequest")
+	tests := []struct {
+		input    string
+		expected string
+	}{
+		{`[]`, "must be an object"},
+		{`{"nmae":"a"}`, "nmae: unknown field"},
+		{`{"times":1.5}`, "times: must be a 32 bit integer"},
+		{`{"times":"4294967296"}`, "times: must be a 32 bit integer"},
+		{`{"ids":1}`, "ids: must be an array"},
+		{`{"ids":[1,"x"]}`, "ids[1]: must be a 64 bit integer"},
+		{`{"mood":"SAD"}`, "mood: unknown value SAD of helloworld.Mood"},
+		{`{"loud":"yes"}`, "loud: must be a bool"},
+		{`{"sentAt":"yesterday"}`, "sentAt: must be an RFC 3339 timestamp"},
+		{`{"wait":"1m"}`, "wait: must be a duration such as 1.5s"},
+		{`{"token":"%%"}`, "token: must be a base64 string"},
+	}
+
+	for _, test := range tests {
+		if _, err := m.FromJSON(decodeJSON(t, test.input)); err == nil || err.Error() != test.expected {
+			t.Errorf("FromJSON returned wrong error for %v: got %v want %v", test.input, err, test.expected)
+		}
+	}
+}
+
+func TestToJSONFail(t *testing.T) {
+	m := setupMessage(t, "helloworld.HelloRequest")
+	tests := []struct {
+		data     string
+		expected string
+	}{
+		{"0a05", "truncated message"},
+		{"0d01000000", "name: invalid wire type"},
+	}
+
+	for _, test := range tests {
+		data, _ := hex.DecodeString(test.data)
+		if _, err := m.ToJSON(data); err == nil || err.Error() != test.expected {
+			t.Errorf("ToJSON returned wrong error for %v: got %v want %v", test.data, err, test.expected)
+		}
+	}
+}
+
+func TestSetParam(t *testing.T) {
+	s, err := ParseSet(setupDescriptorSet())
+	if err != nil {
+		t.Fatal(err)
+	}
+	tests := []struct {
+		message  string
+		path     string
+		values   []string
+		expected string
+	}{
+		{"helloworld.HelloRequest", "name", []string{"a", "b"}, `{"name":"b"}`},
+		{"helloworld.HelloRequest", "ids", []string{"1", "2"}, `{"ids":["1","2"]}`},
+		{"helloworld.HelloRequest", "loud", []string{"true"}, `{"loud":true}`},
+		{"helloworld.HelloRequest", "sent_at", []string{"2020-05-01T00:00:00Z"}, `{"sentAt":"2020-05-01T00:00:00Z"}`},
+		{"helloworld.UpdateGreetingRequest", "greeting.name", []string{"greetings/1"}, `{"greeting":{"name":"greetings/1"}}`},
+		{"helloworld.HelloRequest", "counts", []string{"1"}, "counts: cannot be set from a parameter"},
+		{"helloworld.HelloRequest", "extra", []string{"1"}, "extra: cannot be set from a parameter"},
+		{"helloworld.HelloRequest", "loud", []string{"loud"}, "loud: must be a bool"},
+		{"helloworld.HelloRequest", "name.first", []string{"a"}, "name.first: not a message field"},
+		{"helloworld.HelloRequest", "key", []string{"a"}, "key: unknown field"},
+	}
+
+	for _, test := range tests {
+		obj := make(map[string]interface{})
+		if err := s.Messages[test.message].SetParam(obj, test.path, test.values); err != nil {
+			if err.Error() != test.expected {
+				t.Errorf("SetParam returned wrong error for %v: got %v want %v", test.path, err, test.expected)
+			}
+			continue
+		}
+		if res, _ := json.Marshal(obj); string(res) != test.expected {
+			t.Errorf("SetParam returned wrong JSON for %v: got %s want %s", test.path, res, test.expected)
+		}
+	}
+}
@@ -0,0 +1,214 @@
+package protobuf
+
+import (
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+	"unicode"
+)
+
+const (
+	timestampLayout = "2006-01-02T15:04:05.999999999Z07:00"
+	// seconds of 0001-01-01 and 9999-12-31T23:59:59, the range of Timestamp
+	minTimestamp = -62135596800
+	maxTimestamp = 253402300799
+)
+
+//timestampToJSON RFC 3339 time in UTC
+func timestampToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	seconds, nanos := jsonInteger(obj["seconds"]), jsonInteger(obj["nanos"])
+	if seconds < minTimestamp || seconds > maxTimestamp || nanos < 0 || nanos > 999999999 {
+		return nil, &Error{"", "timestamp out of range"}
+	}
+	return time.Unix(seconds, nanos).UTC().Format(timestampLayout), nil
+}
+
+func timestampFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	s, _ := value.(string)
+	t, err := time.Parse(time.RFC3339Nano, s)
+	if err != nil || t.Unix() < minTimestamp || t.Unix() > maxTimestamp {
+		return nil, &Error{path, "must be an RFC 3339 timestamp"}
+	}
+	return m.encodeObject(map[string]interface{}{
+		"seconds": strconv.FormatInt(t.Unix(), 10),
+		"nanos":   int64(t.Nanosecond()),
+	}, path)
+}
+
+//durationToJSON seconds with a fraction and the suffix s, such as 1.5s
+func durationToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	seconds, nanos := jsonInteger(obj["seconds"]), jsonInteger(obj["nanos"])
+	sign := ""
+	if seconds < 0 || nanos < 0 {
+		sign, seconds, nanos = "-", -seconds, -nanos
+	}
+	text := sign + strconv.FormatInt(seconds, 10)
+	if nanos != 0 {
+		text += "." + strings.TrimRight(fmt.Sprintf("%09d", nanos), "0")
+	}
+	return text + "s", nil
+}
+
+func durationFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	s, _ := value.(string)
+	invalid := &Error{path, "must be a duration such as 1.5s"}
+	if !strings.HasSuffix(s, "s") {
+		return nil, invalid
+	}
+	s = strings.TrimSuffix(s, "s")
+	negative := strings.HasPrefix(s, "-")
+	s = strings.TrimPrefix(s, "-")
+
+	whole, frac := s, ""
+	if i := strings.Index(s, "."); i >= 0 {
+		whole, frac = s[:i], s[i+1:]
+	}
+	if whole == "" || len(frac) > 9 || !digits(whole) || !digits(frac) {
+		return nil, invalid
+	}
+	seconds, err := strconv.ParseInt(whole, 10, 64)
+	if err != nil {
+		return nil, invalid
+	}
+	var nanos int64
+	if frac != "" {
+		nanos, _ = strconv.ParseInt(frac+strings.Repeat("0", 9-len(frac)), 10, 64)
+	}
+	if negative {
+		seconds, nanos = -seconds, -nanos
+	}
+	return m.encodeObject(map[string]interface{}{
+		"seconds": strconv.FormatInt(seconds, 10),
+		"nanos":   nanos,
+	}, path)
+}
+
+//fieldMaskToJSON paths in lowerCamelCase joined by commas
+func fieldMaskToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	paths, _ := obj["paths"].([]interface{})
+	names := make([]string, len(paths))
+	for i, p := range paths {
+		names[i] = camelCase(fmt.Sprint(p))
+	}
+	return strings.Join(names, ","), nil
+}
+
+func fieldMaskFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	s, ok := value.(string)
+	if !ok {
+		return nil, &Error{path, "must be a string"}
+	}
+	var paths []interface{}
+	for _, p := range strings.Split(s, ",") {
+		if p == "" {
+			continue
+		}
+		var snake strings.Builder
+		for _, c := range p {
+			if unicode.IsUpper(c) {
+				snake.WriteByte('_')
+				c = unicode.ToLower(c)
+			}
+			snake.WriteRune(c)
+		}
+		paths = append(paths, snake.String())
+	}
+	return m.encodeObject(map[string]interface{}{"paths": paths}, path)
+}
+
+//structToJSON object of the fields of a Struct
+func structToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	if fields, ok := obj["fields"]; ok {
+		return fields, nil
+	}
+	return map[string]interface{}{}, nil
+}
+
+//listToJSON array of the values of a ListValue
+func listToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	if values, ok := obj["values"]; ok {
+		return values, nil
+	}
+	return []interface{}{}, nil
+}
+
+//containerFromJSON encode a Struct or ListValue from its only field
+func containerFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	f := m.numbers[1]
+	if f == nil {
+		return nil, &Error{path, "invalid descriptor of " + m.Name}
+	}
+	return f.encode(nil, value, path)
+}
+
+//valueToJSON JSON value of the kind set in a Value
+func valueToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	for _, val := range obj {
+		return val, nil
+	}
+	return nil, nil
+}
+
+func valueFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	var kind string
+	switch value.(type) {
+	case nil:
+		kind = "null_value"
+	case bool:
+		kind = "bool_value"
+	case string:
+		kind = "string_value"
+	case map[string]interface{}:
+		kind = "struct_value"
+	case []interface{}:
+		kind = "list_value"
+	default:
+		kind = "number_value"
+	}
+	f := m.names[kind]
+	if f == nil {
+		return nil, &Error{path, "invalid descriptor of " + m.Name}
+	}
+	return f.encodeOne(nil, value, path)
+}
+
+//wrapperToJSON the value of a wrapper such as StringValue
+func wrapperToJSON(m *Message, obj map[string]interface{}) (interface{}, error) {
+	if val, ok := obj["value"]; ok {
+		return val, nil
+	}
+	if f := m.numbers[1]; f != nil {
+		return f.Default(), nil
+	}
+	return nil, nil
+}
+
+func wrapperFromJSON(m *Message, value interface{}, path string) ([]byte, error) {
+	f := m.numbers[1]
+	if f == nil {
+		return nil, &Error{path, "invalid descriptor of " + m.Name}
+	}
+	return f.encodeOne(nil, value, path)
+}
+
+//jsonInteger integer of a decoded int64 string or int32 number
+func jsonInteger(value interface{}) int64 {
+	switch v := value.(type) {
+	case string:
+		i, _ := strconv.ParseInt(v, 10, 64)
+		return i
+	case int64:
+		return v
+	}
+	return 0
+}
+
+func digits(s string) bool {
+	for _, c := range s {
+		if c < '0' || c > '9' {
+			return false
+		}
+	}
+	return true
+}
@@ -0,0 +1,214 @@
+package protobuf
+
+import (
+	"encoding/binary"
+	"errors"
+	"math"
+)
+
+// Wire types
+const (
+	wireVarint     = 0
+	wireFixed64    = 1
+	wireBytes      = 2
+	wireStartGroup = 3
+	wireEndGroup   = 4
+	wireFixed32    = 5
+)
+
+var (
+	errTruncated = errors.New("truncated message")
+	errWireType  = errors.New("invalid wire type")
+	errOverflow  = errors.New("varint overflows 64 bits")
+)
+
+//reader cursor over an encoded message
+type reader struct {
+	data []byte
+	pos  int
+}
+
+func (r *reader) done() bool {
+	return r.pos >= len(r.data)
+}
+
+func (r *reader) varint() (uint64, error) {
+	var v uint64
+	for shift := uint(0); shift < 64; shift += 7 {
+		if r.done() {
+			return 0, errTruncated
+		}
+		b := r.data[r.pos]
+		r.pos++
+		v |= uint64(b&0x7f) << shift
+		if b < 0x80 {
+			return v, nil
+		}
+	}
+	return 0, errOverflow
+}
+
+func (r *reader) fixed32() (uint32, error) {
+	if len(r.data)-r.pos < 4 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint32(r.data[r.pos:])
+	r.pos += 4
+	return v, nil
+}
+
+func (r *reader) fixed64() (uint64, error) {
+	if len(r.data)-r.pos < 8 {
+		return 0, errTruncated
+	}
+	v := binary.LittleEndian.Uint64(r.data[r.pos:])
+	r.pos += 8
+	return v, nil
+}
+
+func (r *reader) bytes() ([]byte, error) {
+	n, err := r.varint()
+	if err != nil {
+		return nil, err
+	}
+	if n > uint64(len(r.data)-r.pos) {
+		return nil, errTruncated
+	}
+	b := r.data[r.pos : r.pos+int(n)]
+	r.pos += int(n)
+	return b, nil
+}
+
+//key field number and wire type of the next field
+func (r *reader) key() (int, int, error) {
+	k, err := r.varint()
+	if err != nil {
+		return 0, 0, err
+	}
+	if k>>3 == 0 || k>>3 > math.MaxInt32 {
+		return 0, 0, errors.New("invalid field number")
+	}
+	return int(k >> 3), int(k & 7), nil
+}
+
+//skip the value of a field of wire type wire
+//  groups are skipped up to their matching end
+func (r *reader) skip(wire int) error {
+	var err error
+	switch wire {
+	case wireVarint:
+		_, err = r.varint()
+	case wireFixed64:
+		_, err = r.fixed64()
+	case wireBytes:
+		_, err = r.bytes()
+	case wireFixed32:
+		_, err = r.fixed32()
+	case wireStartGroup:
+		for {
+			_, w, err := r.key()
+			if err != nil {
+				return err
+			}
+			if w == wireEndGroup {
+				return nil
+			}
+			if err := r.skip(w); err != nil {
+				return err
+			}
+		}
+	default:
+		err = errWireType
+	}
+	return err
+}
+
+func appendVarint(b []byte, v uint64) []byte {
+	for v >= 0x80 {
+		b = append(b, byte(v)|0x80)
+		v >>= 7
+	}
+	return append(b, byte(v))
+}
+
+func appendKey(b []byte, num, wire int) []byte {
+	return appendVarint(b, uint64(num)<<3|uint64(wire))
+}
+
+func appendBytes(b, v []byte) []byte {
+	b = appendVarint(b, uint64(len(v)))
+	return append(b, v...)
+}
+
+func appendFixed32(b []byte, v uint32) []byte {
+	return binary.LittleEndian.AppendUint32(b, v)
+}
+
+func appendFixed64(b []byte, v uint64) []byte {
+	return binary.LittleEndian.AppendUint64(b, v)
+}
+
+func zigzag(v int64) uint64 {
+	return uint64(v<<1) ^ uint64(v>>63)
+}
+
+func unzigzag(v uint64) int64 {
+	return int64(v>>1) ^ -int64(v&1)
+}
+
+//wireValue field of an encoded message
+//  u holds varint and fixed values and b length-delimited ones
+type wireValue struct {
+	num  int
+	wire int
+	u    uint64
+	b    []byte
+}
+
+//value read a value of wire type wire
+func (r *reader) value(wire int) (wireValue, error) {
+	v := wireValue{wire: wire}
+	var err error
+	switch wire {
+	case wireVarint:
+		v.u, err = r.varint()
+	case wireFixed64:
+		v.u, err = r.fixed64()
+	case wireFixed32:
+		var u uint32
+		u, err = r.fixed32()
+		v.u = uint64(u)
+	case wireBytes:
+		v.b, err = r.bytes()
+	default:
+		err = errWireType
+	}
+	return v, err
+}
+
+//each call fn with every field of an encoded message
+//  groups are skipped
+func each(data []byte, fn func(v wireValue) error) error {
+	r := &reader{data: data}
+	for !r.done() {
+		num, wire, err := r.key()
+		if err != nil {
+			return err
+		}
+		if wire == wireStartGroup {
+			if err := r.skip(wire); err != nil {
+				return err
+			}
+			continue
+		}
+		v, err := r.value(wire)
+		if err != nil {
+			return err
+		}
+		v.num = num
+		if err := fn(v); err != nil {
+			return err
+		}
+	}
+	return nil
+}
@@ -0,0 +1,317 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"net"
+	"net/http"
+	"net/url"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	aggregatesKey   = "aggregates"
+	aggregateMetric = "smug_aggregate_calls_total"
+)
+
+// Errors of aggregate requests
+var (
+	ErrUnknownAggregate = errors.New("Invalid Aggregate Name")
+)
+
+var (
+	aggregates    map[string]Aggregate
+	aggregateLock sync.RWMutex
+)
+
+func init() {
+	aggregates = make(map[string]Aggregate)
+}
+
+//Aggregate composite endpoint from the aggregates config section
+//  its calls are sent in parallel and their JSON responses merged into
+//  one document, Timeout applies to calls without a timeout of their own
+type Aggregate struct {
+	Timeout time.Duration   `mapstructure:"timeout"`
+	Calls   []AggregateCall `mapstructure:"calls"`
+}
+
+//AggregateCall request to a registered service made by an aggregate
+//  Service is a service name, optionally name@constraint
+//  Path and Headers are templates, see transformData, without Params,
+//  request values in paths should be escaped with urlquery
+//  Map copies fields of the response into the document, by default the
+//  whole response is set at Name
+//  Timeout, or else the aggregate Timeout, replaces the route timeout
+//  failed Optional calls are only reported in the errors of the document
+type AggregateCall struct {
+	Name     string        `mapstructure:"name"`
+	Service  string        `mapstructure:"service"`
+	Method   string        `mapstructure:"method"`
+	Path     string        `mapstructure:"path"`
+	Headers  []Template    `mapstructure:"headers"`
+	Timeout  time.Duration `mapstructure:"timeout"`
+	Optional bool          `mapstructure:"optional"`
+	Map      []Mapping     `mapstructure:"map"`
+
+	path []Template
+}
+
+//CallError failure of an aggregate call
+type CallError struct {
+	Status int    `json:"status,omitempty"`
+	Reason string `json:"reason"`
+}
+
+//AggregateResult merged document of an aggregate
+//  Errors lists the failed calls by name, Failed is set if any of them
+//  was not optional
+type AggregateResult struct {
+	Data   map[string]interface{}
+	Errors map[string]CallError
+	Failed bool
+}
+
+//AggregateInterface defines aggregate methods
+type AggregateInterface interface {
+	Aggregate(r *http.Request, name string, authorize func(*http.Request) bool) (AggregateResult, error)
+}
+
+//AggregateService defines aggregate service struct
+type AggregateService struct {
+}
+
+//PrepareAggregates read and validate the aggregates section of a candidate config
+func PrepareAggregates(v *viper.Viper) (func(), error) {
+	candidate := make(map[string]Aggregate)
+	if err := v.UnmarshalKey(aggregatesKey, &candidate); err != nil {
+		return nil, err
+	}
+	for name, aggregate := range candidate {
+		if err := validateAggregate(&aggregate); err != nil {
+			return nil, fmt.Errorf("aggregates.%s %s", name, err.Error())
+		}
+		candidate[name] = aggregate
+	}
+
+	return func() {
+		aggregateLock.Lock()
+		aggregates = candidate
+		aggregateLock.Unlock()
+	}, nil
+}
+
+//validateAggregate check and compile the calls of an aggregate
+func validateAggregate(a *Aggregate) error {
+	if len(a.Calls) == 0 {
+		return errors.New("calls: at least one call is required")
+	}
+	if a.Timeout < 0 {
+		return errors.New("timeout: must not be negative")
+	}
+
+	names := make(map[string]bool)
+	for i := range a.Calls {
+		call := &a.Calls[i]
+		if call.Name == "" || call.Service == "" {
+			return fmt.Errorf("calls %d: name and service are required", i)
+		}
+		if names[call.Name] {
+			return fmt.Errorf("calls %d: duplicate name %s", i, call.Name)
+		}
+		names[call.Name] = true
+
+		call.Method = strings.ToUpper(call.Method)
+		if call.Method == "" {
+			call.Method = http.MethodGet
+		}
+		if call.Timeout < 0 {
+			return fmt.Errorf("calls %d: timeout must not be negative", i)
+		}
+
+		call.path = []Template{{Name: "path", Value: call.Path}}
+		if err := compileTemplates(fmt.Sprintf("calls %d", i), call.path); err != nil {
+			return err
+		}
+		if err := compileTemplates(fmt.Sprintf("calls %d headers", i), call.Headers); err != nil {
+			return err
+		}
+		for j, m := range call.Map {
+			if m.From == "" || m.To == "" {
+				return fmt.Errorf("calls %d map %d: from and to are required", i, j)
+			}
+		}
+	}
+	return nil
+}
+
+//Aggregate send the calls of the aggregate name and merge their responses
+//  authorize decides if r may call a service, given the routed request
+func (as AggregateService) Aggregate(r *http.Request, name string, authorize func(*http.Request) bool) (AggregateResult, error) {
+	aggregateLock.RLock()
+	aggregate, ok := aggregates[name]
+	aggregateLock.RUnlock()
+	if !ok {
+		log.Error("Aggregate Error: invalid aggregate name - " + name)
+		return AggregateResult{}, ErrUnknownAggregate
+	}
+
+	type callResult struct {
+		doc interface{}
+		err *CallError
+	}
+	results := make([]callResult, len(aggregate.Calls))
+
+	var wg sync.WaitGroup
+	for i, call := range aggregate.Calls {
+		wg.Add(1)
+		go func(i int, call AggregateCall) {
+			defer wg.Done()
+			doc, err := sendCall(r, aggregate, call, authorize)
+			results[i] = callResult{doc, err}
+		}(i, call)
+	}
+	wg.Wait()
+
+	res := AggregateResult{Data: make(map[string]interface{}), Errors: make(map[string]CallError)}
+	for i, call := range aggregate.Calls {
+		result := "success"
+		if err := results[i].err; err != nil {
+			result = "failure"
+			res.Errors[call.Name] = *err
+			res.Failed = res.Failed || !call.Optional
+			log.WithFields(log.Fields{
+				"aggregate": name,
+				"call":      call.Name,
+				"service":   call.Service,
+			}).Error("Aggregate Error: " + err.Reason)
+		} else if len(call.Map) == 0 {
+			res.Data[call.Name] = results[i].doc
+		} else {
+			for _, m := range call.Map {
+				if val, ok := getField(results[i].doc, m.From); ok {
+					setField(res.Data, m.To, val)
+				}
+			}
+		}
+		metrics.Inc(aggregateMetric, map[string]string{"aggregate": name, "call": call.Name, "result": result})
+	}
+	return res, nil
+}
+
+//sendCall send one call of an aggregate on behalf of r
+//  the call is routed like /service/{service}/{path} with the headers of r
+//  and must pass authorize as a request to the service would
+func sendCall(r *http.Request, aggregate Aggregate, call AggregateCall, authorize func(*http.Request) bool) (interface{}, *CallError) {
+	data := transformData{
+		Service: call.Service,
+		Params:  make(map[string]string),
+		Query:   make(map[string]string),
+		Header:  make(map[string]string),
+	}
+	for key, vals := range r.URL.Query() {
+		data.Query[key] = strings.Join(vals, ",")
+	}
+	for key, vals := range r.Header {
+		data.Header[key] = strings.Join(vals, ",")
+	}
+	if claims, ok := r.Context().Value(claimsKey{}).(map[string]interface{}); ok {
+		data.Claims = claims
+	}
+
+	path, err := call.path[0].render(data)
+	if err != nil {
+		return nil, &CallError{Reason: err.Error()}
+	}
+	// values from the request must not walk out of the templated path,
+	// escaped or not
+	unescaped, err := url.PathUnescape(path)
+	if err != nil {
+		return nil, &CallError{Status: http.StatusBadRequest, Reason: "invalid path " + path}
+	}
+	for _, segment := range strings.Split(unescaped, "/") {
+		if segment == ".." {
+			return nil, &CallError{Status: http.StatusBadRequest, Reason: "invalid path " + path}
+		}
+	}
+
+	routed, err := http.NewRequest(call.Method, servicePath+call.Service+"/"+strings.TrimPrefix(path, "/"), http.NoBody)
+	if err != nil {
+		return nil, &CallError{Reason: err.Error()}
+	}
+	for name, vals := range r.Header {
+		routed.Header[name] = vals
+	}
+	for _, name := range []string{"Accept-Encoding", "Content-Length", "Content-Type"} {
+		routed.Header.Del(name)
+	}
+	for _, h := range call.Headers {
+		val, err := h.render(data)
+		if err != nil {
+			return nil, &CallError{Reason: err.Error()}
+		}
+		routed.Header.Set(h.Name, val)
+	}
+
+	timeout := call.Timeout
+	if timeout == 0 {
+		timeout = aggregate.Timeout
+	}
+	if timeout > 0 {
+		routed = routed.WithContext(context.WithValue(r.Context(), timeoutKey{}, timeout))
+	} else {
+		routed = routed.WithContext(r.Context())
+	}
+
+	// the call is authorized for the instance it is routed to
+	routed = Resolve(routed)
+	if authorize != nil && !authorize(routed) {
+		return nil, &CallError{Status: http.StatusForbidden, Reason: "Forbidden"}
+	}
+	for _, name := range []string{"Api-Key", "Secret-Key"} {
+		routed.Header.Del(name)
+	}
+
+	var ds DiscoveryService
+	rsp, body, err := ds.Route(routed)
+	if err != nil {
+		return nil, callError(err, timeout)
+	}
+	if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+		return nil, &CallError{Status: rsp.StatusCode, Reason: fmt.Sprintf("upstream returned status %d", rsp.StatusCode)}
+	}
+
+	var doc interface{}
+	if len(body) == 0 {
+		return doc, nil
+	}
+	if err := decodeJSON(body, &doc); err != nil {
+		return nil, &CallError{Status: http.StatusBadGateway, Reason: "invalid JSON response"}
+	}
+	return doc, nil
+}
+
+//callError status of a call that got no response from its service
+func callError(err error, timeout time.Duration) *CallError {
+	if ne, ok := err.(net.Error); ok && ne.Timeout() {
+		return &CallError{Status: http.StatusGatewayTimeout, Reason: "timeout after " + timeout.String()}
+	}
+	switch err.(type) {
+	case *ValidationError:
+		return &CallError{Status: http.StatusBadRequest, Reason: err.Error()}
+	}
+	switch err {
+	case ErrUnknownService, ErrNoMatchingVersion, ErrUnknownPath:
+		return &CallError{Status: http.StatusNotFound, Reason: err.Error()}
+	case ErrMethodNotAllowed:
+		return &CallError{Status: http.StatusMethodNotAllowed, Reason: err.Error()}
+	}
+	return &CallError{Status: http.StatusBadGateway, Reason: err.Error()}
+}
@@ -0,0 +1,205 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+type timeoutError struct{}
+
+func (timeoutError) Error() string   { return "timeout" }
+func (timeoutError) Timeout() bool   { return true }
+func (timeoutError) Temporary() bool { return true }
+
+func setupAggregate(t *testing.T, calls []interface{}) *[]string {
+	setupVersionRoute()
+	serviceMap["users"] = []Instance{{URL: "http://users/"}}
+	serviceMap["orders"] = []Instance{{URL: "http://orders/"}}
+
+	v := viper.New()
+	v.Set(aggregatesKey, map[string]interface{}{
+		"home": map[string]interface{}{"timeout": "1s", "calls": calls},
+	})
+	apply, err := PrepareAggregates(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+
+	var lock sync.Mutex
+	var sent []string
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		lock.Lock()
+		sent = append(sent, httpMethod+" "+url+" "+headers["X-User"])
+		lock.Unlock()
+		switch {
+		case strings.HasPrefix(url, "http://users/"):
+			return &http.Response{StatusCode: http.StatusOK}, []byte(`{"user":{"name":"a","id":1}}`), nil
+		case strings.HasPrefix(url, "http://orders/slow"):
+			return nil, nil, timeoutError{}
+		case strings.HasPrefix(url, "http://orders/"):
+			return &http.Response{StatusCode: http.StatusServiceUnavailable}, nil, nil
+		}
+		return nil, nil, errors.New("unexpected url")
+	}
+	return &sent
+}
+
+func TestAggregate(t *testing.T) {
+	sent := setupAggregate(t, []interface{}{
+		map[string]interface{}{"name": "profile", "service": "users", "path": "/users/{{urlquery .Query.id}}",
+			"headers": []interface{}{map[string]interface{}{"name": "X-User", "value": "{{.Query.id}}"}},
+			"map":     []interface{}{map[string]interface{}{"from": "user.name", "to": "profile.name"}}},
+		map[string]interface{}{"name": "raw", "service": "users", "path": "me"},
+		map[string]interface{}{"name": "orders", "service": "orders", "path": "/orders", "optional": true},
+	})
+	var as AggregateService
+
+	r, _ := http.NewRequest(http.MethodGet, "/aggregate/home?id=7", nil)
+	r.Header.Set("api-key", "key")
+	res, err := as.Aggregate(r, "home", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := map[string]interface{}{
+		"profile": map[string]interface{}{"name": "a"},
+		"raw":     map[string]interface{}{"user": map[string]interface{}{"name": "a", "id": "1"}},
+	}
+	if res.Failed || len(res.Data) != 2 || !reflect.DeepEqual(res.Data["profile"], expected["profile"]) {
+		t.Errorf("service returned unexpected document: got %v want %v", res.Data, expected)
+	}
+	if e := res.Errors["orders"]; e.Status != http.StatusServiceUnavailable || len(res.Errors) != 1 {
+		t.Errorf("service returned unexpected errors: got %v", res.Errors)
+	}
+	if !containsString(*sent, "GET http://users/users/7 7") || len(*sent) != 3 {
+		t.Errorf("service sent unexpected calls: got %v", *sent)
+	}
+}
+
+func TestAggregateFail(t *testing.T) {
+	setupAggregate(t, []interface{}{
+		map[string]interface{}{"name": "profile", "service": "users", "path": "/users/{{.Query.id}}"},
+		map[string]interface{}{"name": "slow", "service": "orders", "path": "/slow"},
+		map[string]interface{}{"name": "missing", "service": "missing", "optional": true},
+	})
+	var as AggregateService
+
+	if _, err := as.Aggregate(&http.Request{}, "unknown", nil); err != ErrUnknownAggregate {
+		t.Errorf("service returned unexpected error: got %v want %v", err, ErrUnknownAggregate)
+	}
+
+	r, _ := http.NewRequest(http.MethodGet, "/aggregate/home?id=..", nil)
+	res, err := as.Aggregate(r, "home", nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := map[string]int{
+		"profile": http.StatusBadRequest,
+		"slow":    http.StatusGatewayTimeout,
+		"missing": http.StatusNotFound,
+	}
+	if !res.Failed || len(res.Errors) != len(expected) {
+		t.Errorf("service returned unexpected result: got %v", res)
+	}
+	for name, status := range expected {
+		if res.Errors[name].Status != status {
+			t.Errorf("%v: got status %v want %v", name, res.Errors[name].Status, status)
+		}
+	}
+
+	// escaped traversals are rejected as well
+	for _, id := range []string{"%252e%252e", "a%252F..%252Fb", "%25zz"} {
+		r, _ := http.NewRequest(http.MethodGet, "/aggregate/home?id="+id, nil)
+		res, err := as.Aggregate(r, "home", nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := res.Errors["profile"].Status; got != http.StatusBadRequest {
+			t.Errorf("%v: got status %v want %v", id, got, http.StatusBadRequest)
+		}
+	}
+}
+
+func TestAggregateRoute(t *testing.T) {
+	sent := setupAggregate(t, []interface{}{
+		map[string]interface{}{"name": "profile", "service": "users", "path": "/users/1"},
+		map[string]interface{}{"name": "orders", "service": "orders", "path": "/orders"},
+		map[string]interface{}{"name": "cache", "service": "cache", "optional": true},
+	})
+	serviceMap["cache"] = []Instance{{URL: "tcp://cache:6379", Protocol: ProtocolTCP, Listen: 6380}}
+	routePolicies["users"] = transformPolicy(RoutePolicy{
+		Rewrite: []RewriteRule{{Match: "^/users/(.*)$", Replace: "/v2/users/$1"}},
+		Transform: Transform{Request: RequestTransform{
+			SetHeaders: []Template{{Name: "X-User", Value: "{{.Service}}"}},
+		}},
+	})
+	var as AggregateService
+
+	var lock sync.Mutex
+	var checked []string
+	authorize := func(routed *http.Request) bool {
+		lock.Lock()
+		checked = append(checked, routed.URL.Path+" "+routed.Header.Get("Api-Key"))
+		lock.Unlock()
+		return !strings.HasPrefix(routed.URL.Path, "/service/orders/")
+	}
+	r, _ := http.NewRequest(http.MethodGet, "/aggregate/home", nil)
+	r.Header.Set("api-key", "key")
+	res, err := as.Aggregate(r, "home", authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if len(checked) != 3 || !containsString(checked, "/service/orders/orders key") {
+		t.Errorf("service authorized unexpected calls: got %v", checked)
+	}
+	expected := map[string]int{"orders": http.StatusForbidden, "cache": http.StatusNotFound}
+	if len(res.Errors) != len(expected) {
+		t.Errorf("service returned unexpected errors: got %v", res.Errors)
+	}
+	for name, status := range expected {
+		if res.Errors[name].Status != status {
+			t.Errorf("%v: got status %v want %v", name, res.Errors[name].Status, status)
+		}
+	}
+	if len(*sent) != 1 || (*sent)[0] != "GET http://users/v2/users/1 users" {
+		t.Errorf("service sent unexpected calls: got %v", *sent)
+	}
+}
+
+func TestPrepareAggregatesFail(t *testing.T) {
+	tests := []map[string]interface{}{
+		{"calls": []interface{}{}},
+		{"calls": []interface{}{map[string]interface{}{"name": "a"}}},
+		{"calls": []interface{}{map[string]interface{}{"name": "a", "service": "a"}, map[string]interface{}{"name": "a", "service": "b"}}},
+		{"calls": []interface{}{map[string]interface{}{"name": "a", "service": "a", "path": "{{.Query"}}},
+		{"calls": []interface{}{map[string]interface{}{"name": "a", "service": "a", "map": []interface{}{map[string]interface{}{"from": "a"}}}}},
+		{"timeout": "-1s", "calls": []interface{}{map[string]interface{}{"name": "a", "service": "a"}}},
+	}
+
+	for i, test := range tests {
+		v := viper.New()
+		v.Set(aggregatesKey, map[string]interface{}{"test": test})
+		if _, err := PrepareAggregates(v); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
+
+func containsString(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,503 @@
+package service
+
+import (
+	"errors"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+	"github.com/spf13/viper"
+)
+
+const (
+	cacheMetric         = "smug_cache_requests_total"
+	cacheStatusHeader   = "X-Cache"
+	cacheMaxBytesKey    = "cache.max_bytes"
+	defaultCacheMaxSize = 64 << 20
+)
+
+// Cache lookup results
+const (
+	CacheHit         = "hit"
+	CacheMiss        = "miss"
+	CacheStale       = "stale"
+	CacheRevalidated = "revalidated"
+	CacheBypass      = "bypass"
+)
+
+var (
+	defaultCache *LRUCache
+	cacheStore   CacheStore
+	cacheNow     func() time.Time
+	flights      *flightGroup
+)
+
+func init() {
+	defaultCache = NewLRUCache(defaultCacheMaxSize)
+	cacheStore = defaultCache
+	cacheNow = time.Now
+	flights = &flightGroup{calls: make(map[string]*flightCall)}
+}
+
+//CacheEntry cached upstream response
+//  entries are shared between requests and must not be modified once stored
+//  an entry with Variants only names the request headers the responses of
+//  its URL vary on, each variant is stored under its own key, see variantKey
+type CacheEntry struct {
+	StatusCode int
+	Header     http.Header
+	Body       []byte
+	Vary       map[string]string
+	Variants   []string
+	Stored     time.Time
+	Fresh      time.Duration
+	Stale      time.Duration
+}
+
+//CacheStore storage of cached responses, see SetCacheStore
+type CacheStore interface {
+	Get(key string) (*CacheEntry, bool)
+	Set(key string, entry *CacheEntry)
+	Delete(key string)
+}
+
+//SetCacheStore replace the in-memory LRU store
+//  must be called before serving requests
+func SetCacheStore(store CacheStore) {
+	cacheStore = store
+}
+
+//PrepareCache read the size limit of the in-memory store from a candidate config
+func PrepareCache(v *viper.Viper) (func(), error) {
+	maxBytes := int64(defaultCacheMaxSize)
+	if v.IsSet(cacheMaxBytesKey) {
+		maxBytes = v.GetInt64(cacheMaxBytesKey)
+	}
+	if maxBytes < 0 {
+		return nil, errors.New(cacheMaxBytesKey + " must not be negative")
+	}
+	return func() { defaultCache.Resize(maxBytes) }, nil
+}
+
+func (e *CacheEntry) size() int64 {
+	size := int64(len(e.Body))
+	for key, vals := range e.Header {
+		size += int64(len(key))
+		for _, val := range vals {
+			size += int64(len(val))
+		}
+	}
+	return size
+}
+
+//varyMatches check the request carries the header values the entry varies on
+func (e *CacheEntry) varyMatches(header map[string]string) bool {
+	for key, val := range e.Vary {
+		if header[key] != val {
+			return false
+		}
+	}
+	return true
+}
+
+//lookup entry of url matching the request header and the key it is stored under
+//  the key is that of the request's variant when the responses of url vary
+func lookup(url string, header map[string]string) (*CacheEntry, string, bool) {
+	entry, ok := cacheStore.Get(url)
+	if ok && len(entry.Variants) > 0 {
+		key := variantKey(url, entry.Variants, header)
+		entry, ok = cacheStore.Get(key)
+		if !ok || !entry.varyMatches(header) {
+			return nil, key, false
+		}
+		return entry, key, true
+	}
+	if ok && !entry.varyMatches(header) {
+		return nil, url, false
+	}
+	return entry, url, ok
+}
+
+//store entry of url for the request header, under the key of its variant
+//  when it varies, url then names the headers it varies on
+func store(url string, header map[string]string, entry *CacheEntry) {
+	if len(entry.Vary) == 0 {
+		cacheStore.Set(url, entry)
+		return
+	}
+	cacheStore.Set(url, &CacheEntry{Variants: entry.varyNames()})
+	cacheStore.Set(entryKey(url, header, entry), entry)
+}
+
+//entryKey key entry of url is stored under for the request header
+func entryKey(url string, header map[string]string, entry *CacheEntry) string {
+	if len(entry.Vary) == 0 {
+		return url
+	}
+	return variantKey(url, entry.varyNames(), header)
+}
+
+//varyNames sorted names of the request headers the entry varies on
+func (e *CacheEntry) varyNames() []string {
+	names := make([]string, 0, len(e.Vary))
+	for name := range e.Vary {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	return names
+}
+
+//variantKey key of the variant of url selected by the named request headers
+func variantKey(url string, names []string, header map[string]string) string {
+	key := url
+	for _, name := range names {
+		key += "\n" + name + ": " + header[name]
+	}
+	return key
+}
+
+//response build a response from the entry for one request
+func (e *CacheEntry) response(status string, now time.Time) *http.Response {
+	header := make(http.Header, len(e.Header)+2)
+	for key, vals := range e.Header {
+		header[key] = append([]string(nil), vals...)
+	}
+	header.Set("Age", strconv.Itoa(int(now.Sub(e.Stored)/time.Second)))
+	header.Set(cacheStatusHeader, strings.ToUpper(status))
+	return &http.Response{StatusCode: e.StatusCode, Header: header}
+}
+
+//cachedRequest send a GET request through the response cache
+//  fresh entries are served directly, stale entries within their
+//  stale-while-revalidate window are served while revalidating in the
+//  background, concurrent misses of the same variant of a URL share one
+//  upstream request, and its response when it is not cached but may be shared
+func cachedRequest(serviceName, url string, header map[string]string,
+	client clientInterface) (*http.Response, []byte, error) {
+
+	reqCC := parseCacheControl(header["Cache-Control"])
+	if _, ok := reqCC["no-store"]; ok {
+		metrics.Inc(cacheMetric, map[string]string{"service": serviceName, "result": CacheBypass})
+		return request(url, http.MethodGet, header, "", client)
+	}
+
+	now := cacheNow()
+	entry, key, ok := lookup(url, header)
+	if _, noCache := reqCC["no-cache"]; ok && !noCache {
+		age := now.Sub(entry.Stored)
+		if age < entry.Fresh {
+			metrics.Inc(cacheMetric, map[string]string{"service": serviceName, "result": CacheHit})
+			return conditional(header, entry.response(CacheHit, now), entry.Body)
+		}
+		if age < entry.Fresh+entry.Stale {
+			metrics.Inc(cacheMetric, map[string]string{"service": serviceName, "result": CacheStale})
+			background := make(map[string]string, len(header))
+			for key, val := range header {
+				background[key] = val
+			}
+			go flights.do(key, func() flightResult {
+				return revalidate(serviceName, url, background, client, entry)
+			})
+			return conditional(header, entry.response(CacheStale, now), entry.Body)
+		}
+	}
+
+	result, shared := flights.do(key, func() flightResult {
+		return revalidate(serviceName, url, header, client, entry)
+	})
+	if result.err != nil {
+		return nil, nil, result.err
+	}
+	// a response that was not cached is handed to the callers sharing it
+	// unless it is for one caller, each gets a copy
+	if _, auth := header["Authorization"]; result.entry == nil && (!shared || result.shareable && !auth) {
+		return conditional(header, copyResponse(result.rsp), result.body)
+	}
+	if !shared {
+		return conditional(header, result.rsp, result.body)
+	}
+
+	// cached responses are only shared with the same variant
+	if result.entry == nil || !result.entry.varyMatches(header) {
+		r := revalidate(serviceName, url, header, client, entry)
+		if r.err != nil {
+			return nil, nil, r.err
+		}
+		return conditional(header, r.rsp, r.body)
+	}
+	return conditional(header, result.entry.response(CacheMiss, cacheNow()), result.entry.Body)
+}
+
+//revalidate fetch url, sending the validators of entry if it is set
+//  the response is stored if cacheable
+func revalidate(serviceName, url string, header map[string]string,
+	client clientInterface, entry *CacheEntry) flightResult {
+
+	// conditions of the client are answered by the gateway
+	upstream := make(map[string]string, len(header)+2)
+	for key, val := range header {
+		if key != "If-None-Match" && key != "If-Modified-Since" {
+			upstream[key] = val
+		}
+	}
+	if entry != nil {
+		if etag := entry.Header.Get("ETag"); etag != "" {
+			upstream["If-None-Match"] = etag
+		}
+		if modified := entry.Header.Get("Last-Modified"); modified != "" {
+			upstream["If-Modified-Since"] = modified
+		}
+	}
+
+	rsp, body, err := request(url, http.MethodGet, upstream, "", client)
+	if err != nil {
+		return flightResult{err: err}
+	}
+	if rsp.Header == nil {
+		rsp.Header = http.Header{}
+	}
+	now := cacheNow()
+
+	if rsp.StatusCode == http.StatusNotModified && entry != nil {
+		updated := *entry
+		updated.Header = make(http.Header, len(entry.Header))
+		for key, vals := range entry.Header {
+			updated.Header[key] = vals
+		}
+		for key, vals := range rsp.Header {
+			updated.Header[key] = vals
+		}
+		updated.Stored = now
+		updated.Fresh, updated.Stale, _ = freshness(updated.Header, now)
+		store(url, header, &updated)
+
+		metrics.Inc(cacheMetric, map[string]string{"service": serviceName, "result": CacheRevalidated})
+		return flightResult{rsp: updated.response(CacheRevalidated, now), body: updated.Body, entry: &updated}
+	}
+
+	metrics.Inc(cacheMetric, map[string]string{"service": serviceName, "result": CacheMiss})
+	stored, ok := newCacheEntry(rsp, body, header, now)
+	if !ok {
+		if entry != nil {
+			cacheStore.Delete(entryKey(url, header, entry))
+		}
+		return flightResult{rsp: rsp, body: body, shareable: shareable(rsp, header)}
+	}
+	store(url, header, stored)
+	rsp.Header.Set(cacheStatusHeader, strings.ToUpper(CacheMiss))
+	return flightResult{rsp: rsp, body: body, entry: stored}
+}
+
+//shareable check a response that is not cached may be handed to other callers
+//  responses for one caller, private or setting cookies, are not
+func shareable(rsp *http.Response, header map[string]string) bool {
+	if _, auth := header["Authorization"]; auth || rsp.Header.Get("Set-Cookie") != "" {
+		return false
+	}
+	_, private := parseCacheControl(strings.Join(rsp.Header["Cache-Control"], ","))["private"]
+	return !private
+}
+
+//copyResponse copy of a shared response whose header may be modified
+func copyResponse(rsp *http.Response) *http.Response {
+	copied := *rsp
+	copied.Header = make(http.Header, len(rsp.Header))
+	for key, vals := range rsp.Header {
+		copied.Header[key] = append([]string(nil), vals...)
+	}
+	return &copied
+}
+
+//newCacheEntry entry for a response if a shared cache may store it
+func newCacheEntry(rsp *http.Response, body []byte, header map[string]string, now time.Time) (*CacheEntry, bool) {
+	switch rsp.StatusCode {
+	case http.StatusOK, http.StatusNonAuthoritativeInfo, http.StatusNoContent,
+		http.StatusMultipleChoices, http.StatusMovedPermanently, http.StatusNotFound, http.StatusGone:
+	default:
+		return nil, false
+	}
+
+	cc := parseCacheControl(strings.Join(rsp.Header["Cache-Control"], ","))
+	if _, ok := cc["no-store"]; ok {
+		return nil, false
+	}
+	if _, ok := cc["private"]; ok {
+		return nil, false
+	}
+	if rsp.Header.Get("Set-Cookie") != "" {
+		return nil, false
+	}
+	if _, auth := header["Authorization"]; auth {
+		_, public := cc["public"]
+		_, shared := cc["s-maxage"]
+		if !public && !shared {
+			return nil, false
+		}
+	}
+
+	vary := make(map[string]string)
+	for _, line := range rsp.Header["Vary"] {
+		for _, name := range strings.Split(line, ",") {
+			name = http.CanonicalHeaderKey(strings.TrimSpace(name))
+			if name == "*" {
+				return nil, false
+			}
+			if name != "" {
+				vary[name] = header[name]
+			}
+		}
+	}
+
+	fresh, stale, ok := freshness(rsp.Header, now)
+	if !ok {
+		return nil, false
+	}
+
+	entryHeader := make(http.Header, len(rsp.Header))
+	for key, vals := range rsp.Header {
+		entryHeader[key] = append([]string(nil), vals...)
+	}
+	return &CacheEntry{
+		StatusCode: rsp.StatusCode,
+		Header:     entryHeader,
+		Body:       body,
+		Vary:       vary,
+		Stored:     now,
+		Fresh:      fresh,
+		Stale:      stale,
+	}, true
+}
+
+//freshness lifetime and stale-while-revalidate window of a response
+//  responses without a lifetime are only kept if they can be revalidated
+func freshness(header http.Header, now time.Time) (time.Duration, time.Duration, bool) {
+	cc := parseCacheControl(strings.Join(header["Cache-Control"], ","))
+	stale := seconds(cc["stale-while-revalidate"])
+
+	if _, ok := cc["no-cache"]; ok {
+		return 0, 0, true
+	}
+	if age, ok := cc["s-maxage"]; ok {
+		return seconds(age), stale, true
+	}
+	if age, ok := cc["max-age"]; ok {
+		return seconds(age), stale, true
+	}
+	if expires := header.Get("Expires"); expires != "" {
+		t, err := http.ParseTime(expires)
+		if err != nil || !t.After(now) {
+			return 0, stale, true
+		}
+		return t.Sub(now), stale, true
+	}
+
+	validated := header.Get("ETag") != "" || header.Get("Last-Modified") != ""
+	return 0, 0, validated
+}
+
+//conditional answer If-None-Match and If-Modified-Since of the client
+func conditional(header map[string]string, rsp *http.Response, body []byte) (*http.Response, []byte, error) {
+	if rsp.StatusCode != http.StatusOK {
+		return rsp, body, nil
+	}
+
+	notModified := false
+	if match := header["If-None-Match"]; match != "" {
+		etag := rsp.Header.Get("ETag")
+		for _, candidate := range strings.Split(match, ",") {
+			candidate = strings.TrimSpace(candidate)
+			if etag != "" && (candidate == "*" || strings.TrimPrefix(candidate, "W/") == strings.TrimPrefix(etag, "W/")) {
+				notModified = true
+			}
+		}
+	} else if since := header["If-Modified-Since"]; since != "" {
+		t, err := http.ParseTime(since)
+		modified, merr := http.ParseTime(rsp.Header.Get("Last-Modified"))
+		notModified = err == nil && merr == nil && !modified.After(t)
+	}
+	if !notModified {
+		return rsp, body, nil
+	}
+
+	// clients get a copy so shared responses are never modified
+	copied := *rsp
+	copied.StatusCode = http.StatusNotModified
+	copied.Header = make(http.Header, len(rsp.Header))
+	for key, vals := range rsp.Header {
+		copied.Header[key] = vals
+	}
+	copied.Header.Del("Content-Length")
+	return &copied, nil, nil
+}
+
+//parseCacheControl directives of a Cache-Control header by lowercase name
+func parseCacheControl(value string) map[string]string {
+	directives := make(map[string]string)
+	for _, part := range strings.Split(value, ",") {
+		part = strings.TrimSpace(part)
+		if part == "" {
+			continue
+		}
+		name, val := part, ""
+		if i := strings.Index(part, "="); i >= 0 {
+			name, val = part[:i], strings.Trim(part[i+1:], `"`)
+		}
+		directives[strings.ToLower(name)] = val
+	}
+	return directives
+}
+
+func seconds(value string) time.Duration {
+	n, err := strconv.Atoi(value)
+	if err != nil || n < 0 {
+		return 0
+	}
+	return time.Duration(n) * time.Second
+}
+
+//flightGroup collapse concurrent calls with the same key into one
+type flightGroup struct {
+	lock  sync.Mutex
+	calls map[string]*flightCall
+}
+
+type flightCall struct {
+	done   chan struct{}
+	result flightResult
+}
+
+//flightResult response of a call, entry is set if it was cached and
+//  shareable if it was not but may be handed to other callers
+type flightResult struct {
+	rsp       *http.Response
+	body      []byte
+	entry     *CacheEntry
+	shareable bool
+	err       error
+}
+
+//do run fn unless a call for key is in flight, then wait for its result
+//  shared reports whether the result came from another caller
+func (g *flightGroup) do(key string, fn func() flightResult) (flightResult, bool) {
+	g.lock.Lock()
+	if call, ok := g.calls[key]; ok {
+		g.lock.Unlock()
+		<-call.done
+		return call.result, true
+	}
+	call := &flightCall{done: make(chan struct{})}
+	g.calls[key] = call
+	g.lock.Unlock()
+
+	call.result = fn()
+
+	g.lock.Lock()
+	delete(g.calls, key)
+	g.lock.Unlock()
+	close(call.done)
+	return call.result, false
+}
@@ -0,0 +1,292 @@
+package service
+
+import (
+	"net/http"
+	"sync"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+type upstreamMock struct {
+	calls  int32
+	header func() http.Header
+	status int
+	sent   chan map[string]string
+	block  chan struct{}
+}
+
+func setupCache() (*upstreamMock, *time.Time) {
+	setupServiceDiscovery()
+	defaultCache = NewLRUCache(defaultCacheMaxSize)
+	cacheStore = defaultCache
+	current := time.Unix(1000, 0)
+	cacheNow = func() time.Time { return current }
+
+	up := &upstreamMock{status: http.StatusOK, sent: make(chan map[string]string, 16)}
+	up.header = func() http.Header { return http.Header{"Cache-Control": {"max-age=60"}} }
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		atomic.AddInt32(&up.calls, 1)
+		up.sent <- headers
+		if up.block != nil {
+			<-up.block
+		}
+		return &http.Response{StatusCode: up.status, Header: up.header()}, []byte("body"), nil
+	}
+	return up, &current
+}
+
+func TestCachedRequestHit(t *testing.T) {
+	up, _ := setupCache()
+
+	for i, expected := range []string{"MISS", "HIT"} {
+		rsp, body, err := cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rsp.Header.Get(cacheStatusHeader); got != expected || string(body) != "body" {
+			t.Errorf("call %d: got %v %v want %v %v", i, got, string(body), expected, "body")
+		}
+	}
+	if up.calls != 1 {
+		t.Errorf("function sent unexpected requests: got %v want %v", up.calls, 1)
+	}
+}
+
+func TestCachedRequestNotStored(t *testing.T) {
+	tests := []struct {
+		header  http.Header
+		request map[string]string
+	}{
+		{http.Header{"Cache-Control": {"no-store"}}, map[string]string{}},
+		{http.Header{"Cache-Control": {"private, max-age=60"}}, map[string]string{}},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"*"}}, map[string]string{}},
+		{http.Header{"Cache-Control": {"max-age=60"}, "Set-Cookie": {"a=b"}}, map[string]string{}},
+		{http.Header{"Cache-Control": {"max-age=60"}}, map[string]string{"Authorization": "Bearer a"}},
+		{http.Header{}, map[string]string{}},
+		{http.Header{"Cache-Control": {"max-age=60"}}, map[string]string{"Cache-Control": "no-store"}},
+	}
+
+	for i, test := range tests {
+		up, _ := setupCache()
+		header := test.header
+		up.header = func() http.Header { return header.Clone() }
+
+		cachedRequest("test", "http://v1/a", test.request, nil)
+		cachedRequest("test", "http://v1/a", test.request, nil)
+		if up.calls != 2 {
+			t.Errorf("case %d: got %v requests want %v", i, up.calls, 2)
+		}
+	}
+}
+
+func TestCachedRequestRevalidate(t *testing.T) {
+	up, clock := setupCache()
+	up.header = func() http.Header {
+		return http.Header{"Cache-Control": {"max-age=10"}, "Etag": {`"v1"`}}
+	}
+
+	cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+	<-up.sent
+	*clock = clock.Add(11 * time.Second)
+
+	up.status = http.StatusNotModified
+	rsp, body, err := cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sent := <-up.sent; sent["If-None-Match"] != `"v1"` {
+		t.Errorf("function sent unexpected validator: got %v want %v", sent["If-None-Match"], `"v1"`)
+	}
+	if rsp.StatusCode != http.StatusOK || string(body) != "body" || rsp.Header.Get(cacheStatusHeader) != "REVALIDATED" {
+		t.Errorf("function returned unexpected response: got %v %v %v", rsp.StatusCode, string(body), rsp.Header)
+	}
+
+	// revalidation refreshed the entry
+	cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+	if up.calls != 2 {
+		t.Errorf("function sent unexpected requests: got %v want %v", up.calls, 2)
+	}
+}
+
+func TestCachedRequestStaleWhileRevalidate(t *testing.T) {
+	up, clock := setupCache()
+	up.header = func() http.Header {
+		return http.Header{"Cache-Control": {"max-age=10, stale-while-revalidate=30"}}
+	}
+
+	cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+	<-up.sent
+	*clock = clock.Add(20 * time.Second)
+
+	rsp, body, _ := cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+	if rsp.Header.Get(cacheStatusHeader) != "STALE" || string(body) != "body" {
+		t.Errorf("function returned unexpected response: got %v %v", rsp.Header, string(body))
+	}
+
+	select {
+	case <-up.sent:
+	case <-time.After(time.Second):
+		t.Fatal("Failed to revalidate in the background")
+	}
+	waitFlights()
+}
+
+//waitFlights wait for background revalidations to finish
+func waitFlights() {
+	for {
+		flights.lock.Lock()
+		n := len(flights.calls)
+		flights.lock.Unlock()
+		if n == 0 {
+			return
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestCachedRequestSingleflight(t *testing.T) {
+	up, _ := setupCache()
+	up.block = make(chan struct{})
+
+	var wg sync.WaitGroup
+	bodies := make([]string, 5)
+	for i := range bodies {
+		wg.Add(1)
+		go func(i int) {
+			defer wg.Done()
+			_, body, _ := cachedRequest("test", "http://v1/a", map[string]string{}, nil)
+			bodies[i] = string(body)
+		}(i)
+	}
+
+	<-up.sent
+	// give the other callers time to join the flight
+	time.Sleep(50 * time.Millisecond)
+	close(up.block)
+	wg.Wait()
+
+	if up.calls != 1 {
+		t.Errorf("function sent unexpected requests: got %v want %v", up.calls, 1)
+	}
+	for i, body := range bodies {
+		if body != "body" {
+			t.Errorf("caller %d: got %v want %v", i, body, "body")
+		}
+	}
+}
+
+func TestCachedRequestSingleflightNotStored(t *testing.T) {
+	tests := []struct {
+		header   http.Header
+		auth     string
+		expected int32
+	}{
+		{http.Header{"Cache-Control": {"no-store"}}, "", 1},
+		{http.Header{"Cache-Control": {"private"}}, "", 5},
+		{http.Header{"Set-Cookie": {"a=b"}}, "", 5},
+		{http.Header{"Cache-Control": {"no-store"}}, "Bearer a", 5},
+	}
+
+	for _, test := range tests {
+		up, _ := setupCache()
+		up.block = make(chan struct{})
+		header := test.header
+		up.header = func() http.Header { return header.Clone() }
+		reqHeader := map[string]string{}
+		if test.auth != "" {
+			reqHeader["Authorization"] = test.auth
+		}
+
+		var wg sync.WaitGroup
+		bodies := make([]string, 5)
+		for i := range bodies {
+			wg.Add(1)
+			go func(i int) {
+				defer wg.Done()
+				rsp, body, _ := cachedRequest("test", "http://v1/a", reqHeader, nil)
+				rsp.Header.Set("X-Caller", "modified")
+				bodies[i] = string(body)
+			}(i)
+		}
+
+		<-up.sent
+		time.Sleep(50 * time.Millisecond)
+		close(up.block)
+		wg.Wait()
+
+		if up.calls != test.expected {
+			t.Errorf("%v %q: got %v requests want %v", test.header, test.auth, up.calls, test.expected)
+		}
+		for i, body := range bodies {
+			if body != "body" {
+				t.Errorf("%v caller %d: got %v want %v", test.header, i, body, "body")
+			}
+		}
+	}
+}
+
+func TestCachedRequestVary(t *testing.T) {
+	up, _ := setupCache()
+	up.header = func() http.Header {
+		return http.Header{"Cache-Control": {"max-age=60"}, "Vary": {"Accept-Language"}}
+	}
+
+	// each variant is cached under its own key
+	for i, lang := range []string{"en", "en", "fr", "en", "fr", ""} {
+		rsp, _, err := cachedRequest("test", "http://v1/a", map[string]string{"Accept-Language": lang}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		expected := "HIT"
+		if i == 0 || i == 2 || i == 5 {
+			expected = "MISS"
+		}
+		if got := rsp.Header.Get(cacheStatusHeader); got != expected {
+			t.Errorf("call %d %q: got %v want %v", i, lang, got, expected)
+		}
+	}
+	if up.calls != 3 {
+		t.Errorf("function sent unexpected requests: got %v want %v", up.calls, 3)
+	}
+}
+
+func TestCachedRequestConditional(t *testing.T) {
+	up, _ := setupCache()
+	up.header = func() http.Header {
+		return http.Header{"Cache-Control": {"max-age=60"}, "Etag": {`"v1"`}}
+	}
+
+	client := map[string]string{"If-None-Match": `W/"v1"`}
+	for i := 0; i < 2; i++ {
+		rsp, body, _ := cachedRequest("test", "http://v1/a", client, nil)
+		if rsp.StatusCode != http.StatusNotModified || len(body) != 0 {
+			t.Errorf("call %d: got %v %v want %v", i, rsp.StatusCode, string(body), http.StatusNotModified)
+		}
+	}
+	if sent := <-up.sent; sent["If-None-Match"] != "" {
+		t.Errorf("function forwarded client validator: got %v", sent["If-None-Match"])
+	}
+}
+
+func TestPrepareCache(t *testing.T) {
+	v := viper.New()
+	v.Set(cacheMaxBytesKey, -1)
+	if _, err := PrepareCache(v); err == nil {
+		t.Error("Failed negative size")
+	}
+
+	v.Set(cacheMaxBytesKey, 1024)
+	apply, err := PrepareCache(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if defaultCache.maxBytes != 1024 {
+		t.Errorf("function applied unexpected size: got %v want %v", defaultCache.maxBytes, 1024)
+	}
+}
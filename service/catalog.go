@@ -0,0 +1,168 @@
+package service
+
+import (
+	"regexp"
+	"sort"
+	"strings"
+)
+
+var (
+	componentName *regexp.Regexp
+)
+
+func init() {
+	componentName = regexp.MustCompile(`[^A-Za-z0-9._-]`)
+}
+
+//CatalogInterface defines API catalog methods
+type CatalogInterface interface {
+	Index() uint64
+	Catalog(gateway map[string]interface{}) map[string]interface{}
+}
+
+//Catalog merge the OpenAPI specs of every registered instance into gateway
+//  paths are prefixed with the route of the instance, /service/{name}@{version}
+//  and tagged with it, components are renamed so services cannot collide
+//  the security of each operation is the auth the gateway enforces
+//  gateway is not modified
+func (ds DiscoveryService) Catalog(gateway map[string]interface{}) map[string]interface{} {
+	catalog := copySpec(gateway, "").(map[string]interface{})
+	paths, ok := catalog["paths"].(map[string]interface{})
+	if !ok {
+		paths = make(map[string]interface{})
+		catalog["paths"] = paths
+	}
+	components, ok := catalog["components"].(map[string]interface{})
+	if !ok {
+		components = make(map[string]interface{})
+		catalog["components"] = components
+	}
+	tags, _ := catalog["tags"].([]interface{})
+
+	registryLock.RLock()
+	names := make([]string, 0, len(serviceMap))
+	for name := range serviceMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	var instances []Instance
+	var services []string
+	for _, name := range names {
+		for _, instance := range serviceMap[name] {
+			if instance.OpenAPI != nil {
+				instances = append(instances, instance)
+				services = append(services, name)
+			}
+		}
+	}
+	registryLock.RUnlock()
+
+	for i, instance := range instances {
+		name := services[i]
+		route := name
+		if instance.Version != "" {
+			route += "@" + instance.Version
+		}
+		prefix := componentName.ReplaceAllString(route, "_") + "."
+		doc := copySpec(instance.OpenAPI.doc, prefix).(map[string]interface{})
+
+		tag := map[string]interface{}{"name": route}
+		if info, ok := doc["info"].(map[string]interface{}); ok {
+			if title, ok := info["title"].(string); ok {
+				tag["description"] = title
+			}
+		}
+		tags = append(tags, tag)
+
+		auth := policyFor(name).Auth
+		if auth == "" {
+			auth = AuthAPIKey
+		}
+		specPaths, _ := doc["paths"].(map[string]interface{})
+		for path, item := range specPaths {
+			methods, ok := item.(map[string]interface{})
+			if !ok {
+				continue
+			}
+			for _, method := range specMethods {
+				o, ok := methods[method].(map[string]interface{})
+				if !ok {
+					continue
+				}
+				o["tags"] = []interface{}{route}
+				o["security"] = SecurityRequirement(auth)
+				for _, compiled := range instance.OpenAPI.operations {
+					if compiled.path == path && compiled.method == strings.ToUpper(method) && compiled.auth != "" {
+						o["security"] = SecurityRequirement(stricterAuth(auth, compiled.auth))
+					}
+				}
+			}
+			paths[servicePath+route+path] = methods
+		}
+
+		specComponents, _ := doc["components"].(map[string]interface{})
+		for kind, entries := range specComponents {
+			named, ok := entries.(map[string]interface{})
+			if !ok || kind == "securitySchemes" {
+				continue
+			}
+			merged, ok := components[kind].(map[string]interface{})
+			if !ok {
+				merged = make(map[string]interface{})
+				components[kind] = merged
+			}
+			for key, val := range named {
+				merged[prefix+key] = val
+			}
+		}
+	}
+
+	if len(tags) > 0 {
+		catalog["tags"] = tags
+	}
+	return catalog
+}
+
+//SecurityRequirement OpenAPI security of an auth policy
+//  secret-key requests need the api-key as well
+func SecurityRequirement(auth string) []interface{} {
+	switch auth {
+	case AuthPublic:
+		return []interface{}{}
+	case AuthSecretKey:
+		return []interface{}{map[string]interface{}{AuthAPIKey: []interface{}{}, AuthSecretKey: []interface{}{}}}
+	}
+	return []interface{}{map[string]interface{}{AuthAPIKey: []interface{}{}}}
+}
+
+//copySpec deep copy a spec, prefixing the names its component references use
+func copySpec(node interface{}, prefix string) interface{} {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for key, val := range n {
+			if ref, ok := val.(string); ok && key == "$ref" && prefix != "" {
+				m[key] = prefixRef(ref, prefix)
+				continue
+			}
+			m[key] = copySpec(val, prefix)
+		}
+		return m
+	case []interface{}:
+		l := make([]interface{}, len(n))
+		for i, val := range n {
+			l[i] = copySpec(val, prefix)
+		}
+		return l
+	}
+	return node
+}
+
+//prefixRef rename the component of a reference such as #/components/schemas/User
+func prefixRef(ref, prefix string) string {
+	parts := strings.SplitN(ref, "/", 4)
+	if len(parts) != 4 || parts[0] != "#" || parts[1] != "components" {
+		return ref
+	}
+	return strings.Join(parts[:3], "/") + "/" + prefix + parts[3]
+}
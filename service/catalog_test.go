@@ -0,0 +1,56 @@
+package service
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func TestCatalog(t *testing.T) {
+	setupSpecRoute(t)
+	serviceMap["plain"] = []Instance{{URL: "http://plain/"}}
+	routePolicies["test"] = RoutePolicy{Auth: AuthPublic}
+	var ds DiscoveryService
+
+	gateway := map[string]interface{}{
+		"openapi": "3.0.3",
+		"paths":   map[string]interface{}{"/list": map[string]interface{}{"get": map[string]interface{}{}}},
+	}
+	catalog := ds.Catalog(gateway)
+
+	if len(gateway["paths"].(map[string]interface{})) != 1 || gateway["components"] != nil {
+		t.Errorf("function modified the gateway spec: got %v", gateway)
+	}
+
+	paths := catalog["paths"].(map[string]interface{})
+	expected := []string{"/list", "/service/test@1.0.0/files/{name}.json", "/service/test@1.0.0/users/me", "/service/test@1.0.0/users/{id}"}
+	for _, path := range expected {
+		if _, ok := paths[path]; !ok {
+			t.Errorf("catalog is missing path %v: got %v", path, paths)
+		}
+	}
+	if len(paths) != len(expected) {
+		t.Errorf("catalog has unexpected paths: got %v want %v", len(paths), len(expected))
+	}
+
+	item := paths["/service/test@1.0.0/users/{id}"].(map[string]interface{})
+	get := item["get"].(map[string]interface{})
+	j, _ := json.Marshal(get)
+	want := `{"operationId":"getUser","responses":{"200":{"content":{"application/json":{"schema":{"$ref":"#/components/schemas/test_1.0.0.User"}}}}},"security":[],"tags":["test@1.0.0"]}`
+	if string(j) != want {
+		t.Errorf("catalog has unexpected operation: got %v want %v", string(j), want)
+	}
+
+	put := item["put"].(map[string]interface{})
+	if !reflect.DeepEqual(put["security"], SecurityRequirement(AuthSecretKey)) {
+		t.Errorf("catalog has unexpected security: got %v", put["security"])
+	}
+
+	schemas := catalog["components"].(map[string]interface{})["schemas"].(map[string]interface{})
+	if _, ok := schemas["test_1.0.0.User"]; !ok || len(schemas) != 1 {
+		t.Errorf("catalog has unexpected schemas: got %v", schemas)
+	}
+	if tags := catalog["tags"].([]interface{}); len(tags) != 1 {
+		t.Errorf("catalog has unexpected tags: got %v", tags)
+	}
+}
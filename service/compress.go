@@ -0,0 +1,222 @@
+package service
+
+import (
+	"bytes"
+	"compress/flate"
+	"compress/gzip"
+	"compress/zlib"
+	"fmt"
+	"io"
+	"io/ioutil"
+	"net/http"
+	"sort"
+	"strconv"
+	"strings"
+)
+
+// Content encodings the gateway can produce and decode
+const (
+	EncodingGzip    = "gzip"
+	EncodingDeflate = "deflate"
+)
+
+const (
+	defaultMinCompressSize = 1024
+)
+
+var (
+	defaultCompressTypes []string
+	maxDecodedBytes      int64
+)
+
+func init() {
+	maxDecodedBytes = 32 << 20
+	defaultCompressTypes = []string{
+		"text/*",
+		"application/json",
+		"application/xml",
+		"application/javascript",
+		"application/x-www-form-urlencoded",
+		"image/svg+xml",
+	}
+}
+
+//Compression response compression settings of a route
+//  responses of a compressible type and at least MinSize bytes are
+//  gzip or deflate encoded for clients that accept it
+//  Types entries may end in /* to match every subtype
+type Compression struct {
+	Disabled bool     `mapstructure:"disabled"`
+	MinSize  int      `mapstructure:"min_size"`
+	Types    []string `mapstructure:"types"`
+	Level    int      `mapstructure:"level"`
+}
+
+func validateCompression(c Compression) error {
+	if c.MinSize < 0 {
+		return fmt.Errorf("min_size: must not be negative")
+	}
+	if c.Level < 0 || c.Level > gzip.BestCompression {
+		return fmt.Errorf("level: must be between 1 and 9")
+	}
+	return nil
+}
+
+//decode remove a gzip or deflate encoding of the upstream
+//  the encoding is kept when the client accepts it unless force is set
+//  other encodings are always passed through
+//  a body that decodes to more than maxDecodedBytes fails with
+//  ErrResponseTooLarge
+func (c Compression) decode(r *http.Request, header http.Header, body []byte, force bool) ([]byte, error) {
+	encoding := strings.ToLower(strings.TrimSpace(header.Get("Content-Encoding")))
+	if encoding != EncodingGzip && encoding != EncodingDeflate {
+		return body, nil
+	}
+	if !force && acceptsEncoding(r.Header.Get("Accept-Encoding"), encoding) {
+		return body, nil
+	}
+	if len(body) == 0 {
+		return body, nil
+	}
+
+	var reader io.Reader
+	var err error
+	if encoding == EncodingGzip {
+		reader, err = gzip.NewReader(bytes.NewReader(body))
+	} else {
+		reader, err = zlib.NewReader(bytes.NewReader(body))
+		if err != nil {
+			// some servers send deflate without the zlib wrapper
+			reader, err = flate.NewReader(bytes.NewReader(body)), nil
+		}
+	}
+	if err != nil {
+		return nil, err
+	}
+
+	decoded, err := ioutil.ReadAll(io.LimitReader(reader, maxDecodedBytes+1))
+	if err != nil {
+		return nil, err
+	}
+	if int64(len(decoded)) > maxDecodedBytes {
+		return nil, ErrResponseTooLarge
+	}
+	header.Del("Content-Encoding")
+	header.Del("Content-Length")
+	return decoded, nil
+}
+
+//encode compress the response for the client
+//  already encoded, small and incompressible responses are left unchanged,
+//  as are partial responses whose ranges refer to the unencoded body
+func (c Compression) encode(r *http.Request, status int, header http.Header, body []byte) ([]byte, error) {
+	if c.Disabled || header.Get("Content-Encoding") != "" || !c.compressible(header.Get("Content-Type")) {
+		return body, nil
+	}
+	if status == http.StatusPartialContent || header.Get("Content-Range") != "" {
+		return body, nil
+	}
+	if !strings.Contains(strings.ToLower(strings.Join(header["Vary"], ",")), "accept-encoding") {
+		header.Add("Vary", "Accept-Encoding")
+	}
+
+	minSize := c.MinSize
+	if minSize == 0 {
+		minSize = defaultMinCompressSize
+	}
+	if len(body) < minSize {
+		return body, nil
+	}
+
+	encoding := negotiateEncoding(r.Header.Get("Accept-Encoding"))
+	if encoding == "" {
+		return body, nil
+	}
+
+	level := c.Level
+	if level == 0 {
+		level = gzip.DefaultCompression
+	}
+
+	var buf bytes.Buffer
+	var w io.WriteCloser
+	var err error
+	if encoding == EncodingGzip {
+		w, err = gzip.NewWriterLevel(&buf, level)
+	} else {
+		w, err = zlib.NewWriterLevel(&buf, level)
+	}
+	if err != nil {
+		return nil, err
+	}
+	if _, err := w.Write(body); err != nil {
+		return nil, err
+	}
+	if err := w.Close(); err != nil {
+		return nil, err
+	}
+
+	header.Set("Content-Encoding", encoding)
+	header.Del("Content-Length")
+	return buf.Bytes(), nil
+}
+
+func (c Compression) compressible(contentType string) bool {
+	types := c.Types
+	if len(types) == 0 {
+		types = defaultCompressTypes
+	}
+	return matchMediaType(types, contentType)
+}
+
+//negotiateEncoding choose gzip or deflate from an Accept-Encoding header
+//  the highest quality wins, gzip is preferred on a tie
+func negotiateEncoding(accept string) string {
+	q := parseAcceptEncoding(accept)
+	candidates := []string{EncodingGzip, EncodingDeflate}
+	sort.SliceStable(candidates, func(i, j int) bool {
+		return encodingQuality(q, candidates[i]) > encodingQuality(q, candidates[j])
+	})
+	if encodingQuality(q, candidates[0]) <= 0 {
+		return ""
+	}
+	return candidates[0]
+}
+
+//acceptsEncoding check if the client accepts encoding
+func acceptsEncoding(accept, encoding string) bool {
+	return encodingQuality(parseAcceptEncoding(accept), encoding) > 0
+}
+
+func encodingQuality(q map[string]float64, encoding string) float64 {
+	if v, ok := q[encoding]; ok {
+		return v
+	}
+	if v, ok := q["*"]; ok {
+		return v
+	}
+	return 0
+}
+
+//parseAcceptEncoding quality of each coding in an Accept-Encoding header
+func parseAcceptEncoding(accept string) map[string]float64 {
+	q := make(map[string]float64)
+	for _, part := range strings.Split(accept, ",") {
+		params := strings.Split(part, ";")
+		coding := strings.ToLower(strings.TrimSpace(params[0]))
+		if coding == "" {
+			continue
+		}
+		quality := 1.0
+		for _, param := range params[1:] {
+			param = strings.TrimSpace(param)
+			if strings.HasPrefix(param, "q=") {
+				if v, err := strconv.ParseFloat(strings.TrimPrefix(param, "q="), 64); err == nil {
+					quality = v
+				}
+			}
+		}
+		q[coding] = quality
+	}
+	return q
+}
@@ -0,0 +1,204 @@
+package service
+
+import (
+	"bytes"
+	"compress/gzip"
+	"compress/zlib"
+	"io/ioutil"
+	"net/http"
+	"strings"
+	"testing"
+)
+
+func gzipBytes(data string) []byte {
+	var buf bytes.Buffer
+	w := gzip.NewWriter(&buf)
+	w.Write([]byte(data))
+	w.Close()
+	return buf.Bytes()
+}
+
+func TestNegotiateEncoding(t *testing.T) {
+	tests := []struct {
+		accept   string
+		expected string
+	}{
+		{"", ""},
+		{"gzip", "gzip"},
+		{"deflate, gzip", "gzip"},
+		{"gzip;q=0.5, deflate", "deflate"},
+		{"br", ""},
+		{"*", "gzip"},
+		{"*;q=0.1, gzip;q=0", "deflate"},
+		{"identity", ""},
+	}
+
+	for _, test := range tests {
+		if got := negotiateEncoding(test.accept); got != test.expected {
+			t.Errorf("%q: got %v want %v", test.accept, got, test.expected)
+		}
+	}
+}
+
+func TestCompressionEncode(t *testing.T) {
+	large := strings.Repeat(`{"a":1}`, 500)
+	tests := []struct {
+		compression Compression
+		accept      string
+		header      http.Header
+		body        string
+		expected    string
+	}{
+		{Compression{}, "gzip", http.Header{"Content-Type": {"application/json"}}, large, "gzip"},
+		{Compression{}, "deflate", http.Header{"Content-Type": {"text/html; charset=utf-8"}}, large, "deflate"},
+		{Compression{}, "", http.Header{"Content-Type": {"application/json"}}, large, ""},
+		{Compression{}, "gzip", http.Header{"Content-Type": {"application/json"}}, "{}", ""},
+		{Compression{}, "gzip", http.Header{"Content-Type": {"image/png"}}, large, ""},
+		{Compression{}, "gzip", http.Header{"Content-Type": {"application/json"}, "Content-Encoding": {"br"}}, large, "br"},
+		{Compression{Disabled: true}, "gzip", http.Header{"Content-Type": {"application/json"}}, large, ""},
+		{Compression{MinSize: 1}, "gzip", http.Header{"Content-Type": {"application/json"}}, "{}", "gzip"},
+		{Compression{Types: []string{"application/*"}, Level: 9}, "gzip", http.Header{"Content-Type": {"application/octet-stream"}}, large, "gzip"},
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "/service/test/", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+
+		body, err := test.compression.encode(req, http.StatusOK, test.header, []byte(test.body))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if got := test.header.Get("Content-Encoding"); got != test.expected {
+			t.Errorf("case %d: got %v want %v", i, got, test.expected)
+		}
+
+		var decoded []byte
+		switch test.expected {
+		case "gzip":
+			r, _ := gzip.NewReader(bytes.NewReader(body))
+			decoded, _ = ioutil.ReadAll(r)
+		case "deflate":
+			r, _ := zlib.NewReader(bytes.NewReader(body))
+			decoded, _ = ioutil.ReadAll(r)
+		default:
+			decoded = body
+		}
+		if string(decoded) != test.body {
+			t.Errorf("case %d: body changed by encoding", i)
+		}
+	}
+}
+
+func TestCompressionEncodePartial(t *testing.T) {
+	large := strings.Repeat(`{"a":1}`, 500)
+	tests := []struct {
+		status int
+		header http.Header
+	}{
+		{http.StatusPartialContent, http.Header{"Content-Type": {"application/json"}, "Content-Range": {"bytes 0-3499/7000"}}},
+		{http.StatusPartialContent, http.Header{"Content-Type": {"application/json"}}},
+		{http.StatusRequestedRangeNotSatisfiable, http.Header{"Content-Type": {"application/json"}, "Content-Range": {"bytes */7000"}}},
+	}
+
+	for i, test := range tests {
+		req, _ := http.NewRequest("GET", "/service/test/", nil)
+		req.Header.Set("Accept-Encoding", "gzip")
+
+		body, err := Compression{}.encode(req, test.status, test.header, []byte(large))
+		if err != nil || string(body) != large || test.header.Get("Content-Encoding") != "" {
+			t.Errorf("case %d: partial response was encoded: %v %v", i, test.header, err)
+		}
+	}
+}
+
+func TestCompressionDecode(t *testing.T) {
+	tests := []struct {
+		accept   string
+		force    bool
+		expected string
+	}{
+		{"gzip", false, "gzip"},
+		{"", false, ""},
+		{"deflate", false, ""},
+		{"gzip", true, ""},
+	}
+
+	for _, test := range tests {
+		req, _ := http.NewRequest("GET", "/service/test/", nil)
+		req.Header.Set("Accept-Encoding", test.accept)
+		header := http.Header{"Content-Encoding": {"gzip"}, "Content-Length": {"10"}}
+
+		body, err := Compression{}.decode(req, header, gzipBytes("hello"), test.force)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := header.Get("Content-Encoding"); got != test.expected {
+			t.Errorf("%q force %v: got %v want %v", test.accept, test.force, got, test.expected)
+		}
+		if test.expected == "" && string(body) != "hello" {
+			t.Errorf("%q force %v: got body %v want %v", test.accept, test.force, string(body), "hello")
+		}
+	}
+
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+	if _, err := (Compression{}).decode(req, http.Header{"Content-Encoding": {"gzip"}}, []byte("bad"), false); err == nil {
+		t.Error("Failed invalid gzip body")
+	}
+}
+
+func TestCompressionDecodeTooLarge(t *testing.T) {
+	defer func(max int64) { maxDecodedBytes = max }(maxDecodedBytes)
+	maxDecodedBytes = 5
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+
+	if body, err := (Compression{}).decode(req, http.Header{"Content-Encoding": {"gzip"}}, gzipBytes("hello"), false); err != nil || string(body) != "hello" {
+		t.Errorf("function returned unexpected body: got %v %v want %v", string(body), err, "hello")
+	}
+	bomb := gzipBytes(strings.Repeat("a", 1<<20))
+	if _, err := (Compression{}).decode(req, http.Header{"Content-Encoding": {"gzip"}}, bomb, false); err != ErrResponseTooLarge {
+		t.Errorf("function returned unexpected error: got %v want %v", err, ErrResponseTooLarge)
+	}
+}
+
+func TestDiscoveryRouteCompression(t *testing.T) {
+	setupVersionRoute()
+	routePolicies["test"] = transformPolicy(RoutePolicy{Transform: Transform{
+		Response: ResponseTransform{Remap: []Mapping{{From: "a", To: "b"}}},
+	}})
+	var ds DiscoveryService
+
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		header := http.Header{"Content-Encoding": {"gzip"}, "Content-Type": {"application/json"}}
+		return &http.Response{Header: header}, gzipBytes(`{"a":"` + strings.Repeat("x", 2000) + `"}`), nil
+	}
+
+	req, _ := http.NewRequest("GET", "http://www.test.com/service/test@1/check", bytes.NewReader(nil))
+	req.Header.Set("Accept-Encoding", "deflate")
+
+	rsp, body, err := ds.Route(req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if rsp.Header.Get("Content-Encoding") != "deflate" || rsp.Header.Get("Vary") != "Accept-Encoding" {
+		t.Errorf("service returned unexpected header: got %v", rsp.Header)
+	}
+	r, err := zlib.NewReader(bytes.NewReader(body))
+	if err != nil {
+		t.Fatal(err)
+	}
+	decoded, _ := ioutil.ReadAll(r)
+	if !strings.HasPrefix(string(decoded), `{"b":"xxx`) {
+		t.Errorf("service returned unexpected body: got %.20v", string(decoded))
+	}
+}
+
+func TestValidateCompressionFail(t *testing.T) {
+	tests := []Compression{{MinSize: -1}, {Level: 10}, {Level: -2}}
+	for i, c := range tests {
+		if err := validateCompression(c); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
@@ -0,0 +1,127 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"regexp"
+	"strings"
+	"time"
+)
+
+// Methods allowed to cross-origin requests when a CORS policy names none
+var defaultCORSMethods = []string{http.MethodGet, http.MethodHead, http.MethodPost,
+	http.MethodPut, http.MethodPatch, http.MethodDelete}
+
+//CORSPolicy cross-origin requests allowed by a route
+//  Origins are origins such as https://app.example.com, where * matches
+//  anything up to the next slash, or * alone for any origin
+//  Headers are the request headers allowed, * allows any
+//  ExposeHeaders are the response headers readable by scripts
+//  MaxAge is how long browsers may cache a preflight
+type CORSPolicy struct {
+	Origins       []string      `mapstructure:"origins"`
+	Methods       []string      `mapstructure:"methods"`
+	Headers       []string      `mapstructure:"headers"`
+	ExposeHeaders []string      `mapstructure:"expose_headers"`
+	Credentials   bool          `mapstructure:"credentials"`
+	MaxAge        time.Duration `mapstructure:"max_age"`
+
+	origins []*regexp.Regexp
+}
+
+//CORS CORS policy of the service a /service/ request is routed to
+//  nil when the service allows no cross-origin requests
+func CORS(r *http.Request) *CORSPolicy {
+	return policyFor(requestService(r)).CORS
+}
+
+func compileCORS(c *CORSPolicy) error {
+	if len(c.Origins) == 0 {
+		return errors.New("origins: at least one origin is required")
+	}
+	c.origins = nil
+	for _, origin := range c.Origins {
+		if origin == "*" {
+			if c.Credentials {
+				return errors.New("origins: * cannot be used with credentials")
+			}
+			continue
+		}
+		if !strings.Contains(origin, "://") || strings.HasSuffix(origin, "/") {
+			return fmt.Errorf("origins: %s must be scheme://host[:port]", origin)
+		}
+		pattern := strings.Replace(regexp.QuoteMeta(origin), `\*`, `[^/]+`, -1)
+		c.origins = append(c.origins, regexp.MustCompile(`^(?i:`+pattern+`)$`))
+	}
+
+	for i, method := range c.Methods {
+		if method == "" || strings.ContainsAny(method, " ,") {
+			return fmt.Errorf("methods: invalid method %s", method)
+		}
+		c.Methods[i] = strings.ToUpper(method)
+	}
+	for _, header := range append(append([]string{}, c.Headers...), c.ExposeHeaders...) {
+		if header == "" || strings.ContainsAny(header, " ,:") {
+			return fmt.Errorf("headers: invalid header %s", header)
+		}
+	}
+	if c.MaxAge < 0 {
+		return errors.New("max_age: must not be negative")
+	}
+	return nil
+}
+
+//AllowsOrigin check the Origin of a request against the policy
+func (c *CORSPolicy) AllowsOrigin(origin string) bool {
+	for _, allowed := range c.Origins {
+		if allowed == "*" {
+			return true
+		}
+	}
+	for _, re := range c.origins {
+		if re.MatchString(origin) {
+			return true
+		}
+	}
+	return false
+}
+
+//AllowedMethods methods of the policy, or the defaults
+func (c *CORSPolicy) AllowedMethods() []string {
+	if len(c.Methods) == 0 {
+		return defaultCORSMethods
+	}
+	return c.Methods
+}
+
+//AllowsMethod check the method of a preflight
+func (c *CORSPolicy) AllowsMethod(method string) bool {
+	for _, allowed := range c.AllowedMethods() {
+		if allowed == method {
+			return true
+		}
+	}
+	return false
+}
+
+//AllowsHeaders check the comma separated headers of a preflight
+func (c *CORSPolicy) AllowsHeaders(headers string) bool {
+	for _, header := range strings.Split(headers, ",") {
+		header = strings.TrimSpace(header)
+		if header == "" {
+			continue
+		}
+		allowed := false
+		for _, h := range c.Headers {
+			if h == "*" || strings.EqualFold(h, header) {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+	return true
+}
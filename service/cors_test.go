@@ -0,0 +1,81 @@
+package service
+
+import (
+	"net/http/httptest"
+	"testing"
+)
+
+func TestCORSPolicy(t *testing.T) {
+	c := &CORSPolicy{
+		Origins: []string{"https://app.example.com", "https://*.example.org", "http://localhost:*"},
+		Methods: []string{"get", "post"},
+		Headers: []string{"api-key", "Content-Type"},
+	}
+	if err := compileCORS(c); err != nil {
+		t.Fatal(err)
+	}
+
+	origins := []struct {
+		origin   string
+		expected bool
+	}{
+		{"https://app.example.com", true},
+		{"HTTPS://APP.example.com", true},
+		{"https://admin.example.org", true},
+		{"http://localhost:3000", true},
+		{"http://app.example.com", false},
+		{"https://example.org", false},
+		{"https://evil.com/.example.org", false},
+		{"https://app.example.com.evil.com", false},
+	}
+	for _, test := range origins {
+		if got := c.AllowsOrigin(test.origin); got != test.expected {
+			t.Errorf("service returned wrong origin check for %v: got %v want %v", test.origin, got, test.expected)
+		}
+	}
+
+	if !c.AllowsMethod("POST") || c.AllowsMethod("DELETE") {
+		t.Errorf("service returned wrong methods: got %v", c.AllowedMethods())
+	}
+	if !c.AllowsHeaders("API-Key, content-type") || !c.AllowsHeaders("") || c.AllowsHeaders("api-key, secret-key") {
+		t.Errorf("service returned wrong header check: got %v", c.Headers)
+	}
+
+	any := &CORSPolicy{Origins: []string{"*"}, Headers: []string{"*"}}
+	if err := compileCORS(any); err != nil {
+		t.Fatal(err)
+	}
+	if !any.AllowsOrigin("https://anything.com") || !any.AllowsHeaders("x-custom") || !any.AllowsMethod("DELETE") {
+		t.Errorf("service did not allow any origin: got %v", any)
+	}
+}
+
+func TestCompileCORSFail(t *testing.T) {
+	tests := []CORSPolicy{
+		{},
+		{Origins: []string{"*"}, Credentials: true},
+		{Origins: []string{"app.example.com"}},
+		{Origins: []string{"https://app.example.com/"}},
+		{Origins: []string{"*"}, Methods: []string{"GET, POST"}},
+		{Origins: []string{"*"}, Headers: []string{"api-key: x"}},
+		{Origins: []string{"*"}, MaxAge: -1},
+	}
+
+	for i, test := range tests {
+		if err := compileCORS(&test); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
+
+func TestCORSFor(t *testing.T) {
+	routePolicies = map[string]RoutePolicy{"test": {CORS: &CORSPolicy{Origins: []string{"*"}}}}
+	defer func() { routePolicies = make(map[string]RoutePolicy) }()
+
+	if c := CORS(httptest.NewRequest("OPTIONS", "/service/test/items", nil)); c == nil {
+		t.Errorf("service returned no CORS policy for test")
+	}
+	if c := CORS(httptest.NewRequest("OPTIONS", "/service/other/items", nil)); c != nil {
+		t.Errorf("service returned a CORS policy for other: got %v", c)
+	}
+}
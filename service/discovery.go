@@ -1,22 +1,32 @@
 package service
 
 import (
+	"context"
 	"errors"
 	"net/http"
 	"strings"
+	"time"
 
 	log "github.com/sirupsen/logrus"
 )
 
 const (
-	servicePath = "/service/"
+	servicePath          = "/service/"
+	acceptVersionHeader  = "Accept-Version"
+	serviceVersionHeader = "X-Service-Version"
 )
 
-var ()
+// Errors of routed requests
+var (
+	ErrUnknownService    = errors.New("Invalid Service Name")
+	ErrNoMatchingVersion = errors.New("No Matching Version")
+	ErrResponseTooLarge  = errors.New("Response Too Large")
+)
 
 //DiscoveryInterface defines service methods
 type DiscoveryInterface interface {
 	List() []string
+	Services() map[string][]Instance
 	Route(*http.Request) (*http.Response, []byte, error)
 }
 
@@ -26,6 +36,9 @@ type DiscoveryService struct {
 
 //List show all services avaliable
 func (ds DiscoveryService) List() []string {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+
 	keys := make([]string, 0, len(serviceMap))
 	for k := range serviceMap {
 		keys = append(keys, k)
@@ -33,27 +46,135 @@ func (ds DiscoveryService) List() []string {
 	return keys
 }
 
-//Route sends request to service
-func (ds DiscoveryService) Route(r *http.Request) (*http.Response, []byte, error) {
+//Services show all services with the registration details of each version
+func (ds DiscoveryService) Services() map[string][]Instance {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
 
+	services := make(map[string][]Instance, len(serviceMap))
+	for k, v := range serviceMap {
+		services[k] = append([]Instance(nil), v...)
+	}
+	return services
+}
+
+//target resolved upstream of a routed request
+//  path is the request path after the service name, rawPath its escaped form
+//  operation is the matching operation of the instance's OpenAPI spec
+type target struct {
+	service   string
+	instance  Instance
+	path      string
+	rawPath   string
+	operation *operation
+}
+
+//resolve target of a request, the one attached by Resolve if any
+func (ds DiscoveryService) resolve(r *http.Request) (target, error) {
+	if res, ok := r.Context().Value(targetKey{}).(resolved); ok {
+		return res.target, res.err
+	}
+	return ds.resolveTarget(r)
+}
+
+//targetKey context key of the target a request was resolved to
+type targetKey struct{}
+
+//resolved target of a request, or the error resolving it
+type resolved struct {
+	target target
+	err    error
+}
+
+//Resolve resolve a /service/ request to its target once
+//  the auth policy and the route of the returned request see the same
+//  instance, a split is decided only once
+func Resolve(r *http.Request) *http.Request {
+	var ds DiscoveryService
+	t, err := ds.resolveTarget(r)
+	return r.WithContext(context.WithValue(r.Context(), targetKey{}, resolved{t, err}))
+}
+
+//resolveTarget find the service instance a request is routed to
+//  routing rules first narrow the instances to an upstream group
+//  version is taken from /service/{name}@{constraint}/, the Accept-Version
+//  header, the traffic split or the configured default version, in that order,
+//  without any of them instances with a weight share the requests
+func (ds DiscoveryService) resolveTarget(r *http.Request) (target, error) {
 	// format URL and service name
 	path := strings.TrimPrefix(r.URL.Path, servicePath)
 	temp := strings.SplitN(path, "/", 2)
 
-	var serviceName string
-	var serviceURL string
-	if len(temp) == 1 {
-		serviceName = temp[0]
-	} else {
-		serviceName = temp[0]
-		serviceURL = temp[1]
+	var t target
+	if len(temp) == 2 {
+		t.path = temp[1]
+	}
+	escaped := strings.TrimPrefix(r.URL.EscapedPath(), servicePath)
+	if i := strings.Index(escaped, "/"); i >= 0 {
+		t.rawPath = escaped[i+1:]
+	}
+
+	serviceName, constraint, versioned := splitVersion(temp[0])
+	if !versioned {
+		constraint = r.Header.Get(acceptVersionHeader)
 	}
 
-	if _, ok := serviceMap[serviceName]; !ok {
+	registryLock.RLock()
+	instances, ok := serviceMap[serviceName]
+	registryLock.RUnlock()
+	if !ok {
 		log.Error("Route Error: invalid service name - " + serviceName)
-		return nil, nil, errors.New("Invalid Service Name")
+		return t, ErrUnknownService
+	}
+
+	t.service = serviceName
+	instances = applyRules(serviceName, instances, r, t.rawPath)
+	if constraint == "" {
+		if instance, ok := splitInstance(serviceName, instances, r); ok {
+			t.instance = instance
+			return t, nil
+		}
+		constraint = policyFor(serviceName).DefaultVersion
+	}
+	if constraint == "" {
+		if instance, ok := weightedInstance(instances); ok {
+			t.instance = instance
+			return t, nil
+		}
+	}
+
+	instance, err := selectVersion(instances, constraint)
+	if err != nil {
+		log.Error("Route Error: " + err.Error() + " - " + serviceName)
+		return t, err
+	}
+
+	t.instance = instance
+	return t, nil
+}
+
+//timeoutKey context key of a timeout replacing the route timeout
+type timeoutKey struct{}
+
+//Route sends request to service
+func (ds DiscoveryService) Route(r *http.Request) (*http.Response, []byte, error) {
+	t, err := ds.resolve(r)
+	if err != nil {
+		return nil, nil, err
+	}
+	// L4 services are only served on their listen port
+	if t.instance.Listen != 0 {
+		return nil, nil, ErrUnknownPath
+	}
+	if t.instance.DescriptorSet != nil {
+		return transcode(r, t)
+	}
+	if t.operation, err = matchOperation(t, r.Method); err != nil {
+		log.Error("Route Error: " + err.Error() + " - " + r.Method + " " + t.service + "/" + t.path)
+		return nil, nil, err
 	}
-	serviceURL = serviceMap[serviceName] + serviceURL
+	policy := policyFor(t.service)
+	serviceURL := policy.upstreamURL(t.instance.URL, t.rawPath, r.URL.RawQuery)
 
 	// format request body
 	body, err := readAllFunc(r.Body)
@@ -62,6 +183,14 @@ func (ds DiscoveryService) Route(r *http.Request) (*http.Response, []byte, error
 		return nil, nil, err
 	}
 
+	rule, hasSchema := schemaRuleFor(t, policy, r.Method)
+	if hasSchema {
+		if err := validateRequestSchema(t, rule, r.Method, body); err != nil {
+			countOperation(t, http.StatusBadRequest)
+			return nil, nil, err
+		}
+	}
+
 	// formate request header
 	header := make(map[string]string)
 	for key, vals := range r.Header {
@@ -74,11 +203,110 @@ func (ds DiscoveryService) Route(r *http.Request) (*http.Response, []byte, error
 	}
 	delete(header, "api-key")
 	delete(header, "secret-key")
+	if policy.Host != "" {
+		header["Host"] = policy.Host
+	}
+
+	data := newTransformData(r, t, policy)
+	if body, err = policy.Transform.Request.apply(data, header, body); err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+
+	// per-route timeout and the transport of the instance protocol
+	timeout := policy.Timeout
+	if d, ok := r.Context().Value(timeoutKey{}).(time.Duration); ok {
+		timeout = d
+	}
+	client := clientFor(t.instance, timeout)
 
 	// send request
-	rsp, body, err := request(serviceURL, r.Method, header, string(body), nil)
+	var rsp *http.Response
+	if policy.Cache && r.Method == http.MethodGet {
+		rsp, body, err = cachedRequest(t.service, serviceURL, header, client)
+	} else {
+		rsp, body, err = request(serviceURL, r.Method, header, string(body), client)
+	}
 	if err != nil {
 		log.Error("Route Error: " + err.Error())
+		countOperation(t, 0)
+		return rsp, body, err
+	}
+	countOperation(t, rsp.StatusCode)
+	if rsp.Header == nil {
+		rsp.Header = http.Header{}
+	}
+
+	// bodies are decoded for transforms and encoded for the client last
+	decode := policy.Transform.Response.rewritesBody() || (hasSchema && rule.Response != "")
+	if body, err = policy.Compression.decode(r, rsp.Header, body, decode); err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+	if hasSchema {
+		reportResponseSchema(t, rule, rsp, body)
+	}
+	if body, err = policy.Transform.Response.apply(data, rsp.Header, body); err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+	if body, err = policy.Compression.encode(r, rsp.StatusCode, rsp.Header, body); err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+	if t.instance.Version != "" {
+		rsp.Header.Set(serviceVersionHeader, t.instance.Version)
+	}
+	return rsp, body, nil
+}
+
+//splitVersion split name@version
+func splitVersion(s string) (string, string, bool) {
+	if i := strings.Index(s, "@"); i >= 0 {
+		return s[:i], s[i+1:], true
+	}
+	return s, "", false
+}
+
+//selectVersion pick the highest version matching constraint
+//  any version matches an empty constraint
+//  pre-releases are only picked when the constraint names one, or when
+//  nothing but pre-releases is registered
+func selectVersion(instances []Instance, constraint string) (Instance, error) {
+	var c versionConstraint
+	if constraint != "" {
+		var err error
+		if c, err = parseConstraint(constraint); err != nil {
+			return Instance{}, err
+		}
+	}
+
+	instance, found := highestVersion(instances, c, c.prerelease())
+	if !found && c == nil {
+		instance, found = highestVersion(instances, c, true)
+	}
+	if !found {
+		return instance, ErrNoMatchingVersion
+	}
+	return instance, nil
+}
+
+func highestVersion(instances []Instance, c versionConstraint, pre bool) (Instance, bool) {
+	var best Instance
+	var bestVersion semver
+	found := false
+	for _, instance := range instances {
+		// unversioned instances only match requests without a constraint
+		v, err := parseVersion(instance.Version)
+		if err != nil && c != nil {
+			continue
+		}
+		if (v.pre != "" && !pre) || (c != nil && !c.match(v)) {
+			continue
+		}
+		if !found || v.compare(bestVersion) > 0 {
+			best, bestVersion, found = instance, v, true
+		}
 	}
-	return rsp, body, err
+	return best, found
 }
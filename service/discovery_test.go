@@ -9,10 +9,11 @@ import (
 	"net/url"
 	"strconv"
 	"testing"
+	"time"
 )
 
 func setupServiceDiscovery() {
-	serviceMap = make(map[string]string)
+	serviceMap = make(map[string][]Instance)
 	readAllFunc = ioutil.ReadAll
 	request = sendRequest
 }
@@ -20,7 +21,7 @@ func setupServiceDiscovery() {
 func TestDiscoveryList(t *testing.T) {
 	setupServiceDiscovery()
 	var ds DiscoveryService
-	serviceMap["test"] = "test"
+	serviceMap["test"] = []Instance{{URL: "test"}}
 
 	// invalid URL
 	res := ds.List()
@@ -55,7 +56,7 @@ func TestDiscoveryRouteReadAllFuncFail(t *testing.T) {
 	var ds DiscoveryService
 
 	// setup helper
-	serviceMap["test"] = "http://www.test.com"
+	serviceMap["test"] = []Instance{{URL: "http://www.test.com"}}
 	readAllFunc = func(r io.Reader) ([]byte, error) {
 		return nil, errors.New("test")
 	}
@@ -83,7 +84,7 @@ func TestDiscoveryRouteRequestFail(t *testing.T) {
 	var ds DiscoveryService
 
 	// setup helper
-	serviceMap["test"] = "http://www.test.com"
+	serviceMap["test"] = []Instance{{URL: "http://www.test.com"}}
 	request = func(url, httpMethod string,
 		headers map[string]string, body string,
 		client clientInterface) (*http.Response, []byte, error) {
@@ -108,3 +109,149 @@ func TestDiscoveryRouteRequestFail(t *testing.T) {
 			err.Error(), errorText)
 	}
 }
+
+func setupVersionRoute() *string {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	serviceMap["test"] = []Instance{
+		{URL: "http://v1/", Version: "1.4.0"},
+		{URL: "http://v2/", Version: "2.1.0"},
+		{URL: "http://v2beta/", Version: "2.2.0-beta"},
+	}
+
+	var sent string
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		sent = url
+		return &http.Response{Header: http.Header{}}, nil, nil
+	}
+	return &sent
+}
+
+func TestDiscoveryRouteVersion(t *testing.T) {
+	tests := []struct {
+		path     string
+		accept   string
+		fallback string
+		expected string
+	}{
+		{"/service/test/check", "", "", "http://v2/check"},
+		{"/service/test@v1/check", "", "", "http://v1/check"},
+		{"/service/test@v1/check", "2", "", "http://v1/check"},
+		{"/service/test/check", "^1.0", "", "http://v1/check"},
+		{"/service/test/check", ">=2.2.0-alpha", "", "http://v2beta/check"},
+		{"/service/test/check", "", "1", "http://v1/check"},
+		{"/service/test/check", "2", "1", "http://v2/check"},
+	}
+
+	for _, test := range tests {
+		sent := setupVersionRoute()
+		routePolicies["test"] = RoutePolicy{DefaultVersion: test.fallback}
+		var ds DiscoveryService
+
+		req := http.Request{}
+		req.URL, _ = url.ParseRequestURI("http://www.test.com" + test.path)
+		req.Body = readCloserMock{bytes.NewBufferString("")}
+		req.Method = http.MethodGet
+		req.Header = http.Header{}
+		if test.accept != "" {
+			req.Header.Set(acceptVersionHeader, test.accept)
+		}
+
+		rsp, _, err := ds.Route(&req)
+		if err != nil {
+			t.Fatalf("%v: %v", test.path, err)
+		}
+		if *sent != test.expected {
+			t.Errorf("%v accept %q default %q: got %v want %v",
+				test.path, test.accept, test.fallback, *sent, test.expected)
+		}
+		if rsp.Header.Get(serviceVersionHeader) == "" {
+			t.Errorf("%v: missing %v header", test.path, serviceVersionHeader)
+		}
+	}
+}
+
+func TestDiscoveryRouteVersionFail(t *testing.T) {
+	setupVersionRoute()
+	var ds DiscoveryService
+
+	req := http.Request{}
+	req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test@v3/check")
+	req.Body = readCloserMock{bytes.NewBufferString("")}
+	req.Method = http.MethodGet
+	req.Header = http.Header{}
+
+	errorText := "No Matching Version"
+	_, _, err := ds.Route(&req)
+	if err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			err, errorText)
+	}
+}
+
+func TestDiscoveryRoutePolicy(t *testing.T) {
+	sent := setupVersionRoute()
+	routePolicies["test"] = RoutePolicy{StripPrefix: "/api", Timeout: time.Second}
+	var ds DiscoveryService
+
+	var timeoutClient clientInterface
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		*sent = url
+		timeoutClient = client
+		return &http.Response{Header: http.Header{}}, nil, nil
+	}
+
+	req := http.Request{}
+	req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test@1/api/check")
+	req.Body = readCloserMock{bytes.NewBufferString("")}
+	req.Method = http.MethodGet
+	req.Header = http.Header{}
+
+	if _, _, err := ds.Route(&req); err != nil {
+		t.Fatal(err)
+	}
+	if *sent != "http://v1/check" {
+		t.Errorf("service sent unexpected URL: got %v want %v", *sent, "http://v1/check")
+	}
+	if c, ok := timeoutClient.(*http.Client); !ok || c.Timeout != time.Second {
+		t.Errorf("service used unexpected client: got %v", timeoutClient)
+	}
+}
+
+func TestAuthPolicy(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = map[string]RoutePolicy{"public": {Auth: AuthPublic}}
+
+	req, _ := http.NewRequest("GET", "/service/public@v1/check", nil)
+	if got := AuthPolicy(req); got != AuthPublic {
+		t.Errorf("function returned unexpected policy: got %v want %v", got, AuthPublic)
+	}
+
+	req, _ = http.NewRequest("GET", "/service/other/check", nil)
+	if got := AuthPolicy(req); got != AuthAPIKey {
+		t.Errorf("function returned unexpected policy: got %v want %v", got, AuthAPIKey)
+	}
+}
+
+func TestRequestLimits(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = map[string]RoutePolicy{"upload": {MaxBodyBytes: 1 << 20, ContentTypes: []string{"image/*"}}}
+
+	req, _ := http.NewRequest("POST", "/service/upload@1/file", nil)
+	limits := RequestLimits(req)
+	if limits.MaxBodyBytes != 1<<20 {
+		t.Errorf("function returned unexpected limit: got %v want %v", limits.MaxBodyBytes, 1<<20)
+	}
+	if !limits.AllowsContentType("image/png") || limits.AllowsContentType("text/plain") {
+		t.Errorf("function returned unexpected content types: got %v", limits.ContentTypes)
+	}
+
+	req, _ = http.NewRequest("POST", "/service/other/file", nil)
+	if limits := RequestLimits(req); limits.MaxBodyBytes != 0 || !limits.AllowsContentType("text/plain") {
+		t.Errorf("function returned unexpected limits: got %+v", limits)
+	}
+}
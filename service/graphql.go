@@ -0,0 +1,917 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"regexp"
+	"sort"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/dtan44/SMUG/graphql"
+	"github.com/dtan44/SMUG/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	graphqlMetric    = "smug_graphql_calls_total"
+	queryTypeName    = "Query"
+	mutationTypeName = "Mutation"
+	graphqlMinRetry  = time.Second
+	graphqlMaxRetry  = time.Minute
+)
+
+// Errors of GraphQL requests
+var (
+	ErrGraphQLMethod       = errors.New("Mutations Require POST")
+	ErrGraphQLSubscription = errors.New("Subscriptions Are Not Supported")
+)
+
+var (
+	graphqlName         *regexp.Regexp
+	builtinTypes        map[string]bool
+	introspectionFields map[string]map[string]string
+	graphqlSchemas      graphqlCache
+	graphqlNow          func() time.Time
+)
+
+func init() {
+	graphqlName = regexp.MustCompile(`[^_0-9A-Za-z]`)
+	builtinTypes = map[string]bool{"String": true, "Int": true, "Float": true, "Boolean": true, "ID": true}
+	introspectionFields = map[string]map[string]string{
+		"__Schema": {
+			"types":            "__Type",
+			"queryType":        "__Type",
+			"mutationType":     "__Type",
+			"subscriptionType": "__Type",
+			"directives":       "__Directive",
+		},
+		"__Type": {
+			"fields":        "__Field",
+			"interfaces":    "__Type",
+			"possibleTypes": "__Type",
+			"enumValues":    "__EnumValue",
+			"inputFields":   "__InputValue",
+			"ofType":        "__Type",
+		},
+		"__Field":      {"args": "__InputValue", "type": "__Type"},
+		"__InputValue": {"type": "__Type"},
+		"__Directive":  {"args": "__InputValue"},
+	}
+	graphqlSchemas.schemas = make(map[string]*remoteSchema)
+	graphqlSchemas.failed = make(map[string]graphqlFailure)
+	graphqlNow = time.Now
+}
+
+//GraphQLRequest GraphQL request as sent over HTTP
+type GraphQLRequest struct {
+	Query         string                 `json:"query"`
+	OperationName string                 `json:"operationName,omitempty"`
+	Variables     map[string]interface{} `json:"variables,omitempty"`
+}
+
+//GraphQLError error of a GraphQL response
+//  Path is the response path of the field that failed
+type GraphQLError struct {
+	Message    string                 `json:"message"`
+	Path       []interface{}          `json:"path,omitempty"`
+	Extensions map[string]interface{} `json:"extensions,omitempty"`
+}
+
+//GraphQLResponse result of a GraphQL request
+type GraphQLResponse struct {
+	Data   map[string]interface{} `json:"data,omitempty"`
+	Errors []GraphQLError         `json:"errors,omitempty"`
+}
+
+//GraphQLInterface defines GraphQL gateway methods
+type GraphQLInterface interface {
+	GraphQL(r *http.Request, req GraphQLRequest, authorize func(*http.Request) bool) (GraphQLResponse, error)
+}
+
+//GraphQLService defines GraphQL gateway service struct
+type GraphQLService struct {
+}
+
+//remoteSchema introspected schema of a GraphQL service
+//  type names are prefixed with the namespace of the service, query and
+//  mutation are the prefixed root types, empty if the service has none
+type remoteSchema struct {
+	service    string
+	namespace  string
+	path       string
+	query      string
+	mutation   string
+	types      []interface{}
+	directives []interface{}
+}
+
+type graphqlCache struct {
+	lock    sync.Mutex
+	index   uint64
+	schemas map[string]*remoteSchema
+	failed  map[string]graphqlFailure
+}
+
+//graphqlFailure failed introspection of a service, not retried before retry
+//  the delay doubles with each attempt up to graphqlMaxRetry
+type graphqlFailure struct {
+	err      error
+	attempts uint
+	retry    time.Time
+}
+
+//stitchedSchema schema served at /graphql
+//  the root types have one field per service namespace, failed holds the
+//  namespaces whose schema could not be introspected
+type stitchedSchema struct {
+	namespaces map[string]*remoteSchema
+	failed     map[string]error
+	types      map[string]map[string]interface{}
+	schema     map[string]interface{}
+}
+
+//graphqlNamespace root field name of a service, invalid characters become _
+func graphqlNamespace(serviceName string) string {
+	ns := graphqlName.ReplaceAllString(serviceName, "_")
+	if ns == "" || (ns[0] >= '0' && ns[0] <= '9') {
+		ns = "_" + ns
+	}
+	return ns
+}
+
+//graphqlTypeName name of a service type in the stitched schema
+func graphqlTypeName(ns, name string) string {
+	if builtinTypes[name] || strings.HasPrefix(name, "__") {
+		return name
+	}
+	return ns + "_" + name
+}
+
+//loadSchemas stitch the schemas of the registered GraphQL services r may call
+//  a service is introspected once per registry revision, through Route,
+//  failures are retried with a backoff
+//  the path is taken from the first instance of a service that sets graphql
+//  services authorize refuses are left out, as they are when executed
+func loadSchemas(r *http.Request, authorize func(*http.Request) bool) *stitchedSchema {
+	var ds DiscoveryService
+	index := ds.Index()
+
+	registryLock.RLock()
+	paths := make(map[string]string)
+	var names []string
+	for name, instances := range serviceMap {
+		for _, instance := range instances {
+			if instance.GraphQL != "" {
+				paths[name] = strings.TrimPrefix(instance.GraphQL, "/")
+				names = append(names, name)
+				break
+			}
+		}
+	}
+	registryLock.RUnlock()
+	sort.Strings(names)
+
+	graphqlSchemas.lock.Lock()
+	if graphqlSchemas.index != index {
+		graphqlSchemas.index = index
+		graphqlSchemas.schemas = make(map[string]*remoteSchema)
+		graphqlSchemas.failed = make(map[string]graphqlFailure)
+	}
+	cached := make(map[string]*remoteSchema, len(graphqlSchemas.schemas))
+	for name, remote := range graphqlSchemas.schemas {
+		cached[name] = remote
+	}
+	failed := make(map[string]graphqlFailure, len(graphqlSchemas.failed))
+	for name, failure := range graphqlSchemas.failed {
+		failed[name] = failure
+	}
+	graphqlSchemas.lock.Unlock()
+
+	s := &stitchedSchema{
+		namespaces: make(map[string]*remoteSchema),
+		failed:     make(map[string]error),
+	}
+	var ordered []*remoteSchema
+	for _, name := range names {
+		ns := graphqlNamespace(name)
+		if _, ok := s.namespaces[ns]; ok || s.failed[ns] != nil {
+			log.Warn("GraphQL Error: namespace " + ns + " of " + name + " is already taken")
+			continue
+		}
+
+		if authorize != nil {
+			routed, err := graphqlRequest(r, name, paths[name], http.NoBody)
+			if err != nil || !authorize(routed) {
+				continue
+			}
+		}
+
+		remote, ok := cached[name]
+		if failure, retried := failed[name]; !ok && retried && graphqlNow().Before(failure.retry) {
+			s.failed[ns] = failure.err
+			continue
+		}
+		if !ok {
+			var err error
+			if remote, err = introspect(name, paths[name], ns); err != nil {
+				log.Error("GraphQL Error: introspecting " + name + " - " + err.Error())
+				s.failed[ns] = err
+				graphqlSchemas.lock.Lock()
+				if graphqlSchemas.index == index {
+					graphqlSchemas.fail(name, err)
+				}
+				graphqlSchemas.lock.Unlock()
+				continue
+			}
+			graphqlSchemas.lock.Lock()
+			if graphqlSchemas.index == index {
+				graphqlSchemas.schemas[name] = remote
+				delete(graphqlSchemas.failed, name)
+			}
+			graphqlSchemas.lock.Unlock()
+		}
+		s.namespaces[ns] = remote
+		ordered = append(ordered, remote)
+	}
+
+	s.build(ordered)
+	return s
+}
+
+//fail record a failed introspection of a service, c.lock must be held
+func (c *graphqlCache) fail(serviceName string, err error) {
+	failure := c.failed[serviceName]
+	delay := graphqlMaxRetry
+	if failure.attempts < 6 {
+		delay = graphqlMinRetry << failure.attempts
+	}
+	c.failed[serviceName] = graphqlFailure{err: err, attempts: failure.attempts + 1, retry: graphqlNow().Add(delay)}
+}
+
+//introspect fetch the schema of a service and move it into namespace ns
+func introspect(serviceName, path, ns string) (*remoteSchema, error) {
+	body, err := json.Marshal(GraphQLRequest{Query: introspectionQuery})
+	if err != nil {
+		return nil, err
+	}
+	routed, err := http.NewRequest(http.MethodPost, servicePath+serviceName+"/"+path, bytes.NewReader(body))
+	if err != nil {
+		return nil, err
+	}
+	routed.Header.Set("Content-Type", "application/json")
+
+	data, errs, err := sendGraphQL(routed)
+	if err != nil {
+		return nil, err
+	}
+	if len(errs) > 0 {
+		msg, _ := errs[0]["message"].(string)
+		return nil, errors.New(msg)
+	}
+	schema, ok := data["__schema"].(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid introspection result")
+	}
+
+	remote := &remoteSchema{service: serviceName, namespace: ns, path: path}
+	rootName := func(key string) string {
+		root, _ := schema[key].(map[string]interface{})
+		if name, ok := root["name"].(string); ok {
+			return graphqlTypeName(ns, name)
+		}
+		return ""
+	}
+	remote.query, remote.mutation = rootName("queryType"), rootName("mutationType")
+	remote.types, _ = schema["types"].([]interface{})
+	remote.directives, _ = schema["directives"].([]interface{})
+	for _, t := range remote.types {
+		renameTypes(t, ns)
+	}
+	for _, d := range remote.directives {
+		renameTypes(d, ns)
+	}
+	if remote.query == "" {
+		return nil, errors.New("schema has no query type")
+	}
+	return remote, nil
+}
+
+//renameTypes prefix the type names in an introspection result with ns
+//  types and type references are the objects with a kind
+func renameTypes(node interface{}, ns string) {
+	switch n := node.(type) {
+	case map[string]interface{}:
+		if _, ok := n["kind"].(string); ok {
+			if name, ok := n["name"].(string); ok {
+				n["name"] = graphqlTypeName(ns, name)
+			}
+		}
+		for _, val := range n {
+			renameTypes(val, ns)
+		}
+	case []interface{}:
+		for _, val := range n {
+			renameTypes(val, ns)
+		}
+	}
+}
+
+//build add the root types and merge the types and directives of services
+//  built-in scalars and introspection types are taken from the first service
+func (s *stitchedSchema) build(remotes []*remoteSchema) {
+	s.types = make(map[string]map[string]interface{})
+	query := rootType(queryTypeName, "Root fields of the GraphQL services, one per service")
+	var mutation map[string]interface{}
+	directives := make(map[string]interface{})
+
+	for _, remote := range remotes {
+		query["fields"] = append(query["fields"].([]interface{}), namespaceField(remote, remote.query))
+		if remote.mutation != "" {
+			if mutation == nil {
+				mutation = rootType(mutationTypeName, "Mutations of the GraphQL services, one field per service")
+			}
+			mutation["fields"] = append(mutation["fields"].([]interface{}), namespaceField(remote, remote.mutation))
+		}
+		for _, t := range remote.types {
+			typ, _ := t.(map[string]interface{})
+			if name, ok := typ["name"].(string); ok && s.types[name] == nil {
+				s.types[name] = typ
+			}
+		}
+		for _, d := range remote.directives {
+			directive, _ := d.(map[string]interface{})
+			if name, ok := directive["name"].(string); ok && directives[name] == nil {
+				directives[name] = directive
+			}
+		}
+	}
+
+	s.types[queryTypeName] = query
+	s.schema = map[string]interface{}{
+		"queryType":        query,
+		"mutationType":     nil,
+		"subscriptionType": nil,
+	}
+	if mutation != nil {
+		s.types[mutationTypeName] = mutation
+		s.schema["mutationType"] = mutation
+	}
+
+	names := make([]string, 0, len(s.types))
+	for name := range s.types {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	types := make([]interface{}, len(names))
+	for i, name := range names {
+		types[i] = s.types[name]
+	}
+	s.schema["types"] = types
+
+	names = names[:0]
+	for name := range directives {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	list := make([]interface{}, len(names))
+	for i, name := range names {
+		list[i] = directives[name]
+	}
+	s.schema["directives"] = list
+}
+
+func rootType(name, description string) map[string]interface{} {
+	return map[string]interface{}{
+		"kind":          "OBJECT",
+		"name":          name,
+		"description":   description,
+		"fields":        []interface{}{},
+		"inputFields":   nil,
+		"interfaces":    []interface{}{},
+		"enumValues":    nil,
+		"possibleTypes": nil,
+	}
+}
+
+func namespaceField(remote *remoteSchema, typeName string) map[string]interface{} {
+	return map[string]interface{}{
+		"name":              remote.namespace,
+		"description":       "The " + remote.service + " service",
+		"args":              []interface{}{},
+		"type":              map[string]interface{}{"kind": "OBJECT", "name": typeName, "ofType": nil},
+		"isDeprecated":      false,
+		"deprecationReason": nil,
+	}
+}
+
+//execution state of one GraphQL request
+type execution struct {
+	r         *http.Request
+	doc       *graphql.Document
+	op        *graphql.Operation
+	variables map[string]interface{}
+	schema    *stitchedSchema
+	authorize func(*http.Request) bool
+}
+
+//GraphQL execute a GraphQL request against the stitched schema
+//  each root field is a service namespace, its selections are sent to the
+//  service as one operation, routed like /service/{name}/{graphql}
+//  query fields are sent in parallel, mutation fields one after another
+//  authorize decides if r may call a service, given the routed request
+//  returns an error for requests that cannot be executed at all
+func (gs GraphQLService) GraphQL(r *http.Request, req GraphQLRequest, authorize func(*http.Request) bool) (GraphQLResponse, error) {
+	doc, err := graphql.Parse(req.Query)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	op, err := doc.Operation(req.OperationName)
+	if err != nil {
+		return GraphQLResponse{}, err
+	}
+	if op.Type == graphql.Subscription {
+		return GraphQLResponse{}, ErrGraphQLSubscription
+	}
+	if op.Type == graphql.Mutation && r.Method == http.MethodGet {
+		return GraphQLResponse{}, ErrGraphQLMethod
+	}
+
+	variables := make(map[string]interface{}, len(req.Variables))
+	for name, val := range req.Variables {
+		variables[name] = val
+	}
+	for _, def := range op.Variables {
+		if _, ok := variables[def.Name]; !ok && def.Default != nil {
+			variables[def.Name] = def.Default.Interface(nil)
+		}
+	}
+
+	ex := &execution{r: r, doc: doc, op: op, variables: variables, schema: loadSchemas(r, authorize), authorize: authorize}
+	return ex.run(), nil
+}
+
+func (ex *execution) run() GraphQLResponse {
+	root := queryTypeName
+	if ex.op.Type == graphql.Mutation {
+		root = mutationTypeName
+	}
+	fields := collectFields(ex.doc, ex.variables, ex.op.SelectionSet, root)
+
+	type fieldResult struct {
+		data   interface{}
+		errors []GraphQLError
+	}
+	results := make([]fieldResult, len(fields))
+	var wg sync.WaitGroup
+	for i, field := range fields {
+		if ex.op.Type == graphql.Mutation {
+			data, errs := ex.resolve(field, root)
+			results[i] = fieldResult{data, errs}
+			continue
+		}
+		wg.Add(1)
+		go func(i int, field *graphql.Field) {
+			defer wg.Done()
+			data, errs := ex.resolve(field, root)
+			results[i] = fieldResult{data, errs}
+		}(i, field)
+	}
+	wg.Wait()
+
+	res := GraphQLResponse{Data: make(map[string]interface{}, len(fields))}
+	for i, field := range fields {
+		res.Data[field.Key()] = results[i].data
+		res.Errors = append(res.Errors, results[i].errors...)
+	}
+	return res
+}
+
+//resolve value of a root field
+func (ex *execution) resolve(field *graphql.Field, root string) (interface{}, []GraphQLError) {
+	fail := func(msg string) (interface{}, []GraphQLError) {
+		return nil, []GraphQLError{{Message: msg, Path: []interface{}{field.Key()}}}
+	}
+
+	switch {
+	case field.Name == "__typename":
+		return root, nil
+	case field.Name == "__schema" && root == queryTypeName:
+		return ex.introspect(field.SelectionSet, ex.schema.schema, "__Schema"), nil
+	case field.Name == "__type" && root == queryTypeName:
+		name, _ := argument(field.Arguments, "name", ex.variables).(string)
+		if typ, ok := ex.schema.types[name]; ok {
+			return ex.introspect(field.SelectionSet, typ, "__Type"), nil
+		}
+		return nil, nil
+	}
+
+	if err := ex.schema.failed[field.Name]; err != nil {
+		return fail("schema of " + field.Name + " is unavailable: " + err.Error())
+	}
+	remote := ex.schema.namespaces[field.Name]
+	if remote == nil || (root == mutationTypeName && remote.mutation == "") {
+		return fail(fmt.Sprintf("Cannot query field %q on type %q.", field.Name, root))
+	}
+	if len(field.SelectionSet) == 0 {
+		return fail(fmt.Sprintf("Field %q of type %q must have a selection of subfields.", field.Name, remote.query))
+	}
+	return ex.dispatch(field, remote)
+}
+
+//dispatch send the selections of a namespace field to its service
+func (ex *execution) dispatch(field *graphql.Field, remote *remoteSchema) (interface{}, []GraphQLError) {
+	key := field.Key()
+	fail := func(result, msg string) (interface{}, []GraphQLError) {
+		metrics.Inc(graphqlMetric, map[string]string{"service": remote.service, "result": result})
+		log.WithFields(log.Fields{"service": remote.service, "field": key}).Error("GraphQL Error: " + msg)
+		return nil, []GraphQLError{{
+			Message:    msg,
+			Path:       []interface{}{key},
+			Extensions: map[string]interface{}{"service": remote.service},
+		}}
+	}
+
+	// the sub-operation uses the service's own type names
+	sub := &graphql.Document{}
+	op := &graphql.Operation{Type: ex.op.Type, Name: ex.op.Name}
+	op.SelectionSet = unprefixSelections(field.SelectionSet, remote.namespace)
+	sub.Operations = []*graphql.Operation{op}
+
+	used := make(map[string]bool)
+	var fragments []string
+	references(ex.doc, field.SelectionSet, used, &fragments)
+	for _, name := range fragments {
+		if f := ex.doc.Fragment(name); f != nil {
+			sub.Fragments = append(sub.Fragments, &graphql.Fragment{
+				Name:          f.Name,
+				TypeCondition: strings.TrimPrefix(f.TypeCondition, remote.namespace+"_"),
+				Directives:    f.Directives,
+				SelectionSet:  unprefixSelections(f.SelectionSet, remote.namespace),
+			})
+		}
+	}
+	variables := make(map[string]interface{})
+	for _, def := range ex.op.Variables {
+		if !used[def.Name] {
+			continue
+		}
+		op.Variables = append(op.Variables, &graphql.VariableDefinition{
+			Name:    def.Name,
+			Type:    unprefixType(def.Type, remote.namespace),
+			Default: def.Default,
+		})
+		if val, ok := ex.variables[def.Name]; ok {
+			variables[def.Name] = val
+		}
+	}
+
+	body, err := json.Marshal(GraphQLRequest{Query: sub.String(), OperationName: op.Name, Variables: variables})
+	if err != nil {
+		return fail("failure", err.Error())
+	}
+	routed, err := graphqlRequest(ex.r, remote.service, remote.path, bytes.NewReader(body))
+	if err != nil {
+		return fail("failure", err.Error())
+	}
+	if ex.authorize != nil && !ex.authorize(routed) {
+		return fail("forbidden", "Forbidden")
+	}
+
+	data, errs, err := sendGraphQL(routed)
+	if err != nil {
+		return fail("failure", err.Error())
+	}
+	metrics.Inc(graphqlMetric, map[string]string{"service": remote.service, "result": "success"})
+
+	prefixTypenames(sub, ex.variables, data, op.SelectionSet, remote.namespace)
+	var gqlErrors []GraphQLError
+	for _, e := range errs {
+		ge := GraphQLError{Path: []interface{}{key}, Extensions: map[string]interface{}{}}
+		ge.Message, _ = e["message"].(string)
+		if path, ok := e["path"].([]interface{}); ok {
+			ge.Path = append(ge.Path, path...)
+		}
+		if ext, ok := e["extensions"].(map[string]interface{}); ok {
+			for k, v := range ext {
+				ge.Extensions[k] = v
+			}
+		}
+		ge.Extensions["service"] = remote.service
+		gqlErrors = append(gqlErrors, ge)
+	}
+	if data == nil {
+		return nil, gqlErrors
+	}
+	return data, gqlErrors
+}
+
+//graphqlRequest request to the GraphQL path of a service with the headers of r
+//  it is resolved, so it is authorized for the instance it is routed to
+func graphqlRequest(r *http.Request, serviceName, path string, body io.Reader) (*http.Request, error) {
+	routed, err := http.NewRequest(http.MethodPost, servicePath+serviceName+"/"+path, body)
+	if err != nil {
+		return nil, err
+	}
+	routed = routed.WithContext(r.Context())
+	for name, vals := range r.Header {
+		routed.Header[name] = vals
+	}
+	for _, name := range []string{"Accept-Encoding", "Content-Length"} {
+		routed.Header.Del(name)
+	}
+	routed.Header.Set("Content-Type", "application/json")
+	return Resolve(routed), nil
+}
+
+//sendGraphQL route a GraphQL request to a service and decode the response
+func sendGraphQL(routed *http.Request) (map[string]interface{}, []map[string]interface{}, error) {
+	var ds DiscoveryService
+	rsp, body, err := ds.Route(routed)
+	if err != nil {
+		return nil, nil, err
+	}
+
+	var res struct {
+		Data   map[string]interface{}   `json:"data"`
+		Errors []map[string]interface{} `json:"errors"`
+	}
+	if err := decodeJSON(body, &res); err != nil || (res.Data == nil && res.Errors == nil) {
+		if rsp.StatusCode < 200 || rsp.StatusCode > 299 {
+			return nil, nil, fmt.Errorf("upstream returned status %d", rsp.StatusCode)
+		}
+		return nil, nil, errors.New("invalid GraphQL response")
+	}
+	return res.Data, res.Errors, nil
+}
+
+//introspect answer an introspection selection from the stitched schema
+func (ex *execution) introspect(selections []graphql.Selection, obj map[string]interface{}, typeName string) map[string]interface{} {
+	out := make(map[string]interface{})
+	for _, field := range collectFields(ex.doc, ex.variables, selections, "") {
+		if field.Name == "__typename" {
+			out[field.Key()] = typeName
+			continue
+		}
+		val := obj[field.Name]
+		if typeName == "__Type" && (field.Name == "fields" || field.Name == "enumValues") {
+			if include, _ := argument(field.Arguments, "includeDeprecated", ex.variables).(bool); !include {
+				val = withoutDeprecated(val)
+			}
+		}
+		out[field.Key()] = ex.introspectValue(val, introspectionFields[typeName][field.Name], field.SelectionSet)
+	}
+	return out
+}
+
+//introspectValue resolve a field of an introspection object
+//  references to named types are replaced with the full type
+func (ex *execution) introspectValue(val interface{}, typeName string, selections []graphql.Selection) interface{} {
+	switch v := val.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = ex.introspectValue(item, typeName, selections)
+		}
+		return list
+	case map[string]interface{}:
+		if typeName == "" || len(selections) == 0 {
+			return v
+		}
+		if name, ok := v["name"].(string); ok && typeName == "__Type" && ex.schema.types[name] != nil {
+			v = ex.schema.types[name]
+		}
+		return ex.introspect(selections, v, typeName)
+	}
+	return val
+}
+
+func withoutDeprecated(val interface{}) interface{} {
+	list, ok := val.([]interface{})
+	if !ok {
+		return val
+	}
+	var out []interface{}
+	for _, item := range list {
+		if m, ok := item.(map[string]interface{}); ok && m["isDeprecated"] == true {
+			continue
+		}
+		out = append(out, item)
+	}
+	if out == nil {
+		out = []interface{}{}
+	}
+	return out
+}
+
+//collectFields fields of a selection set in order, by response key
+//  fields with the same key are merged, @skip and @include are applied
+//  fragments are skipped when their type condition is not typeName,
+//  unless typeName is empty
+func collectFields(doc *graphql.Document, variables map[string]interface{}, selections []graphql.Selection, typeName string) []*graphql.Field {
+	var fields []*graphql.Field
+	index := make(map[string]int)
+	visited := make(map[string]bool)
+
+	var collect func(selections []graphql.Selection)
+	collect = func(selections []graphql.Selection) {
+		for _, sel := range selections {
+			switch s := sel.(type) {
+			case *graphql.Field:
+				if !included(s.Directives, variables) {
+					continue
+				}
+				if i, ok := index[s.Key()]; ok {
+					merged := *fields[i]
+					merged.SelectionSet = append(append([]graphql.Selection(nil), merged.SelectionSet...), s.SelectionSet...)
+					fields[i] = &merged
+					continue
+				}
+				index[s.Key()] = len(fields)
+				fields = append(fields, s)
+			case *graphql.InlineFragment:
+				if included(s.Directives, variables) && (typeName == "" || s.TypeCondition == "" || s.TypeCondition == typeName) {
+					collect(s.SelectionSet)
+				}
+			case *graphql.FragmentSpread:
+				f := doc.Fragment(s.Name)
+				if f == nil || visited[s.Name] || !included(s.Directives, variables) {
+					continue
+				}
+				if typeName == "" || f.TypeCondition == typeName {
+					visited[s.Name] = true
+					collect(f.SelectionSet)
+				}
+			}
+		}
+	}
+	collect(selections)
+	return fields
+}
+
+func included(directives []*graphql.Directive, variables map[string]interface{}) bool {
+	for _, d := range directives {
+		cond, _ := argument(d.Arguments, "if", variables).(bool)
+		if (d.Name == "skip" && cond) || (d.Name == "include" && !cond) {
+			return false
+		}
+	}
+	return true
+}
+
+func argument(args []*graphql.Argument, name string, variables map[string]interface{}) interface{} {
+	for _, arg := range args {
+		if arg.Name == name {
+			return arg.Value.Interface(variables)
+		}
+	}
+	return nil
+}
+
+//references collect the variables and fragments used by a selection set
+//  fragments are listed in the order they are first spread
+func references(doc *graphql.Document, selections []graphql.Selection, variables map[string]bool, fragments *[]string) {
+	var value func(v *graphql.Value)
+	value = func(v *graphql.Value) {
+		if v.Kind == graphql.VariableValue {
+			variables[v.Raw] = true
+		}
+		for _, item := range v.List {
+			value(item)
+		}
+		for _, f := range v.Fields {
+			value(f.Value)
+		}
+	}
+	directives := func(ds []*graphql.Directive) {
+		for _, d := range ds {
+			for _, arg := range d.Arguments {
+				value(arg.Value)
+			}
+		}
+	}
+
+	for _, sel := range selections {
+		switch s := sel.(type) {
+		case *graphql.Field:
+			for _, arg := range s.Arguments {
+				value(arg.Value)
+			}
+			directives(s.Directives)
+			references(doc, s.SelectionSet, variables, fragments)
+		case *graphql.InlineFragment:
+			directives(s.Directives)
+			references(doc, s.SelectionSet, variables, fragments)
+		case *graphql.FragmentSpread:
+			directives(s.Directives)
+			seen := false
+			for _, name := range *fragments {
+				seen = seen || name == s.Name
+			}
+			if f := doc.Fragment(s.Name); f != nil && !seen {
+				*fragments = append(*fragments, s.Name)
+				directives(f.Directives)
+				references(doc, f.SelectionSet, variables, fragments)
+			}
+		}
+	}
+}
+
+//unprefixSelections copy selections with the type conditions of namespace ns
+//  renamed back to the service's type names
+func unprefixSelections(selections []graphql.Selection, ns string) []graphql.Selection {
+	out := make([]graphql.Selection, len(selections))
+	for i, sel := range selections {
+		switch s := sel.(type) {
+		case *graphql.Field:
+			field := *s
+			field.SelectionSet = unprefixSelections(s.SelectionSet, ns)
+			out[i] = &field
+		case *graphql.InlineFragment:
+			inline := *s
+			inline.TypeCondition = strings.TrimPrefix(s.TypeCondition, ns+"_")
+			inline.SelectionSet = unprefixSelections(s.SelectionSet, ns)
+			out[i] = &inline
+		default:
+			out[i] = sel
+		}
+	}
+	return out
+}
+
+func unprefixType(t *graphql.Type, ns string) *graphql.Type {
+	c := *t
+	if c.Elem != nil {
+		c.Elem = unprefixType(c.Elem, ns)
+	} else if !builtinTypes[c.Name] {
+		c.Name = strings.TrimPrefix(c.Name, ns+"_")
+	}
+	return &c
+}
+
+//prefixTypenames move the __typename values of a service response into
+//  namespace ns
+func prefixTypenames(doc *graphql.Document, variables map[string]interface{}, val interface{}, selections []graphql.Selection, ns string) {
+	switch v := val.(type) {
+	case []interface{}:
+		for _, item := range v {
+			prefixTypenames(doc, variables, item, selections, ns)
+		}
+	case map[string]interface{}:
+		for _, field := range collectFields(doc, variables, selections, "") {
+			item, ok := v[field.Key()]
+			if !ok {
+				continue
+			}
+			if name, ok := item.(string); ok && field.Name == "__typename" {
+				v[field.Key()] = graphqlTypeName(ns, name)
+				continue
+			}
+			prefixTypenames(doc, variables, item, field.SelectionSet, ns)
+		}
+	}
+}
+
+const introspectionQuery = `query IntrospectionQuery {
+  __schema {
+    queryType { name }
+    mutationType { name }
+    subscriptionType { name }
+    types { ...FullType }
+    directives { name description locations args { ...InputValue } }
+  }
+}
+fragment FullType on __Type {
+  kind
+  name
+  description
+  fields(includeDeprecated: true) {
+    name
+    description
+    args { ...InputValue }
+    type { ...TypeRef }
+    isDeprecated
+    deprecationReason
+  }
+  inputFields { ...InputValue }
+  interfaces { ...TypeRef }
+  enumValues(includeDeprecated: true) { name description isDeprecated deprecationReason }
+  possibleTypes { ...TypeRef }
+}
+fragment InputValue on __InputValue {
+  name
+  description
+  type { ...TypeRef }
+  defaultValue
+}
+fragment TypeRef on __Type {
+  kind
+  name
+  ofType { kind name ofType { kind name ofType { kind name ofType { kind name
+    ofType { kind name ofType { kind name ofType { kind name } } } } } } }
+}`
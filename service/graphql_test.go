@@ -0,0 +1,371 @@
+package service
+
+import (
+	"encoding/json"
+	"net/http"
+	"net/http/httptest"
+	"reflect"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/graphql"
+	"github.com/dtan44/SMUG/metrics"
+)
+
+const usersSchema = `{
+	"queryType": {"name": "Query"},
+	"mutationType": null,
+	"types": [
+		{"kind": "OBJECT", "name": "Query", "fields": [
+			{"name": "user", "args": [{"name": "id", "type": {"kind": "NON_NULL", "name": null, "ofType": {"kind": "SCALAR", "name": "ID", "ofType": null}}}],
+			 "type": {"kind": "OBJECT", "name": "User", "ofType": null}, "isDeprecated": false},
+			{"name": "broken", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false},
+			{"name": "legacy", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": true}
+		], "interfaces": []},
+		{"kind": "OBJECT", "name": "User", "fields": [
+			{"name": "id", "args": [], "type": {"kind": "SCALAR", "name": "ID", "ofType": null}, "isDeprecated": false},
+			{"name": "name", "args": [], "type": {"kind": "SCALAR", "name": "String", "ofType": null}, "isDeprecated": false}
+		], "interfaces": []},
+		{"kind": "SCALAR", "name": "ID"},
+		{"kind": "SCALAR", "name": "String"}
+	],
+	"directives": [{"name": "include", "locations": ["FIELD"], "args": []}]
+}`
+
+const ordersSchema = `{
+	"queryType": {"name": "RootQuery"},
+	"mutationType": {"name": "Mutation"},
+	"types": [
+		{"kind": "OBJECT", "name": "RootQuery", "fields": [
+			{"name": "orders", "args": [], "type": {"kind": "LIST", "name": null, "ofType": {"kind": "OBJECT", "name": "Order", "ofType": null}}, "isDeprecated": false}
+		], "interfaces": []},
+		{"kind": "OBJECT", "name": "Mutation", "fields": [
+			{"name": "cancel", "args": [], "type": {"kind": "OBJECT", "name": "Order", "ofType": null}, "isDeprecated": false}
+		], "interfaces": []},
+		{"kind": "OBJECT", "name": "Order", "fields": [
+			{"name": "id", "args": [], "type": {"kind": "SCALAR", "name": "ID", "ofType": null}, "isDeprecated": false},
+			{"name": "total", "args": [], "type": {"kind": "SCALAR", "name": "Float", "ofType": null}, "isDeprecated": false}
+		], "interfaces": []},
+		{"kind": "SCALAR", "name": "ID"},
+		{"kind": "SCALAR", "name": "Float"}
+	],
+	"directives": [{"name": "skip", "locations": ["FIELD"], "args": []}]
+}`
+
+//toyService in-process GraphQL service answering introspection with schema
+//  and other operations from data["query"] or data["mutation"]
+type toyService struct {
+	server   *httptest.Server
+	lock     sync.Mutex
+	received []GraphQLRequest
+	headers  []http.Header
+}
+
+func newToyService(schema string, data map[string]interface{}) *toyService {
+	ts := &toyService{}
+	ts.server = httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		var req GraphQLRequest
+		json.NewDecoder(r.Body).Decode(&req)
+		ts.lock.Lock()
+		ts.received = append(ts.received, req)
+		ts.headers = append(ts.headers, r.Header)
+		ts.lock.Unlock()
+
+		w.Header().Set("Content-Type", "application/json")
+		if strings.Contains(req.Query, "__schema") {
+			w.Write([]byte(`{"data":{"__schema":` + schema + `}}`))
+			return
+		}
+
+		doc, err := graphql.Parse(req.Query)
+		if err != nil {
+			w.WriteHeader(http.StatusBadRequest)
+			json.NewEncoder(w).Encode(GraphQLResponse{Errors: []GraphQLError{{Message: err.Error()}}})
+			return
+		}
+		op, _ := doc.Operation(req.OperationName)
+		root, _ := data[op.Type].(map[string]interface{})
+		var res GraphQLResponse
+		res.Data = toyResolve(doc, req.Variables, op.SelectionSet, root, &res).(map[string]interface{})
+		json.NewEncoder(w).Encode(res)
+	}))
+	return ts
+}
+
+func toyResolve(doc *graphql.Document, variables map[string]interface{}, selections []graphql.Selection, val interface{}, res *GraphQLResponse) interface{} {
+	switch v := val.(type) {
+	case []interface{}:
+		list := make([]interface{}, len(v))
+		for i, item := range v {
+			list[i] = toyResolve(doc, variables, selections, item, res)
+		}
+		return list
+	case map[string]interface{}:
+		out := make(map[string]interface{})
+		for _, field := range collectFields(doc, variables, selections, "") {
+			if field.Name == "broken" {
+				res.Errors = append(res.Errors, GraphQLError{Message: "broken field", Path: []interface{}{field.Key()}})
+				out[field.Key()] = nil
+				continue
+			}
+			out[field.Key()] = toyResolve(doc, variables, field.SelectionSet, v[field.Name], res)
+		}
+		return out
+	}
+	return val
+}
+
+func (ts *toyService) requests() []GraphQLRequest {
+	ts.lock.Lock()
+	defer ts.lock.Unlock()
+	return append([]GraphQLRequest(nil), ts.received...)
+}
+
+func setupGraphQL(t *testing.T) (*toyService, *toyService) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	graphqlSchemas.schemas = make(map[string]*remoteSchema)
+	graphqlSchemas.failed = make(map[string]graphqlFailure)
+	graphqlNow = time.Now
+	metrics.Reset()
+
+	users := newToyService(usersSchema, map[string]interface{}{
+		"query": map[string]interface{}{
+			"user": map[string]interface{}{"__typename": "User", "id": "u1", "name": "ann"},
+		},
+	})
+	orders := newToyService(ordersSchema, map[string]interface{}{
+		"query": map[string]interface{}{
+			"orders": []interface{}{map[string]interface{}{"__typename": "Order", "id": "o1", "total": 9.5}},
+		},
+		"mutation": map[string]interface{}{
+			"cancel": map[string]interface{}{"__typename": "Order", "id": "o1"},
+		},
+	})
+	t.Cleanup(users.server.Close)
+	t.Cleanup(orders.server.Close)
+
+	serviceMap["users"] = []Instance{{URL: users.server.URL + "/", GraphQL: "graphql"}}
+	serviceMap["orders"] = []Instance{{URL: orders.server.URL + "/", GraphQL: "/api/graphql"}}
+	serviceMap["rest"] = []Instance{{URL: "http://rest/"}}
+	return users, orders
+}
+
+func graphqlJSON(t *testing.T, v interface{}) string {
+	j, err := json.Marshal(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	return string(j)
+}
+
+func TestGraphQLIntrospection(t *testing.T) {
+	users, orders := setupGraphQL(t)
+	var gs GraphQLService
+
+	r, _ := http.NewRequest(http.MethodPost, "/graphql", nil)
+	res, err := gs.GraphQL(r, GraphQLRequest{Query: `{
+		__schema { queryType { name fields { name type { name } } } mutationType { fields { name } } }
+		__type(name: "users_Query") { kind name fields { name type { kind ofType { name } name } } }
+		orders: __type(name: "orders_RootQuery") { fields { type { ofType { name fields { name } } } } }
+		missing: __type(name: "User") { name }
+	}`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"__schema":{"mutationType":{"fields":[{"name":"orders"}]},` +
+		`"queryType":{"fields":[{"name":"orders","type":{"name":"orders_RootQuery"}},{"name":"users","type":{"name":"users_Query"}}],"name":"Query"}},` +
+		`"__type":{"fields":[{"name":"user","type":{"kind":"OBJECT","name":"users_User","ofType":null}},{"name":"broken","type":{"kind":"SCALAR","name":"String","ofType":null}}],"kind":"OBJECT","name":"users_Query"},` +
+		`"missing":null,` +
+		`"orders":{"fields":[{"type":{"ofType":{"fields":[{"name":"id"},{"name":"total"}],"name":"orders_Order"}}}]}}`
+	if data := graphqlJSON(t, res.Data); data != expected || len(res.Errors) != 0 {
+		t.Errorf("service returned unexpected schema: got %v %v want %v", data, res.Errors, expected)
+	}
+	if len(users.requests()) != 1 || len(orders.requests()) != 1 {
+		t.Errorf("service did not introspect each service once: got %v %v", users.requests(), orders.requests())
+	}
+
+	// schemas are reused until the registry changes
+	gs.GraphQL(r, GraphQLRequest{Query: `{ __typename }`}, nil)
+	if len(users.requests()) != 1 {
+		t.Errorf("service introspected a cached schema again: got %v requests want 1", len(users.requests()))
+	}
+	registryLock.Lock()
+	revision++
+	registryLock.Unlock()
+	gs.GraphQL(r, GraphQLRequest{Query: `{ __typename }`}, nil)
+	if len(users.requests()) != 2 {
+		t.Errorf("service did not introspect after a registry change: got %v requests want 2", len(users.requests()))
+	}
+}
+
+func TestGraphQLQuery(t *testing.T) {
+	users, orders := setupGraphQL(t)
+	var gs GraphQLService
+
+	r, _ := http.NewRequest(http.MethodPost, "/graphql", nil)
+	r.Header.Set("X-User", "ann")
+	r.Header.Set("Accept-Encoding", "gzip")
+	res, err := gs.GraphQL(r, GraphQLRequest{
+		Query: `query Home($id: ID!, $unused: Int, $all: Boolean = true) {
+			__typename
+			users { user(id: $id) { ...U } }
+			shop: orders @include(if: $all) { orders { id __typename ... on orders_Order { total } } }
+			skipped: users @skip(if: true) { user(id: "x") { id } }
+		}
+		fragment U on users_User { id name kind: __typename }`,
+		Variables: map[string]interface{}{"id": "u1", "unused": 1},
+	}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"__typename":"Query",` +
+		`"shop":{"orders":[{"__typename":"orders_Order","id":"o1","total":9.5}]},` +
+		`"users":{"user":{"id":"u1","kind":"users_User","name":"ann"}}}`
+	if data := graphqlJSON(t, res.Data); data != expected || len(res.Errors) != 0 {
+		t.Errorf("service returned unexpected data: got %v %v want %v", data, res.Errors, expected)
+	}
+
+	sent := users.requests()[1]
+	query := `query Home($id: ID!) { user(id: $id) { ...U } } fragment U on User { id name kind: __typename }`
+	if sent.Query != query || !reflect.DeepEqual(sent.Variables, map[string]interface{}{"id": "u1"}) || sent.OperationName != "Home" {
+		t.Errorf("service sent unexpected operation: got %v %v want %v", sent.Query, sent.Variables, query)
+	}
+	if header := users.headers[1]; header.Get("X-User") != "ann" || header.Get("Content-Type") != "application/json" {
+		t.Errorf("service did not forward the request headers: got %v", header)
+	}
+	query = `query Home { orders { id __typename ... on Order { total } } }`
+	if sent := orders.requests()[1]; sent.Query != query {
+		t.Errorf("service sent unexpected operation: got %v want %v", sent.Query, query)
+	}
+	if count := metrics.Get(graphqlMetric, map[string]string{"service": "users", "result": "success"}); count != 1 {
+		t.Errorf("service counted wrong number of calls: got %v want %v", count, 1)
+	}
+}
+
+func TestGraphQLMutation(t *testing.T) {
+	_, orders := setupGraphQL(t)
+	var gs GraphQLService
+
+	r, _ := http.NewRequest(http.MethodPost, "/graphql", nil)
+	res, err := gs.GraphQL(r, GraphQLRequest{Query: `mutation { orders { cancel { id __typename } } users { user { id } } }`}, nil)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if data := graphqlJSON(t, res.Data); data != `{"orders":{"cancel":{"__typename":"orders_Order","id":"o1"}},"users":null}` {
+		t.Errorf("service returned unexpected data: got %v", data)
+	}
+	if len(res.Errors) != 1 || res.Errors[0].Message != `Cannot query field "users" on type "Mutation".` {
+		t.Errorf("service returned unexpected errors: got %v", res.Errors)
+	}
+	if sent := orders.requests()[1]; sent.Query != `mutation { cancel { id __typename } }` {
+		t.Errorf("service sent unexpected operation: got %v", sent.Query)
+	}
+
+	r, _ = http.NewRequest(http.MethodGet, "/graphql", nil)
+	if _, err := gs.GraphQL(r, GraphQLRequest{Query: `mutation { orders { cancel { id } } }`}, nil); err != ErrGraphQLMethod {
+		t.Errorf("service returned wrong error: got %v want %v", err, ErrGraphQLMethod)
+	}
+}
+
+func TestGraphQLFail(t *testing.T) {
+	_, orders := setupGraphQL(t)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	serviceMap["down"] = []Instance{{URL: down.URL + "/", GraphQL: "graphql"}}
+	var gs GraphQLService
+
+	r, _ := http.NewRequest(http.MethodPost, "/graphql", nil)
+	authorize := func(routed *http.Request) bool {
+		return !strings.HasPrefix(routed.URL.Path, "/service/orders/")
+	}
+	res, err := gs.GraphQL(r, GraphQLRequest{Query: `{
+		users { user { id } broken }
+		orders { orders { id } }
+		down { id }
+		rest { id }
+		nested: users
+	}`}, authorize)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expected := `{"down":null,"nested":null,"orders":null,"rest":null,"users":{"broken":null,"user":{"id":"u1"}}}`
+	if data := graphqlJSON(t, res.Data); data != expected {
+		t.Errorf("service returned unexpected data: got %v want %v", data, expected)
+	}
+	messages := make(map[string]GraphQLError)
+	for _, e := range res.Errors {
+		messages[graphqlJSON(t, e.Path)] = e
+	}
+	if e := messages[`["users","broken"]`]; e.Message != "broken field" || e.Extensions["service"] != "users" {
+		t.Errorf("service did not prefix the errors of a service: got %v", res.Errors)
+	}
+	if e := messages[`["orders"]`]; e.Message != `Cannot query field "orders" on type "Query".` || len(orders.requests()) != 0 {
+		t.Errorf("service did not hide the unauthorized namespace: got %v", res.Errors)
+	}
+	if e := messages[`["down"]`]; !strings.HasPrefix(e.Message, "schema of down is unavailable") {
+		t.Errorf("service did not report the failed introspection: got %v", res.Errors)
+	}
+	if e := messages[`["rest"]`]; e.Message != `Cannot query field "rest" on type "Query".` {
+		t.Errorf("service did not reject an unknown field: got %v", res.Errors)
+	}
+	if e := messages[`["nested"]`]; !strings.Contains(e.Message, "must have a selection") {
+		t.Errorf("service did not reject a namespace without selections: got %v", res.Errors)
+	}
+
+	for _, query := range []string{`{ users { id }`, `query A { a } query B { b }`, `subscription { users { id } }`} {
+		if _, err := gs.GraphQL(r, GraphQLRequest{Query: query}, nil); err == nil {
+			t.Errorf("service accepted invalid request %q", query)
+		}
+	}
+}
+
+func TestGraphQLIntrospectionRetry(t *testing.T) {
+	setupGraphQL(t)
+	var lock sync.Mutex
+	attempts := 0
+	down := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		lock.Lock()
+		attempts++
+		lock.Unlock()
+		w.WriteHeader(http.StatusInternalServerError)
+	}))
+	defer down.Close()
+	serviceMap["down"] = []Instance{{URL: down.URL + "/", GraphQL: "graphql"}}
+	var gs GraphQLService
+
+	now := time.Now()
+	graphqlNow = func() time.Time { return now }
+	r, _ := http.NewRequest(http.MethodPost, "/graphql", nil)
+	tests := []struct {
+		after    time.Duration
+		expected int
+	}{
+		{0, 1},
+		{graphqlMinRetry - time.Millisecond, 1},
+		{graphqlMinRetry, 2},
+		// the delay doubles after each failure
+		{graphqlMinRetry, 2},
+		{2 * graphqlMinRetry, 3},
+	}
+
+	for _, test := range tests {
+		now = now.Add(test.after)
+		res, err := gs.GraphQL(r, GraphQLRequest{Query: `{ down { id } }`}, nil)
+		if err != nil {
+			t.Fatal(err)
+		}
+		lock.Lock()
+		got := attempts
+		lock.Unlock()
+		if got != test.expected || len(res.Errors) != 1 {
+			t.Errorf("after %v: got %v introspections %v want %v", test.after, got, res.Errors, test.expected)
+		}
+	}
+}
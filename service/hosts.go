@@ -0,0 +1,118 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net"
+	"regexp"
+	"strings"
+	"sync"
+
+	"github.com/spf13/viper"
+)
+
+const (
+	hostsKey = "hosts"
+)
+
+var (
+	hostRules []HostRule
+	hostLock  sync.RWMutex
+)
+
+//HostRule route requests for a host to a service, from the hosts config section
+//  Host is a name such as shop.example.com, where * matches one label,
+//  Regex is matched against the whole host instead
+//  Service is the service routed to, without it the service is the label
+//  matched by the one * of Host, or the service group, or else the only
+//  group, of Regex
+type HostRule struct {
+	Host    string `mapstructure:"host"`
+	Regex   string `mapstructure:"regex"`
+	Service string `mapstructure:"service"`
+
+	re *regexp.Regexp
+}
+
+//PrepareHosts read and validate the hosts section of a candidate config
+func PrepareHosts(v *viper.Viper) (func(), error) {
+	var candidate []HostRule
+	if err := v.UnmarshalKey(hostsKey, &candidate); err != nil {
+		return nil, err
+	}
+	for i := range candidate {
+		if err := compileHostRule(&candidate[i]); err != nil {
+			return nil, fmt.Errorf("hosts %d: %s", i, err.Error())
+		}
+	}
+
+	return func() {
+		hostLock.Lock()
+		hostRules = candidate
+		hostLock.Unlock()
+	}, nil
+}
+
+func compileHostRule(rule *HostRule) error {
+	if (rule.Host == "") == (rule.Regex == "") {
+		return errors.New("exactly one of host and regex is required")
+	}
+
+	pattern := rule.Regex
+	if rule.Host != "" {
+		labels := strings.Split(strings.ToLower(strings.TrimSuffix(rule.Host, ".")), ".")
+		for i, label := range labels {
+			switch {
+			case label == "*":
+				labels[i] = `([^.]+)`
+			case label == "" || strings.ContainsAny(label, "*/:"):
+				return fmt.Errorf("host: invalid name %s, * must be a whole label", rule.Host)
+			default:
+				labels[i] = regexp.QuoteMeta(label)
+			}
+		}
+		pattern = strings.Join(labels, `\.`)
+	}
+	re, err := regexp.Compile(`^(?i:` + pattern + `)$`)
+	if err != nil {
+		return fmt.Errorf("regex: %s", err.Error())
+	}
+	rule.re = re
+
+	if rule.Service == "" && re.NumSubexp() != 1 && re.SubexpIndex("service") < 0 {
+		return errors.New("service: required unless the host captures it")
+	}
+	return nil
+}
+
+//HostService service a request host is routed to by the hosts config
+//  the port and a trailing dot are ignored, rules are tried in order
+//  host names are case-insensitive, so a service name taken from the host
+//  is lowercased like the names in the routes config
+func HostService(host string) (string, bool) {
+	if h, _, err := net.SplitHostPort(host); err == nil {
+		host = h
+	}
+	host = strings.TrimSuffix(host, ".")
+
+	hostLock.RLock()
+	defer hostLock.RUnlock()
+	for _, rule := range hostRules {
+		match := rule.re.FindStringSubmatch(host)
+		if match == nil {
+			continue
+		}
+		name := rule.Service
+		if name == "" {
+			group := rule.re.SubexpIndex("service")
+			if group < 0 {
+				group = 1
+			}
+			name = strings.ToLower(match[group])
+		}
+		if name != "" {
+			return name, true
+		}
+	}
+	return "", false
+}
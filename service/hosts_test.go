@@ -0,0 +1,71 @@
+package service
+
+import (
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func setupHosts(t *testing.T, rules []interface{}) {
+	v := viper.New()
+	v.Set(hostsKey, rules)
+	apply, err := PrepareHosts(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+}
+
+func TestHostService(t *testing.T) {
+	setupHosts(t, []interface{}{
+		map[string]interface{}{"host": "shop.example.com", "service": "orders"},
+		map[string]interface{}{"host": "*.api.example.com"},
+		map[string]interface{}{"regex": `(?P<service>[a-z]+)-(eu|us)\.example\.com`},
+		map[string]interface{}{"regex": `legacy[0-9]+\.example\.com`, "service": "orders"},
+	})
+	defer setupHosts(t, nil)
+
+	tests := []struct {
+		host     string
+		expected string
+	}{
+		{"shop.example.com", "orders"},
+		{"SHOP.example.com.", "orders"},
+		{"users.api.example.com", "users"},
+		{"Users.api.example.com:8080", "users"},
+		{"payments-eu.example.com", "payments"},
+		{"legacy42.example.com", "orders"},
+		{"a.b.api.example.com", ""},
+		{"api.example.com", ""},
+		{"shop.example.com.evil.com", ""},
+		{"localhost:8080", ""},
+	}
+
+	for _, test := range tests {
+		name, ok := HostService(test.host)
+		if name != test.expected || ok != (test.expected != "") {
+			t.Errorf("service returned wrong service for %v: got %v want %v", test.host, name, test.expected)
+		}
+	}
+}
+
+func TestPrepareHostsFail(t *testing.T) {
+	tests := []map[string]interface{}{
+		{},
+		{"host": "a.example.com", "regex": "a"},
+		{"host": "a*.example.com"},
+		{"host": "a..example.com", "service": "a"},
+		{"host": "shop.example.com"},
+		{"host": "*.*.example.com"},
+		{"regex": "("},
+		{"regex": `([a-z]+)-([a-z]+)\.example\.com`},
+	}
+
+	for i, test := range tests {
+		v := viper.New()
+		v.Set(hostsKey, []interface{}{test})
+		if _, err := PrepareHosts(v); err == nil {
+			t.Errorf("%d: expected error", i)
+		}
+	}
+}
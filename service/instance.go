@@ -0,0 +1,181 @@
+package service
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+const (
+	maxWeight = 100
+)
+
+var (
+	versionPattern *regexp.Regexp
+	protocols      map[string]bool
+)
+
+func init() {
+	versionPattern = regexp.MustCompile(`^v?(0|[1-9][0-9]*)(\.(0|[1-9][0-9]*))?(\.(0|[1-9][0-9]*))?(-[0-9A-Za-z.-]+)?$`)
+	protocols = map[string]bool{
+		"":            true,
+		ProtocolHTTP:  true,
+		ProtocolHTTPS: true,
+		ProtocolH2C:   true,
+		ProtocolH2:    true,
+		ProtocolGRPC:  true,
+		ProtocolTCP:   true,
+		ProtocolUDP:   true,
+	}
+}
+
+//Instance registered service details
+//  OpenAPI is the spec of the instance, or fetched from OpenAPIURL
+//  GraphQL is the path of the instance's GraphQL endpoint, see /graphql
+//  DescriptorSet holds the protobuf descriptors of a gRPC instance, or is
+//  fetched from DescriptorSetURL, its google.api.http rules are transcoded
+//  Listen is the gateway port of a tcp or udp service, see ProxyL4
+//  Zone is matched by routing rule groups like a tag, Weight shares the
+//  requests that pick no version between the weighted instances
+type Instance struct {
+	URL        string            `json:"URL"`
+	Version    string            `json:"version,omitempty"`
+	Tags       []string          `json:"tags,omitempty"`
+	Zone       string            `json:"zone,omitempty"`
+	Weight     int               `json:"weight,omitempty"`
+	Protocol   string            `json:"protocol,omitempty"`
+	Metadata   map[string]string `json:"metadata,omitempty"`
+	Static     bool              `json:"static,omitempty"`
+	Schemas    []SchemaRule      `json:"schemas,omitempty"`
+	OpenAPIURL string            `json:"openapi_url,omitempty" mapstructure:"openapi_url"`
+	OpenAPI    *APISpec          `json:"openapi,omitempty" mapstructure:"-"`
+	GraphQL    string            `json:"graphql,omitempty" mapstructure:"graphql"`
+
+	DescriptorSetURL string         `json:"descriptor_set_url,omitempty" mapstructure:"descriptor_set_url"`
+	DescriptorSet    *DescriptorSet `json:"descriptor_set,omitempty" mapstructure:"-"`
+
+	Listen int `json:"listen,omitempty" mapstructure:"listen"`
+}
+
+//HasTag check if instance is tagged with tag
+func (in Instance) HasTag(tag string) bool {
+	for _, t := range in.Tags {
+		if t == tag {
+			return true
+		}
+	}
+	return false
+}
+
+//normalizeInstance give the URL a trailing slash and lowercase the protocol
+func normalizeInstance(in Instance) Instance {
+	if in.URL != "" && !strings.HasSuffix(in.URL, "/") {
+		in.URL += "/"
+	}
+	in.Protocol = strings.ToLower(in.Protocol)
+	return in
+}
+
+//FieldError invalid field and the reason it was rejected
+type FieldError struct {
+	Field  string `json:"field"`
+	Reason string `json:"reason"`
+}
+
+//ValidationError list of invalid fields
+type ValidationError struct {
+	Fields []FieldError
+}
+
+func (ve *ValidationError) Error() string {
+	reasons := make([]string, 0, len(ve.Fields))
+	for _, f := range ve.Fields {
+		reasons = append(reasons, f.Field+": "+f.Reason)
+	}
+	return "Invalid Fields - " + strings.Join(reasons, "; ")
+}
+
+func (ve *ValidationError) add(field, reason string) {
+	ve.Fields = append(ve.Fields, FieldError{field, reason})
+}
+
+//validateInstance check registration input
+//  returns *ValidationError listing every invalid field
+func validateInstance(in Instance) error {
+	ve := &ValidationError{}
+
+	protocol := strings.ToLower(in.Protocol)
+	u, err := url.Parse(in.URL)
+	switch {
+	case err != nil || u.Hostname() == "":
+		ve.add("URL", "must be an absolute URL with a host")
+	case protocol == ProtocolTCP || protocol == ProtocolUDP:
+		if u.Scheme != protocol || u.Port() == "" {
+			ve.add("URL", "must be "+protocol+"://host:port")
+		}
+	case u.Scheme != "http" && u.Scheme != "https":
+		ve.add("URL", "must be an http or https URL")
+	}
+
+	if in.Version != "" && !versionPattern.MatchString(in.Version) {
+		ve.add("version", "must be a semantic version")
+	}
+
+	for _, tag := range in.Tags {
+		if tag == "" || strings.ContainsAny(tag, " ,\t\n") {
+			ve.add("tags", "must be non-empty and contain no spaces or commas")
+			break
+		}
+	}
+
+	if in.Weight < 0 || in.Weight > maxWeight {
+		ve.add("weight", "must be between 0 and 100")
+	}
+
+	if !protocols[protocol] {
+		ve.add("protocol", "unsupported protocol "+in.Protocol)
+	}
+
+	if protocol == ProtocolTCP || protocol == ProtocolUDP {
+		if in.Listen < 1 || in.Listen > maxPort {
+			ve.add("listen", "must be a port between 1 and 65535")
+		}
+	} else if in.Listen != 0 {
+		ve.add("listen", "only tcp and udp services listen on a port")
+	}
+
+	// transcoded requests are gRPC calls, which need HTTP/2
+	switch protocol {
+	case ProtocolGRPC, ProtocolH2C, ProtocolH2:
+	default:
+		if in.DescriptorSet != nil {
+			ve.add("descriptor_set", "requires protocol grpc, h2c or h2")
+		}
+		if in.DescriptorSetURL != "" {
+			ve.add("descriptor_set_url", "requires protocol grpc, h2c or h2")
+		}
+	}
+
+	for key := range in.Metadata {
+		if key == "" {
+			ve.add("metadata", "keys must be non-empty")
+			break
+		}
+	}
+
+	if err := validateSchemaRules(in.Schemas); err != nil {
+		ve.add("schemas", err.Error())
+	}
+
+	for _, segment := range strings.Split(in.GraphQL, "/") {
+		if segment == ".." || strings.ContainsAny(segment, "?#") {
+			ve.add("graphql", "must be a path relative to the URL")
+			break
+		}
+	}
+
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
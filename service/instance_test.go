@@ -0,0 +1,102 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestValidateInstanceSuccess(t *testing.T) {
+	in := Instance{
+		URL:      "http://www.test.com",
+		Version:  "v1.2.3-beta.1",
+		Tags:     []string{"staging", "mobile"},
+		Zone:     "us-east-1a",
+		Weight:   50,
+		Protocol: "HTTPS",
+		Metadata: map[string]string{"team": "orders"},
+	}
+
+	if err := validateInstance(in); err != nil {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			err.Error(), "nil")
+	}
+}
+
+func TestValidateInstanceFields(t *testing.T) {
+	in := Instance{
+		URL:      "http://www.test.com",
+		Version:  "one",
+		Tags:     []string{"has space"},
+		Weight:   101,
+		Protocol: "ftp",
+		Metadata: map[string]string{"": "value"},
+	}
+
+	err := validateInstance(in)
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("service returned unexpected error type: got %T want %T",
+			err, &ValidationError{})
+	}
+
+	expected := []string{"version", "tags", "weight", "protocol", "metadata"}
+	if len(ve.Fields) != len(expected) {
+		t.Fatalf("service returned unexpected fields: got %v want %v",
+			ve.Fields, expected)
+	}
+	for i, field := range expected {
+		if ve.Fields[i].Field != field {
+			t.Errorf("service returned unexpected field: got %v want %v",
+				ve.Fields[i].Field, field)
+		}
+	}
+}
+
+func TestValidateInstanceURL(t *testing.T) {
+	tests := []struct {
+		in       Instance
+		expected string
+	}{
+		{Instance{URL: "http://test"}, ""},
+		{Instance{URL: "https://test:8443/api", Protocol: ProtocolH2}, ""},
+		{Instance{URL: ""}, "must be an absolute URL with a host"},
+		{Instance{URL: "test"}, "must be an absolute URL with a host"},
+		{Instance{URL: "http:///path"}, "must be an absolute URL with a host"},
+		{Instance{URL: "ftp://test"}, "must be an http or https URL"},
+		{Instance{URL: "tcp://test:80", Protocol: ProtocolGRPC}, "must be an http or https URL"},
+		{Instance{URL: "tcp://:6379", Protocol: ProtocolTCP, Listen: 6380}, "must be an absolute URL with a host"},
+	}
+
+	for _, test := range tests {
+		err := validateInstance(test.in)
+		var reason string
+		if ve, ok := err.(*ValidationError); ok && ve.Fields[0].Field == "URL" {
+			reason = ve.Fields[0].Reason
+		}
+		if reason != test.expected {
+			t.Errorf("service returned unexpected reason for %v: got %v want %v", test.in.URL, reason, test.expected)
+		}
+	}
+}
+
+func TestValidationErrorText(t *testing.T) {
+	ve := &ValidationError{}
+	ve.add("version", "must be a semantic version")
+	ve.add("weight", "must be between 0 and 100")
+
+	errorText := "Invalid Fields - version: must be a semantic version; weight: must be between 0 and 100"
+	if ve.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			ve.Error(), errorText)
+	}
+}
+
+func TestHasTag(t *testing.T) {
+	in := Instance{Tags: []string{"staging"}}
+
+	if !in.HasTag("staging") {
+		t.Error("Failed Instance Has Tag")
+	}
+	if in.HasTag("mobile") {
+		t.Error("Failed Instance Missing Tag")
+	}
+}
@@ -0,0 +1,421 @@
+package service
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"io"
+	"net"
+	"net/url"
+	"sort"
+	"strconv"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	l4Metric              = "smug_l4_connections_total"
+	defaultIdleTimeout    = 5 * time.Minute
+	defaultMaxConnections = 1024
+	l4DialTimeout         = 5 * time.Second
+	udpBufferSize         = 65535
+	maxPort               = 65535
+)
+
+var (
+	l4Lock      sync.Mutex
+	l4Listeners map[string]*l4Listener
+)
+
+func init() {
+	l4Listeners = make(map[string]*l4Listener)
+}
+
+//l4Listener listen port of a TCP or UDP service
+//  conns counts the open connections, or UDP sessions, against the limit
+type l4Listener struct {
+	service  string
+	protocol string
+	port     int
+	closer   io.Closer
+	conns    int64
+
+	sessionLock sync.Mutex
+	sessions    map[string]*udpSession
+}
+
+//udpSession datagrams of one client, sent on from a connected socket
+type udpSession struct {
+	upstream net.Conn
+	active   int64
+}
+
+//ProxyL4 serve the listen ports of the TCP and UDP services until ctx is done
+//  listeners follow the registry, a port opens when its service registers
+//  and closes when the last instance is gone
+func ProxyL4(ctx context.Context) {
+	var ds DiscoveryService
+	index := ds.Index()
+	for ctx.Err() == nil {
+		syncListeners()
+		index = ds.Watch(ctx, index)
+	}
+
+	l4Lock.Lock()
+	defer l4Lock.Unlock()
+	for key, l := range l4Listeners {
+		l.close()
+		delete(l4Listeners, key)
+	}
+}
+
+//syncListeners open the listen ports of the L4 services in the registry
+//  and close those no longer used, a port claimed by two services goes to
+//  the first by name
+func syncListeners() {
+	wanted := make(map[string]*l4Listener)
+	registryLock.RLock()
+	names := make([]string, 0, len(serviceMap))
+	for name := range serviceMap {
+		names = append(names, name)
+	}
+	sort.Strings(names)
+	for _, name := range names {
+		for _, in := range serviceMap[name] {
+			if in.Listen == 0 {
+				continue
+			}
+			key := in.Protocol + "/" + strconv.Itoa(in.Listen)
+			if other, ok := wanted[key]; ok {
+				if other.service != name {
+					log.Error("L4 Error: " + key + " of " + name + " is used by " + other.service)
+				}
+				continue
+			}
+			wanted[key] = &l4Listener{service: name, protocol: in.Protocol, port: in.Listen}
+		}
+	}
+	registryLock.RUnlock()
+
+	l4Lock.Lock()
+	defer l4Lock.Unlock()
+	for key, l := range l4Listeners {
+		if w, ok := wanted[key]; !ok || w.service != l.service {
+			l.close()
+			delete(l4Listeners, key)
+		}
+	}
+	for key, l := range wanted {
+		if _, ok := l4Listeners[key]; ok {
+			continue
+		}
+		if err := l.open(); err != nil {
+			log.Error("L4 Error: " + err.Error() + " - " + l.service)
+			continue
+		}
+		l4Listeners[key] = l
+	}
+}
+
+func (l *l4Listener) open() error {
+	addr := ":" + strconv.Itoa(l.port)
+	if l.protocol == ProtocolUDP {
+		pc, err := net.ListenPacket("udp", addr)
+		if err != nil {
+			return err
+		}
+		l.closer = pc
+		l.sessions = make(map[string]*udpSession)
+		go l.serveUDP(pc)
+	} else {
+		ln, err := net.Listen("tcp", addr)
+		if err != nil {
+			return err
+		}
+		l.closer = ln
+		go l.serveTCP(ln)
+	}
+	log.WithFields(log.Fields{"service": l.service, "protocol": l.protocol, "port": l.port}).Info("L4 listener opened")
+	return nil
+}
+
+//close stop accepting connections
+//  open TCP connections run until they end or go idle, UDP sessions end
+func (l *l4Listener) close() {
+	l.closer.Close()
+	l.sessionLock.Lock()
+	for _, s := range l.sessions {
+		s.upstream.Close()
+	}
+	l.sessionLock.Unlock()
+	log.WithFields(log.Fields{"service": l.service, "protocol": l.protocol, "port": l.port}).Info("L4 listener closed")
+}
+
+//acquire take a connection slot under the limit of the route policy
+func (l *l4Listener) acquire(policy RoutePolicy) bool {
+	limit := int64(policy.MaxConnections)
+	if limit == 0 {
+		limit = defaultMaxConnections
+	}
+	if atomic.AddInt64(&l.conns, 1) > limit {
+		atomic.AddInt64(&l.conns, -1)
+		return false
+	}
+	return true
+}
+
+func (l *l4Listener) release() {
+	atomic.AddInt64(&l.conns, -1)
+}
+
+func (l *l4Listener) count(status string) {
+	metrics.Inc(l4Metric, map[string]string{"service": l.service, "protocol": l.protocol, "status": status})
+}
+
+func (l *l4Listener) serveTCP(ln net.Listener) {
+	for {
+		conn, err := ln.Accept()
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Error("L4 Error: " + err.Error() + " - " + l.service)
+			time.Sleep(10 * time.Millisecond)
+			continue
+		}
+		go l.handleTCP(conn)
+	}
+}
+
+//handleTCP proxy a client connection to an instance of the service
+func (l *l4Listener) handleTCP(client net.Conn) {
+	defer client.Close()
+	policy := policyFor(l.service)
+	if !l.acquire(policy) {
+		l.count("rejected")
+		return
+	}
+	defer l.release()
+
+	upstream, err := l.dial(client.RemoteAddr())
+	if err != nil {
+		log.Error("L4 Error: " + err.Error() + " - " + l.service)
+		l.count("error")
+		return
+	}
+	defer upstream.Close()
+	l.count("accepted")
+	pipe(client, upstream, idleTimeout(policy))
+}
+
+//dial connect to the instance picked for a client
+func (l *l4Listener) dial(client net.Addr) (net.Conn, error) {
+	host, _, _ := net.SplitHostPort(client.String())
+	instance, err := l4Instance(l.service, host)
+	if err != nil {
+		return nil, err
+	}
+	target, err := url.Parse(instance.URL)
+	if err != nil {
+		return nil, err
+	}
+	return net.DialTimeout(l.protocol, target.Host, l4DialTimeout)
+}
+
+//pipe copy between two connections until both directions are done
+//  or neither side sent anything for idle
+func pipe(a, b net.Conn, idle time.Duration) {
+	active := time.Now().UnixNano()
+	var wg sync.WaitGroup
+	copyHalf := func(dst, src net.Conn) {
+		defer wg.Done()
+		buf := make([]byte, 32*1024)
+		for {
+			n, err := src.Read(buf)
+			if n > 0 {
+				atomic.StoreInt64(&active, time.Now().UnixNano())
+				if _, err := dst.Write(buf[:n]); err != nil {
+					break
+				}
+			}
+			if err != nil {
+				break
+			}
+		}
+		// the other direction may still be sending
+		if cw, ok := dst.(interface{ CloseWrite() error }); ok {
+			cw.CloseWrite()
+		} else {
+			dst.Close()
+		}
+	}
+	wg.Add(2)
+	go copyHalf(b, a)
+	go copyHalf(a, b)
+
+	done := make(chan struct{})
+	go func() {
+		wg.Wait()
+		close(done)
+	}()
+	timer := time.NewTimer(idle)
+	defer timer.Stop()
+	for {
+		select {
+		case <-done:
+			return
+		case <-timer.C:
+			if remaining := idle - time.Since(time.Unix(0, atomic.LoadInt64(&active))); remaining > 0 {
+				timer.Reset(remaining)
+				continue
+			}
+			a.Close()
+			b.Close()
+			<-done
+			return
+		}
+	}
+}
+
+func (l *l4Listener) serveUDP(pc net.PacketConn) {
+	buf := make([]byte, udpBufferSize)
+	for {
+		n, addr, err := pc.ReadFrom(buf)
+		if errors.Is(err, net.ErrClosed) {
+			return
+		}
+		if err != nil {
+			log.Error("L4 Error: " + err.Error() + " - " + l.service)
+			continue
+		}
+		s := l.session(pc, addr)
+		if s == nil {
+			continue
+		}
+		atomic.StoreInt64(&s.active, time.Now().UnixNano())
+		if _, err := s.upstream.Write(buf[:n]); err != nil {
+			log.Error("L4 Error: " + err.Error() + " - " + l.service)
+		}
+	}
+}
+
+//session UDP session of a client, started on its first datagram
+//  nil when the session limit is reached or no instance can be reached
+func (l *l4Listener) session(pc net.PacketConn, client net.Addr) *udpSession {
+	l.sessionLock.Lock()
+	defer l.sessionLock.Unlock()
+	if s, ok := l.sessions[client.String()]; ok {
+		return s
+	}
+
+	policy := policyFor(l.service)
+	if !l.acquire(policy) {
+		l.count("rejected")
+		return nil
+	}
+	upstream, err := l.dial(client)
+	if err != nil {
+		log.Error("L4 Error: " + err.Error() + " - " + l.service)
+		l.release()
+		l.count("error")
+		return nil
+	}
+
+	s := &udpSession{upstream: upstream, active: time.Now().UnixNano()}
+	l.sessions[client.String()] = s
+	l.count("accepted")
+	go l.replyUDP(pc, client, s, idleTimeout(policy))
+	return s
+}
+
+//replyUDP send the replies of the instance back to the client
+//  until the session is idle for idle
+func (l *l4Listener) replyUDP(pc net.PacketConn, client net.Addr, s *udpSession, idle time.Duration) {
+	defer func() {
+		l.sessionLock.Lock()
+		delete(l.sessions, client.String())
+		l.sessionLock.Unlock()
+		s.upstream.Close()
+		l.release()
+	}()
+
+	buf := make([]byte, udpBufferSize)
+	for {
+		remaining := idle - time.Since(time.Unix(0, atomic.LoadInt64(&s.active)))
+		if remaining <= 0 {
+			return
+		}
+		s.upstream.SetReadDeadline(time.Now().Add(remaining))
+		n, err := s.upstream.Read(buf)
+		if n > 0 {
+			atomic.StoreInt64(&s.active, time.Now().UnixNano())
+			pc.WriteTo(buf[:n], client)
+		}
+		var ne net.Error
+		if err != nil && !(errors.As(err, &ne) && ne.Timeout()) {
+			return
+		}
+	}
+}
+
+//l4Instance pick a healthy instance for a connection
+//  versions are chosen as for HTTP requests, by traffic split with the
+//  client address as sticky key, or by the default version
+func l4Instance(serviceName, client string) (Instance, error) {
+	registryLock.RLock()
+	var healthy []Instance
+	for _, in := range serviceMap[serviceName] {
+		if serviceHealth[instanceKey(serviceName, in.Version)] {
+			healthy = append(healthy, in)
+		}
+	}
+	registryLock.RUnlock()
+	if len(healthy) == 0 {
+		return Instance{}, errors.New("No Healthy Instance")
+	}
+
+	sticky := func(rule SplitRule) string {
+		if rule.sticky() {
+			return client
+		}
+		return ""
+	}
+	if instance, ok := splitBy(serviceName, healthy, sticky); ok {
+		return instance, nil
+	}
+	constraint := policyFor(serviceName).DefaultVersion
+	if constraint == "" {
+		if instance, ok := weightedInstance(healthy); ok {
+			return instance, nil
+		}
+	}
+	return selectVersion(healthy, constraint)
+}
+
+func idleTimeout(policy RoutePolicy) time.Duration {
+	if policy.IdleTimeout > 0 {
+		return policy.IdleTimeout
+	}
+	return defaultIdleTimeout
+}
+
+//checkListen check the listen port of an instance against the registry
+//  versions of a service share their port, which no other service may use
+//  registryLock must be held
+func checkListen(serviceName string, in Instance) error {
+	for name, instances := range serviceMap {
+		for _, existing := range instances {
+			switch {
+			case name == serviceName && (existing.Listen != in.Listen || existing.Protocol != in.Protocol) && (existing.Listen != 0 || in.Listen != 0):
+				return &ValidationError{[]FieldError{{"listen", "must match the other versions of the service"}}}
+			case name != serviceName && in.Listen != 0 && existing.Listen == in.Listen && existing.Protocol == in.Protocol:
+				return &ValidationError{[]FieldError{{"listen", fmt.Sprintf("port %d is used by service %s", in.Listen, name)}}}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,301 @@
+package service
+
+import (
+	"context"
+	"io"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"strconv"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+)
+
+//setupL4 start ProxyL4 on an empty registry, stopped by the returned func
+func setupL4(t *testing.T) func() {
+	setupWatch()
+	routePolicies = make(map[string]RoutePolicy)
+	metrics.Reset()
+
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		ProxyL4(ctx)
+		close(done)
+	}()
+	return func() {
+		cancel()
+		<-done
+	}
+}
+
+func freePort(t *testing.T, network string) int {
+	var addr net.Addr
+	if network == ProtocolUDP {
+		pc, err := net.ListenPacket("udp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = pc.LocalAddr()
+		pc.Close()
+	} else {
+		ln, err := net.Listen("tcp", "127.0.0.1:0")
+		if err != nil {
+			t.Fatal(err)
+		}
+		addr = ln.Addr()
+		ln.Close()
+	}
+	_, port, _ := net.SplitHostPort(addr.String())
+	n, _ := strconv.Atoi(port)
+	return n
+}
+
+//echoTCP upstream writing back what it reads
+func echoTCP(t *testing.T) net.Listener {
+	ln, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	go func() {
+		for {
+			conn, err := ln.Accept()
+			if err != nil {
+				return
+			}
+			go func() {
+				io.Copy(conn, conn)
+				conn.Close()
+			}()
+		}
+	}()
+	return ln
+}
+
+//dialL4 connect to a listen port once the proxy has opened it
+func dialL4(t *testing.T, network string, port int) net.Conn {
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		conn, err := net.Dial(network, "127.0.0.1:"+strconv.Itoa(port))
+		if err == nil || time.Now().After(deadline) {
+			if err != nil {
+				t.Fatal(err)
+			}
+			return conn
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func echo(t *testing.T, conn net.Conn, text string) {
+	conn.SetDeadline(time.Now().Add(2 * time.Second))
+	if _, err := conn.Write([]byte(text)); err != nil {
+		t.Fatal(err)
+	}
+	buf := make([]byte, len(text))
+	if _, err := io.ReadFull(conn, buf); err != nil || string(buf) != text {
+		t.Fatalf("proxy returned wrong reply: got %q %v want %q", buf, err, text)
+	}
+}
+
+func expectClosed(t *testing.T, conn net.Conn, within time.Duration) {
+	conn.SetReadDeadline(time.Now().Add(within))
+	if n, err := conn.Read(make([]byte, 1)); err != io.EOF {
+		t.Errorf("proxy did not close the connection: got %v %v want %v", n, err, io.EOF)
+	}
+}
+
+func TestValidateInstanceL4(t *testing.T) {
+	tests := []struct {
+		in       Instance
+		expected []string
+	}{
+		{Instance{URL: "tcp://cache:6379", Protocol: "TCP", Listen: 6380}, nil},
+		{Instance{URL: "udp://dns:53", Protocol: ProtocolUDP, Listen: 5353}, nil},
+		{Instance{URL: "http://cache:6379", Protocol: ProtocolTCP}, []string{"URL", "listen"}},
+		{Instance{URL: "udp://dns", Protocol: ProtocolUDP, Listen: 70000}, []string{"URL", "listen"}},
+		{Instance{URL: "http://www.test.com", Listen: 8080}, []string{"listen"}},
+	}
+
+	for _, test := range tests {
+		err := validateInstance(test.in)
+		var fields []string
+		if ve, ok := err.(*ValidationError); ok {
+			for _, f := range ve.Fields {
+				fields = append(fields, f.Field)
+			}
+		}
+		if len(fields) != len(test.expected) {
+			t.Errorf("service returned unexpected fields for %v: got %v want %v", test.in, fields, test.expected)
+			continue
+		}
+		for i := range fields {
+			if fields[i] != test.expected[i] {
+				t.Errorf("service returned unexpected fields for %v: got %v want %v", test.in, fields, test.expected)
+				break
+			}
+		}
+	}
+}
+
+func TestRegisterListenConflict(t *testing.T) {
+	setupWatch()
+	healthCheck = func(URL string) bool { return true }
+	var rs RegistrationService
+
+	if err := rs.Register("cache", Instance{URL: "tcp://cache:6379", Version: "v1", Protocol: ProtocolTCP, Listen: 6380}); err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		name     string
+		in       Instance
+		expected string
+	}{
+		{"other", Instance{URL: "tcp://other:6379", Protocol: ProtocolTCP, Listen: 6380}, "Invalid Fields - listen: port 6380 is used by service cache"},
+		{"cache", Instance{URL: "tcp://cache:6379", Version: "v2", Protocol: ProtocolTCP, Listen: 6381}, "Invalid Fields - listen: must match the other versions of the service"},
+		{"cache", Instance{URL: "http://cache", Version: "v2"}, "Invalid Fields - listen: must match the other versions of the service"},
+		{"other", Instance{URL: "udp://other:53", Protocol: ProtocolUDP, Listen: 6380}, ""},
+		{"cache", Instance{URL: "tcp://cache:6379", Version: "v2", Protocol: ProtocolTCP, Listen: 6380}, ""},
+	}
+
+	for _, test := range tests {
+		err := rs.Register(test.name, test.in)
+		if (err == nil && test.expected != "") || (err != nil && err.Error() != test.expected) {
+			t.Errorf("service returned unexpected error for %v: got %v want %v", test.in, err, test.expected)
+		}
+	}
+}
+
+func TestDiscoveryRouteL4(t *testing.T) {
+	setupWatch()
+	healthCheck = func(URL string) bool { return true }
+	var rs RegistrationService
+	var ds DiscoveryService
+	rs.Register("cache", Instance{URL: "tcp://cache:6379", Protocol: ProtocolTCP, Listen: 6380})
+
+	r := httptest.NewRequest(http.MethodGet, "/service/cache/keys", nil)
+	if _, _, err := ds.Route(r); err != ErrUnknownPath {
+		t.Errorf("service returned unexpected error: got %v want %v", err, ErrUnknownPath)
+	}
+}
+
+func TestProxyTCP(t *testing.T) {
+	stop := setupL4(t)
+	defer stop()
+	upstream := echoTCP(t)
+	defer upstream.Close()
+
+	port := freePort(t, ProtocolTCP)
+	policyLock.Lock()
+	routePolicies["cache"] = RoutePolicy{MaxConnections: 1, IdleTimeout: 200 * time.Millisecond}
+	policyLock.Unlock()
+	var rs RegistrationService
+	in := Instance{URL: "tcp://" + upstream.Addr().String(), Protocol: ProtocolTCP, Listen: port}
+	if err := rs.Register("cache", in); err != nil {
+		t.Fatal(err)
+	}
+
+	conn := dialL4(t, "tcp", port)
+	defer conn.Close()
+	echo(t, conn, "PING")
+
+	// over the connection limit
+	extra := dialL4(t, "tcp", port)
+	defer extra.Close()
+	expectClosed(t, extra, time.Second)
+	if count := metrics.Get(l4Metric, map[string]string{"service": "cache", "protocol": "tcp", "status": "rejected"}); count != 1 {
+		t.Errorf("service returned unexpected rejections: got %v want %v", count, 1)
+	}
+
+	// traffic keeps the connection open past the idle timeout
+	for i := 0; i < 3; i++ {
+		time.Sleep(100 * time.Millisecond)
+		echo(t, conn, "PING")
+	}
+	expectClosed(t, conn, time.Second)
+
+	// the port closes with the service
+	rs.Deregister("cache")
+	deadline := time.Now().Add(2 * time.Second)
+	for {
+		c, err := net.Dial("tcp", "127.0.0.1:"+strconv.Itoa(port))
+		if err != nil {
+			break
+		}
+		c.Close()
+		if time.Now().After(deadline) {
+			t.Fatal("proxy did not close the listen port")
+		}
+		time.Sleep(10 * time.Millisecond)
+	}
+}
+
+func TestProxyTCPUnhealthy(t *testing.T) {
+	stop := setupL4(t)
+	defer stop()
+
+	port := freePort(t, ProtocolTCP)
+	registryLock.Lock()
+	serviceMap["cache"] = []Instance{{URL: "tcp://127.0.0.1:1/", Protocol: ProtocolTCP, Listen: port}}
+	serviceHealth[instanceKey("cache", "")] = false
+	publish(Event{Type: EventHealth, Service: "cache"})
+	registryLock.Unlock()
+
+	conn := dialL4(t, "tcp", port)
+	defer conn.Close()
+	expectClosed(t, conn, time.Second)
+	if count := metrics.Get(l4Metric, map[string]string{"service": "cache", "protocol": "tcp", "status": "error"}); count != 1 {
+		t.Errorf("service returned unexpected errors: got %v want %v", count, 1)
+	}
+}
+
+func TestProxyUDP(t *testing.T) {
+	stop := setupL4(t)
+	defer stop()
+
+	upstream, err := net.ListenPacket("udp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer upstream.Close()
+	go func() {
+		buf := make([]byte, udpBufferSize)
+		for {
+			n, addr, err := upstream.ReadFrom(buf)
+			if err != nil {
+				return
+			}
+			upstream.WriteTo(buf[:n], addr)
+		}
+	}()
+
+	port := freePort(t, ProtocolUDP)
+	var rs RegistrationService
+	in := Instance{URL: "udp://" + upstream.LocalAddr().String(), Protocol: ProtocolUDP, Listen: port}
+	if err := rs.Register("dns", in); err != nil {
+		t.Fatal(err)
+	}
+
+	// datagrams sent before the port opens are lost
+	conn := dialL4(t, "udp", port)
+	defer conn.Close()
+	buf := make([]byte, 16)
+	for i := 0; ; i++ {
+		conn.Write([]byte("query"))
+		conn.SetReadDeadline(time.Now().Add(100 * time.Millisecond))
+		n, err := conn.Read(buf)
+		if err == nil {
+			if string(buf[:n]) != "query" {
+				t.Errorf("proxy returned wrong reply: got %q want %q", buf[:n], "query")
+			}
+			break
+		}
+		if i == 20 {
+			t.Fatal("proxy did not reply: " + err.Error())
+		}
+		time.Sleep(50 * time.Millisecond)
+	}
+}
@@ -0,0 +1,58 @@
+package service
+
+import (
+	"fmt"
+	"mime"
+	"net/http"
+	"strings"
+)
+
+//Limits request limits of a route
+//  a zero MaxBodyBytes leaves the gateway limit in place
+//  an empty ContentTypes allows every content type
+type Limits struct {
+	MaxBodyBytes int64
+	ContentTypes []string
+}
+
+//RequestLimits limits of the service a /service/ request is routed to
+func RequestLimits(r *http.Request) Limits {
+	policy := policyFor(requestService(r))
+	return Limits{MaxBodyBytes: policy.MaxBodyBytes, ContentTypes: policy.ContentTypes}
+}
+
+//AllowsContentType check a request Content-Type against the allowlist
+func (l Limits) AllowsContentType(contentType string) bool {
+	if len(l.ContentTypes) == 0 {
+		return true
+	}
+	return matchMediaType(l.ContentTypes, contentType)
+}
+
+func validateLimits(policy RoutePolicy) error {
+	if policy.MaxBodyBytes < 0 {
+		return fmt.Errorf("max_body_bytes: must not be negative")
+	}
+	for _, t := range policy.ContentTypes {
+		if !strings.Contains(t, "/") {
+			return fmt.Errorf("content_types: invalid media type %s", t)
+		}
+	}
+	return nil
+}
+
+//matchMediaType check the media type of contentType against types
+//  types entries may end in /* to match every subtype
+func matchMediaType(types []string, contentType string) bool {
+	mediaType, _, err := mime.ParseMediaType(contentType)
+	if err != nil {
+		return false
+	}
+	for _, t := range types {
+		t = strings.ToLower(t)
+		if t == mediaType || (strings.HasSuffix(t, "/*") && strings.HasPrefix(mediaType, strings.TrimSuffix(t, "*"))) {
+			return true
+		}
+	}
+	return false
+}
@@ -0,0 +1,101 @@
+package service
+
+import (
+	"container/list"
+	"sync"
+)
+
+//LRUCache in-memory CacheStore bounded by the total size of its entries
+//  the least recently used entries are evicted first
+type LRUCache struct {
+	lock     sync.Mutex
+	maxBytes int64
+	size     int64
+	items    map[string]*list.Element
+	order    *list.List
+}
+
+type lruItem struct {
+	key   string
+	entry *CacheEntry
+	size  int64
+}
+
+//NewLRUCache create an LRUCache holding up to maxBytes
+func NewLRUCache(maxBytes int64) *LRUCache {
+	return &LRUCache{
+		maxBytes: maxBytes,
+		items:    make(map[string]*list.Element),
+		order:    list.New(),
+	}
+}
+
+//Get cached entry of key
+func (c *LRUCache) Get(key string) (*CacheEntry, bool) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	el, ok := c.items[key]
+	if !ok {
+		return nil, false
+	}
+	c.order.MoveToFront(el)
+	return el.Value.(*lruItem).entry, true
+}
+
+//Set cache entry under key
+//  entries larger than the cache are not stored
+func (c *LRUCache) Set(key string, entry *CacheEntry) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+
+	c.remove(key)
+	size := int64(len(key)) + entry.size()
+	if size > c.maxBytes {
+		return
+	}
+
+	c.items[key] = c.order.PushFront(&lruItem{key, entry, size})
+	c.size += size
+	c.evict()
+}
+
+//Delete remove the entry of key
+func (c *LRUCache) Delete(key string) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.remove(key)
+}
+
+//Len number of cached entries
+func (c *LRUCache) Len() int {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	return c.order.Len()
+}
+
+//Resize change the size limit, evicting entries that no longer fit
+func (c *LRUCache) Resize(maxBytes int64) {
+	c.lock.Lock()
+	defer c.lock.Unlock()
+	c.maxBytes = maxBytes
+	c.evict()
+}
+
+func (c *LRUCache) remove(key string) {
+	if el, ok := c.items[key]; ok {
+		c.order.Remove(el)
+		delete(c.items, key)
+		c.size -= el.Value.(*lruItem).size
+	}
+}
+
+func (c *LRUCache) evict() {
+	for c.size > c.maxBytes {
+		el := c.order.Back()
+		if el == nil {
+			return
+		}
+		c.remove(el.Value.(*lruItem).key)
+	}
+}
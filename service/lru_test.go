@@ -0,0 +1,41 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestLRUCacheEvict(t *testing.T) {
+	c := NewLRUCache(30)
+	c.Set("a", &CacheEntry{Body: make([]byte, 9)})
+	c.Set("b", &CacheEntry{Body: make([]byte, 9)})
+	c.Set("c", &CacheEntry{Body: make([]byte, 9)})
+
+	// a is used so b is the least recently used
+	if _, ok := c.Get("a"); !ok {
+		t.Fatal("Failed Get")
+	}
+	c.Set("d", &CacheEntry{Body: make([]byte, 9)})
+
+	if _, ok := c.Get("b"); ok {
+		t.Error("Failed to evict least recently used entry")
+	}
+	for _, key := range []string{"a", "c", "d"} {
+		if _, ok := c.Get(key); !ok {
+			t.Errorf("Failed to keep %v", key)
+		}
+	}
+
+	c.Set("e", &CacheEntry{Body: make([]byte, 100)})
+	if _, ok := c.Get("e"); ok {
+		t.Error("Failed to skip entry larger than the cache")
+	}
+
+	c.Resize(10)
+	if c.Len() != 1 {
+		t.Errorf("function kept unexpected entries: got %v want %v", c.Len(), 1)
+	}
+	c.Delete("d")
+	if c.Len() != 0 {
+		t.Errorf("function kept unexpected entries: got %v want %v", c.Len(), 0)
+	}
+}
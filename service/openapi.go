@@ -0,0 +1,411 @@
+package service
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+	yaml "gopkg.in/yaml.v2"
+)
+
+const (
+	operationMetric     = "smug_operation_requests_total"
+	authExtension       = "x-smug-auth"
+	defaultFetchTimeout = 10 * time.Second
+)
+
+// Errors of requests that match no operation of a service's OpenAPI spec
+var (
+	ErrUnknownPath      = errors.New("Unknown Path")
+	ErrMethodNotAllowed = errors.New("Method Not Allowed")
+)
+
+var (
+	specMethods []string
+	fetchSpec   func(specURL string) ([]byte, error)
+	fetchClient clientInterface
+)
+
+func init() {
+	specMethods = []string{"get", "put", "post", "delete", "options", "head", "patch", "trace"}
+	fetchSpec = fetchSpecURL
+	fetchClient = &http.Client{Timeout: defaultFetchTimeout}
+}
+
+//APISpec OpenAPI 3 document of a service
+//  each operation becomes a route of its own with its own metrics, auth
+//  and schemas, requests matching no operation are rejected
+//  the document is accepted as a JSON object or as JSON or YAML text
+type APISpec struct {
+	doc        map[string]interface{}
+	operations []operation
+}
+
+//operation compiled method and path of an OpenAPI operation
+type operation struct {
+	id     string
+	method string
+	path   string
+	re     *regexp.Regexp
+	params int
+	auth   string
+	schema SchemaRule
+}
+
+//UnmarshalJSON parse and compile the document of a registration
+func (spec *APISpec) UnmarshalJSON(data []byte) error {
+	var text string
+	if err := json.Unmarshal(data, &text); err == nil {
+		data = []byte(text)
+	}
+	parsed, err := parseSpec(data)
+	if err != nil {
+		return err
+	}
+	*spec = *parsed
+	return nil
+}
+
+//MarshalJSON write the document as a JSON object
+func (spec APISpec) MarshalJSON() ([]byte, error) {
+	return json.Marshal(spec.doc)
+}
+
+//parseSpec parse and compile an OpenAPI 3 document in JSON or YAML
+func parseSpec(data []byte) (*APISpec, error) {
+	var raw interface{}
+	if err := decodeJSON(data, &raw); err != nil {
+		if err := yaml.Unmarshal(data, &raw); err != nil {
+			return nil, fmt.Errorf("invalid spec: %s", err.Error())
+		}
+	}
+	doc, ok := stringKeys(raw).(map[string]interface{})
+	if !ok {
+		return nil, errors.New("invalid spec: must be an object")
+	}
+	version, _ := doc["openapi"].(string)
+	if !strings.HasPrefix(version, "3.") {
+		return nil, errors.New("invalid spec: openapi version 3 is required")
+	}
+
+	spec := &APISpec{doc: doc}
+	if err := spec.compile(); err != nil {
+		return nil, err
+	}
+	return spec, nil
+}
+
+//compile build the operations of the paths object
+//  literal paths are matched before templated ones
+func (spec *APISpec) compile() error {
+	paths, ok := spec.doc["paths"].(map[string]interface{})
+	if !ok {
+		return errors.New("invalid spec: paths must be an object")
+	}
+	components, _ := spec.doc["components"].(map[string]interface{})
+
+	for path, item := range paths {
+		methods, ok := item.(map[string]interface{})
+		if !ok {
+			return fmt.Errorf("invalid spec: paths %s must be an object", path)
+		}
+		re, err := compilePathTemplate(path)
+		if err != nil {
+			return fmt.Errorf("invalid spec: paths %s %s", path, err.Error())
+		}
+		for _, method := range specMethods {
+			op, ok := methods[method].(map[string]interface{})
+			if !ok {
+				continue
+			}
+			o := operation{
+				method: strings.ToUpper(method),
+				path:   path,
+				re:     re,
+				params: strings.Count(path, "{"),
+				auth:   spec.operationAuth(op),
+			}
+			switch o.auth {
+			case "", AuthAPIKey, AuthSecretKey, AuthPublic:
+			default:
+				return fmt.Errorf("invalid spec: %s %s: unsupported policy %s", method, path, o.auth)
+			}
+			o.id, _ = op["operationId"].(string)
+			if o.id == "" {
+				o.id = o.method + " " + path
+			}
+			if o.schema, err = operationSchemas(op, components); err != nil {
+				return fmt.Errorf("invalid spec: %s %s", o.id, err.Error())
+			}
+			o.schema.Method, o.schema.Path = o.method, path
+			spec.operations = append(spec.operations, o)
+		}
+	}
+
+	sort.SliceStable(spec.operations, func(i, j int) bool {
+		a, b := spec.operations[i], spec.operations[j]
+		if a.params != b.params {
+			return a.params < b.params
+		}
+		return a.path < b.path
+	})
+	return nil
+}
+
+//operationAuth auth policy of an operation, empty for the route default
+//  x-smug-auth names the policy, an empty security list makes it public
+//  and apiKey schemes named api-key or secret-key map to their policy
+func (spec *APISpec) operationAuth(op map[string]interface{}) string {
+	for _, node := range []map[string]interface{}{op, spec.doc} {
+		if auth, ok := node[authExtension].(string); ok {
+			return auth
+		}
+		security, ok := node["security"].([]interface{})
+		if !ok {
+			continue
+		}
+		if len(security) == 0 {
+			return AuthPublic
+		}
+		return spec.securityAuth(security)
+	}
+	return ""
+}
+
+func (spec *APISpec) securityAuth(security []interface{}) string {
+	components, _ := spec.doc["components"].(map[string]interface{})
+	schemes, _ := components["securitySchemes"].(map[string]interface{})
+
+	auth := ""
+	for _, requirement := range security {
+		names, _ := requirement.(map[string]interface{})
+		if len(names) == 0 {
+			// an empty requirement makes the other requirements optional
+			return AuthPublic
+		}
+		for name := range names {
+			scheme, _ := schemes[name].(map[string]interface{})
+			if scheme["type"] != "apiKey" {
+				continue
+			}
+			switch scheme["name"] {
+			case AuthSecretKey:
+				auth = AuthSecretKey
+			case AuthAPIKey:
+				if auth == "" {
+					auth = AuthAPIKey
+				}
+			}
+		}
+	}
+	return auth
+}
+
+//operationSchemas JSON request and response schemas of an operation
+//  the response schema is that of the first 2xx response
+//  schemas keep the components so their references resolve
+func operationSchemas(op, components map[string]interface{}) (SchemaRule, error) {
+	var rule SchemaRule
+	if body, ok := op["requestBody"].(map[string]interface{}); ok {
+		if schema := jsonContentSchema(body); schema != nil {
+			text, err := schemaText(schema, components)
+			if err != nil {
+				return rule, err
+			}
+			rule.Request = text
+			rule.optional = body["required"] != true
+		}
+	}
+
+	responses, _ := op["responses"].(map[string]interface{})
+	codes := make([]string, 0, len(responses))
+	for code := range responses {
+		if strings.HasPrefix(code, "2") {
+			codes = append(codes, code)
+		}
+	}
+	sort.Strings(codes)
+	if len(codes) > 0 {
+		response, _ := responses[codes[0]].(map[string]interface{})
+		if schema := jsonContentSchema(response); schema != nil {
+			text, err := schemaText(schema, components)
+			if err != nil {
+				return rule, err
+			}
+			rule.Response = text
+		}
+	}
+	return rule, nil
+}
+
+//jsonContentSchema schema of the JSON media type of a request body or response
+func jsonContentSchema(node map[string]interface{}) map[string]interface{} {
+	content, _ := node["content"].(map[string]interface{})
+	types := make([]string, 0, len(content))
+	for mediaType := range content {
+		types = append(types, mediaType)
+	}
+	sort.Strings(types)
+	for _, mediaType := range types {
+		if mediaType != "application/json" && !strings.HasSuffix(mediaType, "+json") {
+			continue
+		}
+		media, _ := content[mediaType].(map[string]interface{})
+		if schema, ok := media["schema"].(map[string]interface{}); ok {
+			return schema
+		}
+	}
+	return nil
+}
+
+func schemaText(schema, components map[string]interface{}) (string, error) {
+	root := make(map[string]interface{}, len(schema)+1)
+	for key, val := range schema {
+		root[key] = val
+	}
+	if components != nil {
+		root["components"] = components
+	}
+	j, err := json.Marshal(root)
+	if err != nil {
+		return "", err
+	}
+	if _, err := compileSchema(string(j)); err != nil {
+		return "", err
+	}
+	return string(j), nil
+}
+
+//match find the operation of a request
+//  path is relative to the service and starts with /
+func (spec *APISpec) match(method, path string) (*operation, error) {
+	known := false
+	for i := range spec.operations {
+		op := &spec.operations[i]
+		if !op.re.MatchString(path) {
+			continue
+		}
+		known = true
+		if op.method == method {
+			return op, nil
+		}
+	}
+	if known {
+		return nil, ErrMethodNotAllowed
+	}
+	return nil, ErrUnknownPath
+}
+
+//loadSpec fetch and parse the spec at OpenAPIURL of an instance
+//  relative URLs are resolved against the instance URL
+//  returns *ValidationError when the spec cannot be used
+func loadSpec(in Instance) (Instance, error) {
+	if in.OpenAPI != nil || in.OpenAPIURL == "" {
+		return in, nil
+	}
+
+	specURL, err := url.Parse(in.OpenAPIURL)
+	if err == nil && !specURL.IsAbs() {
+		var base *url.URL
+		if base, err = url.Parse(normalizeInstance(in).URL); err == nil {
+			specURL = base.ResolveReference(specURL)
+		}
+	}
+	if err != nil {
+		return in, &ValidationError{[]FieldError{{"openapi_url", "must be a valid URL"}}}
+	}
+
+	data, err := fetchSpec(specURL.String())
+	if err != nil {
+		return in, &ValidationError{[]FieldError{{"openapi_url", err.Error()}}}
+	}
+	if in.OpenAPI, err = parseSpec(data); err != nil {
+		return in, &ValidationError{[]FieldError{{"openapi_url", err.Error()}}}
+	}
+	return in, nil
+}
+
+//fetchSpecURL GET an OpenAPI document or descriptor set
+//  a slow server fails the fetch after defaultFetchTimeout
+func fetchSpecURL(specURL string) ([]byte, error) {
+	rsp, body, err := request(specURL, http.MethodGet, map[string]string{}, "", fetchClient)
+	if err != nil {
+		return nil, err
+	}
+	if rsp.StatusCode != http.StatusOK {
+		return nil, fmt.Errorf("fetching spec returned status %d", rsp.StatusCode)
+	}
+	return body, nil
+}
+
+//matchOperation operation of a routed request, nil without a spec
+func matchOperation(t target, method string) (*operation, error) {
+	if t.instance.OpenAPI == nil {
+		return nil, nil
+	}
+	return t.instance.OpenAPI.match(method, "/"+t.path)
+}
+
+//operationAuthPolicy auth policy of the operation a request is routed to
+//  the operation is matched in the spec of the version the request resolves
+//  to, a request attached a target by Resolve is routed to the same one
+func operationAuthPolicy(r *http.Request) string {
+	var ds DiscoveryService
+	t, err := ds.resolve(r)
+	if err != nil {
+		return ""
+	}
+	op, err := matchOperation(t, r.Method)
+	if err != nil || op == nil {
+		return ""
+	}
+	return op.auth
+}
+
+//countOperation record the status of a request to an operation
+//  requests that got no response are counted with status error
+func countOperation(t target, status int) {
+	if t.operation == nil {
+		return
+	}
+	label := "error"
+	if status > 0 {
+		label = strconv.Itoa(status)
+	}
+	metrics.Inc(operationMetric, map[string]string{
+		"service":   t.service,
+		"operation": t.operation.id,
+		"status":    label,
+	})
+}
+
+//stringKeys convert the maps decoded from YAML to JSON compatible maps
+func stringKeys(node interface{}) interface{} {
+	switch n := node.(type) {
+	case map[interface{}]interface{}:
+		m := make(map[string]interface{}, len(n))
+		for key, val := range n {
+			m[fmt.Sprint(key)] = stringKeys(val)
+		}
+		return m
+	case map[string]interface{}:
+		for key, val := range n {
+			n[key] = stringKeys(val)
+		}
+		return n
+	case []interface{}:
+		for i, val := range n {
+			n[i] = stringKeys(val)
+		}
+		return n
+	}
+	return node
+}
@@ -0,0 +1,314 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"math/rand"
+	"net/http"
+	"net/http/httptest"
+	"net/url"
+	"testing"
+	"time"
+
+	"github.com/dtan44/SMUG/metrics"
+)
+
+const testSpec = `{
+  "openapi": "3.0.1",
+  "paths": {
+    "/users/{id}": {
+      "get": {"operationId": "getUser", "responses": {"200": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}}}},
+      "put": {
+        "operationId": "putUser",
+        "security": [{"secret": []}],
+        "requestBody": {"required": true, "content": {"application/json": {"schema": {"$ref": "#/components/schemas/User"}}}},
+        "responses": {"204": {}}
+      }
+    },
+    "/users/me": {"get": {"security": []}},
+    "/files/{name}.json": {"get": {"x-smug-auth": "secret-key"}}
+  },
+  "components": {
+    "schemas": {"User": {"type": "object", "required": ["name"], "properties": {"name": {"type": "string", "pattern": "^[a-z]+$"}, "email": {"type": "string", "nullable": true}}}},
+    "securitySchemes": {"secret": {"type": "apiKey", "in": "header", "name": "secret-key"}}
+  }
+}`
+
+const testSpecYAML = `
+openapi: 3.0.0
+paths:
+  /health:
+    get:
+      operationId: health
+`
+
+func setupSpecRoute(t *testing.T) *string {
+	sent := setupVersionRoute()
+	spec, err := parseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+	serviceMap["test"] = []Instance{{URL: "http://v1/", Version: "1.0.0", OpenAPI: spec}}
+	return sent
+}
+
+func TestParseSpec(t *testing.T) {
+	spec, err := parseSpec([]byte(testSpec))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	tests := []struct {
+		method string
+		path   string
+		id     string
+		auth   string
+		err    error
+	}{
+		{"GET", "/users/me", "GET /users/me", AuthPublic, nil},
+		{"GET", "/users/42", "getUser", "", nil},
+		{"PUT", "/users/42", "putUser", AuthSecretKey, nil},
+		{"GET", "/files/a.json", "GET /files/{name}.json", AuthSecretKey, nil},
+		{"DELETE", "/users/42", "", "", ErrMethodNotAllowed},
+		{"GET", "/users/42/posts", "", "", ErrUnknownPath},
+		{"GET", "/files/a.xml", "", "", ErrUnknownPath},
+	}
+
+	for _, test := range tests {
+		op, err := spec.match(test.method, test.path)
+		if err != test.err {
+			t.Errorf("%v %v: got error %v want %v", test.method, test.path, err, test.err)
+			continue
+		}
+		if err == nil && (op.id != test.id || op.auth != test.auth) {
+			t.Errorf("%v %v: got %v %v want %v %v", test.method, test.path, op.id, op.auth, test.id, test.auth)
+		}
+	}
+
+	yamlSpec, err := parseSpec([]byte(testSpecYAML))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if op, err := yamlSpec.match("GET", "/health"); err != nil || op.id != "health" {
+		t.Errorf("function parsed unexpected YAML spec: got %v %v", op, err)
+	}
+}
+
+func TestParseSpecFail(t *testing.T) {
+	for _, data := range []string{
+		`[1, 2]`,
+		`{"openapi": "2.0", "paths": {}}`,
+		`{"openapi": "3.0.0"}`,
+		`{"openapi": "3.0.0", "paths": {"/a/{b": {}}}`,
+		`{"openapi": "3.0.0", "paths": {"/a": {"get": {"x-smug-auth": "none"}}}}`,
+		`{"openapi": "3.0.0", "paths": {"/a": {"post": {"requestBody": {"content": {"application/json": {"schema": {"$ref": "#/components/schemas/A"}}}}}}}}`,
+	} {
+		if _, err := parseSpec([]byte(data)); err == nil {
+			t.Errorf("%v: expected error", data)
+		}
+	}
+}
+
+func TestInstanceSpecJSON(t *testing.T) {
+	var in Instance
+	body := `{"URL":"http://a","openapi":` + testSpec + `}`
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		t.Fatal(err)
+	}
+	if in.OpenAPI == nil || len(in.OpenAPI.operations) != 4 {
+		t.Fatalf("function decoded unexpected spec: got %+v", in.OpenAPI)
+	}
+
+	text, _ := json.Marshal(testSpecYAML)
+	if err := json.Unmarshal([]byte(`{"URL":"http://a","openapi":`+string(text)+`}`), &in); err != nil {
+		t.Fatal(err)
+	}
+	j, err := json.Marshal(in.OpenAPI)
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"openapi":"3.0.0","paths":{"/health":{"get":{"operationId":"health"}}}}`
+	if string(j) != expected {
+		t.Errorf("function encoded unexpected spec: got %v want %v", string(j), expected)
+	}
+}
+
+func TestLoadSpec(t *testing.T) {
+	var fetched string
+	fetchSpec = func(specURL string) ([]byte, error) {
+		fetched = specURL
+		if specURL == "http://host/missing" {
+			return nil, errors.New("not found")
+		}
+		return []byte(testSpecYAML), nil
+	}
+	defer func() { fetchSpec = fetchSpecURL }()
+
+	in, err := loadSpec(Instance{URL: "http://host/api", OpenAPIURL: "openapi.yaml"})
+	if err != nil || in.OpenAPI == nil {
+		t.Fatalf("function returned unexpected result: got %v %v", in.OpenAPI, err)
+	}
+	if fetched != "http://host/api/openapi.yaml" {
+		t.Errorf("function fetched unexpected URL: got %v want %v", fetched, "http://host/api/openapi.yaml")
+	}
+
+	_, err = loadSpec(Instance{URL: "http://host/", OpenAPIURL: "/missing"})
+	if ve, ok := err.(*ValidationError); !ok || ve.Fields[0].Field != "openapi_url" {
+		t.Errorf("function returned unexpected error: got %v", err)
+	}
+}
+
+func TestFetchSpecTimeout(t *testing.T) {
+	request = sendRequest
+	fetchClient = &http.Client{Timeout: 50 * time.Millisecond}
+	defer func() { fetchClient = &http.Client{Timeout: defaultFetchTimeout} }()
+
+	done := make(chan struct{})
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		select {
+		case <-done:
+		case <-time.After(time.Second):
+		}
+	}))
+	defer server.Close()
+	defer close(done)
+
+	start := time.Now()
+	if _, err := fetchSpecURL(server.URL + "/openapi.json"); err == nil {
+		t.Error("Failed fetch timeout")
+	}
+	if elapsed := time.Since(start); elapsed > 500*time.Millisecond {
+		t.Errorf("function returned too late: got %v", elapsed)
+	}
+}
+
+func TestDiscoveryRouteSpec(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		err      error
+		fields   int
+		expected string
+	}{
+		{http.MethodGet, "/users/42", "", nil, 0, "http://v1/users/42"},
+		{http.MethodPut, "/users/42", `{"name":"a","email":null}`, nil, 0, "http://v1/users/42"},
+		{http.MethodPut, "/users/42", `{"name":"A"}`, nil, 1, ""},
+		{http.MethodPut, "/users/42", ``, nil, 1, ""},
+		{http.MethodPost, "/users/42", `{}`, ErrMethodNotAllowed, 0, ""},
+		{http.MethodGet, "/orders", "", ErrUnknownPath, 0, ""},
+	}
+
+	for _, test := range tests {
+		sent := setupSpecRoute(t)
+		var ds DiscoveryService
+
+		req := http.Request{}
+		req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test" + test.path)
+		req.Body = readCloserMock{bytes.NewBufferString(test.body)}
+		req.Method = test.method
+		req.Header = http.Header{}
+
+		_, _, err := ds.Route(&req)
+		fields := 0
+		if ve, ok := err.(*ValidationError); ok {
+			fields = len(ve.Fields)
+		} else if err != test.err {
+			t.Errorf("%v %v: got error %v want %v", test.method, test.path, err, test.err)
+		}
+		if fields != test.fields || *sent != test.expected {
+			t.Errorf("%v %v %q: got %v fields sent %v want %v fields sent %v",
+				test.method, test.path, test.body, fields, *sent, test.fields, test.expected)
+		}
+	}
+}
+
+func TestDiscoveryRouteSpecMetrics(t *testing.T) {
+	setupSpecRoute(t)
+	var ds DiscoveryService
+
+	labels := map[string]string{"service": "test", "operation": "getUser", "status": "200"}
+	before := metrics.Get(operationMetric, labels)
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte(`{"name":"a"}`), nil
+	}
+
+	req := http.Request{}
+	req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test/users/42")
+	req.Body = readCloserMock{bytes.NewBufferString("")}
+	req.Method = http.MethodGet
+	req.Header = http.Header{}
+
+	if _, _, err := ds.Route(&req); err != nil {
+		t.Fatal(err)
+	}
+	if got := metrics.Get(operationMetric, labels); got != before+1 {
+		t.Errorf("service counted unexpected requests: got %v want %v", got, before+1)
+	}
+}
+
+func TestAuthPolicySpec(t *testing.T) {
+	setupSpecRoute(t)
+	serviceMap["test"] = append(serviceMap["test"], Instance{URL: "http://v2/", Version: "2.0.0"})
+
+	tests := []struct {
+		auth     string
+		method   string
+		path     string
+		expected string
+	}{
+		{"", http.MethodGet, "/service/test@1/users/me", AuthAPIKey},
+		{AuthPublic, http.MethodGet, "/service/test@1/users/me", AuthPublic},
+		{AuthPublic, http.MethodGet, "/service/test@1/users/42", AuthPublic},
+		{AuthPublic, http.MethodPut, "/service/test@1/users/42", AuthSecretKey},
+		{"", http.MethodGet, "/service/test@1/files/a.json", AuthSecretKey},
+		{AuthSecretKey, http.MethodGet, "/service/test@1/unknown", AuthSecretKey},
+		// the latest version has no spec
+		{"", http.MethodGet, "/service/test/files/a.json", AuthAPIKey},
+	}
+
+	for _, test := range tests {
+		routePolicies["test"] = RoutePolicy{Auth: test.auth}
+		r, _ := http.NewRequest(test.method, test.path, nil)
+		if got := AuthPolicy(r); got != test.expected {
+			t.Errorf("%v %v %v: got %v want %v", test.auth, test.method, test.path, got, test.expected)
+		}
+	}
+}
+
+func TestAuthPolicyResolved(t *testing.T) {
+	setupSpecRoute(t)
+	metrics.Reset()
+	serviceMap["test"] = append(serviceMap["test"], Instance{URL: "http://v2/", Version: "2.0.0"})
+	splitRules["test"] = SplitRule{Weights: map[string]int{"v1": 50, "v2": 50}}
+	defer delete(splitRules, "test")
+
+	// v1 is picked first, any later split decision picks v2
+	picks := 0
+	randIntn = func(n int) int {
+		picks++
+		if picks == 1 {
+			return 0
+		}
+		return n - 1
+	}
+	defer func() { randIntn = rand.Intn }()
+
+	r, _ := http.NewRequest(http.MethodGet, "/service/test/files/a.json", nil)
+	r = Resolve(r)
+	if got := AuthPolicy(r); got != AuthSecretKey {
+		t.Errorf("function returned unexpected policy: got %v want %v", got, AuthSecretKey)
+	}
+	var ds DiscoveryService
+	if got, _ := ds.resolve(r); got.instance.Version != "1.0.0" {
+		t.Errorf("function returned unexpected version: got %v want %v", got.instance.Version, "1.0.0")
+	}
+
+	labels := map[string]string{"service": "test", "split": "v1", "version": "1.0.0"}
+	if got := metrics.Get(splitMetric, labels); got != 1 || picks != 1 {
+		t.Errorf("split decisions not counted once: got %v want %v", got, 1)
+	}
+}
@@ -0,0 +1,70 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+	"sync"
+)
+
+var (
+	templateCache sync.Map
+	templateParam *regexp.Regexp
+)
+
+func init() {
+	templateParam = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*$`)
+}
+
+//compilePathTemplate regular expression of a templated path such as /users/{id}
+//  each {name} matches within one path segment and is captured as name,
+//  a segment may mix parameters and text such as /files/{name}.json
+//  compiled templates are cached
+func compilePathTemplate(template string) (*regexp.Regexp, error) {
+	if re, ok := templateCache.Load(template); ok {
+		return re.(*regexp.Regexp), nil
+	}
+
+	path := template
+	if !strings.HasPrefix(path, "/") {
+		path = "/" + path
+	}
+
+	var pattern strings.Builder
+	pattern.WriteString("^")
+	for _, segment := range strings.Split(path, "/")[1:] {
+		pattern.WriteString("/")
+		for segment != "" {
+			open := strings.Index(segment, "{")
+			if open < 0 {
+				break
+			}
+			end := strings.Index(segment[open:], "}")
+			if end < 0 {
+				return nil, fmt.Errorf("invalid path segment %s", segment)
+			}
+			name := segment[open+1 : open+end]
+			if !templateParam.MatchString(name) {
+				return nil, fmt.Errorf("invalid path parameter {%s}", name)
+			}
+			if strings.Contains(segment[:open], "}") {
+				return nil, fmt.Errorf("invalid path segment %s", segment)
+			}
+			pattern.WriteString(regexp.QuoteMeta(segment[:open]))
+			pattern.WriteString("(?P<" + name + ">[^/]+?)")
+			segment = segment[open+end+1:]
+		}
+		if strings.Contains(segment, "}") {
+			return nil, fmt.Errorf("invalid path segment %s", segment)
+		}
+		pattern.WriteString(regexp.QuoteMeta(segment))
+	}
+	pattern.WriteString("$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, err
+	}
+	templateCache.Store(template, re)
+	return re, nil
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"errors"
+	"fmt"
+	"net/http"
+	"strings"
+	"sync"
+	"sync/atomic"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+	"github.com/spf13/viper"
+)
+
+const (
+	routesKey = "routes"
+)
+
+// Auth policies of a route
+const (
+	AuthAPIKey    = "api-key"
+	AuthSecretKey = "secret-key"
+	AuthPublic    = "public"
+)
+
+var (
+	registryLoaded int32
+	routePolicies  map[string]RoutePolicy
+	policyLock     sync.RWMutex
+)
+
+func init() {
+	routePolicies = make(map[string]RoutePolicy)
+}
+
+//RoutePolicy per-service settings from the routes config section
+//  Upstreams declares static instances for services that cannot register
+//  Host overrides the Host header sent to the upstream
+//  Cache sends GET requests through the response cache
+//  IdleTimeout and MaxConnections limit the connections of L4 services
+//  CORS allows browsers to call the service from other origins
+type RoutePolicy struct {
+	DefaultVersion string        `mapstructure:"default_version"`
+	Split          *SplitRule    `mapstructure:"split"`
+	Rules          []RoutingRule `mapstructure:"rules"`
+	Upstreams      []Instance    `mapstructure:"upstreams"`
+	StripPrefix    string        `mapstructure:"strip_prefix"`
+	AddPrefix      string        `mapstructure:"add_prefix"`
+	Rewrite        []RewriteRule `mapstructure:"rewrite"`
+	Host           string        `mapstructure:"host"`
+	Transform      Transform     `mapstructure:"transform"`
+	Cache          bool          `mapstructure:"cache"`
+	Compression    Compression   `mapstructure:"compression"`
+	MaxBodyBytes   int64         `mapstructure:"max_body_bytes"`
+	ContentTypes   []string      `mapstructure:"content_types"`
+	Schemas        []SchemaRule  `mapstructure:"schemas"`
+	Timeout        time.Duration `mapstructure:"timeout"`
+	Auth           string        `mapstructure:"auth"`
+	IdleTimeout    time.Duration `mapstructure:"idle_timeout"`
+	MaxConnections int           `mapstructure:"max_connections"`
+	CORS           *CORSPolicy   `mapstructure:"cors"`
+}
+
+//LoadPolicies read per-service policies from the routes config section
+//  static upstreams are synced into the registry
+func LoadPolicies() error {
+	apply, err := PreparePolicies(viper.GetViper())
+	if err != nil {
+		return err
+	}
+	apply()
+	return nil
+}
+
+//PreparePolicies read and validate the routes section of a candidate config
+func PreparePolicies(v *viper.Viper) (func(), error) {
+	policies := make(map[string]RoutePolicy)
+	if err := v.UnmarshalKey(routesKey, &policies); err != nil {
+		return nil, err
+	}
+	for name, policy := range policies {
+		if err := validatePolicy(&policy); err != nil {
+			return nil, fmt.Errorf("routes.%s %s", name, err.Error())
+		}
+		if err := loadUpstreams(policy.Upstreams); err != nil {
+			return nil, fmt.Errorf("routes.%s %s", name, err.Error())
+		}
+		policies[name] = policy
+	}
+
+	registryLock.RLock()
+	err := checkStatic(policies)
+	registryLock.RUnlock()
+	if err != nil {
+		return nil, err
+	}
+
+	// registrations wait for the reload to finish, see RegisterLock,
+	// so the check still holds when the routes apply
+	return func() {
+		if err := syncStatic(policies); err != nil {
+			log.Error("PreparePolicies Error: " + err.Error())
+			return
+		}
+
+		policyLock.Lock()
+		routePolicies = policies
+		policyLock.Unlock()
+		atomic.StoreInt32(&registryLoaded, 1)
+	}, nil
+}
+
+//Ready check that the registry has loaded its static routes
+func Ready() error {
+	if atomic.LoadInt32(&registryLoaded) == 0 {
+		return errors.New("not loaded")
+	}
+	return nil
+}
+
+//validatePolicy check and compile a policy
+func validatePolicy(policy *RoutePolicy) error {
+	if err := compileRules(policy.Rules); err != nil {
+		return fmt.Errorf("rules %s", err.Error())
+	}
+
+	if err := compileRewrites(policy.Rewrite); err != nil {
+		return err
+	}
+
+	if err := compileTransform(&policy.Transform); err != nil {
+		return fmt.Errorf("transform %s", err.Error())
+	}
+
+	if err := validateCompression(policy.Compression); err != nil {
+		return fmt.Errorf("compression %s", err.Error())
+	}
+
+	if err := validateLimits(*policy); err != nil {
+		return err
+	}
+
+	if err := validateSchemaRules(policy.Schemas); err != nil {
+		return fmt.Errorf("schemas %s", err.Error())
+	}
+
+	if policy.Split != nil {
+		if err := validateSplit(*policy.Split); err != nil {
+			return fmt.Errorf("split %s", err.Error())
+		}
+	}
+
+	if policy.CORS != nil {
+		if err := compileCORS(policy.CORS); err != nil {
+			return fmt.Errorf("cors %s", err.Error())
+		}
+	}
+
+	switch policy.Auth {
+	case "", AuthAPIKey, AuthSecretKey, AuthPublic:
+	default:
+		return fmt.Errorf("auth: unsupported policy %s", policy.Auth)
+	}
+
+	if policy.Timeout < 0 {
+		return fmt.Errorf("timeout: must not be negative")
+	}
+	if policy.IdleTimeout < 0 {
+		return fmt.Errorf("idle_timeout: must not be negative")
+	}
+	if policy.MaxConnections < 0 {
+		return fmt.Errorf("max_connections: must not be negative")
+	}
+
+	versions := make(map[string]bool)
+	for i, upstream := range policy.Upstreams {
+		if upstream.URL == "" {
+			return fmt.Errorf("upstreams %d: URL is required", i)
+		}
+		if err := validateInstance(upstream); err != nil {
+			return fmt.Errorf("upstreams %d: %s", i, err.Error())
+		}
+		if versions[versionKey(upstream.Version)] {
+			return fmt.Errorf("upstreams %d: duplicate version %s", i, upstream.Version)
+		}
+		versions[versionKey(upstream.Version)] = true
+	}
+	return nil
+}
+
+//loadUpstreams fetch the OpenAPI specs and descriptor sets of static upstreams
+//  each fetch is bounded by defaultFetchTimeout
+func loadUpstreams(upstreams []Instance) error {
+	for i, upstream := range upstreams {
+		upstream, err := loadSpec(upstream)
+		if err == nil {
+			upstream, err = loadDescriptorSet(upstream)
+		}
+		if err != nil {
+			return fmt.Errorf("upstreams %d: %s", i, err.Error())
+		}
+		upstreams[i] = upstream
+	}
+	return nil
+}
+
+//policyFor policy of serviceName, zero value if none is configured
+func policyFor(serviceName string) RoutePolicy {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+	return routePolicies[serviceName]
+}
+
+//AuthPolicy auth policy of the service a /service/ request is routed to
+//  a matching operation of the OpenAPI spec of the version the request
+//  resolves to may tighten the configured policy, never loosen it
+func AuthPolicy(r *http.Request) string {
+	auth := policyFor(requestService(r)).Auth
+	if auth == "" {
+		auth = AuthAPIKey
+	}
+	return stricterAuth(auth, operationAuthPolicy(r))
+}
+
+//stricterAuth the stricter of two auth policies, an empty policy is ignored
+func stricterAuth(a, b string) string {
+	if authRank(b) > authRank(a) {
+		return b
+	}
+	return a
+}
+
+//authRank order auth policies from public to secret-key, which needs the
+//  api-key as well
+func authRank(auth string) int {
+	switch auth {
+	case AuthPublic:
+		return 1
+	case AuthAPIKey:
+		return 2
+	case AuthSecretKey:
+		return 3
+	}
+	return 0
+}
+
+//requestService name of the service a /service/ request is routed to
+func requestService(r *http.Request) string {
+	path := strings.TrimPrefix(r.URL.Path, servicePath)
+	serviceName, _, _ := splitVersion(strings.SplitN(path, "/", 2)[0])
+	return serviceName
+}
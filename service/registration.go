@@ -2,7 +2,11 @@ package service
 
 import (
 	"errors"
+	"net"
 	"net/http"
+	"net/url"
+	"sync"
+	"time"
 )
 
 const (
@@ -12,22 +16,29 @@ const (
 
 //TODO: Replace temporary cache with database
 var (
-	serviceMap  map[string]string
-	healthCheck func(URL string) bool
-	request     func(url, httpMethod string,
+	serviceMap    map[string][]Instance
+	serviceHealth map[string]bool
+	registryLock  sync.RWMutex
+	healthCheck   func(URL string) bool
+	request       func(url, httpMethod string,
 		headers map[string]string, body string,
 		client clientInterface) (*http.Response, []byte, error)
 )
 
+//RegisterLock held while an instance is registered
+//  a config reload holds it so static routes checked in prepare still apply
+var RegisterLock sync.Mutex
+
 func init() {
-	serviceMap = make(map[string]string)
+	serviceMap = make(map[string][]Instance)
+	serviceHealth = make(map[string]bool)
 	healthCheck = healthCheckURL
 	request = sendRequest
 }
 
 //RegistrationInterface defines service methods
 type RegistrationInterface interface {
-	Register(serviceName, serviceURL string) error
+	Register(serviceName string, instance Instance) error
 	Deregister(serviceName string) error
 }
 
@@ -41,34 +52,129 @@ type RegistrationService struct {
 }
 
 //Register perform register service
-//  serviceName must be unique
-//  serviceURL must pass health check
-func (rs RegistrationService) Register(serviceName, serviceURL string) error {
-	if !healthCheck(serviceURL) {
+//  serviceName and instance version must be unique
+//  instance fields must be valid
+//  an OpenAPI spec or descriptor set URL is fetched and must parse
+//  instance URL must pass health check
+func (rs RegistrationService) Register(serviceName string, instance Instance) error {
+	if err := validateInstance(instance); err != nil {
+		return err
+	}
+	instance, err := loadSpec(instance)
+	if err != nil {
+		return err
+	}
+	if instance, err = loadDescriptorSet(instance); err != nil {
+		return err
+	}
+
+	if !healthCheck(instance.URL) {
 		return errors.New("URL Health Check Failed")
 	}
 
-	if _, ok := serviceMap[serviceName]; !ok {
-		if string(serviceURL[len(serviceURL)-1]) != "/" {
-			serviceURL += "/"
+	RegisterLock.Lock()
+	defer RegisterLock.Unlock()
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	for _, existing := range serviceMap[serviceName] {
+		if sameVersion(existing.Version, instance.Version) {
+			return errors.New("Service Name already Exist")
 		}
-		serviceMap[serviceName] = serviceURL
-		return nil
 	}
 
-	return errors.New("Service Name already Exist")
+	instance = normalizeInstance(instance)
+	if err := checkListen(serviceName, instance); err != nil {
+		return err
+	}
+	instance.Static = false
+	serviceMap[serviceName] = append(serviceMap[serviceName], instance)
+	serviceHealth[instanceKey(serviceName, instance.Version)] = true
+	publish(Event{Type: EventRegister, Service: serviceName, Version: instance.Version, URL: instance.URL})
+	return nil
 }
 
 //Deregister perform deregister service
 //  serviceName must exist
+//  static services from the routes config cannot be deregistered
+//  serviceName@version removes only that version
 func (rs RegistrationService) Deregister(serviceName string) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
 
-	if _, ok := serviceMap[serviceName]; ok {
-		delete(serviceMap, serviceName)
-		return nil
+	name, version, versioned := splitVersion(serviceName)
+	instances, ok := serviceMap[name]
+	if !ok {
+		return errors.New("Service Name does not Exist")
 	}
 
-	return errors.New("Service Name does not Exist")
+	for _, instance := range instances {
+		if instance.Static && (!versioned || sameVersion(instance.Version, version)) {
+			return errors.New("Service is Static")
+		}
+	}
+
+	remaining := make([]Instance, 0, len(instances))
+	for _, instance := range instances {
+		if versioned && !sameVersion(instance.Version, version) {
+			remaining = append(remaining, instance)
+			continue
+		}
+		delete(serviceHealth, instanceKey(name, instance.Version))
+		publish(Event{Type: EventDeregister, Service: name, Version: instance.Version, URL: instance.URL})
+	}
+
+	if len(remaining) == len(instances) {
+		return errors.New("Service Version does not Exist")
+	}
+	if len(remaining) == 0 {
+		delete(serviceMap, name)
+		clearSplit(name)
+	} else {
+		serviceMap[name] = remaining
+	}
+	return nil
+}
+
+//CheckHealth run the health check against every registered service
+//  a change in health publishes a health event
+func (rs RegistrationService) CheckHealth() {
+	registryLock.RLock()
+	var events []Event
+	for name, instances := range serviceMap {
+		for _, instance := range instances {
+			events = append(events, Event{Service: name, Version: instance.Version, URL: instance.URL})
+		}
+	}
+	registryLock.RUnlock()
+
+	// health checks are run without holding the lock since they may be slow
+	for _, e := range events {
+		healthy := healthCheck(e.URL)
+		key := instanceKey(e.Service, e.Version)
+
+		registryLock.Lock()
+		if previous, ok := serviceHealth[key]; ok && previous != healthy {
+			serviceHealth[key] = healthy
+			e.Type = EventHealth
+			e.Healthy = &healthy
+			publish(e)
+		}
+		registryLock.Unlock()
+	}
+}
+
+//MonitorHealth periodically check the health of registered services
+func MonitorHealth(interval time.Duration) {
+	var rs RegistrationService
+	for range time.Tick(interval) {
+		rs.CheckHealth()
+	}
+}
+
+//instanceKey identify a single registered version of a service
+func instanceKey(serviceName, version string) string {
+	return serviceName + "@" + version
 }
 
 // func mapToString(data map[string]string) string {
@@ -86,6 +192,16 @@ func healthCheckURL(URL string) bool {
 		return false
 	}
 
+	// L4 services are healthy while they accept connections
+	if u, err := url.Parse(URL); err == nil && u.Scheme == ProtocolTCP {
+		conn, err := net.DialTimeout("tcp", u.Host, l4DialTimeout)
+		if err != nil {
+			return false
+		}
+		conn.Close()
+		return true
+	}
+
 	// initialize request
 	// healthcheckURL := URL + healthCheckPath
 	// headers := make(map[string]string)
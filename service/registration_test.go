@@ -6,7 +6,7 @@ import (
 )
 
 func setupServiceRegister() {
-	serviceMap = make(map[string]string)
+	serviceMap = make(map[string][]Instance)
 	healthCheck = healthCheckURL
 	request = func(url, httpMethod string,
 		headers map[string]string, body string,
@@ -23,9 +23,10 @@ func TestRegisterURLFail(t *testing.T) {
 	var rs RegistrationService
 
 	errorText := "URL Health Check Failed"
+	healthCheck = func(URL string) bool { return false }
 
-	// invalid URL
-	err := rs.Register("", "")
+	// unhealthy URL
+	err := rs.Register("", Instance{URL: "http://test"})
 	if err.Error() != errorText {
 		t.Errorf("service returned unexpected error: got %v want %v",
 			err.Error(), errorText)
@@ -36,23 +37,38 @@ func TestRegisterMapFail(t *testing.T) {
 	setupServiceRegister()
 	var rs RegistrationService
 
-	serviceMap = make(map[string]string)
-	serviceMap["test"] = "test"
+	serviceMap = make(map[string][]Instance)
+	serviceMap["test"] = []Instance{{URL: "test"}}
 	errorText := "Service Name already Exist"
 
-	err := rs.Register("test", "test")
+	err := rs.Register("test", Instance{URL: "http://test"})
 	if err.Error() != errorText {
 		t.Errorf("service returned unexpected error: got %v want %v",
 			err.Error(), errorText)
 	}
 }
 
+func TestRegisterSameVersion(t *testing.T) {
+	setupServiceRegister()
+	var rs RegistrationService
+
+	serviceMap = make(map[string][]Instance)
+	serviceMap["test"] = []Instance{{URL: "test", Version: "v1.0.0"}}
+	errorText := "Service Name already Exist"
+
+	err := rs.Register("test", Instance{URL: "http://test", Version: "1.0.0"})
+	if err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			err, errorText)
+	}
+}
+
 func TestRegisterSuccess(t *testing.T) {
 	setupServiceRegister()
 	var rs RegistrationService
 
 	// valid URL
-	err := rs.Register("test", "test")
+	err := rs.Register("test", Instance{URL: "http://test"})
 	if err != nil {
 		t.Errorf("Failed to register service")
 	}
@@ -75,10 +91,89 @@ func TestDeregisterSuccess(t *testing.T) {
 	setupServiceRegister()
 	var rs RegistrationService
 
-	serviceMap["test"] = "test"
+	serviceMap["test"] = []Instance{{URL: "test"}}
 
 	err := rs.Deregister("test")
 	if err != nil {
 		t.Errorf("Failed to register service")
 	}
 }
+
+func TestRegisterValidationFail(t *testing.T) {
+	setupServiceRegister()
+	var rs RegistrationService
+
+	err := rs.Register("test", Instance{URL: "http://test", Weight: -1})
+	if _, ok := err.(*ValidationError); !ok {
+		t.Errorf("service returned unexpected error: got %v want %T",
+			err, &ValidationError{})
+	}
+}
+
+func TestRegisterMetadataSuccess(t *testing.T) {
+	setupServiceRegister()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	err := rs.Register("test", Instance{URL: "http://test", Version: "1.0.0",
+		Tags: []string{"staging"}, Protocol: "HTTP"})
+	if err != nil {
+		t.Fatalf("Failed to register service")
+	}
+
+	in := ds.Services()["test"][0]
+	if in.URL != "http://test/" || in.Version != "1.0.0" || !in.HasTag("staging") || in.Protocol != "http" {
+		t.Errorf("service returned unexpected instance: got %+v", in)
+	}
+}
+
+func TestRegisterVersions(t *testing.T) {
+	setupServiceRegister()
+	var rs RegistrationService
+
+	if err := rs.Register("test", Instance{URL: "http://v1", Version: "1.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+	if err := rs.Register("test", Instance{URL: "http://v2", Version: "2.0.0"}); err != nil {
+		t.Fatal(err)
+	}
+
+	errorText := "Service Name already Exist"
+	err := rs.Register("test", Instance{URL: "http://v2", Version: "2.0.0"})
+	if err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			err, errorText)
+	}
+	if len(serviceMap["test"]) != 2 {
+		t.Errorf("service registered unexpected versions: got %v want %v",
+			len(serviceMap["test"]), 2)
+	}
+}
+
+func TestDeregisterVersion(t *testing.T) {
+	setupServiceRegister()
+	var rs RegistrationService
+
+	serviceMap["test"] = []Instance{{URL: "v1", Version: "1.0.0"}, {URL: "v2", Version: "2.0.0"}}
+
+	errorText := "Service Version does not Exist"
+	err := rs.Deregister("test@3.0.0")
+	if err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v",
+			err, errorText)
+	}
+
+	if err := rs.Deregister("test@1.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if len(serviceMap["test"]) != 1 || serviceMap["test"][0].Version != "2.0.0" {
+		t.Errorf("service returned unexpected versions: got %v", serviceMap["test"])
+	}
+
+	if err := rs.Deregister("test@2.0.0"); err != nil {
+		t.Fatal(err)
+	}
+	if _, ok := serviceMap["test"]; ok {
+		t.Error("Failed to remove service without versions")
+	}
+}
@@ -54,6 +54,11 @@ func sendRequest(url, httpMethod string, headers map[string]string,
 
 	if headers != nil {
 		for key, val := range headers {
+			// the Host header is only sent through req.Host
+			if http.CanonicalHeaderKey(key) == "Host" {
+				req.Host = val
+				continue
+			}
 			req.Header.Set(key, val)
 		}
 	}
@@ -120,3 +120,23 @@ func TestSendRequestSuccess(t *testing.T) {
 		t.Fail()
 	}
 }
+
+// Test Host header override
+type clientHost struct {
+	host *string
+}
+
+func (c clientHost) Do(req *http.Request) (*http.Response, error) {
+	*c.host = req.Host
+	return clientStatusSuccess{}.Do(req)
+}
+
+func TestSendRequestHost(t *testing.T) {
+	setup()
+	var host string
+
+	sendRequest("http://upstream/", "GET", map[string]string{"Host": "legacy.internal"}, "", clientHost{&host})
+	if host != "legacy.internal" {
+		t.Errorf("request sent unexpected host: got %v want %v", host, "legacy.internal")
+	}
+}
@@ -0,0 +1,87 @@
+package service
+
+import (
+	"fmt"
+	"regexp"
+	"strings"
+)
+
+//RewriteRule replace the upstream path when it matches a regular expression
+//  Replace may reference capture groups as $1 or ${name}, and may add a
+//  query which the query of the request is merged into
+type RewriteRule struct {
+	Match   string `mapstructure:"match"`
+	Replace string `mapstructure:"replace"`
+
+	re *regexp.Regexp
+}
+
+//compileRewrites validate the rewrite rules of a policy
+func compileRewrites(rules []RewriteRule) error {
+	for i := range rules {
+		if rules[i].Match == "" {
+			return fmt.Errorf("rewrite %d: match is required", i)
+		}
+		re, err := regexp.Compile(rules[i].Match)
+		if err != nil {
+			return fmt.Errorf("rewrite %d: %s", i, err.Error())
+		}
+		rules[i].re = re
+	}
+	return nil
+}
+
+//rewritePath apply the rewrites of a policy to the path after the service name
+//  path is escaped and the result stays escaped
+//  StripPrefix only removes whole path segments, then the first matching
+//  Rewrite rule is applied to the path with its leading slash, then AddPrefix
+func (policy RoutePolicy) rewritePath(path string) string {
+	path = policy.stripPrefix("/" + path)
+
+	for _, rule := range policy.Rewrite {
+		if rule.re == nil || !rule.re.MatchString(path) {
+			continue
+		}
+		path = rule.re.ReplaceAllString(path, rule.Replace)
+		if !strings.HasPrefix(path, "/") {
+			path = "/" + path
+		}
+		break
+	}
+
+	if prefix := strings.TrimSuffix(policy.AddPrefix, "/"); prefix != "" {
+		if !strings.HasPrefix(prefix, "/") {
+			prefix = "/" + prefix
+		}
+		path = prefix + path
+	}
+
+	return strings.TrimPrefix(path, "/")
+}
+
+//upstreamURL URL of an instance for the escaped path after the service name
+//  the rewritten path keeps a query added by a rewrite, the query of the
+//  request is appended to it
+func (policy RoutePolicy) upstreamURL(instanceURL, path, rawQuery string) string {
+	serviceURL := instanceURL + policy.rewritePath(path)
+	switch {
+	case rawQuery == "":
+	case strings.Contains(serviceURL, "?"):
+		serviceURL += "&" + rawQuery
+	default:
+		serviceURL += "?" + rawQuery
+	}
+	return serviceURL
+}
+
+//stripPrefix remove StripPrefix from a path with its leading slash
+func (policy RoutePolicy) stripPrefix(path string) string {
+	if prefix := strings.TrimSuffix(policy.StripPrefix, "/"); prefix != "" {
+		if path == prefix {
+			return "/"
+		} else if strings.HasPrefix(path, prefix+"/") {
+			return strings.TrimPrefix(path, prefix)
+		}
+	}
+	return path
+}
@@ -0,0 +1,108 @@
+package service
+
+import (
+	"bytes"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func rewritePolicy(policy RoutePolicy) RoutePolicy {
+	if err := compileRewrites(policy.Rewrite); err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func TestRewritePath(t *testing.T) {
+	tests := []struct {
+		policy   RoutePolicy
+		path     string
+		expected string
+	}{
+		{RoutePolicy{}, "orders/1", "orders/1"},
+		{RoutePolicy{StripPrefix: "/api"}, "api/orders/1", "orders/1"},
+		{RoutePolicy{StripPrefix: "/api/"}, "api", ""},
+		{RoutePolicy{StripPrefix: "/api"}, "apiary/1", "apiary/1"},
+		{RoutePolicy{AddPrefix: "v1"}, "orders", "v1/orders"},
+		{RoutePolicy{StripPrefix: "/api", AddPrefix: "/legacy/"}, "api/orders", "legacy/orders"},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/orders/(\d+)$`, Replace: "/order?id=$1"}}}, "orders/42", "order?id=42"},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/users/(?P<id>[^/]+)/posts`, Replace: "/posts/by/${id}"}}}, "users/a%2Fb/posts/1", "posts/by/a%2Fb/1"},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/a`, Replace: "/first"}, {Match: `^/a`, Replace: "/second"}}}, "a/b", "first/b"},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/x`, Replace: "y"}}}, "x/1", "y/1"},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/nomatch`, Replace: "/y"}}}, "x/1", "x/1"},
+		{RoutePolicy{StripPrefix: "/api", AddPrefix: "/v2", Rewrite: []RewriteRule{{Match: `^/item/(\w+)`, Replace: "/items/$1"}}}, "api/item/abc", "v2/items/abc"},
+	}
+
+	for _, test := range tests {
+		policy := rewritePolicy(test.policy)
+		if got := policy.rewritePath(test.path); got != test.expected {
+			t.Errorf("%+v rewrite %v: got %v want %v",
+				test.policy, test.path, got, test.expected)
+		}
+	}
+}
+
+func TestCompileRewritesFail(t *testing.T) {
+	tests := [][]RewriteRule{
+		{{Replace: "/a"}},
+		{{Match: "(", Replace: "/a"}},
+	}
+
+	for i, rules := range tests {
+		if err := compileRewrites(rules); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+//TestDiscoveryRouteRewrite golden table of routed request URLs and upstream URLs
+func TestDiscoveryRouteRewrite(t *testing.T) {
+	tests := []struct {
+		policy   RoutePolicy
+		request  string
+		expected string
+		host     string
+	}{
+		{RoutePolicy{}, "/service/test@1/check", "http://v1/check", ""},
+		{RoutePolicy{}, "/service/test@1/check?a=1&b=two%20words", "http://v1/check?a=1&b=two%20words", ""},
+		{RoutePolicy{}, "/service/test@1/files/a%2Fb.txt", "http://v1/files/a%2Fb.txt", ""},
+		{RoutePolicy{}, "/service/test@1/", "http://v1/", ""},
+		{RoutePolicy{}, "/service/test@1?q=1", "http://v1/?q=1", ""},
+		{RoutePolicy{StripPrefix: "/api"}, "/service/test@1/api/check?x=y", "http://v1/check?x=y", ""},
+		{RoutePolicy{AddPrefix: "/v1"}, "/service/test@1/check", "http://v1/v1/check", ""},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/orders/(\d+)$`, Replace: "/legacy/order/$1"}}}, "/service/test@1/orders/7?full=1", "http://v1/legacy/order/7?full=1", ""},
+		{RoutePolicy{Rewrite: []RewriteRule{{Match: `^/orders/(\d+)$`, Replace: "/order?id=$1"}}}, "/service/test@1/orders/42?full=1", "http://v1/order?id=42&full=1", ""},
+		{RoutePolicy{Host: "legacy.internal"}, "/service/test@1/check", "http://v1/check", "legacy.internal"},
+	}
+
+	for _, test := range tests {
+		setupVersionRoute()
+		routePolicies["test"] = rewritePolicy(test.policy)
+		var ds DiscoveryService
+
+		var sent, host string
+		request = func(url, httpMethod string,
+			headers map[string]string, body string,
+			client clientInterface) (*http.Response, []byte, error) {
+			sent, host = url, headers["Host"]
+			return &http.Response{Header: http.Header{}}, nil, nil
+		}
+
+		req := http.Request{}
+		req.URL, _ = url.ParseRequestURI("http://www.test.com" + test.request)
+		req.Body = readCloserMock{bytes.NewBufferString("")}
+		req.Method = http.MethodGet
+		req.Header = http.Header{}
+
+		if _, _, err := ds.Route(&req); err != nil {
+			t.Fatalf("%v: %v", test.request, err)
+		}
+		if sent != test.expected {
+			t.Errorf("%v: got %v want %v", test.request, sent, test.expected)
+		}
+		if host != test.host {
+			t.Errorf("%v host: got %v want %v", test.request, host, test.host)
+		}
+	}
+}
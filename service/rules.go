@@ -0,0 +1,203 @@
+package service
+
+import (
+	"fmt"
+	"net"
+	"net/http"
+	"regexp"
+	"sort"
+
+	log "github.com/sirupsen/logrus"
+)
+
+//RoutingRule send matching requests to an upstream group
+//  header, cookie, query and path values are regular expressions,
+//  an empty value only requires the header, cookie or param to be present
+//  Path is matched against the escaped path after the service name, as
+//  rewrites are
+//  rules are evaluated in ascending priority and the first match wins
+type RoutingRule struct {
+	Name     string            `mapstructure:"name"`
+	Priority int               `mapstructure:"priority"`
+	Methods  []string          `mapstructure:"methods"`
+	Headers  map[string]string `mapstructure:"headers"`
+	Cookies  map[string]string `mapstructure:"cookies"`
+	Query    map[string]string `mapstructure:"query"`
+	CIDRs    []string          `mapstructure:"cidrs"`
+	Path     string            `mapstructure:"path"`
+	Group    string            `mapstructure:"group"`
+
+	headers map[string]*regexp.Regexp
+	cookies map[string]*regexp.Regexp
+	query   map[string]*regexp.Regexp
+	nets    []*net.IPNet
+	path    *regexp.Regexp
+}
+
+//compileRules validate and order the routing rules of a policy
+func compileRules(rules []RoutingRule) error {
+	for i := range rules {
+		if err := rules[i].compile(); err != nil {
+			return fmt.Errorf("rule %d: %s", i, err.Error())
+		}
+	}
+	sort.SliceStable(rules, func(i, j int) bool {
+		return rules[i].Priority < rules[j].Priority
+	})
+	return nil
+}
+
+func (rule *RoutingRule) compile() error {
+	if rule.Group == "" {
+		return fmt.Errorf("group is required")
+	}
+
+	var err error
+	if rule.headers, err = compileMatchers(rule.Headers); err != nil {
+		return err
+	}
+	if rule.cookies, err = compileMatchers(rule.Cookies); err != nil {
+		return err
+	}
+	if rule.query, err = compileMatchers(rule.Query); err != nil {
+		return err
+	}
+
+	rule.nets = nil
+	for _, cidr := range rule.CIDRs {
+		_, n, err := net.ParseCIDR(cidr)
+		if err != nil {
+			return err
+		}
+		rule.nets = append(rule.nets, n)
+	}
+
+	rule.path = nil
+	if rule.Path != "" {
+		if rule.path, err = regexp.Compile(rule.Path); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+func compileMatchers(values map[string]string) (map[string]*regexp.Regexp, error) {
+	matchers := make(map[string]*regexp.Regexp, len(values))
+	for key, value := range values {
+		if value == "" {
+			matchers[key] = nil
+			continue
+		}
+		re, err := regexp.Compile(value)
+		if err != nil {
+			return nil, err
+		}
+		matchers[key] = re
+	}
+	return matchers, nil
+}
+
+//matches check every condition of the rule against the request
+//  path is the escaped request path after the service name
+func (rule RoutingRule) matches(r *http.Request, path string) bool {
+	if len(rule.Methods) > 0 {
+		allowed := false
+		for _, m := range rule.Methods {
+			if m == r.Method {
+				allowed = true
+				break
+			}
+		}
+		if !allowed {
+			return false
+		}
+	}
+
+	for key, re := range rule.headers {
+		vals, ok := r.Header[http.CanonicalHeaderKey(key)]
+		if !ok || !matchAny(re, vals) {
+			return false
+		}
+	}
+
+	for key, re := range rule.cookies {
+		c, err := r.Cookie(key)
+		if err != nil || !matchAny(re, []string{c.Value}) {
+			return false
+		}
+	}
+
+	query := r.URL.Query()
+	for key, re := range rule.query {
+		vals, ok := query[key]
+		if !ok || !matchAny(re, vals) {
+			return false
+		}
+	}
+
+	if len(rule.nets) > 0 {
+		host, _, err := net.SplitHostPort(r.RemoteAddr)
+		if err != nil {
+			host = r.RemoteAddr
+		}
+		ip := net.ParseIP(host)
+		inside := false
+		for _, n := range rule.nets {
+			if ip != nil && n.Contains(ip) {
+				inside = true
+				break
+			}
+		}
+		if !inside {
+			return false
+		}
+	}
+
+	if rule.path != nil && !rule.path.MatchString(path) {
+		return false
+	}
+	return true
+}
+
+func matchAny(re *regexp.Regexp, vals []string) bool {
+	if re == nil {
+		return true
+	}
+	for _, v := range vals {
+		if re.MatchString(v) {
+			return true
+		}
+	}
+	return false
+}
+
+//inGroup check if instance belongs to the upstream group
+//  by tag, by the group metadata key or by zone
+func (in Instance) inGroup(group string) bool {
+	return in.HasTag(group) || in.Metadata["group"] == group || (in.Zone != "" && in.Zone == group)
+}
+
+//applyRules narrow instances to the group of the first matching rule
+//  rules whose group has no instances are skipped
+func applyRules(serviceName string, instances []Instance, r *http.Request, path string) []Instance {
+	for _, rule := range policyFor(serviceName).Rules {
+		if !rule.matches(r, path) {
+			continue
+		}
+
+		var group []Instance
+		for _, in := range instances {
+			if in.inGroup(rule.Group) {
+				group = append(group, in)
+			}
+		}
+		if len(group) == 0 {
+			log.WithFields(log.Fields{"service": serviceName, "rule": rule.Name, "group": rule.Group}).Error("Routing rule Error: empty group")
+			continue
+		}
+
+		log.WithFields(log.Fields{"service": serviceName, "rule": rule.Name, "group": rule.Group}).Info("Routing rule matched")
+		return group
+	}
+	return instances
+}
@@ -0,0 +1,187 @@
+package service
+
+import (
+	"net/http"
+	"testing"
+
+	"github.com/spf13/viper"
+)
+
+func setupRules() {
+	setupServiceRegister()
+	routePolicies = make(map[string]RoutePolicy)
+	serviceMap["test"] = []Instance{
+		{URL: "http://prod/", Version: "1.0.0"},
+		{URL: "http://staging/", Version: "1.1.0", Tags: []string{"staging"}},
+		{URL: "http://mobile/", Version: "1.0.1", Metadata: map[string]string{"group": "mobile"}},
+	}
+}
+
+func newRuleRequest(method, target string) *http.Request {
+	req, _ := http.NewRequest(method, target, nil)
+	req.RemoteAddr = "10.1.2.3:5000"
+	return req
+}
+
+func TestRuleMatches(t *testing.T) {
+	tests := []struct {
+		name     string
+		rule     RoutingRule
+		expected bool
+	}{
+		{"method", RoutingRule{Methods: []string{"GET"}}, true},
+		{"method miss", RoutingRule{Methods: []string{"POST"}}, false},
+		{"header", RoutingRule{Headers: map[string]string{"x-tester": "^yes$"}}, true},
+		{"header present", RoutingRule{Headers: map[string]string{"X-Tester": ""}}, true},
+		{"header miss", RoutingRule{Headers: map[string]string{"X-Missing": ""}}, false},
+		{"cookie", RoutingRule{Cookies: map[string]string{"beta": "on"}}, true},
+		{"cookie miss", RoutingRule{Cookies: map[string]string{"beta": "off"}}, false},
+		{"query", RoutingRule{Query: map[string]string{"client": "^ios|android$"}}, true},
+		{"cidr", RoutingRule{CIDRs: []string{"192.168.0.0/16", "10.0.0.0/8"}}, true},
+		{"cidr miss", RoutingRule{CIDRs: []string{"192.168.0.0/16"}}, false},
+		{"path", RoutingRule{Path: "^orders/[0-9]+$"}, true},
+		{"path miss", RoutingRule{Path: "^users/"}, false},
+	}
+
+	for _, test := range tests {
+		test.rule.Group = "staging"
+		if err := test.rule.compile(); err != nil {
+			t.Fatalf("%v: %v", test.name, err)
+		}
+
+		req := newRuleRequest("GET", "/service/test/orders/12?client=android")
+		req.Header.Set("X-Tester", "yes")
+		req.AddCookie(&http.Cookie{Name: "beta", Value: "on"})
+
+		if got := test.rule.matches(req, "orders/12"); got != test.expected {
+			t.Errorf("%v: got %v want %v", test.name, got, test.expected)
+		}
+	}
+}
+
+func TestCompileRulesFail(t *testing.T) {
+	tests := [][]RoutingRule{
+		{{}},
+		{{Group: "a", Headers: map[string]string{"X": "("}}},
+		{{Group: "a", CIDRs: []string{"10.0.0.0"}}},
+		{{Group: "a", Path: "["}},
+	}
+
+	for i, rules := range tests {
+		if err := compileRules(rules); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestApplyRulesPriority(t *testing.T) {
+	setupRules()
+	rules := []RoutingRule{
+		{Name: "mobile", Priority: 20, Headers: map[string]string{"User-Agent": "Mobile"}, Group: "mobile"},
+		{Name: "testers", Priority: 10, Headers: map[string]string{"X-Tester": ""}, Group: "staging"},
+	}
+	if err := compileRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	routePolicies["test"] = RoutePolicy{Rules: rules}
+
+	req := newRuleRequest("GET", "/service/test/")
+	req.Header.Set("User-Agent", "Mobile Safari")
+	req.Header.Set("X-Tester", "1")
+
+	group := applyRules("test", serviceMap["test"], req, "")
+	if len(group) != 1 || group[0].URL != "http://staging/" {
+		t.Errorf("function returned unexpected group: got %v", group)
+	}
+
+	req.Header.Del("X-Tester")
+	group = applyRules("test", serviceMap["test"], req, "")
+	if len(group) != 1 || group[0].URL != "http://mobile/" {
+		t.Errorf("function returned unexpected group: got %v", group)
+	}
+}
+
+func TestApplyRulesZone(t *testing.T) {
+	setupRules()
+	serviceMap["test"][0].Zone = "us-east-1a"
+	rules := []RoutingRule{{CIDRs: []string{"10.0.0.0/8"}, Group: "us-east-1a"}}
+	if err := compileRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	routePolicies["test"] = RoutePolicy{Rules: rules}
+
+	group := applyRules("test", serviceMap["test"], newRuleRequest("GET", "/service/test/"), "")
+	if len(group) != 1 || group[0].URL != "http://prod/" {
+		t.Errorf("function returned unexpected group: got %v", group)
+	}
+}
+
+func TestApplyRulesEmptyGroup(t *testing.T) {
+	setupRules()
+	rules := []RoutingRule{{Group: "canary"}}
+	compileRules(rules)
+	routePolicies["test"] = RoutePolicy{Rules: rules}
+
+	group := applyRules("test", serviceMap["test"], newRuleRequest("GET", "/service/test/"), "")
+	if len(group) != len(serviceMap["test"]) {
+		t.Errorf("function returned unexpected group: got %v", group)
+	}
+}
+
+func TestResolveRulesEscapedPath(t *testing.T) {
+	setupRules()
+	rules := []RoutingRule{{Path: "^files/a%2Fb$", Group: "mobile"}}
+	if err := compileRules(rules); err != nil {
+		t.Fatal(err)
+	}
+	routePolicies["test"] = RoutePolicy{Rules: rules}
+	var ds DiscoveryService
+
+	tests := []struct {
+		target   string
+		expected string
+	}{
+		{"/service/test/files/a%2Fb", "http://mobile/"},
+		{"/service/test/files/a/b", "http://staging/"},
+	}
+
+	for _, test := range tests {
+		target, err := ds.resolve(newRuleRequest("GET", test.target))
+		if err != nil || target.instance.URL != test.expected {
+			t.Errorf("%v: got %v %v want %v", test.target, target.instance.URL, err, test.expected)
+		}
+	}
+}
+
+func TestLoadPoliciesRules(t *testing.T) {
+	defer viper.Set(routesKey, nil)
+	viper.Set(routesKey, map[string]interface{}{
+		"test": map[string]interface{}{
+			"default_version": "1",
+			"rules": []interface{}{
+				map[string]interface{}{"priority": 2, "group": "b"},
+				map[string]interface{}{"priority": 1, "group": "a", "cidrs": []string{"10.0.0.0/8"}},
+			},
+		},
+	})
+
+	if err := LoadPolicies(); err != nil {
+		t.Fatal(err)
+	}
+	policy := policyFor("test")
+	if policy.DefaultVersion != "1" || len(policy.Rules) != 2 || policy.Rules[0].Group != "a" {
+		t.Errorf("function returned unexpected policy: got %+v", policy)
+	}
+	if len(policy.Rules[0].nets) != 1 {
+		t.Errorf("function did not compile rule: got %+v", policy.Rules[0])
+	}
+
+	viper.Set(routesKey, map[string]interface{}{
+		"test": map[string]interface{}{
+			"rules": []interface{}{map[string]interface{}{"path": "("}},
+		},
+	})
+	if err := LoadPolicies(); err == nil {
+		t.Error("Failed Invalid Rules")
+	}
+}
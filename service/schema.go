@@ -0,0 +1,585 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"fmt"
+	"math"
+	"net/url"
+	"reflect"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+	"unicode/utf8"
+)
+
+var (
+	schemaCache sync.Map
+	formats     map[string]func(string) bool
+)
+
+func init() {
+	email := regexp.MustCompile(`^[^@\s]+@[^@\s]+\.[^@\s]+$`)
+	uuid := regexp.MustCompile(`^[0-9a-fA-F]{8}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{4}-[0-9a-fA-F]{12}$`)
+	formats = map[string]func(string) bool{
+		"email": email.MatchString,
+		"uuid":  uuid.MatchString,
+		"date-time": func(s string) bool {
+			_, err := time.Parse(time.RFC3339, s)
+			return err == nil
+		},
+		"date": func(s string) bool {
+			_, err := time.Parse("2006-01-02", s)
+			return err == nil
+		},
+		"uri": func(s string) bool {
+			u, err := url.Parse(s)
+			return err == nil && u.IsAbs()
+		},
+	}
+}
+
+//jsonSchema compiled JSON Schema document
+//  supports the validation keywords of draft 7 except dependencies,
+//  if/then/else and remote references, unknown formats are ignored
+//  the nullable keyword of OpenAPI 3.0 is also understood
+type jsonSchema struct {
+	root     interface{}
+	patterns map[string]*regexp.Regexp
+	refs     map[string]bool
+}
+
+//compileSchema parse a JSON Schema document
+//  compiled schemas are cached by their text
+func compileSchema(text string) (*jsonSchema, error) {
+	if s, ok := schemaCache.Load(text); ok {
+		return s.(*jsonSchema), nil
+	}
+
+	var root interface{}
+	if err := decodeJSON([]byte(text), &root); err != nil {
+		return nil, fmt.Errorf("invalid schema: %s", err.Error())
+	}
+	s := &jsonSchema{root: root, patterns: make(map[string]*regexp.Regexp), refs: make(map[string]bool)}
+	if err := s.compile(root); err != nil {
+		return nil, err
+	}
+	if err := s.checkCycles(); err != nil {
+		return nil, err
+	}
+
+	schemaCache.Store(text, s)
+	return s, nil
+}
+
+//compile check the schema and compile its patterns
+func (s *jsonSchema) compile(node interface{}) error {
+	switch n := node.(type) {
+	case bool:
+		return nil
+	case map[string]interface{}:
+		if p, ok := n["pattern"].(string); ok {
+			re, err := regexp.Compile(p)
+			if err != nil {
+				return fmt.Errorf("invalid schema: pattern %s", err.Error())
+			}
+			s.patterns[p] = re
+		}
+		if ref, ok := n["$ref"].(string); ok && !s.refs[ref] {
+			target, err := s.resolve(ref)
+			if err != nil {
+				return err
+			}
+			// references may point outside of the definitions
+			s.refs[ref] = true
+			if err := s.compile(target); err != nil {
+				return err
+			}
+		}
+		for key, val := range n {
+			switch key {
+			case "properties", "patternProperties", "definitions", "$defs":
+				children, ok := val.(map[string]interface{})
+				if !ok {
+					return fmt.Errorf("invalid schema: %s must be an object", key)
+				}
+				for name, child := range children {
+					if key == "patternProperties" {
+						re, err := regexp.Compile(name)
+						if err != nil {
+							return fmt.Errorf("invalid schema: pattern %s", err.Error())
+						}
+						s.patterns[name] = re
+					}
+					if err := s.compile(child); err != nil {
+						return err
+					}
+				}
+			case "items", "additionalProperties", "additionalItems", "not", "contains", "propertyNames":
+				if list, ok := val.([]interface{}); ok {
+					for _, child := range list {
+						if err := s.compile(child); err != nil {
+							return err
+						}
+					}
+				} else if err := s.compile(val); err != nil {
+					return err
+				}
+			case "allOf", "anyOf", "oneOf":
+				list, ok := val.([]interface{})
+				if !ok {
+					return fmt.Errorf("invalid schema: %s must be an array", key)
+				}
+				for _, child := range list {
+					if err := s.compile(child); err != nil {
+						return err
+					}
+				}
+			}
+		}
+		return nil
+	default:
+		return fmt.Errorf("invalid schema: must be an object or boolean")
+	}
+}
+
+//checkCycles reject references that lead back to themselves without
+//  consuming input, which would never finish validating
+//  recursion through properties or items is allowed
+func (s *jsonSchema) checkCycles() error {
+	const visiting, done = 1, 2
+	state := make(map[string]int, len(s.refs))
+
+	var visit func(ref string) error
+	visit = func(ref string) error {
+		switch state[ref] {
+		case visiting:
+			return fmt.Errorf("invalid schema: reference %s loops back to itself", ref)
+		case done:
+			return nil
+		}
+		state[ref] = visiting
+		target, err := s.resolve(ref)
+		if err != nil {
+			return err
+		}
+		for _, next := range inPlaceRefs(target) {
+			if err := visit(next); err != nil {
+				return err
+			}
+		}
+		state[ref] = done
+		return nil
+	}
+
+	for ref := range s.refs {
+		if err := visit(ref); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+//inPlaceRefs references a schema applies to the value it validates
+//  $ref replaces the schema, allOf, anyOf, oneOf and not apply theirs
+func inPlaceRefs(node interface{}) []string {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		return nil
+	}
+	if ref, ok := schema["$ref"].(string); ok {
+		return []string{ref}
+	}
+
+	var refs []string
+	for _, key := range []string{"allOf", "anyOf", "oneOf"} {
+		list, _ := schema[key].([]interface{})
+		for _, sub := range list {
+			refs = append(refs, inPlaceRefs(sub)...)
+		}
+	}
+	if not, ok := schema["not"]; ok {
+		refs = append(refs, inPlaceRefs(not)...)
+	}
+	return refs
+}
+
+//resolve find a local reference such as #/definitions/name
+func (s *jsonSchema) resolve(ref string) (interface{}, error) {
+	if !strings.HasPrefix(ref, "#") {
+		return nil, fmt.Errorf("invalid schema: only local references are supported, got %s", ref)
+	}
+	node := s.root
+	pointer := strings.TrimPrefix(ref, "#")
+	if pointer == "" {
+		return node, nil
+	}
+	for _, token := range strings.Split(strings.TrimPrefix(pointer, "/"), "/") {
+		token = strings.Replace(strings.Replace(token, "~1", "/", -1), "~0", "~", -1)
+		obj, ok := node.(map[string]interface{})
+		if !ok {
+			return nil, fmt.Errorf("invalid schema: unresolved reference %s", ref)
+		}
+		if node, ok = obj[token]; !ok {
+			return nil, fmt.Errorf("invalid schema: unresolved reference %s", ref)
+		}
+	}
+	return node, nil
+}
+
+//validateDocument validate a JSON document against the schema
+//  returns *ValidationError listing the JSON pointer of every failure
+func (s *jsonSchema) validateDocument(data []byte) error {
+	var doc interface{}
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	if err := d.Decode(&doc); err != nil {
+		return &ValidationError{[]FieldError{{"", "must be valid JSON"}}}
+	}
+
+	ve := &ValidationError{}
+	s.validate(s.root, doc, "", ve)
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
+
+//validate check value against a schema node and record failures at pointer
+func (s *jsonSchema) validate(node, value interface{}, pointer string, ve *ValidationError) {
+	schema, ok := node.(map[string]interface{})
+	if !ok {
+		if node == false {
+			ve.add(pointer, "is not allowed")
+		}
+		return
+	}
+
+	if ref, ok := schema["$ref"].(string); ok {
+		if target, err := s.resolve(ref); err == nil {
+			s.validate(target, value, pointer, ve)
+		}
+		return
+	}
+
+	// OpenAPI 3.0 marks optional values with nullable instead of a null type
+	if value == nil && schema["nullable"] == true {
+		return
+	}
+
+	if t, ok := schema["type"]; ok && !matchesType(t, value) {
+		ve.add(pointer, "must be of type "+typeNames(t))
+		return
+	}
+
+	if enum, ok := schema["enum"].([]interface{}); ok {
+		found := false
+		for _, candidate := range enum {
+			if jsonEqual(candidate, value) {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ve.add(pointer, "must be one of the enumerated values")
+		}
+	}
+	if c, ok := schema["const"]; ok && !jsonEqual(c, value) {
+		ve.add(pointer, "must be the constant value")
+	}
+
+	switch v := value.(type) {
+	case map[string]interface{}:
+		s.validateObject(schema, v, pointer, ve)
+	case []interface{}:
+		s.validateArray(schema, v, pointer, ve)
+	case string:
+		s.validateString(schema, v, pointer, ve)
+	case json.Number:
+		validateNumber(schema, v, pointer, ve)
+	}
+
+	if all, ok := schema["allOf"].([]interface{}); ok {
+		for _, sub := range all {
+			s.validate(sub, value, pointer, ve)
+		}
+	}
+	if anyOf, ok := schema["anyOf"].([]interface{}); ok && s.countValid(anyOf, value) == 0 {
+		ve.add(pointer, "must match at least one schema of anyOf")
+	}
+	if oneOf, ok := schema["oneOf"].([]interface{}); ok && s.countValid(oneOf, value) != 1 {
+		ve.add(pointer, "must match exactly one schema of oneOf")
+	}
+	if not, ok := schema["not"]; ok && s.countValid([]interface{}{not}, value) == 1 {
+		ve.add(pointer, "must not match the schema of not")
+	}
+}
+
+func (s *jsonSchema) countValid(schemas []interface{}, value interface{}) int {
+	n := 0
+	for _, sub := range schemas {
+		ve := &ValidationError{}
+		s.validate(sub, value, "", ve)
+		if len(ve.Fields) == 0 {
+			n++
+		}
+	}
+	return n
+}
+
+func (s *jsonSchema) validateObject(schema, obj map[string]interface{}, pointer string, ve *ValidationError) {
+	if required, ok := schema["required"].([]interface{}); ok {
+		for _, r := range required {
+			name, _ := r.(string)
+			if _, ok := obj[name]; !ok {
+				ve.add(pointer+"/"+escapePointer(name), "is required")
+			}
+		}
+	}
+	if n, ok := schemaInt(schema, "minProperties"); ok && len(obj) < n {
+		ve.add(pointer, fmt.Sprintf("must have at least %d properties", n))
+	}
+	if n, ok := schemaInt(schema, "maxProperties"); ok && len(obj) > n {
+		ve.add(pointer, fmt.Sprintf("must have at most %d properties", n))
+	}
+
+	keys := make([]string, 0, len(obj))
+	for key := range obj {
+		keys = append(keys, key)
+	}
+	sort.Strings(keys)
+
+	properties, _ := schema["properties"].(map[string]interface{})
+	patterns, _ := schema["patternProperties"].(map[string]interface{})
+	for _, key := range keys {
+		child := pointer + "/" + escapePointer(key)
+		if names, ok := schema["propertyNames"]; ok {
+			s.validate(names, key, child, ve)
+		}
+
+		matched := false
+		if sub, ok := properties[key]; ok {
+			s.validate(sub, obj[key], child, ve)
+			matched = true
+		}
+		for pattern, sub := range patterns {
+			if s.patterns[pattern].MatchString(key) {
+				s.validate(sub, obj[key], child, ve)
+				matched = true
+			}
+		}
+		if additional, ok := schema["additionalProperties"]; ok && !matched {
+			if additional == false {
+				ve.add(child, "is not an allowed property")
+			} else {
+				s.validate(additional, obj[key], child, ve)
+			}
+		}
+	}
+}
+
+func (s *jsonSchema) validateArray(schema map[string]interface{}, arr []interface{}, pointer string, ve *ValidationError) {
+	if n, ok := schemaInt(schema, "minItems"); ok && len(arr) < n {
+		ve.add(pointer, fmt.Sprintf("must have at least %d items", n))
+	}
+	if n, ok := schemaInt(schema, "maxItems"); ok && len(arr) > n {
+		ve.add(pointer, fmt.Sprintf("must have at most %d items", n))
+	}
+	if unique, _ := schema["uniqueItems"].(bool); unique {
+		for i := range arr {
+			for j := i + 1; j < len(arr); j++ {
+				if jsonEqual(arr[i], arr[j]) {
+					ve.add(fmt.Sprintf("%s/%d", pointer, j), "must be unique")
+				}
+			}
+		}
+	}
+
+	switch items := schema["items"].(type) {
+	case []interface{}:
+		for i, v := range arr {
+			child := fmt.Sprintf("%s/%d", pointer, i)
+			if i < len(items) {
+				s.validate(items[i], v, child, ve)
+			} else if additional, ok := schema["additionalItems"]; ok {
+				s.validate(additional, v, child, ve)
+			}
+		}
+	case nil:
+	default:
+		for i, v := range arr {
+			s.validate(items, v, fmt.Sprintf("%s/%d", pointer, i), ve)
+		}
+	}
+
+	if contains, ok := schema["contains"]; ok {
+		found := false
+		for _, v := range arr {
+			if s.countValid([]interface{}{contains}, v) == 1 {
+				found = true
+				break
+			}
+		}
+		if !found {
+			ve.add(pointer, "must contain a matching item")
+		}
+	}
+}
+
+func (s *jsonSchema) validateString(schema map[string]interface{}, str, pointer string, ve *ValidationError) {
+	length := utf8.RuneCountInString(str)
+	if n, ok := schemaInt(schema, "minLength"); ok && length < n {
+		ve.add(pointer, fmt.Sprintf("must be at least %d characters", n))
+	}
+	if n, ok := schemaInt(schema, "maxLength"); ok && length > n {
+		ve.add(pointer, fmt.Sprintf("must be at most %d characters", n))
+	}
+	if p, ok := schema["pattern"].(string); ok && !s.patterns[p].MatchString(str) {
+		ve.add(pointer, "must match pattern "+p)
+	}
+	if f, ok := schema["format"].(string); ok {
+		if check, known := formats[f]; known && !check(str) {
+			ve.add(pointer, "must be a valid "+f)
+		}
+	}
+}
+
+func validateNumber(schema map[string]interface{}, num json.Number, pointer string, ve *ValidationError) {
+	v, err := num.Float64()
+	if err != nil {
+		return
+	}
+	if min, ok := schemaFloat(schema, "minimum"); ok && v < min {
+		ve.add(pointer, "must be at least "+formatFloat(min))
+	}
+	if max, ok := schemaFloat(schema, "maximum"); ok && v > max {
+		ve.add(pointer, "must be at most "+formatFloat(max))
+	}
+	if min, ok := schemaFloat(schema, "exclusiveMinimum"); ok && v <= min {
+		ve.add(pointer, "must be greater than "+formatFloat(min))
+	}
+	if max, ok := schemaFloat(schema, "exclusiveMaximum"); ok && v >= max {
+		ve.add(pointer, "must be less than "+formatFloat(max))
+	}
+	if m, ok := schemaFloat(schema, "multipleOf"); ok && m > 0 {
+		if q := v / m; math.Abs(q-math.Round(q)) > 1e-9 {
+			ve.add(pointer, "must be a multiple of "+formatFloat(m))
+		}
+	}
+}
+
+//matchesType check value against a type name or list of type names
+func matchesType(t, value interface{}) bool {
+	names, ok := t.([]interface{})
+	if !ok {
+		names = []interface{}{t}
+	}
+	for _, n := range names {
+		name, _ := n.(string)
+		switch v := value.(type) {
+		case map[string]interface{}:
+			if name == "object" {
+				return true
+			}
+		case []interface{}:
+			if name == "array" {
+				return true
+			}
+		case string:
+			if name == "string" {
+				return true
+			}
+		case bool:
+			if name == "boolean" {
+				return true
+			}
+		case nil:
+			if name == "null" {
+				return true
+			}
+		case json.Number:
+			if name == "number" {
+				return true
+			}
+			if f, err := v.Float64(); name == "integer" && err == nil && f == math.Trunc(f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+func typeNames(t interface{}) string {
+	if names, ok := t.([]interface{}); ok {
+		parts := make([]string, 0, len(names))
+		for _, n := range names {
+			parts = append(parts, fmt.Sprint(n))
+		}
+		return strings.Join(parts, " or ")
+	}
+	return fmt.Sprint(t)
+}
+
+//jsonEqual compare decoded JSON values, numbers by value
+func jsonEqual(a, b interface{}) bool {
+	if an, ok := a.(json.Number); ok {
+		bn, ok := b.(json.Number)
+		if !ok {
+			return false
+		}
+		af, aerr := an.Float64()
+		bf, berr := bn.Float64()
+		return aerr == nil && berr == nil && af == bf
+	}
+	switch av := a.(type) {
+	case map[string]interface{}:
+		bv, ok := b.(map[string]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for k, v := range av {
+			if !jsonEqual(v, bv[k]) {
+				return false
+			}
+		}
+		return true
+	case []interface{}:
+		bv, ok := b.([]interface{})
+		if !ok || len(av) != len(bv) {
+			return false
+		}
+		for i := range av {
+			if !jsonEqual(av[i], bv[i]) {
+				return false
+			}
+		}
+		return true
+	}
+	return reflect.DeepEqual(a, b)
+}
+
+func schemaFloat(schema map[string]interface{}, key string) (float64, bool) {
+	n, ok := schema[key].(json.Number)
+	if !ok {
+		return 0, false
+	}
+	f, err := n.Float64()
+	return f, err == nil
+}
+
+func schemaInt(schema map[string]interface{}, key string) (int, bool) {
+	f, ok := schemaFloat(schema, key)
+	return int(f), ok
+}
+
+func formatFloat(f float64) string {
+	return strconv.FormatFloat(f, 'f', -1, 64)
+}
+
+//escapePointer escape a JSON pointer reference token
+func escapePointer(token string) string {
+	return strings.Replace(strings.Replace(token, "~", "~0", -1), "/", "~1", -1)
+}
@@ -0,0 +1,95 @@
+package service
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestSchemaValidate(t *testing.T) {
+	schema := `{
+		"type": "object",
+		"required": ["name", "age"],
+		"additionalProperties": false,
+		"definitions": {"tag": {"type": "string", "pattern": "^[a-z]+$"}},
+		"properties": {
+			"name": {"type": "string", "minLength": 2, "maxLength": 5},
+			"age": {"type": "integer", "minimum": 0, "exclusiveMaximum": 150},
+			"email": {"type": "string", "format": "email"},
+			"role": {"enum": ["admin", "user"]},
+			"tags": {"type": "array", "items": {"$ref": "#/definitions/tag"}, "maxItems": 2, "uniqueItems": true},
+			"a/b": {"type": "number", "multipleOf": 0.5},
+			"id": {"oneOf": [{"type": "string"}, {"type": "integer"}]},
+			"meta": {"type": ["object", "null"], "properties": {"x": {"not": {"type": "boolean"}}}}
+		}
+	}`
+
+	tests := []struct {
+		doc      string
+		expected []FieldError
+	}{
+		{`{"name":"bob","age":30}`, nil},
+		{`{"name":"bob","age":30,"email":"a@b.co","role":"admin","tags":["x","y"],"a/b":1.5,"id":7,"meta":null}`, nil},
+		{`{}`, []FieldError{{"/name", "is required"}, {"/age", "is required"}}},
+		{`[]`, []FieldError{{"", "must be of type object"}}},
+		{`{"name`, []FieldError{{"", "must be valid JSON"}}},
+		{`{"name":"b","age":1.5}`, []FieldError{{"/age", "must be of type integer"}, {"/name", "must be at least 2 characters"}}},
+		{`{"name":"bob","age":150,"extra":1}`, []FieldError{{"/age", "must be less than 150"}, {"/extra", "is not an allowed property"}}},
+		{`{"name":"bob","age":-1,"email":"nope"}`, []FieldError{{"/age", "must be at least 0"}, {"/email", "must be a valid email"}}},
+		{`{"name":"bob","age":1,"role":"root"}`, []FieldError{{"/role", "must be one of the enumerated values"}}},
+		{`{"name":"bob","age":1,"tags":["A","b","b"]}`, []FieldError{{"/tags", "must have at most 2 items"}, {"/tags/2", "must be unique"}, {"/tags/0", "must match pattern ^[a-z]+$"}}},
+		{`{"name":"bob","age":1,"a/b":0.3}`, []FieldError{{"/a~1b", "must be a multiple of 0.5"}}},
+		{`{"name":"bob","age":1,"id":true}`, []FieldError{{"/id", "must match exactly one schema of oneOf"}}},
+		{`{"name":"bob","age":1,"meta":{"x":true}}`, []FieldError{{"/meta/x", "must not match the schema of not"}}},
+	}
+
+	s, err := compileSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, test := range tests {
+		var got []FieldError
+		if err := s.validateDocument([]byte(test.doc)); err != nil {
+			got = err.(*ValidationError).Fields
+		}
+		if !reflect.DeepEqual(got, test.expected) {
+			t.Errorf("%v: got %v want %v", test.doc, got, test.expected)
+		}
+	}
+}
+
+func TestCompileSchemaRecursive(t *testing.T) {
+	schema := `{"$ref": "#/definitions/node", "definitions": {"node": {"type": "object",
+		"properties": {"children": {"type": "array", "items": {"$ref": "#/definitions/node"}}}}}}`
+	s, err := compileSchema(schema)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	if err := s.validateDocument([]byte(`{"children": [{"children": []}, {"children": [1]}]}`)); err == nil {
+		t.Error("Failed to validate recursive schema")
+	} else if ve := err.(*ValidationError); len(ve.Fields) != 1 || ve.Fields[0].Field != "/children/1/children/0" {
+		t.Errorf("function returned unexpected fields: got %v", ve.Fields)
+	}
+}
+
+func TestCompileSchemaFail(t *testing.T) {
+	tests := []string{
+		`not json`,
+		`"string"`,
+		`{"pattern": "("}`,
+		`{"$ref": "#/definitions/missing"}`,
+		`{"$ref": "http://example.com/schema"}`,
+		`{"allOf": {}}`,
+		`{"properties": []}`,
+		`{"$ref": "#"}`,
+		`{"$ref": "#/definitions/a", "definitions": {"a": {"$ref": "#/definitions/b"}, "b": {"$ref": "#/definitions/a"}}}`,
+		`{"allOf": [{"$ref": "#"}]}`,
+		`{"definitions": {"a": {"anyOf": [{"type": "string"}, {"not": {"$ref": "#/definitions/a"}}]}}, "$ref": "#/definitions/a"}`,
+	}
+
+	for _, test := range tests {
+		if _, err := compileSchema(test); err == nil {
+			t.Errorf("%v: expected error", test)
+		}
+	}
+}
@@ -0,0 +1,253 @@
+package service
+
+import (
+	"errors"
+	"hash/fnv"
+	"math/rand"
+	"net/http"
+	"sort"
+
+	"github.com/dtan44/SMUG/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+const (
+	splitMetric = "smug_split_decisions_total"
+)
+
+var (
+	splitRules map[string]SplitRule
+	randIntn   func(n int) int
+)
+
+func init() {
+	splitRules = make(map[string]SplitRule)
+	randIntn = rand.Intn
+}
+
+//SplitRule weighted traffic split between versions of a service
+//  Weights maps a version constraint to its share of traffic
+//  StickyHeader or StickyCookie keep a client on the same version
+type SplitRule struct {
+	Weights      map[string]int `json:"weights" mapstructure:"weights"`
+	StickyHeader string         `json:"stickyHeader,omitempty" mapstructure:"sticky_header"`
+	StickyCookie string         `json:"stickyCookie,omitempty" mapstructure:"sticky_cookie"`
+}
+
+//TrafficInterface defines traffic split methods
+type TrafficInterface interface {
+	SetSplit(serviceName string, rule SplitRule) error
+	RemoveSplit(serviceName string) error
+	Splits() map[string]SplitRule
+}
+
+//SetSplit replace the traffic split of a service
+//  serviceName must exist
+//  every weight key must be a version constraint
+func (rs RegistrationService) SetSplit(serviceName string, rule SplitRule) error {
+	if err := validateSplit(rule); err != nil {
+		return err
+	}
+
+	// the service cannot be deregistered before the rule is set
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	if _, ok := serviceMap[serviceName]; !ok {
+		return errors.New("Service Name does not Exist")
+	}
+
+	policyLock.Lock()
+	splitRules[serviceName] = rule
+	policyLock.Unlock()
+
+	log.WithFields(log.Fields{"service": serviceName, "weights": rule.Weights}).Info("Traffic split updated")
+	return nil
+}
+
+//RemoveSplit remove the runtime traffic split of a service
+func (rs RegistrationService) RemoveSplit(serviceName string) error {
+	policyLock.Lock()
+	defer policyLock.Unlock()
+
+	if _, ok := splitRules[serviceName]; !ok {
+		return errors.New("Traffic Split does not Exist")
+	}
+	delete(splitRules, serviceName)
+	return nil
+}
+
+//Splits show the runtime traffic splits
+func (rs RegistrationService) Splits() map[string]SplitRule {
+	policyLock.RLock()
+	defer policyLock.RUnlock()
+
+	rules := make(map[string]SplitRule, len(splitRules))
+	for k, v := range splitRules {
+		rules[k] = v
+	}
+	return rules
+}
+
+func validateSplit(rule SplitRule) error {
+	ve := &ValidationError{}
+
+	total := 0
+	for constraint, weight := range rule.Weights {
+		if _, err := parseConstraint(constraint); err != nil {
+			ve.add("weights", "invalid version constraint "+constraint)
+		}
+		if weight < 0 {
+			ve.add("weights", "weight of "+constraint+" must not be negative")
+		}
+		total += weight
+	}
+	if total <= 0 {
+		ve.add("weights", "must have a positive total weight")
+	}
+
+	if rule.StickyHeader != "" && rule.StickyCookie != "" {
+		ve.add("sticky", "use either a header or a cookie")
+	}
+
+	if len(ve.Fields) > 0 {
+		return ve
+	}
+	return nil
+}
+
+//weightedInstance choose among the instances with a weight in proportion
+//  to it, instances without a weight are not chosen
+func weightedInstance(instances []Instance) (Instance, bool) {
+	total := 0
+	for _, in := range instances {
+		if in.Weight > 0 {
+			total += in.Weight
+		}
+	}
+	if total == 0 {
+		return Instance{}, false
+	}
+
+	n := randIntn(total)
+	for _, in := range instances {
+		if in.Weight <= 0 {
+			continue
+		}
+		if n < in.Weight {
+			return in, true
+		}
+		n -= in.Weight
+	}
+	return Instance{}, false
+}
+
+//clearSplit remove the runtime traffic split of a service that is gone, so
+//  a service registered again under its name starts without one
+//  registryLock must be held
+func clearSplit(serviceName string) {
+	policyLock.Lock()
+	delete(splitRules, serviceName)
+	policyLock.Unlock()
+}
+
+//splitFor runtime traffic split of a service, falling back to the routes config
+func splitFor(serviceName string) (SplitRule, bool) {
+	policyLock.RLock()
+	rule, ok := splitRules[serviceName]
+	policyLock.RUnlock()
+	if ok {
+		return rule, true
+	}
+
+	if rule := policyFor(serviceName).Split; rule != nil {
+		return *rule, true
+	}
+	return SplitRule{}, false
+}
+
+//stickyKey key of the sticky header or cookie of a request, if any
+func (rule SplitRule) stickyKey(r *http.Request) string {
+	if rule.StickyHeader != "" {
+		return r.Header.Get(rule.StickyHeader)
+	}
+	if rule.StickyCookie != "" {
+		if c, err := r.Cookie(rule.StickyCookie); err == nil {
+			return c.Value
+		}
+	}
+	return ""
+}
+
+//sticky check if the rule keeps clients on the same version
+func (rule SplitRule) sticky() bool {
+	return rule.StickyHeader != "" || rule.StickyCookie != ""
+}
+
+//pick choose a version constraint for a client
+//  sticky clients hash to the same bucket for the same key
+func (rule SplitRule) pick(serviceName, key string) (string, bool) {
+	constraints := make([]string, 0, len(rule.Weights))
+	total := 0
+	for constraint, weight := range rule.Weights {
+		if weight > 0 {
+			constraints = append(constraints, constraint)
+			total += weight
+		}
+	}
+	if total == 0 {
+		return "", false
+	}
+	sort.Strings(constraints)
+
+	var n int
+	sticky := key != ""
+	if sticky {
+		h := fnv.New32a()
+		h.Write([]byte(serviceName + "/" + key))
+		n = int(h.Sum32() % uint32(total))
+	} else {
+		n = randIntn(total)
+	}
+
+	for _, constraint := range constraints {
+		n -= rule.Weights[constraint]
+		if n < 0 {
+			return constraint, sticky
+		}
+	}
+	return constraints[len(constraints)-1], sticky
+}
+
+//splitInstance choose a version by traffic split and report the decision
+func splitInstance(serviceName string, instances []Instance, r *http.Request) (Instance, bool) {
+	return splitBy(serviceName, instances, func(rule SplitRule) string { return rule.stickyKey(r) })
+}
+
+//splitBy choose a version by traffic split and report the decision
+//  key gives the sticky key of the client under the split rule
+func splitBy(serviceName string, instances []Instance, key func(SplitRule) string) (Instance, bool) {
+	rule, ok := splitFor(serviceName)
+	if !ok {
+		return Instance{}, false
+	}
+
+	constraint, sticky := rule.pick(serviceName, key(rule))
+	if constraint == "" {
+		return Instance{}, false
+	}
+
+	instance, err := selectVersion(instances, constraint)
+	if err != nil {
+		log.WithFields(log.Fields{"service": serviceName, "split": constraint}).Error("Traffic split Error: " + err.Error())
+		return Instance{}, false
+	}
+
+	metrics.Inc(splitMetric, map[string]string{"service": serviceName, "split": constraint, "version": instance.Version})
+	log.WithFields(log.Fields{
+		"service": serviceName,
+		"split":   constraint,
+		"version": instance.Version,
+		"sticky":  sticky,
+	}).Info("Traffic split decision")
+	return instance, true
+}
@@ -0,0 +1,194 @@
+package service
+
+import (
+	"math/rand"
+	"net/http"
+	"testing"
+
+	"github.com/dtan44/SMUG/metrics"
+)
+
+func setupSplit() {
+	setupServiceRegister()
+	splitRules = make(map[string]SplitRule)
+	routePolicies = make(map[string]RoutePolicy)
+	randIntn = rand.Intn
+	metrics.Reset()
+	serviceMap["test"] = []Instance{
+		{URL: "http://v1/", Version: "1.0.0"},
+		{URL: "http://v2/", Version: "2.0.0"},
+	}
+}
+
+func TestSetSplitValidationFail(t *testing.T) {
+	setupSplit()
+	var rs RegistrationService
+
+	err := rs.SetSplit("test", SplitRule{Weights: map[string]int{"latest": 5, "v1": -5}})
+	ve, ok := err.(*ValidationError)
+	if !ok {
+		t.Fatalf("service returned unexpected error: got %v want %T", err, ve)
+	}
+	if len(ve.Fields) != 3 {
+		t.Errorf("service returned unexpected fields: got %v", ve.Fields)
+	}
+}
+
+func TestSetSplitServiceFail(t *testing.T) {
+	setupSplit()
+	var rs RegistrationService
+
+	errorText := "Service Name does not Exist"
+	err := rs.SetSplit("missing", SplitRule{Weights: map[string]int{"v1": 1}})
+	if err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v", err, errorText)
+	}
+}
+
+func TestSetAndRemoveSplit(t *testing.T) {
+	setupSplit()
+	var rs RegistrationService
+
+	if err := rs.SetSplit("test", SplitRule{Weights: map[string]int{"v1": 95, "v2": 5}}); err != nil {
+		t.Fatal(err)
+	}
+	if rule := rs.Splits()["test"]; rule.Weights["v2"] != 5 {
+		t.Errorf("service returned unexpected split: got %+v", rule)
+	}
+
+	if err := rs.RemoveSplit("test"); err != nil {
+		t.Fatal(err)
+	}
+	errorText := "Traffic Split does not Exist"
+	if err := rs.RemoveSplit("test"); err == nil || err.Error() != errorText {
+		t.Errorf("service returned unexpected error: got %v want %v", err, errorText)
+	}
+}
+
+func TestDeregisterClearsSplit(t *testing.T) {
+	setupSplit()
+	healthCheck = func(URL string) bool { return true }
+	var rs RegistrationService
+
+	if err := rs.SetSplit("test", SplitRule{Weights: map[string]int{"v1": 1}}); err != nil {
+		t.Fatal(err)
+	}
+	rs.Deregister("test@1")
+	if _, ok := rs.Splits()["test"]; !ok {
+		t.Error("Failed split removed with a remaining version")
+	}
+
+	rs.Deregister("test")
+	rs.Register("test", Instance{URL: "http://v3", Version: "3.0.0"})
+	if _, ok := rs.Splits()["test"]; ok {
+		t.Error("Failed split kept after the service was deregistered")
+	}
+}
+
+func TestSplitInstanceWeights(t *testing.T) {
+	setupSplit()
+	splitRules["test"] = SplitRule{Weights: map[string]int{"v1": 95, "v2": 5}}
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+
+	// buckets are ordered by constraint, v1 owns [0,95) and v2 owns [95,100)
+	randIntn = func(n int) int { return 94 }
+	if in, _ := splitInstance("test", serviceMap["test"], req); in.Version != "1.0.0" {
+		t.Errorf("function returned unexpected version: got %v want %v", in.Version, "1.0.0")
+	}
+
+	randIntn = func(n int) int { return 95 }
+	if in, _ := splitInstance("test", serviceMap["test"], req); in.Version != "2.0.0" {
+		t.Errorf("function returned unexpected version: got %v want %v", in.Version, "2.0.0")
+	}
+
+	labels := map[string]string{"service": "test", "split": "v2", "version": "2.0.0"}
+	if got := metrics.Get(splitMetric, labels); got != 1 {
+		t.Errorf("split decisions not counted: got %v want %v", got, 1)
+	}
+}
+
+func TestSplitInstanceSticky(t *testing.T) {
+	setupSplit()
+	splitRules["test"] = SplitRule{Weights: map[string]int{"v1": 50, "v2": 50}, StickyCookie: "user"}
+	randIntn = func(n int) int {
+		t.Fatal("sticky split used random bucket")
+		return 0
+	}
+
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+	req.AddCookie(&http.Cookie{Name: "user", Value: "alice"})
+
+	first, ok := splitInstance("test", serviceMap["test"], req)
+	if !ok {
+		t.Fatal("Failed to split traffic")
+	}
+	for i := 0; i < 10; i++ {
+		if in, _ := splitInstance("test", serviceMap["test"], req); in.Version != first.Version {
+			t.Errorf("sticky split changed version: got %v want %v", in.Version, first.Version)
+		}
+	}
+}
+
+func TestSplitFromPolicy(t *testing.T) {
+	setupSplit()
+	routePolicies["test"] = RoutePolicy{Split: &SplitRule{Weights: map[string]int{"v1": 1}}}
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+
+	if in, ok := splitInstance("test", serviceMap["test"], req); !ok || in.Version != "1.0.0" {
+		t.Errorf("function returned unexpected version: got %v want %v", in.Version, "1.0.0")
+	}
+}
+
+func TestValidatePolicySplit(t *testing.T) {
+	tests := []*SplitRule{
+		{Weights: map[string]int{"v1": 0}},
+		{Weights: map[string]int{"~>": 1}},
+		{Weights: map[string]int{"v1": 1}, StickyHeader: "X-User", StickyCookie: "user"},
+	}
+
+	for i, rule := range tests {
+		if err := validatePolicy(&RoutePolicy{Split: rule}); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+
+	valid := RoutePolicy{Split: &SplitRule{Weights: map[string]int{"v1": 9, "v2": 1}}}
+	if err := validatePolicy(&valid); err != nil {
+		t.Errorf("function returned unexpected error: got %v want %v", err, nil)
+	}
+}
+
+func TestResolveInstanceWeights(t *testing.T) {
+	setupSplit()
+	serviceMap["test"] = []Instance{
+		{URL: "http://v1/", Version: "1.0.0", Weight: 30},
+		{URL: "http://v2/", Version: "2.0.0", Weight: 70},
+		{URL: "http://v3/", Version: "3.0.0"},
+	}
+	var ds DiscoveryService
+
+	tests := []struct {
+		target   string
+		n        int
+		expected string
+	}{
+		{"/service/test/", 29, "http://v1/"},
+		{"/service/test/", 30, "http://v2/"},
+		{"/service/test@3/", 0, "http://v3/"},
+	}
+
+	for _, test := range tests {
+		randIntn = func(n int) int { return test.n }
+		req, _ := http.NewRequest("GET", test.target, nil)
+		if target, err := ds.resolve(req); err != nil || target.instance.URL != test.expected {
+			t.Errorf("%v %v: got %v %v want %v", test.target, test.n, target.instance.URL, err, test.expected)
+		}
+	}
+
+	// a default version takes precedence over weights
+	routePolicies["test"] = RoutePolicy{DefaultVersion: "3"}
+	req, _ := http.NewRequest("GET", "/service/test/", nil)
+	if target, _ := ds.resolve(req); target.instance.URL != "http://v3/" {
+		t.Errorf("function returned unexpected instance: got %v want %v", target.instance.URL, "http://v3/")
+	}
+}
@@ -0,0 +1,75 @@
+package service
+
+import (
+	"errors"
+)
+
+//syncStatic replace the static instances in the registry with those of policies
+//  static instances may not share a version with a registered instance
+func syncStatic(policies map[string]RoutePolicy) error {
+	registryLock.Lock()
+	defer registryLock.Unlock()
+
+	if err := checkStatic(policies); err != nil {
+		return err
+	}
+
+	previous := make(map[string]Instance)
+	for name, instances := range serviceMap {
+		remaining := make([]Instance, 0, len(instances))
+		for _, instance := range instances {
+			if instance.Static {
+				previous[instanceKey(name, instance.Version)] = instance
+			} else {
+				remaining = append(remaining, instance)
+			}
+		}
+		serviceMap[name] = remaining
+	}
+
+	for name, policy := range policies {
+		for _, upstream := range policy.Upstreams {
+			upstream = normalizeInstance(upstream)
+			upstream.Static = true
+			serviceMap[name] = append(serviceMap[name], upstream)
+
+			key := instanceKey(name, upstream.Version)
+			old, existed := previous[key]
+			delete(previous, key)
+			if existed && old.URL == upstream.URL {
+				continue
+			}
+			serviceHealth[key] = true
+			publish(Event{Type: EventRegister, Service: name, Version: upstream.Version, URL: upstream.URL})
+		}
+	}
+
+	for name, instances := range serviceMap {
+		if len(instances) == 0 {
+			delete(serviceMap, name)
+			clearSplit(name)
+		}
+	}
+
+	for key, instance := range previous {
+		name, version, _ := splitVersion(key)
+		delete(serviceHealth, key)
+		publish(Event{Type: EventDeregister, Service: name, Version: version, URL: instance.URL})
+	}
+	return nil
+}
+
+//checkStatic check static upstreams against registered instances
+//  registryLock must be held
+func checkStatic(policies map[string]RoutePolicy) error {
+	for name, policy := range policies {
+		for _, upstream := range policy.Upstreams {
+			for _, existing := range serviceMap[name] {
+				if !existing.Static && sameVersion(existing.Version, upstream.Version) {
+					return errors.New("routes." + name + " conflicts with registered version " + upstream.Version)
+				}
+			}
+		}
+	}
+	return nil
+}
@@ -0,0 +1,220 @@
+package service
+
+import (
+	"testing"
+	"time"
+
+	"github.com/spf13/viper"
+)
+
+func setupStatic() {
+	setupServiceRegister()
+	serviceHealth = make(map[string]bool)
+	routePolicies = make(map[string]RoutePolicy)
+}
+
+func TestSyncStaticAddAndRemove(t *testing.T) {
+	setupStatic()
+	var ds DiscoveryService
+
+	policies := map[string]RoutePolicy{
+		"legacy": {Upstreams: []Instance{{URL: "http://legacy", Version: "1.0.0"}}},
+	}
+	index := ds.Index()
+	if err := syncStatic(policies); err != nil {
+		t.Fatal(err)
+	}
+
+	in := serviceMap["legacy"][0]
+	if !in.Static || in.URL != "http://legacy/" {
+		t.Errorf("function returned unexpected instance: got %+v", in)
+	}
+	if ds.Index() != index+1 {
+		t.Errorf("function published unexpected events: got %v want %v", ds.Index()-index, 1)
+	}
+
+	// an unchanged reload publishes nothing
+	index = ds.Index()
+	syncStatic(policies)
+	if ds.Index() != index {
+		t.Errorf("function published unexpected events: got %v want %v", ds.Index()-index, 0)
+	}
+
+	syncStatic(map[string]RoutePolicy{})
+	if _, ok := serviceMap["legacy"]; ok {
+		t.Error("Failed to remove static service")
+	}
+}
+
+func TestSyncStaticConflict(t *testing.T) {
+	setupStatic()
+	serviceMap["test"] = []Instance{{URL: "http://dynamic/", Version: "1.0.0"}}
+
+	err := syncStatic(map[string]RoutePolicy{
+		"test": {Upstreams: []Instance{{URL: "http://static", Version: "1.0.0"}}},
+	})
+	if err == nil {
+		t.Error("Failed Static Conflict")
+	}
+	if len(serviceMap["test"]) != 1 || serviceMap["test"][0].Static {
+		t.Errorf("function modified registry on conflict: got %v", serviceMap["test"])
+	}
+}
+
+func TestDeregisterStaticFail(t *testing.T) {
+	setupStatic()
+	var rs RegistrationService
+	serviceMap["test"] = []Instance{{URL: "http://static/", Version: "1.0.0", Static: true}}
+
+	errorText := "Service is Static"
+	for _, name := range []string{"test", "test@1.0.0"} {
+		err := rs.Deregister(name)
+		if err == nil || err.Error() != errorText {
+			t.Errorf("service returned unexpected error: got %v want %v", err, errorText)
+		}
+	}
+}
+
+func TestRegisterIgnoresStatic(t *testing.T) {
+	setupStatic()
+	var rs RegistrationService
+
+	rs.Register("test", Instance{URL: "http://test", Static: true})
+	if serviceMap["test"][0].Static {
+		t.Error("Failed registered service marked static")
+	}
+}
+
+func TestLoadPoliciesStatic(t *testing.T) {
+	setupStatic()
+	defer viper.Set(routesKey, nil)
+	viper.Set(routesKey, map[string]interface{}{
+		"legacy": map[string]interface{}{
+			"upstreams":    []interface{}{map[string]interface{}{"url": "http://legacy", "tags": []string{"old"}}},
+			"strip_prefix": "/api",
+			"timeout":      "2s",
+			"auth":         "public",
+		},
+	})
+
+	if err := LoadPolicies(); err != nil {
+		t.Fatal(err)
+	}
+	policy := policyFor("legacy")
+	if policy.Timeout.Seconds() != 2 || policy.Auth != AuthPublic || policy.StripPrefix != "/api" {
+		t.Errorf("function returned unexpected policy: got %+v", policy)
+	}
+	if in := serviceMap["legacy"][0]; !in.Static || !in.HasTag("old") {
+		t.Errorf("function returned unexpected instance: got %+v", in)
+	}
+}
+
+func TestValidatePolicyFail(t *testing.T) {
+	tests := []RoutePolicy{
+		{Auth: "basic"},
+		{Timeout: -1},
+		{Rewrite: []RewriteRule{{Match: "("}}},
+		{MaxBodyBytes: -1},
+		{ContentTypes: []string{"json"}},
+		{Schemas: []SchemaRule{{Path: "/a", Mode: "strict"}}},
+		{Schemas: []SchemaRule{{Request: "{}"}}},
+		{Upstreams: []Instance{{}}},
+		{Upstreams: []Instance{{URL: "http://a", Weight: -1}}},
+		{Upstreams: []Instance{{URL: "http://a"}, {URL: "http://b"}}},
+	}
+
+	for i, policy := range tests {
+		if err := validatePolicy(&policy); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestPreparePoliciesKeepsPrevious(t *testing.T) {
+	setupStatic()
+	routePolicies["test"] = RoutePolicy{DefaultVersion: "1"}
+	serviceMap["test"] = []Instance{{URL: "http://dynamic/", Version: "1.0.0"}}
+
+	v := viper.New()
+	v.Set(routesKey, map[string]interface{}{
+		"test": map[string]interface{}{
+			"upstreams": []interface{}{map[string]interface{}{"url": "http://static", "version": "1.0.0"}},
+		},
+	})
+	if _, err := PreparePolicies(v); err == nil {
+		t.Error("Failed Static Conflict")
+	}
+
+	v.Set(routesKey, map[string]interface{}{
+		"test": map[string]interface{}{"default_version": "2"},
+	})
+	apply, err := PreparePolicies(v)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if policyFor("test").DefaultVersion != "1" {
+		t.Error("Failed policies swapped before apply")
+	}
+
+	apply()
+	if policyFor("test").DefaultVersion != "2" {
+		t.Error("Failed to apply policies")
+	}
+}
+
+func TestPreparePoliciesRegisterWaits(t *testing.T) {
+	setupStatic()
+	healthCheck = func(URL string) bool { return true }
+	var rs RegistrationService
+
+	v := viper.New()
+	v.Set(routesKey, map[string]interface{}{
+		"test": map[string]interface{}{
+			"upstreams": []interface{}{map[string]interface{}{"url": "http://static", "version": "1.0.0"}},
+		},
+	})
+
+	// a reload holds registrations between prepare and apply
+	RegisterLock.Lock()
+	apply, err := PreparePolicies(v)
+	if err != nil {
+		RegisterLock.Unlock()
+		t.Fatal(err)
+	}
+
+	done := make(chan error, 1)
+	go func() {
+		done <- rs.Register("test", Instance{URL: "http://dynamic", Version: "1.0.0"})
+	}()
+	select {
+	case err := <-done:
+		t.Errorf("Failed register during reload: got %v", err)
+	case <-time.After(50 * time.Millisecond):
+	}
+
+	apply()
+	RegisterLock.Unlock()
+	if err := <-done; err == nil || err.Error() != "Service Name already Exist" {
+		t.Errorf("function returned unexpected error: got %v want %v", err, "Service Name already Exist")
+	}
+	if in := serviceMap["test"]; len(in) != 1 || !in[0].Static {
+		t.Errorf("function returned unexpected instances: got %+v", in)
+	}
+}
+
+func TestReady(t *testing.T) {
+	setupStatic()
+	registryLoaded = 0
+	if err := Ready(); err == nil {
+		t.Error("Failed registry ready before load")
+	}
+
+	apply, err := PreparePolicies(viper.New())
+	if err != nil {
+		t.Fatal(err)
+	}
+	apply()
+	if err := Ready(); err != nil {
+		t.Errorf("function returned unexpected error: got %v want %v", err, "nil")
+	}
+}
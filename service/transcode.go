@@ -0,0 +1,518 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"net/http"
+	"net/url"
+	"regexp"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/dtan44/SMUG/protobuf"
+	log "github.com/sirupsen/logrus"
+)
+
+var (
+	fieldPathPattern *regexp.Regexp
+	// headers of a REST request that are not sent on as gRPC metadata
+	transcodeSkipHeaders map[string]bool
+	// status codes of gRPC replies to REST clients, by gRPC code
+	grpcHTTPStatus map[int]int
+)
+
+func init() {
+	fieldPathPattern = regexp.MustCompile(`^[A-Za-z_][A-Za-z0-9_]*(\.[A-Za-z_][A-Za-z0-9_]*)*$`)
+	transcodeSkipHeaders = map[string]bool{
+		"Api-Key": true, "Secret-Key": true, "Host": true,
+		"Accept": true, "Accept-Encoding": true, "Content-Type": true, "Content-Length": true,
+		"Content-Encoding": true, "Connection": true, "Keep-Alive": true, "Proxy-Connection": true,
+		"Transfer-Encoding": true, "Upgrade": true, "Te": true, "Trailer": true,
+	}
+	grpcHTTPStatus = map[int]int{
+		0:  http.StatusOK,
+		1:  499,
+		2:  http.StatusInternalServerError,
+		3:  http.StatusBadRequest,
+		4:  http.StatusGatewayTimeout,
+		5:  http.StatusNotFound,
+		6:  http.StatusConflict,
+		7:  http.StatusForbidden,
+		8:  http.StatusTooManyRequests,
+		9:  http.StatusBadRequest,
+		10: http.StatusConflict,
+		11: http.StatusBadRequest,
+		12: http.StatusNotImplemented,
+		13: http.StatusInternalServerError,
+		14: http.StatusServiceUnavailable,
+		15: http.StatusInternalServerError,
+		16: http.StatusUnauthorized,
+	}
+}
+
+//DescriptorSet protobuf descriptors of a gRPC instance
+//  methods annotated with google.api.http are served as REST/JSON routes
+//  and transcoded to gRPC calls, registered as a base64 FileDescriptorSet
+type DescriptorSet struct {
+	raw      []byte
+	bindings []binding
+}
+
+//binding compiled google.api.http rule of a method
+type binding struct {
+	rule     protobuf.HTTPRule
+	method   *protobuf.Method
+	re       *regexp.Regexp
+	vars     []string
+	body     *protobuf.Field
+	response *protobuf.Field
+}
+
+//GRPCError status of a failed gRPC call in JSON
+type GRPCError struct {
+	Code    int    `json:"code"`
+	Message string `json:"message"`
+}
+
+//UnmarshalJSON decode and compile the base64 descriptor set of a registration
+func (ds *DescriptorSet) UnmarshalJSON(data []byte) error {
+	var raw []byte
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return errors.New("invalid descriptor set: must be base64")
+	}
+	parsed, err := parseDescriptorSet(raw)
+	if err != nil {
+		return err
+	}
+	*ds = *parsed
+	return nil
+}
+
+//MarshalJSON write the descriptor set as base64
+func (ds DescriptorSet) MarshalJSON() ([]byte, error) {
+	return json.Marshal(ds.raw)
+}
+
+//parseDescriptorSet parse a FileDescriptorSet and compile its HTTP rules
+//  routes without variables are matched before templated ones
+func parseDescriptorSet(data []byte) (*DescriptorSet, error) {
+	set, err := protobuf.ParseSet(data)
+	if err != nil {
+		return nil, err
+	}
+
+	ds := &DescriptorSet{raw: data}
+	for _, svc := range set.Services {
+		for _, method := range svc.Methods {
+			for _, rule := range method.HTTP {
+				b, err := compileBinding(method, rule)
+				if err != nil {
+					return nil, fmt.Errorf("invalid descriptor set: %s %s", method.FullName(), err.Error())
+				}
+				ds.bindings = append(ds.bindings, b)
+			}
+		}
+	}
+	sort.SliceStable(ds.bindings, func(i, j int) bool {
+		return len(ds.bindings[i].vars) == 0 && len(ds.bindings[j].vars) > 0
+	})
+	return ds, nil
+}
+
+//compileBinding check the fields a rule refers to and compile its path
+func compileBinding(method *protobuf.Method, rule protobuf.HTTPRule) (binding, error) {
+	b := binding{rule: rule, method: method}
+	var err error
+	if b.re, b.vars, err = compileHTTPTemplate(rule.Path); err != nil {
+		return b, err
+	}
+	for _, v := range b.vars {
+		if _, err := method.Input.FieldPath(v); err != nil {
+			return b, err
+		}
+	}
+	if rule.Body != "" && rule.Body != "*" {
+		if b.body = method.Input.Field(rule.Body); b.body == nil {
+			return b, fmt.Errorf("body: unknown field %s", rule.Body)
+		}
+	}
+	if rule.ResponseBody != "" {
+		if b.response = method.Output.Field(rule.ResponseBody); b.response == nil {
+			return b, fmt.Errorf("response_body: unknown field %s", rule.ResponseBody)
+		}
+	}
+	return b, nil
+}
+
+//compileHTTPTemplate regular expression of a google.api.http path
+//  such as /v1/{name=shelves/*/books/*}:publish, * matches one segment,
+//  ** any number and each {field} one segment unless given a pattern,
+//  the captured values belong to the returned field paths in order
+func compileHTTPTemplate(template string) (*regexp.Regexp, []string, error) {
+	if !strings.HasPrefix(template, "/") {
+		return nil, nil, fmt.Errorf("path %s must start with /", template)
+	}
+
+	path, verb := template, ""
+	if i := strings.LastIndex(path, ":"); i > strings.LastIndex(path, "}") && i > strings.LastIndex(path, "/") {
+		path, verb = path[:i], path[i:]
+	}
+
+	var pattern strings.Builder
+	var vars []string
+	pattern.WriteString("^")
+	rest := path[1:]
+	for {
+		pattern.WriteString("/")
+		var segment string
+		if strings.HasPrefix(rest, "{") {
+			end := strings.Index(rest, "}")
+			if end < 0 {
+				return nil, nil, fmt.Errorf("path %s has an unclosed variable", template)
+			}
+			name, segments := rest[1:end], "*"
+			if i := strings.Index(name, "="); i >= 0 {
+				name, segments = name[:i], name[i+1:]
+			}
+			if !fieldPathPattern.MatchString(name) || strings.ContainsAny(segments, "{}") {
+				return nil, nil, fmt.Errorf("path %s has an invalid variable %s", template, rest[:end+1])
+			}
+			pattern.WriteString("(" + segmentsPattern(segments) + ")")
+			vars = append(vars, name)
+			segment, rest = rest[:end+1], rest[end+1:]
+		} else {
+			segment = rest
+			if i := strings.Index(rest, "/"); i >= 0 {
+				segment = rest[:i]
+			}
+			if segment == "" || strings.ContainsAny(segment, "{}") {
+				return nil, nil, fmt.Errorf("path %s has an invalid segment %q", template, segment)
+			}
+			pattern.WriteString(segmentsPattern(segment))
+			rest = rest[len(segment):]
+		}
+
+		if rest == "" {
+			break
+		}
+		if !strings.HasPrefix(rest, "/") {
+			return nil, nil, fmt.Errorf("path %s has an invalid segment %s", template, segment+rest)
+		}
+		rest = rest[1:]
+	}
+	pattern.WriteString(regexp.QuoteMeta(verb) + "$")
+
+	re, err := regexp.Compile(pattern.String())
+	if err != nil {
+		return nil, nil, err
+	}
+	return re, vars, nil
+}
+
+//segmentsPattern pattern of slash separated literals and wildcards
+func segmentsPattern(segments string) string {
+	parts := strings.Split(segments, "/")
+	for i, part := range parts {
+		switch part {
+		case "*":
+			parts[i] = "[^/]+"
+		case "**":
+			parts[i] = ".*"
+		default:
+			parts[i] = regexp.QuoteMeta(part)
+		}
+	}
+	return strings.Join(parts, "/")
+}
+
+//match binding of a request and the escaped values of its variables
+func (ds *DescriptorSet) match(method, path string) (*binding, []string, error) {
+	known := false
+	for i := range ds.bindings {
+		b := &ds.bindings[i]
+		values := b.re.FindStringSubmatch(path)
+		if values == nil {
+			continue
+		}
+		if b.rule.Method != method {
+			known = true
+			continue
+		}
+		return b, values[1:], nil
+	}
+	if known {
+		return nil, nil, ErrMethodNotAllowed
+	}
+	return nil, nil, ErrUnknownPath
+}
+
+//loadDescriptorSet fetch and parse the descriptor set at DescriptorSetURL
+//  relative URLs are resolved against the instance URL
+//  returns *ValidationError when the descriptor set cannot be used
+func loadDescriptorSet(in Instance) (Instance, error) {
+	if in.DescriptorSet != nil || in.DescriptorSetURL == "" {
+		return in, nil
+	}
+
+	setURL, err := url.Parse(in.DescriptorSetURL)
+	if err == nil && !setURL.IsAbs() {
+		var base *url.URL
+		if base, err = url.Parse(normalizeInstance(in).URL); err == nil {
+			setURL = base.ResolveReference(setURL)
+		}
+	}
+	if err != nil {
+		return in, &ValidationError{[]FieldError{{"descriptor_set_url", "must be a valid URL"}}}
+	}
+
+	data, err := fetchSpec(setURL.String())
+	if err != nil {
+		return in, &ValidationError{[]FieldError{{"descriptor_set_url", err.Error()}}}
+	}
+	if in.DescriptorSet, err = parseDescriptorSet(data); err != nil {
+		return in, &ValidationError{[]FieldError{{"descriptor_set_url", err.Error()}}}
+	}
+	return in, nil
+}
+
+//transcode call the gRPC method bound to a REST/JSON request
+//  the request message is built from the body, path and query of the
+//  request as the google.api.http rule of the method says, the reply or
+//  the gRPC status is returned as JSON with the matching HTTP status
+func transcode(r *http.Request, t target) (*http.Response, []byte, error) {
+	policy := policyFor(t.service)
+	b, values, err := t.instance.DescriptorSet.match(r.Method, "/"+policy.rewritePath(t.rawPath))
+	if err != nil {
+		log.Error("Route Error: " + err.Error() + " - " + r.Method + " " + t.service + "/" + t.path)
+		return nil, nil, err
+	}
+	t.operation = &operation{id: b.method.FullName()}
+
+	body, err := readAllFunc(r.Body)
+	if err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+	msg, err := b.message(r, body, values)
+	if err != nil {
+		countOperation(t, http.StatusBadRequest)
+		return nil, nil, err
+	}
+	payload, err := b.method.Input.FromJSON(msg)
+	if err != nil {
+		countOperation(t, http.StatusBadRequest)
+		return nil, nil, fieldError("", err)
+	}
+
+	header := make(map[string]string)
+	for key, vals := range r.Header {
+		if !transcodeSkipHeaders[key] && !strings.HasPrefix(key, "Grpc-") {
+			header[key] = strings.Join(vals, ",")
+		}
+	}
+	header["Content-Type"] = "application/grpc"
+	header["Te"] = "trailers"
+	if policy.Host != "" {
+		header["Host"] = policy.Host
+	}
+	if policy.Timeout > 0 {
+		header["Grpc-Timeout"] = strconv.FormatInt(policy.Timeout.Milliseconds(), 10) + "m"
+	}
+
+	frame := make([]byte, 5, 5+len(payload))
+	binary.BigEndian.PutUint32(frame[1:], uint32(len(payload)))
+	frame = append(frame, payload...)
+
+	client := clientFor(t.instance, policy.Timeout)
+	serviceURL := t.instance.URL + strings.TrimPrefix(b.method.Path(), "/")
+	rsp, data, err := request(serviceURL, http.MethodPost, header, string(frame), client)
+	if err != nil {
+		log.Error("Route Error: " + err.Error())
+		countOperation(t, 0)
+		return rsp, data, err
+	}
+
+	rsp, body = b.reply(rsp, data)
+	countOperation(t, rsp.StatusCode)
+	if body, err = policy.Compression.encode(r, rsp.StatusCode, rsp.Header, body); err != nil {
+		log.Error("Route Error: " + err.Error())
+		return nil, nil, err
+	}
+	if t.instance.Version != "" {
+		rsp.Header.Set(serviceVersionHeader, t.instance.Version)
+	}
+	return rsp, body, nil
+}
+
+//message JSON request message of the binding
+//  path variables take precedence over the body, query parameters set the
+//  fields not bound otherwise and parameters that are no fields are ignored
+func (b *binding) message(r *http.Request, body []byte, values []string) (map[string]interface{}, error) {
+	msg := make(map[string]interface{})
+	if b.rule.Body != "" && len(body) > 0 {
+		var doc interface{}
+		if err := decodeJSON(body, &doc); err != nil {
+			return nil, &ValidationError{[]FieldError{{"body", "must be valid JSON"}}}
+		}
+		if b.body != nil {
+			msg[b.body.JSONName] = doc
+		} else if obj, ok := doc.(map[string]interface{}); ok {
+			msg = obj
+		} else {
+			return nil, &ValidationError{[]FieldError{{"body", "must be an object"}}}
+		}
+	}
+
+	bound := make(map[string]bool)
+	for i, name := range b.vars {
+		value, err := url.PathUnescape(values[i])
+		if err == nil {
+			err = b.method.Input.SetParam(msg, name, []string{value})
+		}
+		if err != nil {
+			return nil, &ValidationError{[]FieldError{{name, err.Error()}}}
+		}
+		bound[name] = true
+	}
+
+	if b.rule.Body == "*" {
+		return msg, nil
+	}
+	for key, vals := range r.URL.Query() {
+		if bound[key] || b.rule.Body != "" && (key == b.rule.Body || strings.HasPrefix(key, b.rule.Body+".")) {
+			continue
+		}
+		if _, err := b.method.Input.FieldPath(key); err != nil {
+			continue
+		}
+		if err := b.method.Input.SetParam(msg, key, vals); err != nil {
+			return nil, fieldError(key, err)
+		}
+	}
+	return msg, nil
+}
+
+//fieldError ValidationError of a message that cannot be encoded
+func fieldError(field string, err error) error {
+	if pe, ok := err.(*protobuf.Error); ok {
+		return &ValidationError{[]FieldError{{pe.Path, pe.Reason}}}
+	}
+	return &ValidationError{[]FieldError{{field, err.Error()}}}
+}
+
+//reply JSON response of a gRPC reply
+//  the reply of a server streaming method is an array of its messages,
+//  a failed call is a GRPCError
+func (b *binding) reply(rsp *http.Response, data []byte) (*http.Response, []byte) {
+	out := &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}
+	for key, vals := range rsp.Header {
+		if key != "Content-Type" && key != "Content-Length" && key != "Trailer" && !strings.HasPrefix(key, "Grpc-") {
+			out.Header[key] = vals
+		}
+	}
+	out.Header.Set("Content-Type", "application/json")
+
+	code, message := grpcStatus(rsp)
+	var doc interface{}
+	if code == 0 {
+		var err error
+		if doc, err = b.decodeReply(data); err != nil {
+			code, message = grpcInternal, err.Error()
+		}
+	}
+	if code != 0 {
+		doc = GRPCError{code, message}
+	}
+	if status, ok := grpcHTTPStatus[code]; ok {
+		out.StatusCode = status
+	} else {
+		out.StatusCode = http.StatusInternalServerError
+	}
+
+	body, err := json.Marshal(doc)
+	if err != nil {
+		out.StatusCode = http.StatusInternalServerError
+		body, _ = json.Marshal(GRPCError{grpcInternal, err.Error()})
+	}
+	return out, body
+}
+
+//decodeReply JSON of the length-prefixed messages of a gRPC reply
+func (b *binding) decodeReply(data []byte) (interface{}, error) {
+	var messages []interface{}
+	for len(data) > 0 {
+		if len(data) < 5 {
+			return nil, errors.New("truncated gRPC message")
+		}
+		if data[0] != 0 {
+			return nil, errors.New("compressed gRPC messages are not supported")
+		}
+		n := binary.BigEndian.Uint32(data[1:5])
+		if uint64(n) > uint64(len(data)-5) {
+			return nil, errors.New("truncated gRPC message")
+		}
+		msg, err := b.method.Output.ToJSON(data[5 : 5+n])
+		if err != nil {
+			return nil, err
+		}
+		if b.response != nil {
+			fields, _ := msg.(map[string]interface{})
+			var ok bool
+			if msg, ok = fields[b.response.JSONName]; !ok {
+				msg = b.response.Default()
+			}
+		}
+		messages = append(messages, msg)
+		data = data[5+n:]
+	}
+
+	if b.method.ServerStreaming {
+		if messages == nil {
+			messages = []interface{}{}
+		}
+		return messages, nil
+	}
+	if len(messages) != 1 {
+		return nil, fmt.Errorf("expected one reply message, got %d", len(messages))
+	}
+	return messages[0], nil
+}
+
+//grpcStatus code and message of a gRPC reply
+//  from the trailers or, for trailers-only replies, the headers,
+//  replies without a status get one by their HTTP status
+func grpcStatus(rsp *http.Response) (int, string) {
+	status, message := rsp.Trailer.Get("Grpc-Status"), rsp.Trailer.Get("Grpc-Message")
+	if status == "" {
+		status, message = rsp.Header.Get("Grpc-Status"), rsp.Header.Get("Grpc-Message")
+	}
+	if status == "" {
+		switch rsp.StatusCode {
+		case http.StatusOK:
+			return grpcUnknown, "missing grpc-status"
+		case http.StatusBadRequest:
+			return grpcInternal, rsp.Status
+		case http.StatusUnauthorized:
+			return grpcUnauthenticated, rsp.Status
+		case http.StatusForbidden:
+			return grpcPermissionDenied, rsp.Status
+		case http.StatusNotFound:
+			return grpcUnimplemented, rsp.Status
+		case http.StatusTooManyRequests, http.StatusBadGateway, http.StatusServiceUnavailable, http.StatusGatewayTimeout:
+			return grpcUnavailable, rsp.Status
+		}
+		return grpcUnknown, rsp.Status
+	}
+
+	code, err := strconv.Atoi(status)
+	if err != nil {
+		return grpcUnknown, "invalid grpc-status " + status
+	}
+	if unescaped, err := url.PathUnescape(message); err == nil {
+		message = unescaped
+	}
+	return code, message
+}
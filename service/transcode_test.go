@@ -0,0 +1,299 @@
+package service
+
+import (
+	"encoding/binary"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"net/http"
+	"strings"
+	"testing"
+
+	"github.com/dtan44/SMUG/protobuf"
+)
+
+//greeterDescriptorSet base64 descriptor set of greeter.proto and its imports
+//  service Greeter {
+//    rpc SayHello(HelloRequest) returns (HelloReply) {
+//      option (google.api.http) = {
+//        get: "/v1/hello/{name}"
+//        additional_bindings { post: "/v1/hello" body: "*" }
+//      };
+//    }
+//    rpc UpdateGreeting(UpdateGreetingRequest) returns (Greeting) {
+//      option (google.api.http) = { patch: "/v1/{greeting.name=greetings/*}" body: "greeting" };
+//    }
+//    rpc ListGreetings(ListGreetingsRequest) returns (stream Greeting) {
+//      option (google.api.http) = { get: "/v1/greetings" response_body: "text" };
+//    }
+//  }
+const greeterDescriptorSet = "CncKH2dvb2dsZS9wcm90b2J1Zi90aW1lc3RhbXAucHJvdG8SD2dvb2dsZS5wcm90b2J1ZmIGcHJvdG8zIjsKCVRpbWVzdGFt" +
+	"cBIYCgdzZWNvbmRzGAEgASgDUgdzZWNvbmRzEhQKBW5hbm9zGAIgASgFUgVuYW5vcwp1Ch5nb29nbGUvcHJvdG9idWYvZHVy" +
+	"YXRpb24ucHJvdG8SD2dvb2dsZS5wcm90b2J1ZmIGcHJvdG8zIjoKCER1cmF0aW9uEhgKB3NlY29uZHMYASABKANSB3NlY29u" +
+	"ZHMSFAoFbmFub3MYAiABKAVSBW5hbm9zCs0EChxnb29nbGUvcHJvdG9idWYvc3RydWN0LnByb3RvEg9nb29nbGUucHJvdG9i" +
+	"dWZiBnByb3RvMyKYAQoGU3RydWN0EjsKBmZpZWxkcxgBIAMoC1IGZmllbGRzMiMuZ29vZ2xlLnByb3RvYnVmLlN0cnVjdC5G" +
+	"aWVsZHNFbnRyeRpRCgtGaWVsZHNFbnRyeRIQCgNrZXkYASABKAlSA2tleRIsCgV2YWx1ZRgCIAEoC1IFdmFsdWUyFi5nb29n" +
+	"bGUucHJvdG9idWYuVmFsdWU6AjgBIp4CCgVWYWx1ZRI5CgpudWxsX3ZhbHVlGAEgASgOUgludWxsVmFsdWUyGi5nb29nbGUu" +
+	"cHJvdG9idWYuTnVsbFZhbHVlEiEKDG51bWJlcl92YWx1ZRgCIAEoAVILbnVtYmVyVmFsdWUSIQoMc3RyaW5nX3ZhbHVlGAMg" +
+	"ASgJUgtzdHJpbmdWYWx1ZRIdCgpib29sX3ZhbHVlGAQgASgIUglib29sVmFsdWUSOgoMc3RydWN0X3ZhbHVlGAUgASgLUgtz" +
+	"dHJ1Y3RWYWx1ZTIXLmdvb2dsZS5wcm90b2J1Zi5TdHJ1Y3QSOQoKbGlzdF92YWx1ZRgGIAEoC1IJbGlzdFZhbHVlMhouZ29v" +
+	"Z2xlLnByb3RvYnVmLkxpc3RWYWx1ZSI7CglMaXN0VmFsdWUSLgoGdmFsdWVzGAEgAygLUgZ2YWx1ZXMyFi5nb29nbGUucHJv" +
+	"dG9idWYuVmFsdWUqGwoJTnVsbFZhbHVlEg4KCk5VTExfVkFMVUUQAAqCCQoNZ3JlZXRlci5wcm90bxIKaGVsbG93b3JsZGIG" +
+	"cHJvdG8zIrwDCgxIZWxsb1JlcXVlc3QSEgoEbmFtZRgBIAEoCVIEbmFtZRIUCgV0aW1lcxgCIAEoBVIFdGltZXMSEAoDaWRz" +
+	"GAMgAygDUgNpZHMSJAoEbW9vZBgEIAEoDlIEbW9vZDIQLmhlbGxvd29ybGQuTW9vZBISCgRsb3VkGAUgASgIUgRsb3VkEjwK" +
+	"BmNvdW50cxgGIAMoC1IGY291bnRzMiQuaGVsbG93b3JsZC5IZWxsb1JlcXVlc3QuQ291bnRzRW50cnkSMwoHc2VudF9hdBgH" +
+	"IAEoC1IGc2VudEF0MhouZ29vZ2xlLnByb3RvYnVmLlRpbWVzdGFtcBIUCgVyYXRpbxgIIAEoAVIFcmF0aW8SFAoFdG9rZW4Y" +
+	"CSABKAxSBXRva2VuEi0KBWV4dHJhGAogASgLUgVleHRyYTIXLmdvb2dsZS5wcm90b2J1Zi5TdHJ1Y3QSLQoEd2FpdBgLIAEo" +
+	"C1IEd2FpdDIZLmdvb2dsZS5wcm90b2J1Zi5EdXJhdGlvbho5CgtDb3VudHNFbnRyeRIQCgNrZXkYASABKAlSA2tleRIUCgV2" +
+	"YWx1ZRgCIAEoBVIFdmFsdWU6AjgBIlsKCkhlbGxvUmVwbHkSGAoHbWVzc2FnZRgBIAEoCVIHbWVzc2FnZRIzCgdzZW50X2F0" +
+	"GAIgASgLUgZzZW50QXQyGi5nb29nbGUucHJvdG9idWYuVGltZXN0YW1wIjIKCEdyZWV0aW5nEhIKBG5hbWUYASABKAlSBG5h" +
+	"bWUSEgoEdGV4dBgCIAEoCVIEdGV4dCJJChVVcGRhdGVHcmVldGluZ1JlcXVlc3QSMAoIZ3JlZXRpbmcYASABKAtSCGdyZWV0" +
+	"aW5nMhQuaGVsbG93b3JsZC5HcmVldGluZyIzChRMaXN0R3JlZXRpbmdzUmVxdWVzdBIbCglwYWdlX3NpemUYASABKAVSCHBh" +
+	"Z2VTaXplKjMKBE1vb2QSFAoQTU9PRF9VTlNQRUNJRklFRBAAEgkKBUhBUFBZEAESCgoGR1JVTVBZEAIy1wIKB0dyZWV0ZXIS" +
+	"ZgoIU2F5SGVsbG8SGC5oZWxsb3dvcmxkLkhlbGxvUmVxdWVzdBoWLmhlbGxvd29ybGQuSGVsbG9SZXBseSIogtPkkwIiEhAv" +
+	"djEvaGVsbG8ve25hbWV9Wg4iCS92MS9oZWxsbzoBKhJ8Cg5VcGRhdGVHcmVldGluZxIhLmhlbGxvd29ybGQuVXBkYXRlR3Jl" +
+	"ZXRpbmdSZXF1ZXN0GhQuaGVsbG93b3JsZC5HcmVldGluZyIxgtPkkwIrMh8vdjEve2dyZWV0aW5nLm5hbWU9Z3JlZXRpbmdz" +
+	"Lyp9OghncmVldGluZxJmCg1MaXN0R3JlZXRpbmdzEiAuaGVsbG93b3JsZC5MaXN0R3JlZXRpbmdzUmVxdWVzdBoULmhlbGxv" +
+	"d29ybGQuR3JlZXRpbmciG4LT5JMCFRINL3YxL2dyZWV0aW5nc2IEdGV4dDAB"
+
+//newGreeter gRPC test server of the Greeter service
+func newGreeter(t *testing.T) *DescriptorSet {
+	var ds DescriptorSet
+	if err := json.Unmarshal([]byte(`"`+greeterDescriptorSet+`"`), &ds); err != nil {
+		t.Fatal(err)
+	}
+	set, _ := protobuf.ParseSet(ds.raw)
+	messages := set.Messages
+
+	reply := func(w http.ResponseWriter, name string, val interface{}) {
+		b, err := messages[name].FromJSON(val)
+		if err != nil {
+			t.Error(err)
+		}
+		frame := make([]byte, 5)
+		binary.BigEndian.PutUint32(frame[1:], uint32(len(b)))
+		w.Write(append(frame, b...))
+	}
+
+	upstream := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		data, _ := io.ReadAll(r.Body)
+		if r.Header.Get("Content-Type") != "application/grpc" || r.Header.Get("Api-Key") != "" || len(data) < 5 {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Content-Type", "application/grpc")
+		w.Header().Set("X-Request-Id", r.Header.Get("X-Request-Id"))
+
+		var req map[string]interface{}
+		switch r.URL.Path {
+		case "/helloworld.Greeter/SayHello":
+			val, _ := messages["helloworld.HelloRequest"].ToJSON(data[5:])
+			req = val.(map[string]interface{})
+			if req["name"] == "missing" {
+				w.Header().Set("Grpc-Status", "5")
+				w.Header().Set("Grpc-Message", "no%20such%20greeting")
+				return
+			}
+			message := fmt.Sprint("hello ", req["name"])
+			for _, key := range []string{"times", "mood"} {
+				if val, ok := req[key]; ok {
+					message += fmt.Sprint(" ", val)
+				}
+			}
+			reply(w, "helloworld.HelloReply", map[string]interface{}{"message": message})
+		case "/helloworld.Greeter/UpdateGreeting":
+			val, _ := messages["helloworld.UpdateGreetingRequest"].ToJSON(data[5:])
+			reply(w, "helloworld.Greeting", val.(map[string]interface{})["greeting"])
+		case "/helloworld.Greeter/ListGreetings":
+			reply(w, "helloworld.Greeting", map[string]interface{}{"name": "a", "text": "hi"})
+			reply(w, "helloworld.Greeting", map[string]interface{}{"name": "b", "text": "hey"})
+		}
+		w.Header().Set(http.TrailerPrefix+"Grpc-Status", "0")
+	}))
+	t.Cleanup(upstream.Close)
+
+	serviceMap["greeter"] = []Instance{{URL: upstream.URL + "/", Version: "1.0.0", Protocol: ProtocolGRPC, DescriptorSet: &ds}}
+	return &ds
+}
+
+func TestCompileHTTPTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		vars     []string
+		values   []string
+	}{
+		{"/v1/hello/{name}", "/v1/hello/world", []string{"name"}, []string{"world"}},
+		{"/v1/hello/{name}", "/v1/hello/a/b", nil, nil},
+		{"/v1/{name=shelves/*/books/*}", "/v1/shelves/1/books/2", []string{"name"}, []string{"shelves/1/books/2"}},
+		{"/v1/{book.id}:publish", "/v1/7:publish", []string{"book.id"}, []string{"7"}},
+		{"/v1/{book.id}:publish", "/v1/7", nil, nil},
+		{"/v1/files/{path=**}", "/v1/files/a/b/c.txt", []string{"path"}, []string{"a/b/c.txt"}},
+		{"/v1/*/items", "/v1/any/items", nil, []string{}},
+	}
+
+	for _, test := range tests {
+		re, vars, err := compileHTTPTemplate(test.template)
+		if err != nil {
+			t.Errorf("compileHTTPTemplate returned an error for %v: %v", test.template, err)
+			continue
+		}
+		values := re.FindStringSubmatch(test.path)
+		if values == nil && test.values != nil || values != nil && test.values == nil {
+			t.Errorf("compileHTTPTemplate %v matched %v wrongly: got %v want %v", test.template, test.path, values, test.values)
+			continue
+		}
+		if values != nil && (fmt.Sprint(values[1:]) != fmt.Sprint(test.values) || len(test.vars) > 0 && fmt.Sprint(vars) != fmt.Sprint(test.vars)) {
+			t.Errorf("compileHTTPTemplate returned wrong values: got %v %v want %v %v", vars, values[1:], test.vars, test.values)
+		}
+	}
+
+	for _, template := range []string{"v1/hello", "/v1/{name", "/v1/{na-me}", "/v1//x", "/v1/{a}{b}"} {
+		if _, _, err := compileHTTPTemplate(template); err == nil {
+			t.Errorf("compileHTTPTemplate accepted an invalid template %v", template)
+		}
+	}
+}
+
+func TestDescriptorSetJSON(t *testing.T) {
+	var in Instance
+	body := `{"URL":"http://a","protocol":"grpc","descriptor_set":"` + greeterDescriptorSet + `"}`
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		t.Fatal(err)
+	}
+	if in.DescriptorSet == nil || len(in.DescriptorSet.bindings) != 4 {
+		t.Fatalf("function decoded unexpected descriptor set: got %+v", in.DescriptorSet)
+	}
+	// literal routes are matched first
+	if b := in.DescriptorSet.bindings[0]; b.rule.Path != "/v1/hello" {
+		t.Errorf("function ordered bindings wrongly: got %v first", b.rule.Path)
+	}
+	j, _ := json.Marshal(in)
+	if !strings.Contains(string(j), `"descriptor_set":"`+greeterDescriptorSet+`"`) {
+		t.Errorf("function encoded unexpected descriptor set: got %s", j)
+	}
+
+	for _, set := range []string{`"%%"`, `"CgE="`, `1`} {
+		if err := json.Unmarshal([]byte(`{"URL":"http://a","descriptor_set":`+set+`}`), &in); err == nil {
+			t.Errorf("function accepted an invalid descriptor set %v", set)
+		}
+	}
+}
+
+func TestValidateInstanceDescriptorSet(t *testing.T) {
+	ds := &DescriptorSet{}
+	tests := []struct {
+		in       Instance
+		expected string
+	}{
+		{Instance{URL: "http://a", Protocol: ProtocolGRPC, DescriptorSet: ds}, ""},
+		{Instance{URL: "http://a", Protocol: "H2C", DescriptorSetURL: "greeter.pb"}, ""},
+		{Instance{URL: "https://a", Protocol: ProtocolH2, DescriptorSet: ds}, ""},
+		{Instance{URL: "http://a", DescriptorSet: ds}, "Invalid Fields - descriptor_set: requires protocol grpc, h2c or h2"},
+		{Instance{URL: "https://a", Protocol: ProtocolHTTPS, DescriptorSetURL: "greeter.pb"}, "Invalid Fields - descriptor_set_url: requires protocol grpc, h2c or h2"},
+	}
+
+	for _, test := range tests {
+		err := validateInstance(test.in)
+		if (err == nil && test.expected != "") || (err != nil && err.Error() != test.expected) {
+			t.Errorf("service returned unexpected error for %v: got %v want %v", test.in.Protocol, err, test.expected)
+		}
+	}
+}
+
+func TestLoadDescriptorSet(t *testing.T) {
+	var fetched string
+	fetchSpec = func(setURL string) ([]byte, error) {
+		fetched = setURL
+		if setURL == "http://host/missing" {
+			return nil, errors.New("not found")
+		}
+		var raw []byte
+		json.Unmarshal([]byte(`"`+greeterDescriptorSet+`"`), &raw)
+		return raw, nil
+	}
+	defer func() { fetchSpec = fetchSpecURL }()
+
+	in, err := loadDescriptorSet(Instance{URL: "http://host/api", DescriptorSetURL: "greeter.pb"})
+	if err != nil || in.DescriptorSet == nil {
+		t.Fatalf("function returned unexpected result: got %v %v", in.DescriptorSet, err)
+	}
+	if fetched != "http://host/api/greeter.pb" {
+		t.Errorf("function fetched unexpected URL: got %v want %v", fetched, "http://host/api/greeter.pb")
+	}
+
+	_, err = loadDescriptorSet(Instance{URL: "http://host/", DescriptorSetURL: "/missing"})
+	if ve, ok := err.(*ValidationError); !ok || ve.Fields[0].Field != "descriptor_set_url" {
+		t.Errorf("function returned unexpected error: got %v", err)
+	}
+}
+
+func TestDiscoveryRouteTranscode(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	newGreeter(t)
+
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		status   int
+		expected string
+	}{
+		{"GET", "/service/greeter/v1/hello/wor%20ld?times=2&api_key=k", "", http.StatusOK, `{"message":"hello wor ld 2"}`},
+		{"POST", "/service/greeter/v1/hello?times=9", `{"name":"json","mood":"HAPPY"}`, http.StatusOK, `{"message":"hello json HAPPY"}`},
+		{"PATCH", "/service/greeter/v1/greetings/1", `{"text":"hi","name":"ignored"}`, http.StatusOK, `{"name":"greetings/1","text":"hi"}`},
+		{"GET", "/service/greeter/v1/greetings?page_size=2", "", http.StatusOK, `["hi","hey"]`},
+		{"GET", "/service/greeter/v1/hello/missing", "", http.StatusNotFound, `{"code":5,"message":"no such greeting"}`},
+	}
+
+	var ds DiscoveryService
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		r.Header.Set("Api-Key", "key")
+		r.Header.Set("X-Request-Id", "abc")
+		rsp, body, err := ds.Route(r)
+		if err != nil {
+			t.Errorf("Route returned an error for %v %v: %v", test.method, test.target, err)
+			continue
+		}
+		if rsp.StatusCode != test.status || string(body) != test.expected {
+			t.Errorf("Route returned wrong response for %v %v: got %v %s want %v %s", test.method, test.target, rsp.StatusCode, body, test.status, test.expected)
+		}
+		if rsp.Header.Get("Content-Type") != "application/json" || rsp.Header.Get("X-Request-Id") != "abc" || rsp.Header.Get("Grpc-Status") != "" {
+			t.Errorf("Route returned wrong headers: got %v", rsp.Header)
+		}
+		if rsp.Header.Get(serviceVersionHeader) != "1.0.0" {
+			t.Errorf("Route returned wrong version header: got %v want %v", rsp.Header.Get(serviceVersionHeader), "1.0.0")
+		}
+	}
+}
+
+func TestDiscoveryRouteTranscodeFail(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	newGreeter(t)
+
+	tests := []struct {
+		method   string
+		target   string
+		body     string
+		expected string
+	}{
+		{"POST", "/service/greeter/v1/hello", `{"times":"x"}`, "Invalid Fields - times: must be a 32 bit integer"},
+		{"POST", "/service/greeter/v1/hello", `{"nmae":"x"}`, "Invalid Fields - nmae: unknown field"},
+		{"POST", "/service/greeter/v1/hello", `[1]`, "Invalid Fields - body: must be an object"},
+		{"GET", "/service/greeter/v1/hello/x?loud=maybe", "", "Invalid Fields - loud: must be a bool"},
+		{"DELETE", "/service/greeter/v1/hello/world", "", ErrMethodNotAllowed.Error()},
+		{"GET", "/service/greeter/v2/hello", "", ErrUnknownPath.Error()},
+	}
+
+	var ds DiscoveryService
+	for _, test := range tests {
+		r, _ := http.NewRequest(test.method, test.target, strings.NewReader(test.body))
+		if _, _, err := ds.Route(r); err == nil || err.Error() != test.expected {
+			t.Errorf("Route returned wrong error for %v %v: got %v want %v", test.method, test.target, err, test.expected)
+		}
+	}
+}
@@ -0,0 +1,352 @@
+package service
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"net/url"
+	"strings"
+	"text/template"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Response body conversions
+const (
+	ConvertJSONToXML = "json-to-xml"
+	ConvertXMLToJSON = "xml-to-json"
+)
+
+const (
+	defaultXMLRoot = "response"
+)
+
+type claimsKey struct{}
+
+//Transform declarative rewrites of the requests and responses of a route
+type Transform struct {
+	Request  RequestTransform  `mapstructure:"request"`
+	Response ResponseTransform `mapstructure:"response"`
+}
+
+//RequestTransform rewrites applied before a request is sent upstream
+//  header and field values are templates, see transformData
+//  fields are dotted paths into a JSON object body
+type RequestTransform struct {
+	SetHeaders    []Template `mapstructure:"set_headers"`
+	RemoveHeaders []string   `mapstructure:"remove_headers"`
+	SetFields     []Template `mapstructure:"set_fields"`
+	RemoveFields  []string   `mapstructure:"remove_fields"`
+}
+
+//ResponseTransform rewrites applied to an upstream response
+//  Remap builds a new JSON body from fields of the upstream body
+//  Convert is json-to-xml or xml-to-json, XMLRoot names the root element
+//  of converted XML, by default the single key of the body or response
+type ResponseTransform struct {
+	SetHeaders    []Template `mapstructure:"set_headers"`
+	RemoveHeaders []string   `mapstructure:"remove_headers"`
+	Remap         []Mapping  `mapstructure:"remap"`
+	Convert       string     `mapstructure:"convert"`
+	XMLRoot       string     `mapstructure:"xml_root"`
+}
+
+//Template named value rendered with text/template
+//  a list is used instead of a map since config keys are lowercased
+type Template struct {
+	Name  string `mapstructure:"name"`
+	Value string `mapstructure:"value"`
+
+	tmpl *template.Template
+}
+
+//Mapping copy the field at From to To, both dotted paths
+type Mapping struct {
+	From string `mapstructure:"from"`
+	To   string `mapstructure:"to"`
+}
+
+//transformData values available to templates
+//  {{.Params.id}} named captures of the matching rewrite rule
+//  {{.Header.Authorization}} request headers by canonical name
+//  {{.Claims.key}} claims of the authenticated caller, see WithClaims
+type transformData struct {
+	Service string
+	Version string
+	Params  map[string]string
+	Query   map[string]string
+	Header  map[string]string
+	Claims  map[string]interface{}
+}
+
+//WithClaims attach the claims of an authenticated caller to a request context
+//  the key check attaches key, the strongest key presented, and auth, the
+//  policy it was checked against
+func WithClaims(ctx context.Context, claims map[string]interface{}) context.Context {
+	return context.WithValue(ctx, claimsKey{}, claims)
+}
+
+//compileTransform validate a transform and parse its templates
+func compileTransform(t *Transform) error {
+	if err := compileTemplates("request.set_headers", t.Request.SetHeaders); err != nil {
+		return err
+	}
+	if err := compileTemplates("request.set_fields", t.Request.SetFields); err != nil {
+		return err
+	}
+	if err := compileTemplates("response.set_headers", t.Response.SetHeaders); err != nil {
+		return err
+	}
+
+	for i, m := range t.Response.Remap {
+		if m.From == "" || m.To == "" {
+			return fmt.Errorf("response.remap %d: from and to are required", i)
+		}
+	}
+
+	switch t.Response.Convert {
+	case "", ConvertJSONToXML, ConvertXMLToJSON:
+	default:
+		return fmt.Errorf("response.convert: unsupported conversion %s", t.Response.Convert)
+	}
+	return nil
+}
+
+func compileTemplates(key string, templates []Template) error {
+	for i := range templates {
+		if templates[i].Name == "" {
+			return fmt.Errorf("%s %d: name is required", key, i)
+		}
+		tmpl, err := template.New(templates[i].Name).Option("missingkey=zero").Parse(templates[i].Value)
+		if err != nil {
+			return fmt.Errorf("%s %d: %s", key, i, err.Error())
+		}
+		templates[i].tmpl = tmpl
+	}
+	return nil
+}
+
+func (t Template) render(data transformData) (string, error) {
+	if t.tmpl == nil {
+		return t.Value, nil
+	}
+	var buf bytes.Buffer
+	if err := t.tmpl.Execute(&buf, data); err != nil {
+		return "", err
+	}
+	return buf.String(), nil
+}
+
+//newTransformData collect the template values of a routed request
+func newTransformData(r *http.Request, t target, policy RoutePolicy) transformData {
+	data := transformData{
+		Service: t.service,
+		Version: t.instance.Version,
+		Params:  policy.pathParams(t.rawPath),
+		Query:   make(map[string]string),
+		Header:  make(map[string]string),
+	}
+	for key, vals := range r.URL.Query() {
+		data.Query[key] = strings.Join(vals, ",")
+	}
+	for key, vals := range r.Header {
+		data.Header[key] = strings.Join(vals, ",")
+	}
+	if claims, ok := r.Context().Value(claimsKey{}).(map[string]interface{}); ok {
+		data.Claims = claims
+	}
+	return data
+}
+
+//apply the request transform to the outgoing header and body
+//  field rewrites are skipped when the body is not a JSON object
+func (t RequestTransform) apply(data transformData, header map[string]string, body []byte) ([]byte, error) {
+	for _, name := range t.RemoveHeaders {
+		delete(header, http.CanonicalHeaderKey(name))
+	}
+	for _, h := range t.SetHeaders {
+		val, err := h.render(data)
+		if err != nil {
+			return nil, err
+		}
+		header[http.CanonicalHeaderKey(h.Name)] = val
+	}
+
+	if len(t.SetFields) == 0 && len(t.RemoveFields) == 0 {
+		return body, nil
+	}
+
+	var doc map[string]interface{}
+	if err := decodeJSON(body, &doc); err != nil || doc == nil {
+		log.WithFields(log.Fields{"service": data.Service}).Error("Transform Error: request body is not a JSON object")
+		return body, nil
+	}
+
+	for _, field := range t.RemoveFields {
+		removeField(doc, field)
+	}
+	for _, f := range t.SetFields {
+		val, err := f.render(data)
+		if err != nil {
+			return nil, err
+		}
+		setField(doc, f.Name, val)
+	}
+	return json.Marshal(doc)
+}
+
+//apply the response transform to an upstream response
+//  bodies that cannot be decoded are passed through unchanged
+func (t ResponseTransform) apply(data transformData, header http.Header, body []byte) ([]byte, error) {
+	for _, name := range t.RemoveHeaders {
+		header.Del(name)
+	}
+	for _, h := range t.SetHeaders {
+		val, err := h.render(data)
+		if err != nil {
+			return nil, err
+		}
+		header.Set(h.Name, val)
+	}
+
+	if !t.rewritesBody() {
+		return body, nil
+	}
+
+	var doc interface{}
+	var err error
+	if t.Convert == ConvertXMLToJSON {
+		doc, err = decodeXML(body)
+	} else {
+		err = decodeJSON(body, &doc)
+	}
+	if err != nil {
+		log.WithFields(log.Fields{"service": data.Service}).Error("Transform Error: " + err.Error())
+		return body, nil
+	}
+
+	if len(t.Remap) > 0 {
+		doc = remap(doc, t.Remap)
+	}
+
+	var out []byte
+	if t.Convert == ConvertJSONToXML {
+		out, err = encodeXML(t.XMLRoot, doc)
+		header.Set("Content-Type", "application/xml")
+	} else {
+		out, err = json.Marshal(doc)
+		header.Set("Content-Type", "application/json")
+	}
+	if err != nil {
+		return nil, err
+	}
+	header.Del("Content-Length")
+	return out, nil
+}
+
+//rewritesBody check if the transform replaces the response body
+func (t ResponseTransform) rewritesBody() bool {
+	return len(t.Remap) > 0 || t.Convert != ""
+}
+
+//decodeJSON decode data keeping numbers as written
+func decodeJSON(data []byte, v interface{}) error {
+	d := json.NewDecoder(bytes.NewReader(data))
+	d.UseNumber()
+	return d.Decode(v)
+}
+
+//remap build a new JSON object from the mapped fields of doc
+//  fields missing from doc are left out
+func remap(doc interface{}, mappings []Mapping) interface{} {
+	out := make(map[string]interface{})
+	for _, m := range mappings {
+		if val, ok := getField(doc, m.From); ok {
+			setField(out, m.To, val)
+		}
+	}
+	return out
+}
+
+//getField look up a dotted path, array elements are addressed by index
+func getField(doc interface{}, path string) (interface{}, bool) {
+	cur := doc
+	for _, part := range strings.Split(path, ".") {
+		switch node := cur.(type) {
+		case map[string]interface{}:
+			val, ok := node[part]
+			if !ok {
+				return nil, false
+			}
+			cur = val
+		case []interface{}:
+			var i int
+			if _, err := fmt.Sscanf(part, "%d", &i); err != nil || i < 0 || i >= len(node) {
+				return nil, false
+			}
+			cur = node[i]
+		default:
+			return nil, false
+		}
+	}
+	return cur, true
+}
+
+//setField set a dotted path, creating intermediate objects
+func setField(doc map[string]interface{}, path string, val interface{}) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part].(map[string]interface{})
+		if !ok {
+			next = make(map[string]interface{})
+			cur[part] = next
+		}
+		cur = next
+	}
+	cur[parts[len(parts)-1]] = val
+}
+
+//removeField delete a dotted path if present
+func removeField(doc map[string]interface{}, path string) {
+	parts := strings.Split(path, ".")
+	cur := doc
+	for _, part := range parts[:len(parts)-1] {
+		next, ok := cur[part].(map[string]interface{})
+		if !ok {
+			return
+		}
+		cur = next
+	}
+	delete(cur, parts[len(parts)-1])
+}
+
+//pathParams named captures of the rewrite rule matching path
+//  values are unescaped
+func (policy RoutePolicy) pathParams(path string) map[string]string {
+	params := make(map[string]string)
+	path = policy.stripPrefix("/" + path)
+	for _, rule := range policy.Rewrite {
+		if rule.re == nil {
+			continue
+		}
+		match := rule.re.FindStringSubmatch(path)
+		if match == nil {
+			continue
+		}
+		for i, name := range rule.re.SubexpNames() {
+			if name == "" {
+				continue
+			}
+			if val, err := url.PathUnescape(match[i]); err == nil {
+				params[name] = val
+			} else {
+				params[name] = match[i]
+			}
+		}
+		break
+	}
+	return params
+}
@@ -0,0 +1,172 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+)
+
+func transformPolicy(policy RoutePolicy) RoutePolicy {
+	if err := validatePolicy(&policy); err != nil {
+		panic(err)
+	}
+	return policy
+}
+
+func TestRequestTransform(t *testing.T) {
+	policy := transformPolicy(RoutePolicy{
+		Rewrite: []RewriteRule{{Match: `^/orders/(?P<id>[^/]+)$`, Replace: "/order"}},
+		Transform: Transform{Request: RequestTransform{
+			SetHeaders:    []Template{{Name: "X-Caller", Value: "{{.Claims.key}}"}, {Name: "x-order", Value: "{{.Params.id}}"}},
+			RemoveHeaders: []string{"cookie"},
+			SetFields:     []Template{{Name: "order.id", Value: "{{.Params.id}}"}, {Name: "source", Value: "{{.Query.src}}"}},
+			RemoveFields:  []string{"internal", "order.secret"},
+		}},
+	})
+
+	req, _ := http.NewRequest("POST", "/service/test/orders/a%2F1?src=web", nil)
+	req = req.WithContext(WithClaims(req.Context(), map[string]interface{}{"key": AuthAPIKey}))
+	data := newTransformData(req, target{service: "test", rawPath: "orders/a%2F1"}, policy)
+
+	header := map[string]string{"Cookie": "a=b", "Accept": "*/*"}
+	body, err := policy.Transform.Request.apply(data, header,
+		[]byte(`{"internal":true,"order":{"secret":"x","qty":2}}`))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expectedHeader := map[string]string{"Accept": "*/*", "X-Caller": "api-key", "X-Order": "a/1"}
+	if len(header) != len(expectedHeader) {
+		t.Errorf("function returned unexpected header: got %v want %v", header, expectedHeader)
+	}
+	for key, val := range expectedHeader {
+		if header[key] != val {
+			t.Errorf("function returned unexpected header %v: got %v want %v", key, header[key], val)
+		}
+	}
+
+	expected := `{"order":{"id":"a/1","qty":2},"source":"web"}`
+	if string(body) != expected {
+		t.Errorf("function returned unexpected body: got %v want %v", string(body), expected)
+	}
+}
+
+func TestRequestTransformNotJSON(t *testing.T) {
+	transform := RequestTransform{RemoveFields: []string{"a"}}
+	body, err := transform.apply(transformData{}, map[string]string{}, []byte("a=1"))
+	if err != nil || string(body) != "a=1" {
+		t.Errorf("function returned unexpected body: got %v %v want %v", string(body), err, "a=1")
+	}
+}
+
+func TestResponseTransform(t *testing.T) {
+	tests := []struct {
+		transform   ResponseTransform
+		body        string
+		expected    string
+		contentType string
+	}{
+		{ResponseTransform{}, `not json`, `not json`, ""},
+		{ResponseTransform{Remap: []Mapping{{From: "data.items.0.name", To: "first"}, {From: "data.total", To: "meta.count"}, {From: "missing", To: "gone"}}},
+			`{"data":{"items":[{"name":"a"},{"name":"b"}],"total":2}}`, `{"first":"a","meta":{"count":2}}`, "application/json"},
+		{ResponseTransform{Convert: ConvertXMLToJSON},
+			`<?xml version="1.0"?><order id="7"><item>a</item><item>b</item><note lang="en">hi</note></order>`,
+			`{"order":{"@id":"7","item":["a","b"],"note":{"#text":"hi","@lang":"en"}}}`, "application/json"},
+		{ResponseTransform{Convert: ConvertXMLToJSON, Remap: []Mapping{{From: "order.@id", To: "id"}}},
+			`<order id="7"><item>a</item></order>`, `{"id":"7"}`, "application/json"},
+		{ResponseTransform{Convert: ConvertJSONToXML},
+			`{"order":{"@id":7,"item":["a","b"],"total":1.5}}`,
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<order id="7"><item>a</item><item>b</item><total>1.5</total></order>`, "application/xml"},
+		{ResponseTransform{Convert: ConvertJSONToXML, XMLRoot: "result"},
+			`[1,"<x>"]`,
+			`<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<result><item>1</item><item>&lt;x&gt;</item></result>`, "application/xml"},
+		{ResponseTransform{Convert: ConvertXMLToJSON}, `{"not":"xml"}`, `{"not":"xml"}`, ""},
+	}
+
+	for i, test := range tests {
+		transform := transformPolicy(RoutePolicy{Transform: Transform{Response: test.transform}}).Transform.Response
+		header := http.Header{"Content-Length": {"1"}}
+		body, err := transform.apply(transformData{}, header, []byte(test.body))
+		if err != nil {
+			t.Fatalf("case %d: %v", i, err)
+		}
+		if string(body) != test.expected {
+			t.Errorf("case %d: got %v want %v", i, string(body), test.expected)
+		}
+		if got := header.Get("Content-Type"); got != test.contentType {
+			t.Errorf("case %d content type: got %v want %v", i, got, test.contentType)
+		}
+	}
+}
+
+func TestXMLRoundTrip(t *testing.T) {
+	doc := `<?xml version="1.0" encoding="UTF-8"?>` + "\n" + `<a x="1"><b>2</b><b>3</b><c><d>4</d></c></a>`
+	decoded, err := decodeXML([]byte(doc))
+	if err != nil {
+		t.Fatal(err)
+	}
+	encoded, err := encodeXML("", decoded)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if string(encoded) != doc {
+		t.Errorf("function returned unexpected document: got %v want %v", string(encoded), doc)
+	}
+}
+
+func TestCompileTransformFail(t *testing.T) {
+	tests := []Transform{
+		{Request: RequestTransform{SetHeaders: []Template{{Value: "a"}}}},
+		{Request: RequestTransform{SetFields: []Template{{Name: "a", Value: "{{.Params"}}}},
+		{Response: ResponseTransform{SetHeaders: []Template{{Name: "a", Value: "{{end}}"}}}},
+		{Response: ResponseTransform{Remap: []Mapping{{From: "a"}}}},
+		{Response: ResponseTransform{Convert: "yaml"}},
+	}
+
+	for i, transform := range tests {
+		if err := compileTransform(&transform); err == nil {
+			t.Errorf("case %d: expected error", i)
+		}
+	}
+}
+
+func TestDiscoveryRouteTransform(t *testing.T) {
+	setupVersionRoute()
+	routePolicies["test"] = transformPolicy(RoutePolicy{Transform: Transform{
+		Request:  RequestTransform{SetHeaders: []Template{{Name: "X-Version", Value: "{{.Version}}"}}},
+		Response: ResponseTransform{Convert: ConvertXMLToJSON, SetHeaders: []Template{{Name: "X-Service", Value: "{{.Service}}"}}},
+	}})
+	var ds DiscoveryService
+
+	var sentHeader map[string]string
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		sentHeader = headers
+		return &http.Response{Header: http.Header{"Content-Type": {"text/xml"}}}, []byte(`<ok>yes</ok>`), nil
+	}
+
+	req := http.Request{}
+	req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test@1/check")
+	req.Body = readCloserMock{bytes.NewBufferString("")}
+	req.Method = http.MethodGet
+	req.Header = http.Header{}
+
+	rsp, body, err := ds.Route(&req)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if sentHeader["X-Version"] != "1.4.0" {
+		t.Errorf("service sent unexpected header: got %v want %v", sentHeader["X-Version"], "1.4.0")
+	}
+
+	var doc map[string]string
+	if err := json.Unmarshal(body, &doc); err != nil || doc["ok"] != "yes" {
+		t.Errorf("service returned unexpected body: got %v", string(body))
+	}
+	if rsp.Header.Get("X-Service") != "test" || rsp.Header.Get("Content-Type") != "application/json" {
+		t.Errorf("service returned unexpected header: got %v", rsp.Header)
+	}
+}
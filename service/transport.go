@@ -0,0 +1,166 @@
+package service
+
+import (
+	"context"
+	"crypto/tls"
+	"net/http"
+	"net/http/httputil"
+	"net/url"
+	"strconv"
+	"strings"
+	"time"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Instance protocols
+const (
+	ProtocolHTTP  = "http"
+	ProtocolHTTPS = "https"
+	ProtocolH2C   = "h2c"
+	ProtocolH2    = "h2"
+	ProtocolGRPC  = "grpc"
+	ProtocolTCP   = "tcp"
+	ProtocolUDP   = "udp"
+)
+
+// gRPC status codes sent by the gateway
+const (
+	grpcUnknown          = 2
+	grpcDeadlineExceeded = 4
+	grpcPermissionDenied = 7
+	grpcUnimplemented    = 12
+	grpcInternal         = 13
+	grpcUnavailable      = 14
+	grpcUnauthenticated  = 16
+)
+
+var (
+	h2cTransport *http.Transport
+	h2Transport  *http.Transport
+)
+
+func init() {
+	var h2c http.Protocols
+	h2c.SetUnencryptedHTTP2(true)
+	h2cTransport = &http.Transport{Protocols: &h2c}
+
+	var h2 http.Protocols
+	h2.SetHTTP2(true)
+	h2Transport = &http.Transport{Protocols: &h2, TLSClientConfig: &tls.Config{}}
+}
+
+//StreamInterface defines streaming proxy methods
+type StreamInterface interface {
+	Stream(w http.ResponseWriter, r *http.Request)
+}
+
+//IsGRPC check if r is a gRPC request
+func IsGRPC(r *http.Request) bool {
+	return strings.HasPrefix(r.Header.Get("Content-Type"), "application/grpc")
+}
+
+//transportFor transport selected by the protocol of an instance
+//  h2c speaks HTTP/2 without TLS, h2 requires HTTP/2 over TLS and grpc is
+//  either, by the scheme of the URL, nil picks the default transport
+func transportFor(in Instance) http.RoundTripper {
+	switch in.Protocol {
+	case ProtocolH2C:
+		return h2cTransport
+	case ProtocolH2:
+		return h2Transport
+	case ProtocolGRPC:
+		if strings.HasPrefix(in.URL, "https://") {
+			return h2Transport
+		}
+		return h2cTransport
+	}
+	return nil
+}
+
+//clientFor client for requests to an instance
+//  nil when the default client will do
+func clientFor(in Instance, timeout time.Duration) clientInterface {
+	transport := transportFor(in)
+	if transport == nil && timeout <= 0 {
+		return nil
+	}
+	return &http.Client{Transport: transport, Timeout: timeout}
+}
+
+//Stream proxy a request to its service without buffering
+//  bodies are streamed in both directions and trailers, such as grpc-status,
+//  are passed through, errors are reported to gRPC clients as a status
+//  the route timeout is the deadline of the whole stream
+//  the target attached by Resolve is used, as in Route
+func (ds DiscoveryService) Stream(w http.ResponseWriter, r *http.Request) {
+	t, err := ds.resolve(r)
+	if err != nil {
+		code := grpcUnavailable
+		if err == ErrUnknownService {
+			code = grpcUnimplemented
+		}
+		writeGRPCStatus(w, code, err.Error())
+		return
+	}
+	// L4 services are only served on their listen port
+	if t.instance.Listen != 0 {
+		writeGRPCStatus(w, grpcUnimplemented, ErrUnknownPath.Error())
+		return
+	}
+	policy := policyFor(t.service)
+	target, err := url.Parse(policy.upstreamURL(t.instance.URL, t.rawPath, r.URL.RawQuery))
+	if err != nil {
+		writeGRPCStatus(w, grpcUnavailable, err.Error())
+		return
+	}
+
+	if policy.Timeout > 0 {
+		ctx, cancel := context.WithTimeout(r.Context(), policy.Timeout)
+		defer cancel()
+		r = r.WithContext(ctx)
+	}
+
+	transport := transportFor(t.instance)
+	if transport == nil {
+		transport = http.DefaultTransport
+	}
+	proxy := &httputil.ReverseProxy{
+		Rewrite: func(pr *httputil.ProxyRequest) {
+			pr.Out.URL = target
+			pr.Out.Host = target.Host
+			if policy.Host != "" {
+				pr.Out.Host = policy.Host
+			}
+			pr.Out.Header.Del("Api-Key")
+			pr.Out.Header.Del("Secret-Key")
+		},
+		Transport:     transport,
+		FlushInterval: -1,
+		ModifyResponse: func(rsp *http.Response) error {
+			countOperation(t, rsp.StatusCode)
+			if t.instance.Version != "" {
+				rsp.Header.Set(serviceVersionHeader, t.instance.Version)
+			}
+			return nil
+		},
+		ErrorHandler: func(w http.ResponseWriter, r *http.Request, err error) {
+			log.Error("Stream Error: " + err.Error() + " - " + t.service)
+			countOperation(t, 0)
+			code := grpcUnavailable
+			if r.Context().Err() == context.DeadlineExceeded {
+				code = grpcDeadlineExceeded
+			}
+			writeGRPCStatus(w, code, err.Error())
+		},
+	}
+	proxy.ServeHTTP(w, r)
+}
+
+//writeGRPCStatus send a trailers-only gRPC response
+func writeGRPCStatus(w http.ResponseWriter, code int, msg string) {
+	w.Header().Set("Content-Type", "application/grpc")
+	w.Header().Set("Grpc-Status", strconv.Itoa(code))
+	w.Header().Set("Grpc-Message", url.PathEscape(msg))
+	w.WriteHeader(http.StatusOK)
+}
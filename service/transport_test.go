@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bufio"
+	"io"
+	"net/http"
+	"net/http/httptest"
+	"testing"
+)
+
+func TestTransportFor(t *testing.T) {
+	tests := []struct {
+		instance Instance
+		expected http.RoundTripper
+	}{
+		{Instance{URL: "http://a/"}, nil},
+		{Instance{URL: "https://a/", Protocol: ProtocolHTTPS}, nil},
+		{Instance{URL: "http://a/", Protocol: ProtocolH2C}, h2cTransport},
+		{Instance{URL: "https://a/", Protocol: ProtocolH2}, h2Transport},
+		{Instance{URL: "http://a/", Protocol: ProtocolGRPC}, h2cTransport},
+		{Instance{URL: "https://a/", Protocol: ProtocolGRPC}, h2Transport},
+	}
+
+	for _, test := range tests {
+		if res := transportFor(test.instance); res != test.expected {
+			t.Errorf("transportFor returned wrong transport for %v: got %v want %v", test.instance, res, test.expected)
+		}
+	}
+	if client := clientFor(Instance{URL: "http://a/"}, 0); client != nil {
+		t.Errorf("clientFor returned a client for the defaults: got %v want nil", client)
+	}
+}
+
+//newH2CServer test server speaking HTTP/1.1 and h2c
+func newH2CServer(h http.Handler) *httptest.Server {
+	s := httptest.NewUnstartedServer(h)
+	s.Config.Protocols = new(http.Protocols)
+	s.Config.Protocols.SetHTTP1(true)
+	s.Config.Protocols.SetUnencryptedHTTP2(true)
+	s.Start()
+	return s
+}
+
+func TestDiscoveryStream(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+
+	// echoes each line of the request as soon as it arrives
+	upstream := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if r.ProtoMajor != 2 || r.URL.Path != "/helloworld.Greeter/SayHello" || r.Header.Get("Api-Key") != "" {
+			w.WriteHeader(http.StatusBadRequest)
+			return
+		}
+		w.Header().Set("Trailer", "Grpc-Status")
+		w.Header().Set("Content-Type", "application/grpc")
+		lines := bufio.NewScanner(r.Body)
+		for lines.Scan() {
+			w.Write([]byte("echo " + lines.Text() + "\n"))
+			w.(http.Flusher).Flush()
+		}
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer upstream.Close()
+	serviceMap["helloworld.Greeter"] = []Instance{{URL: upstream.URL + "/", Version: "1.0.0", Protocol: ProtocolGRPC}}
+
+	var ds DiscoveryService
+	gateway := newH2CServer(http.HandlerFunc(ds.Stream))
+	defer gateway.Close()
+
+	body, send := io.Pipe()
+	r, _ := http.NewRequest(http.MethodPost, gateway.URL+"/service/helloworld.Greeter/helloworld.Greeter/SayHello", body)
+	r.Header.Set("Content-Type", "application/grpc")
+	r.Header.Set("Api-Key", "key")
+	// the upstream answers once the first message arrives
+	go send.Write([]byte("one\n"))
+	rsp, err := h2cTransport.RoundTrip(r)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer rsp.Body.Close()
+
+	// the first reply arrives while the request is still open
+	lines := bufio.NewReader(rsp.Body)
+	if line, err := lines.ReadString('\n'); line != "echo one\n" {
+		t.Fatalf("stream returned unexpected message: got %q %v want %q", line, err, "echo one\n")
+	}
+	send.Write([]byte("two\n"))
+	send.Close()
+	rest, _ := io.ReadAll(lines)
+
+	if rsp.StatusCode != http.StatusOK || string(rest) != "echo two\n" {
+		t.Errorf("stream returned unexpected response: got %v %q want %v %q", rsp.StatusCode, rest, http.StatusOK, "echo two\n")
+	}
+	if status := rsp.Trailer.Get("Grpc-Status"); status != "0" {
+		t.Errorf("stream did not pass the trailers: got %q want %q", status, "0")
+	}
+	if version := rsp.Header.Get(serviceVersionHeader); version != "1.0.0" {
+		t.Errorf("stream returned wrong version header: got %v want %v", version, "1.0.0")
+	}
+}
+
+func TestDiscoveryStreamFail(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	down := httptest.NewServer(http.NotFoundHandler())
+	down.Close()
+	serviceMap["down"] = []Instance{{URL: down.URL + "/", Protocol: ProtocolGRPC}}
+	serviceMap["cache"] = []Instance{{URL: "tcp://cache:6379", Protocol: ProtocolTCP, Listen: 6380}}
+
+	tests := []struct {
+		path     string
+		expected string
+	}{
+		{"/service/missing/pkg.Missing/Call", "12"},
+		{"/service/down/pkg.Down/Call", "14"},
+		{"/service/cache/pkg.Cache/Get", "12"},
+	}
+
+	var ds DiscoveryService
+	for _, test := range tests {
+		r, _ := http.NewRequest(http.MethodPost, test.path, nil)
+		r.Header.Set("Content-Type", "application/grpc")
+		rr := httptest.NewRecorder()
+		ds.Stream(rr, r)
+
+		if rr.Code != http.StatusOK || rr.Header().Get("Grpc-Status") != test.expected {
+			t.Errorf("stream returned wrong status for %v: got %v %v want %v", test.path, rr.Code, rr.Header().Get("Grpc-Status"), test.expected)
+		}
+	}
+}
+
+func TestDiscoveryStreamResolved(t *testing.T) {
+	setupServiceDiscovery()
+	routePolicies = make(map[string]RoutePolicy)
+	upstream := newH2CServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		w.Header().Set("Grpc-Status", "0")
+	}))
+	defer upstream.Close()
+	serviceMap["test"] = []Instance{{URL: upstream.URL + "/", Version: "1.0.0", Protocol: ProtocolGRPC}}
+
+	r, _ := http.NewRequest(http.MethodPost, "/service/test/pkg.Test/Call", nil)
+	r = Resolve(r)
+
+	// the instance resolved for the key check serves the stream
+	serviceMap["test"] = []Instance{{URL: "http://moved/", Version: "2.0.0", Protocol: ProtocolGRPC}}
+	rr := httptest.NewRecorder()
+	var ds DiscoveryService
+	ds.Stream(rr, r)
+
+	if got := rr.Header().Get(serviceVersionHeader); got != "1.0.0" {
+		t.Errorf("stream used wrong instance: got %v want %v", got, "1.0.0")
+	}
+}
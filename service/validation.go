@@ -0,0 +1,185 @@
+package service
+
+import (
+	"encoding/json"
+	"fmt"
+	"net/http"
+	"strings"
+
+	"github.com/dtan44/SMUG/metrics"
+	log "github.com/sirupsen/logrus"
+)
+
+// Schema validation modes of requests
+const (
+	SchemaEnforce = "enforce"
+	SchemaReport  = "report"
+)
+
+const (
+	schemaMetric = "smug_schema_violations_total"
+)
+
+//SchemaRule JSON Schemas of the requests and responses of an operation
+//  Method is any method when empty, Path is relative to the service and
+//  may contain {param} segments
+//  Request and Response are JSON Schema documents, in config as JSON text
+//  invalid requests are rejected unless Mode is report, invalid responses
+//  are only reported
+type SchemaRule struct {
+	Method   string `json:"method,omitempty" mapstructure:"method"`
+	Path     string `json:"path" mapstructure:"path"`
+	Request  string `json:"-" mapstructure:"request"`
+	Response string `json:"-" mapstructure:"response"`
+	Mode     string `json:"mode,omitempty" mapstructure:"mode"`
+
+	// optional allows requests without a body, as OpenAPI request bodies do
+	optional bool
+}
+
+type schemaRuleJSON struct {
+	Method   string          `json:"method,omitempty"`
+	Path     string          `json:"path"`
+	Request  json.RawMessage `json:"request,omitempty"`
+	Response json.RawMessage `json:"response,omitempty"`
+	Mode     string          `json:"mode,omitempty"`
+}
+
+//UnmarshalJSON accept the schemas of a registration as JSON objects
+func (rule *SchemaRule) UnmarshalJSON(data []byte) error {
+	var raw schemaRuleJSON
+	if err := json.Unmarshal(data, &raw); err != nil {
+		return err
+	}
+	*rule = SchemaRule{
+		Method:   raw.Method,
+		Path:     raw.Path,
+		Request:  string(raw.Request),
+		Response: string(raw.Response),
+		Mode:     raw.Mode,
+	}
+	return nil
+}
+
+//MarshalJSON write the schemas as JSON objects
+func (rule SchemaRule) MarshalJSON() ([]byte, error) {
+	raw := schemaRuleJSON{Method: rule.Method, Path: rule.Path, Mode: rule.Mode}
+	if rule.Request != "" {
+		raw.Request = json.RawMessage(rule.Request)
+	}
+	if rule.Response != "" {
+		raw.Response = json.RawMessage(rule.Response)
+	}
+	return json.Marshal(raw)
+}
+
+//validateSchemaRules check paths, modes and schemas of the rules
+func validateSchemaRules(rules []SchemaRule) error {
+	for i, rule := range rules {
+		if rule.Path == "" {
+			return fmt.Errorf("%d: path is required", i)
+		}
+		if _, err := compilePathTemplate(rule.Path); err != nil {
+			return fmt.Errorf("%d: %s", i, err.Error())
+		}
+		switch rule.Mode {
+		case "", SchemaEnforce, SchemaReport:
+		default:
+			return fmt.Errorf("%d: unsupported mode %s", i, rule.Mode)
+		}
+		for _, schema := range []string{rule.Request, rule.Response} {
+			if schema == "" {
+				continue
+			}
+			if _, err := compileSchema(schema); err != nil {
+				return fmt.Errorf("%d: %s", i, err.Error())
+			}
+		}
+	}
+	return nil
+}
+
+//findSchemaRule first rule matching the method and path
+func findSchemaRule(rules []SchemaRule, method, path string) (SchemaRule, bool) {
+	for _, rule := range rules {
+		if rule.Method != "" && !strings.EqualFold(rule.Method, method) {
+			continue
+		}
+		re, err := compilePathTemplate(rule.Path)
+		if err == nil && re.MatchString(path) {
+			return rule, true
+		}
+	}
+	return SchemaRule{}, false
+}
+
+//schemaRuleFor schema rule of a routed request
+//  rules registered with the instance take precedence over the schemas of
+//  its OpenAPI operation, which take precedence over the routes config
+func schemaRuleFor(t target, policy RoutePolicy, method string) (SchemaRule, bool) {
+	path := "/" + t.path
+	if rule, ok := findSchemaRule(t.instance.Schemas, method, path); ok {
+		return rule, true
+	}
+	if t.operation != nil && (t.operation.schema.Request != "" || t.operation.schema.Response != "") {
+		return t.operation.schema, true
+	}
+	return findSchemaRule(policy.Schemas, method, path)
+}
+
+//validateRequestSchema validate a request body against its schema
+//  returns *ValidationError unless the rule only reports
+func validateRequestSchema(t target, rule SchemaRule, method string, body []byte) error {
+	if rule.Request == "" {
+		return nil
+	}
+	// requests without a body only need one if the method carries it
+	if len(body) == 0 && (rule.optional || method != http.MethodPost && method != http.MethodPut && method != http.MethodPatch) {
+		return nil
+	}
+
+	schema, err := compileSchema(rule.Request)
+	if err != nil {
+		return err
+	}
+	err = schema.validateDocument(body)
+	if err == nil {
+		return nil
+	}
+
+	mode := rule.Mode
+	if mode == "" {
+		mode = SchemaEnforce
+	}
+	reportSchema(t, rule, "request", mode, err)
+	if mode == SchemaReport {
+		return nil
+	}
+	return err
+}
+
+//reportResponseSchema validate a successful response against its schema
+//  failures are logged and counted but never change the response
+func reportResponseSchema(t target, rule SchemaRule, rsp *http.Response, body []byte) {
+	if rule.Response == "" || rsp.StatusCode < 200 || rsp.StatusCode > 299 || len(body) == 0 {
+		return
+	}
+	schema, err := compileSchema(rule.Response)
+	if err != nil {
+		return
+	}
+	if err := schema.validateDocument(body); err != nil {
+		reportSchema(t, rule, "response", SchemaReport, err)
+	}
+}
+
+func reportSchema(t target, rule SchemaRule, direction, mode string, err error) {
+	metrics.Inc(schemaMetric, map[string]string{"service": t.service, "direction": direction, "mode": mode})
+	log.WithFields(log.Fields{
+		"service":   t.service,
+		"version":   t.instance.Version,
+		"path":      rule.Path,
+		"direction": direction,
+		"mode":      mode,
+	}).Error("Schema Error: " + err.Error())
+}
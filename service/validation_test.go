@@ -0,0 +1,153 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"net/http"
+	"net/url"
+	"testing"
+
+	"github.com/dtan44/SMUG/metrics"
+)
+
+const userSchema = `{"type":"object","required":["name"],"properties":{"name":{"type":"string"}}}`
+
+func TestCompilePathTemplate(t *testing.T) {
+	tests := []struct {
+		template string
+		path     string
+		expected bool
+	}{
+		{"/users", "/users", true},
+		{"users/{id}", "/users/42", true},
+		{"/users/{id}", "/users/42/posts", false},
+		{"/users/{id}/posts/{post_id}", "/users/a/posts/b", true},
+		{"/a.b", "/axb", false},
+		{"/files/{name}.json", "/files/a.b.json", true},
+		{"/files/{name}.json", "/files/a.xml", false},
+	}
+
+	for _, test := range tests {
+		re, err := compilePathTemplate(test.template)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := re.MatchString(test.path); got != test.expected {
+			t.Errorf("%v match %v: got %v want %v", test.template, test.path, got, test.expected)
+		}
+	}
+
+	for _, template := range []string{"/users/{}", "/users/{id", "/users/{a-b}", "/users/id}"} {
+		if _, err := compilePathTemplate(template); err == nil {
+			t.Errorf("%v: expected error", template)
+		}
+	}
+}
+
+func TestSchemaRuleJSON(t *testing.T) {
+	var in Instance
+	body := `{"URL":"http://a","schemas":[{"method":"POST","path":"/users","request":` + userSchema + `}]}`
+	if err := json.Unmarshal([]byte(body), &in); err != nil {
+		t.Fatal(err)
+	}
+	if len(in.Schemas) != 1 || in.Schemas[0].Request != userSchema {
+		t.Fatalf("function decoded unexpected schemas: got %+v", in.Schemas)
+	}
+	if err := validateInstance(in); err != nil {
+		t.Errorf("function returned unexpected error: got %v", err)
+	}
+
+	j, err := json.Marshal(in.Schemas[0])
+	if err != nil {
+		t.Fatal(err)
+	}
+	expected := `{"method":"POST","path":"/users","request":` + userSchema + `}`
+	if string(j) != expected {
+		t.Errorf("function encoded unexpected rule: got %v want %v", string(j), expected)
+	}
+
+	in.Schemas[0].Request = `{"type": 1`
+	if err := validateInstance(in); err == nil {
+		t.Error("Failed invalid schema")
+	}
+}
+
+func TestDiscoveryRouteSchema(t *testing.T) {
+	tests := []struct {
+		method   string
+		path     string
+		body     string
+		mode     string
+		fields   int
+		upstream bool
+	}{
+		{http.MethodPost, "/users", `{"name":"a"}`, "", 0, true},
+		{http.MethodPost, "/users", `{"name":1}`, "", 1, false},
+		{http.MethodPost, "/users", ``, "", 1, false},
+		{http.MethodPost, "/users", `{}`, SchemaReport, 0, true},
+		{http.MethodGet, "/users", ``, "", 0, true},
+		{http.MethodPost, "/other", `{}`, "", 0, true},
+	}
+
+	for _, test := range tests {
+		setupVersionRoute()
+		routePolicies["test"] = RoutePolicy{Schemas: []SchemaRule{
+			{Path: "/users", Request: userSchema, Mode: test.mode},
+		}}
+		var ds DiscoveryService
+
+		sent := false
+		request = func(url, httpMethod string,
+			headers map[string]string, body string,
+			client clientInterface) (*http.Response, []byte, error) {
+			sent = true
+			return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, nil, nil
+		}
+
+		req := http.Request{}
+		req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test@1" + test.path)
+		req.Body = readCloserMock{bytes.NewBufferString(test.body)}
+		req.Method = test.method
+		req.Header = http.Header{}
+
+		_, _, err := ds.Route(&req)
+		fields := 0
+		if ve, ok := err.(*ValidationError); ok {
+			fields = len(ve.Fields)
+		} else if err != nil {
+			t.Fatal(err)
+		}
+		if fields != test.fields || sent != test.upstream {
+			t.Errorf("%v %v %q: got %v fields sent %v want %v fields sent %v",
+				test.method, test.path, test.body, fields, sent, test.fields, test.upstream)
+		}
+	}
+}
+
+func TestDiscoveryRouteResponseSchema(t *testing.T) {
+	setupVersionRoute()
+	serviceMap["test"][0].Schemas = []SchemaRule{{Method: "GET", Path: "/users/{id}", Response: userSchema}}
+	var ds DiscoveryService
+
+	request = func(url, httpMethod string,
+		headers map[string]string, body string,
+		client clientInterface) (*http.Response, []byte, error) {
+		return &http.Response{StatusCode: http.StatusOK, Header: http.Header{}}, []byte(`{"id":1}`), nil
+	}
+
+	req := http.Request{}
+	req.URL, _ = url.ParseRequestURI("http://www.test.com/service/test@1/users/1")
+	req.Body = readCloserMock{bytes.NewBufferString("")}
+	req.Method = http.MethodGet
+	req.Header = http.Header{}
+
+	labels := map[string]string{"service": "test", "direction": "response", "mode": SchemaReport}
+	before := metrics.Get(schemaMetric, labels)
+	_, body, err := ds.Route(&req)
+	if err != nil || string(body) != `{"id":1}` {
+		t.Errorf("service returned unexpected result: got %v %v", string(body), err)
+	}
+	if got := metrics.Get(schemaMetric, labels); got != before+1 {
+		t.Errorf("service reported unexpected violations: got %v want %v", got, before+1)
+	}
+}
@@ -0,0 +1,226 @@
+package service
+
+import (
+	"errors"
+	"strconv"
+	"strings"
+)
+
+//semver parsed semantic version
+//  parts records how many of major.minor.patch were given
+type semver struct {
+	major, minor, patch int
+	pre                 string
+	parts               int
+}
+
+//parseVersion parse a full or partial semantic version such as v1, 1.2 or 1.2.3-rc.1
+func parseVersion(s string) (semver, error) {
+	var v semver
+	if !versionPattern.MatchString(s) {
+		return v, errors.New("Invalid Version " + s)
+	}
+
+	s = strings.TrimPrefix(s, "v")
+	if i := strings.Index(s, "-"); i >= 0 {
+		v.pre = s[i+1:]
+		s = s[:i]
+	}
+
+	nums := strings.Split(s, ".")
+	v.parts = len(nums)
+	fields := []*int{&v.major, &v.minor, &v.patch}
+	for i, n := range nums {
+		*fields[i], _ = strconv.Atoi(n)
+	}
+	return v, nil
+}
+
+//compare order versions by precedence
+//  returns -1, 0 or 1
+func (v semver) compare(o semver) int {
+	a := []int{v.major, v.minor, v.patch}
+	b := []int{o.major, o.minor, o.patch}
+	for i := range a {
+		if a[i] != b[i] {
+			if a[i] < b[i] {
+				return -1
+			}
+			return 1
+		}
+	}
+
+	// a pre-release has lower precedence than the release
+	switch {
+	case v.pre == o.pre:
+		return 0
+	case v.pre == "":
+		return 1
+	case o.pre == "":
+		return -1
+	}
+	return comparePrerelease(v.pre, o.pre)
+}
+
+//comparePrerelease order pre-releases by their dot separated identifiers
+//  numeric identifiers compare numerically and below alphanumeric ones,
+//  a shorter list of otherwise equal identifiers comes first
+func comparePrerelease(a, b string) int {
+	x, y := strings.Split(a, "."), strings.Split(b, ".")
+	for i := 0; i < len(x) && i < len(y); i++ {
+		if x[i] == y[i] {
+			continue
+		}
+		m, errM := strconv.ParseUint(x[i], 10, 64)
+		n, errN := strconv.ParseUint(y[i], 10, 64)
+		switch {
+		case errM == nil && errN == nil && m < n:
+			return -1
+		case errM == nil && errN == nil:
+			return 1
+		case errM == nil:
+			return -1
+		case errN == nil:
+			return 1
+		case x[i] < y[i]:
+			return -1
+		}
+		return 1
+	}
+	switch {
+	case len(x) < len(y):
+		return -1
+	case len(x) > len(y):
+		return 1
+	}
+	return 0
+}
+
+//versionKey canonical form of a version, v1 and 1.0.0 share the key 1.0.0
+//  versions that do not parse are their own key
+func versionKey(s string) string {
+	v, err := parseVersion(s)
+	if err != nil {
+		return s
+	}
+	key := strconv.Itoa(v.major) + "." + strconv.Itoa(v.minor) + "." + strconv.Itoa(v.patch)
+	if v.pre != "" {
+		key += "-" + v.pre
+	}
+	return key
+}
+
+//sameVersion check if two versions have the same precedence
+func sameVersion(a, b string) bool {
+	return versionKey(a) == versionKey(b)
+}
+
+//next smallest version above every version matching a partial version
+func (v semver) next() semver {
+	switch v.parts {
+	case 1:
+		return semver{major: v.major + 1, parts: 3}
+	case 2:
+		return semver{major: v.major, minor: v.minor + 1, parts: 3}
+	}
+	return semver{major: v.major, minor: v.minor, patch: v.patch + 1, parts: 3}
+}
+
+//versionConstraint alternatives of comparator sets
+//  e.g. ">=1.2 <2 || ^3.0"
+type versionConstraint [][]comparator
+
+type comparator struct {
+	op string
+	v  semver
+}
+
+//parseConstraint parse a version constraint
+//  supports bare or partial versions, =, >, >=, <, <=, ^, ~ and ||
+func parseConstraint(s string) (versionConstraint, error) {
+	var c versionConstraint
+	for _, alt := range strings.Split(s, "||") {
+		var set []comparator
+		for _, term := range strings.Fields(alt) {
+			op := term
+			if i := strings.IndexAny(term, "v0123456789"); i >= 0 {
+				op = term[:i]
+			}
+			v, err := parseVersion(strings.TrimPrefix(term, op))
+			if err != nil {
+				return nil, err
+			}
+			switch op {
+			case "", "=", ">", ">=", "<", "<=", "^", "~":
+			default:
+				return nil, errors.New("Invalid Version Constraint " + term)
+			}
+			set = append(set, comparator{op, v})
+		}
+		if len(set) == 0 {
+			return nil, errors.New("Invalid Version Constraint " + s)
+		}
+		c = append(c, set)
+	}
+	return c, nil
+}
+
+//prerelease check if any comparator names a pre-release version
+func (c versionConstraint) prerelease() bool {
+	for _, set := range c {
+		for _, cmp := range set {
+			if cmp.v.pre != "" {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+//match check if version satisfies the constraint
+func (c versionConstraint) match(v semver) bool {
+	for _, set := range c {
+		ok := true
+		for _, cmp := range set {
+			if !cmp.match(v) {
+				ok = false
+				break
+			}
+		}
+		if ok {
+			return true
+		}
+	}
+	return false
+}
+
+func (cmp comparator) match(v semver) bool {
+	switch cmp.op {
+	case ">":
+		return v.compare(cmp.v) > 0
+	case ">=":
+		return v.compare(cmp.v) >= 0
+	case "<":
+		return v.compare(cmp.v) < 0
+	case "<=":
+		return v.compare(cmp.v) <= 0
+	case "^":
+		upper := semver{major: cmp.v.major + 1, parts: 3}
+		if cmp.v.major == 0 && cmp.v.parts > 1 {
+			upper = semver{minor: cmp.v.minor + 1, parts: 3}
+		}
+		return v.compare(cmp.v) >= 0 && v.compare(upper) < 0
+	case "~":
+		upper := semver{major: cmp.v.major, minor: cmp.v.minor + 1, parts: 3}
+		if cmp.v.parts == 1 {
+			upper = semver{major: cmp.v.major + 1, parts: 3}
+		}
+		return v.compare(cmp.v) >= 0 && v.compare(upper) < 0
+	}
+
+	// a bare or = version matches every version it prefixes
+	if cmp.v.parts == 3 {
+		return v.compare(cmp.v) == 0
+	}
+	return v.compare(cmp.v) >= 0 && v.compare(cmp.v.next()) < 0
+}
@@ -0,0 +1,91 @@
+package service
+
+import (
+	"testing"
+)
+
+func TestParseVersion(t *testing.T) {
+	v, err := parseVersion("v1.2.3-rc.1")
+	if err != nil {
+		t.Fatal(err)
+	}
+	if v.major != 1 || v.minor != 2 || v.patch != 3 || v.pre != "rc.1" || v.parts != 3 {
+		t.Errorf("function returned unexpected version: got %+v", v)
+	}
+
+	if _, err := parseVersion("latest"); err == nil {
+		t.Error("Failed Invalid Version")
+	}
+}
+
+func TestCompareVersion(t *testing.T) {
+	tests := []struct {
+		a, b     string
+		expected int
+	}{
+		{"1.0.0", "1.0.0", 0},
+		{"1.0.1", "1.0.0", 1},
+		{"1.2", "1.10", -1},
+		{"2", "1.9.9", 1},
+		{"1.0.0-rc.1", "1.0.0", -1},
+		{"1.0.0-beta", "1.0.0-alpha", 1},
+		{"1.0.0-rc.10", "1.0.0-rc.2", 1},
+		{"1.0.0-alpha", "1.0.0-alpha.1", -1},
+		{"1.0.0-1", "1.0.0-alpha", -1},
+	}
+
+	for _, test := range tests {
+		a, _ := parseVersion(test.a)
+		b, _ := parseVersion(test.b)
+		if got := a.compare(b); got != test.expected {
+			t.Errorf("compare(%v, %v): got %v want %v",
+				test.a, test.b, got, test.expected)
+		}
+	}
+}
+
+func TestConstraintMatch(t *testing.T) {
+	tests := []struct {
+		constraint string
+		version    string
+		expected   bool
+	}{
+		{"v2", "2.3.1", true},
+		{"v2", "3.0.0", false},
+		{"1.2", "1.2.9", true},
+		{"1.2", "1.3.0", false},
+		{"=1.2.3", "1.2.3", true},
+		{"1.2.3", "1.2.4", false},
+		{">=1.2 <2", "1.5.0", true},
+		{">=1.2 <2", "2.0.0", false},
+		{">1.0.0", "1.0.0", false},
+		{"<=1.0.0", "1.0.0", true},
+		{"^1.2", "1.9.0", true},
+		{"^1.2", "2.0.0", false},
+		{"^0.2", "0.3.0", false},
+		{"~1.2", "1.2.7", true},
+		{"~1.2", "1.3.0", false},
+		{"1 || ^3", "3.1.0", true},
+		{"1 || ^3", "2.0.0", false},
+	}
+
+	for _, test := range tests {
+		c, err := parseConstraint(test.constraint)
+		if err != nil {
+			t.Fatalf("parseConstraint(%v): %v", test.constraint, err)
+		}
+		v, _ := parseVersion(test.version)
+		if got := c.match(v); got != test.expected {
+			t.Errorf("%v match %v: got %v want %v",
+				test.constraint, test.version, got, test.expected)
+		}
+	}
+}
+
+func TestParseConstraintFail(t *testing.T) {
+	for _, constraint := range []string{"", "latest", "!1.0", ">= "} {
+		if _, err := parseConstraint(constraint); err == nil {
+			t.Errorf("parseConstraint(%q): expected error", constraint)
+		}
+	}
+}
@@ -0,0 +1,111 @@
+package service
+
+import (
+	"context"
+
+	log "github.com/sirupsen/logrus"
+)
+
+// Registry event types
+const (
+	EventRegister   = "register"
+	EventDeregister = "deregister"
+	EventHealth     = "health"
+)
+
+const subscriberBuffer = 16
+
+var (
+	revision    uint64
+	changed     chan struct{}
+	subscribers map[chan Event]struct{}
+)
+
+func init() {
+	changed = make(chan struct{})
+	subscribers = make(map[chan Event]struct{})
+}
+
+//Event describes a single registry mutation
+type Event struct {
+	Index   uint64 `json:"index"`
+	Type    string `json:"type"`
+	Service string `json:"service"`
+	Version string `json:"version,omitempty"`
+	URL     string `json:"URL,omitempty"`
+	Healthy *bool  `json:"healthy,omitempty"`
+}
+
+//WatchInterface defines registry watch methods
+type WatchInterface interface {
+	Index() uint64
+	Watch(ctx context.Context, index uint64) uint64
+	Subscribe() (<-chan Event, func())
+}
+
+//Index current registry revision
+func (ds DiscoveryService) Index() uint64 {
+	registryLock.RLock()
+	defer registryLock.RUnlock()
+	return revision
+}
+
+//Watch block until the registry revision moves past index
+//  returns the current revision once it changes or ctx is done
+func (ds DiscoveryService) Watch(ctx context.Context, index uint64) uint64 {
+	for {
+		registryLock.RLock()
+		current, wait := revision, changed
+		registryLock.RUnlock()
+
+		if current != index {
+			return current
+		}
+
+		select {
+		case <-wait:
+		case <-ctx.Done():
+			return current
+		}
+	}
+}
+
+//Subscribe receive every registry event until the returned cancel is called
+//  the channel is closed if the subscriber falls behind
+func (ds DiscoveryService) Subscribe() (<-chan Event, func()) {
+	ch := make(chan Event, subscriberBuffer)
+
+	registryLock.Lock()
+	subscribers[ch] = struct{}{}
+	registryLock.Unlock()
+
+	cancel := func() {
+		registryLock.Lock()
+		defer registryLock.Unlock()
+		if _, ok := subscribers[ch]; ok {
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+	return ch, cancel
+}
+
+//publish bump the registry revision and notify watchers
+//  registryLock must be held for writing
+func publish(e Event) {
+	revision++
+	e.Index = revision
+
+	close(changed)
+	changed = make(chan struct{})
+
+	for ch := range subscribers {
+		select {
+		case ch <- e:
+		default:
+			log.Error("publish Error: dropping slow subscriber")
+			delete(subscribers, ch)
+			close(ch)
+		}
+	}
+}
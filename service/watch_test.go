@@ -0,0 +1,119 @@
+package service
+
+import (
+	"context"
+	"testing"
+	"time"
+)
+
+func setupWatch() {
+	setupServiceRegister()
+	serviceHealth = make(map[string]bool)
+}
+
+func TestRegisterBumpsIndex(t *testing.T) {
+	setupWatch()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	before := ds.Index()
+	rs.Register("test", Instance{URL: "http://test"})
+	rs.Deregister("test")
+
+	if got := ds.Index(); got != before+2 {
+		t.Errorf("service returned unexpected index: got %v want %v",
+			got, before+2)
+	}
+}
+
+func TestWatchReturnsOnStaleIndex(t *testing.T) {
+	setupWatch()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	rs.Register("test", Instance{URL: "http://test"})
+	index := ds.Index()
+
+	if got := ds.Watch(context.Background(), index-1); got != index {
+		t.Errorf("service returned unexpected index: got %v want %v",
+			got, index)
+	}
+}
+
+func TestWatchTimeout(t *testing.T) {
+	setupWatch()
+	var ds DiscoveryService
+
+	index := ds.Index()
+	ctx, cancel := context.WithTimeout(context.Background(), 10*time.Millisecond)
+	defer cancel()
+
+	if got := ds.Watch(ctx, index); got != index {
+		t.Errorf("service returned unexpected index: got %v want %v",
+			got, index)
+	}
+}
+
+func TestWatchWakesOnChange(t *testing.T) {
+	setupWatch()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	index := ds.Index()
+	go func() {
+		time.Sleep(10 * time.Millisecond)
+		rs.Register("test", Instance{URL: "http://test"})
+	}()
+
+	ctx, cancel := context.WithTimeout(context.Background(), time.Second)
+	defer cancel()
+	if got := ds.Watch(ctx, index); got != index+1 {
+		t.Errorf("service returned unexpected index: got %v want %v",
+			got, index+1)
+	}
+}
+
+func TestSubscribeEvents(t *testing.T) {
+	setupWatch()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	events, cancel := ds.Subscribe()
+	defer cancel()
+
+	rs.Register("test", Instance{URL: "http://test"})
+	rs.Deregister("test")
+
+	e := <-events
+	if e.Type != EventRegister || e.Service != "test" {
+		t.Errorf("service returned unexpected event: got %+v", e)
+	}
+	e = <-events
+	if e.Type != EventDeregister || e.Service != "test" {
+		t.Errorf("service returned unexpected event: got %+v", e)
+	}
+}
+
+func TestCheckHealthPublishesChange(t *testing.T) {
+	setupWatch()
+	var rs RegistrationService
+	var ds DiscoveryService
+
+	rs.Register("test", Instance{URL: "http://test"})
+	events, cancel := ds.Subscribe()
+	defer cancel()
+
+	healthCheck = func(URL string) bool { return false }
+	rs.CheckHealth()
+	rs.CheckHealth()
+
+	e := <-events
+	if e.Type != EventHealth || e.Healthy == nil || *e.Healthy {
+		t.Errorf("service returned unexpected event: got %+v", e)
+	}
+	select {
+	case e := <-events:
+		t.Errorf("service published unchanged health: got %+v", e)
+	default:
+	}
+}
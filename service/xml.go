@@ -0,0 +1,201 @@
+package service
+
+import (
+	"bytes"
+	"encoding/json"
+	"encoding/xml"
+	"errors"
+	"fmt"
+	"io"
+	"sort"
+	"strings"
+)
+
+const (
+	xmlAttrPrefix = "@"
+	xmlTextKey    = "#text"
+	xmlItemName   = "item"
+)
+
+//decodeXML convert an XML document to JSON values
+//  the root element becomes the single key of the result
+//  attributes are keyed @name, text next to attributes or children is #text
+//  repeated elements become arrays and every value is a string
+func decodeXML(data []byte) (interface{}, error) {
+	d := xml.NewDecoder(bytes.NewReader(data))
+	for {
+		tok, err := d.Token()
+		if err == io.EOF {
+			return nil, errors.New("XML document has no root element")
+		}
+		if err != nil {
+			return nil, err
+		}
+		if start, ok := tok.(xml.StartElement); ok {
+			val, err := decodeElement(d, start)
+			if err != nil {
+				return nil, err
+			}
+			return map[string]interface{}{start.Name.Local: val}, nil
+		}
+	}
+}
+
+func decodeElement(d *xml.Decoder, start xml.StartElement) (interface{}, error) {
+	node := make(map[string]interface{})
+	for _, attr := range start.Attr {
+		node[xmlAttrPrefix+attr.Name.Local] = attr.Value
+	}
+
+	var text strings.Builder
+	for {
+		tok, err := d.Token()
+		if err != nil {
+			return nil, err
+		}
+		switch t := tok.(type) {
+		case xml.StartElement:
+			child, err := decodeElement(d, t)
+			if err != nil {
+				return nil, err
+			}
+			addChild(node, t.Name.Local, child)
+		case xml.CharData:
+			text.Write(t)
+		case xml.EndElement:
+			content := strings.TrimSpace(text.String())
+			if len(node) == 0 {
+				return content, nil
+			}
+			if content != "" {
+				node[xmlTextKey] = content
+			}
+			return node, nil
+		}
+	}
+}
+
+func addChild(node map[string]interface{}, name string, child interface{}) {
+	existing, ok := node[name]
+	if !ok {
+		node[name] = child
+		return
+	}
+	if list, ok := existing.([]interface{}); ok {
+		node[name] = append(list, child)
+		return
+	}
+	node[name] = []interface{}{existing, child}
+}
+
+//encodeXML convert JSON values to an XML document under a root element
+//  the reverse of decodeXML, object keys are written in sorted order
+//  and elements of an array that is not an object field are named item
+func encodeXML(root string, doc interface{}) ([]byte, error) {
+	var buf bytes.Buffer
+	buf.WriteString(xml.Header)
+	e := xml.NewEncoder(&buf)
+
+	// without a configured root a single keyed object names its own root
+	if obj, ok := doc.(map[string]interface{}); ok && len(obj) == 1 && root == "" {
+		for name, val := range obj {
+			if _, nested := val.(map[string]interface{}); nested {
+				root, doc = name, val
+			}
+		}
+	}
+	if root == "" {
+		root = defaultXMLRoot
+	}
+
+	if err := encodeElement(e, root, doc); err != nil {
+		return nil, err
+	}
+	if err := e.Flush(); err != nil {
+		return nil, err
+	}
+	return buf.Bytes(), nil
+}
+
+func encodeElement(e *xml.Encoder, name string, val interface{}) error {
+	start := xml.StartElement{Name: xml.Name{Local: name}}
+
+	switch v := val.(type) {
+	case []interface{}:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		for _, item := range v {
+			if err := encodeElement(e, xmlItemName, item); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	case map[string]interface{}:
+		keys := make([]string, 0, len(v))
+		for key := range v {
+			keys = append(keys, key)
+		}
+		sort.Strings(keys)
+
+		var text string
+		var children []string
+		for _, key := range keys {
+			switch {
+			case strings.HasPrefix(key, xmlAttrPrefix):
+				start.Attr = append(start.Attr, xml.Attr{Name: xml.Name{Local: strings.TrimPrefix(key, xmlAttrPrefix)}, Value: scalarText(v[key])})
+			case key == xmlTextKey:
+				text = scalarText(v[key])
+			default:
+				children = append(children, key)
+			}
+		}
+
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if text != "" {
+			if err := e.EncodeToken(xml.CharData(text)); err != nil {
+				return err
+			}
+		}
+		for _, key := range children {
+			// arrays repeat the element instead of nesting items
+			if list, ok := v[key].([]interface{}); ok {
+				for _, item := range list {
+					if err := encodeElement(e, key, item); err != nil {
+						return err
+					}
+				}
+				continue
+			}
+			if err := encodeElement(e, key, v[key]); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	default:
+		if err := e.EncodeToken(start); err != nil {
+			return err
+		}
+		if val != nil {
+			if err := e.EncodeToken(xml.CharData(scalarText(val))); err != nil {
+				return err
+			}
+		}
+		return e.EncodeToken(start.End())
+	}
+}
+
+func scalarText(val interface{}) string {
+	switch v := val.(type) {
+	case string:
+		return v
+	case json.Number:
+		return v.String()
+	case nil:
+		return ""
+	default:
+		return fmt.Sprint(v)
+	}
+}